@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Bucket is a summary of the readings that fall into one time bucket
+type Bucket struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Avg   float64   `json:"avg"`
+	Min   int       `json:"min"`
+	Max   int       `json:"max"`
+	Count int       `json:"count"`
+	// Percentiles holds the requested percentiles keyed by their name, e.g. "p95"
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+}
+
+// percentileParam is one requested percentile, e.g. p95 has Name "p95" and Fraction 0.95
+type percentileParam struct {
+	Name     string
+	Fraction float64
+}
+
+// parsePercentiles reads the optional comma-separated 'agg' parameter, e.g. agg=p50,p95
+func parsePercentiles(r *http.Request) ([]percentileParam, error) {
+	value := r.URL.Query().Get("agg")
+	if value == "" {
+		return nil, nil
+	}
+	var percentiles []percentileParam
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		p, err := strconv.ParseFloat(strings.TrimPrefix(name, "p"), 64)
+		if !strings.HasPrefix(name, "p") || err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid 'agg' parameter: '%s' is not a percentile between p0 and p100", name)
+		}
+		percentiles = append(percentiles, percentileParam{Name: name, Fraction: p / 100})
+	}
+	return percentiles, nil
+}
+
+// bucketUnits maps the accepted 'bucket' values to date_trunc field names
+var bucketUnits = map[string]string{
+	"minute": "minute",
+	"hour":   "hour",
+	"day":    "day",
+	"week":   "week",
+	"month":  "month",
+}
+
+// parseBucketUnit reads the 'bucket' parameter, or its calendar-oriented alias 'group_by',
+// defaulting to hourly buckets; weeks are ISO weeks starting on Monday
+func parseBucketUnit(r *http.Request) (string, error) {
+	q := r.URL.Query()
+	if q.Has("bucket") && q.Has("group_by") {
+		return "", fmt.Errorf("'bucket' and 'group_by' are mutually exclusive")
+	}
+	name, bucket := "bucket", q.Get("bucket")
+	if q.Has("group_by") {
+		name, bucket = "group_by", q.Get("group_by")
+	}
+	if bucket == "" {
+		bucket = "hour"
+	}
+	unit, ok := bucketUnits[bucket]
+	if !ok {
+		return "", fmt.Errorf("invalid '%s' parameter: expected minute, hour, day, week or month", name)
+	}
+	return unit, nil
+}
+
+// bucketEnd returns the exclusive end of the bucket of the given unit starting at start,
+// using calendar arithmetic in the location of start
+func bucketEnd(start time.Time, unit string) time.Time {
+	switch unit {
+	case "minute":
+		return start.Add(time.Minute)
+	case "hour":
+		return start.Add(time.Hour)
+	case "day":
+		return start.AddDate(0, 0, 1)
+	case "week":
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
+
+// getAggregateHandler handles the /pool-data/aggregate endpoint and returns per-bucket avg/min/max
+// percentages, plus any percentiles requested through the 'agg' parameter
+func getAggregateHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		unit, err := parseBucketUnit(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := addPercentageFilter(r, filter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		percentiles, err := parsePercentiles(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format, err := negotiate(r, map[string]string{"xml": "application/xml"})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		buckets, err := queryBuckets(r.Context(), pool, unit, filter, percentiles, loc)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		if format == "xml" {
+			writeXML(w, newXMLBuckets(buckets, percentiles))
+			return
+		}
+		writeJSON(w, buckets)
+	}
+}
+
+// queryBuckets aggregates the readings matching filter into buckets of the given date_trunc unit,
+// following the calendar of loc when it is set. Requests without percentiles or thresholds over
+// whole hours are answered from the hourly rollup.
+func queryBuckets(ctx context.Context, pool *pgxpool.Pool, unit string, filter *queryFilter, percentiles []percentileParam, loc *time.Location) ([]Bucket, error) {
+	if from, to, ok := filter.rollupRange(unit, loc); ok && len(percentiles) == 0 {
+		return queryRollupBuckets(ctx, pool, unit, from, to, loc)
+	}
+
+	// percentile_cont accepts an array of fractions and returns the matching array of values
+	percentileColumn := "NULL::float8[]"
+	if len(percentiles) > 0 {
+		fractions := make([]float64, len(percentiles))
+		for i, p := range percentiles {
+			fractions[i] = p.Fraction
+		}
+		percentileColumn = fmt.Sprintf("percentile_cont(%s::float8[]) WITHIN GROUP (ORDER BY percentage)", filter.bind(fractions))
+	}
+
+	// The unit comes from a fixed whitelist, so it is safe to inline into the query
+	query := fmt.Sprintf(`SELECT %s AS start, avg(percentage)::float8, min(percentage), max(percentage), count(*), %s
+		FROM pool_usage%s GROUP BY start ORDER BY start`, filter.truncTimestamp(unit, loc), percentileColumn, filter.where())
+	rows, err := pool.Query(ctx, query, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []Bucket{}
+	for rows.Next() {
+		var b Bucket
+		var values []float64
+		if err := rows.Scan(&b.Start, &b.Avg, &b.Min, &b.Max, &b.Count, &values); err != nil {
+			return nil, err
+		}
+		b.Start = inLocation(b.Start, loc)
+		b.End = bucketEnd(b.Start, unit)
+		if len(values) > 0 {
+			b.Percentiles = make(map[string]float64, len(values))
+			for i, p := range percentiles {
+				b.Percentiles[p.Name] = values[i]
+			}
+		}
+		buckets = append(buckets, b)
+		if err := checkRowLimit(ctx, len(buckets)); err != nil {
+			return nil, err
+		}
+	}
+	return buckets, rows.Err()
+}
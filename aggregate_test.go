@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseBucketUnit(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		err   string
+	}{
+		{name: "default", query: "", want: "hour"},
+		{name: "empty", query: "bucket=", want: "hour"},
+		{name: "minute", query: "bucket=minute", want: "minute"},
+		{name: "day", query: "bucket=day", want: "day"},
+		{name: "month", query: "bucket=month", want: "month"},
+		{name: "unknown", query: "bucket=year", err: "invalid 'bucket' parameter: expected minute, hour, day, week or month"},
+		{name: "group_by", query: "group_by=week", want: "week"},
+		{name: "empty group_by", query: "group_by=", want: "hour"},
+		{name: "unknown group_by", query: "group_by=weekday", err: "invalid 'group_by' parameter: expected minute, hour, day, week or month"},
+		{name: "bucket and group_by", query: "bucket=day&group_by=day", err: "'bucket' and 'group_by' are mutually exclusive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBucketUnit(httptest.NewRequest("GET", "/pool-data/aggregate?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketEnd(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		start time.Time
+		unit  string
+		want  time.Time
+	}{
+		{name: "minute", start: time.Date(2024, 5, 1, 10, 59, 0, 0, time.UTC), unit: "minute", want: time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{name: "hour", start: time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC), unit: "hour", want: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{name: "day", start: time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC), unit: "day", want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "week", start: time.Date(2024, 4, 29, 0, 0, 0, 0, time.UTC), unit: "week", want: time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)},
+		{name: "month", start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), unit: "month", want: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// The day the clocks go forward lasts 23 hours
+		{name: "short day", start: time.Date(2024, 3, 31, 0, 0, 0, 0, berlin), unit: "day", want: time.Date(2024, 4, 1, 0, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bucketEnd(tt.start, tt.unit); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []percentileParam
+		err   string
+	}{
+		{name: "absent", query: ""},
+		{name: "single", query: "agg=p95", want: []percentileParam{{Name: "p95", Fraction: 0.95}}},
+		{name: "several", query: "agg=p50,%20p99", want: []percentileParam{{Name: "p50", Fraction: 0.5}, {Name: "p99", Fraction: 0.99}}},
+		{name: "fractional", query: "agg=p12.5", want: []percentileParam{{Name: "p12.5", Fraction: 0.125}}},
+		{name: "bounds", query: "agg=p0,p100", want: []percentileParam{{Name: "p0", Fraction: 0}, {Name: "p100", Fraction: 1}}},
+		{name: "missing prefix", query: "agg=95", err: "invalid 'agg' parameter: '95' is not a percentile between p0 and p100"},
+		{name: "above 100", query: "agg=p101", err: "invalid 'agg' parameter: 'p101' is not a percentile between p0 and p100"},
+		{name: "not a percentile", query: "agg=avg", err: "invalid 'agg' parameter: 'avg' is not a percentile between p0 and p100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePercentiles(httptest.NewRequest("GET", "/pool-data/aggregate?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
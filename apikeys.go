@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// apiKeyHeader carries an API key; keys are an alternative to the bearer tokens of the scopes
+const apiKeyHeader = "X-API-Key"
+
+// Audited API key actions
+const (
+	auditAPIKeyCreate = "api_key.create"
+	auditAPIKeyRevoke = "api_key.revoke"
+	auditAPIKeyRotate = "api_key.rotate"
+)
+
+// apiKeyScopes lists the scopes a key may be granted
+var apiKeyScopes = []string{scopeRead, scopeIngest, scopeAdmin}
+
+// errUnknownAPIKey is returned for keys that are not in api_keys, or are revoked or expired
+var errUnknownAPIKey = errors.New("unknown API key")
+
+// APIKey describes an API key; the key itself is only returned when it is created or rotated
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// apiKeyAllows looks key up and returns its ID and name and whether it grants scope, recording when it was last used
+func apiKeyAllows(ctx context.Context, pool *pgxpool.Pool, key, scope string) (int, string, bool, error) {
+	var id int
+	var name string
+	var scopes []string
+	err := pool.QueryRow(ctx, `UPDATE api_keys SET last_used_at = now()
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+		RETURNING id, name, scopes`, hashToken(key)).
+		Scan(&id, &name, &scopes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", false, errUnknownAPIKey
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	return id, name, slices.Contains(scopes, scope), nil
+}
+
+// apiKeyColumns are the columns scanned into an APIKey by scanAPIKey
+const apiKeyColumns = "id, name, scopes, expires_at, created_at, last_used_at, revoked_at"
+
+// scanAPIKey scans a row selecting apiKeyColumns
+func scanAPIKey(row pgx.Row) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.Name, &k.Scopes, &k.ExpiresAt, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+// parseAPIKeyID reads the {id} path value
+func parseAPIKeyID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid API key id '%s'", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// getCreateAPIKeyHandler handles POST /api-keys; the response contains the key, which is not shown again
+func getCreateAPIKeyHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in APIKey
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&in); err != nil {
+			writeBodyError(w, decodeError(err))
+			return
+		}
+		var errs fieldErrors
+		if in.Name == "" {
+			errs.add("name", "is required")
+		}
+		if len(in.Scopes) == 0 {
+			errs.add("scopes", "must grant at least one scope")
+		}
+		for i, scope := range in.Scopes {
+			if !slices.Contains(apiKeyScopes, scope) {
+				errs.add(fmt.Sprintf("scopes[%d]", i), fmt.Sprintf("must be one of %v", apiKeyScopes))
+			}
+		}
+		if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+			errs.add("expires_at", "must lie in the future")
+		}
+		if in.ID != 0 || in.Key != "" || in.LastUsedAt != nil || in.RevokedAt != nil {
+			errs.add("", "only name, scopes and expires_at can be set")
+		}
+		if err := errs.err(); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		key, err := randomToken()
+		if err != nil {
+			http.Error(w, "Failed to generate key", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error generating API key", "error", err)
+			return
+		}
+		created, err := scanAPIKey(pool.QueryRow(r.Context(),
+			"INSERT INTO api_keys (name, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4) RETURNING "+apiKeyColumns,
+			in.Name, hashToken(key), in.Scopes, in.ExpiresAt))
+		if err != nil {
+			http.Error(w, "Failed to store the key", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error inserting API key", "error", err)
+			return
+		}
+		logAudit(r.Context(), pool, auditAPIKeyCreate, strconv.Itoa(created.ID), nil, created)
+		created.Key = key
+		writeJSONStatus(w, http.StatusCreated, created)
+	}
+}
+
+// getListAPIKeysHandler handles GET /api-keys, including revoked and expired keys
+func getListAPIKeysHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := pool.Query(r.Context(), "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		keys := []APIKey{}
+		for rows.Next() {
+			k, err := scanAPIKey(rows)
+			if err != nil {
+				http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+				return
+			}
+			keys = append(keys, k)
+		}
+		writeJSON(w, keys)
+	}
+}
+
+// getRevokeAPIKeyHandler handles DELETE /api-keys/{id}; the key stops working at once but stays listed
+func getRevokeAPIKeyHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseAPIKeyID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		revoked, err := scanAPIKey(pool.QueryRow(r.Context(),
+			"UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL RETURNING "+apiKeyColumns, id))
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "API key not found or already revoked", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to revoke the key", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error revoking API key", "error", err)
+			return
+		}
+		logAudit(r.Context(), pool, auditAPIKeyRevoke, strconv.Itoa(id), nil, revoked)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getRotateAPIKeyHandler handles POST /api-keys/{id}/rotate, issuing a new key with the same name,
+// scopes and expiry. The old key keeps working for the 'grace' period, so clients can switch over.
+func getRotateAPIKeyHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseAPIKeyID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		grace, err := parseDurationParam(r, "grace")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key, err := randomToken()
+		if err != nil {
+			http.Error(w, "Failed to generate key", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error generating API key", "error", err)
+			return
+		}
+
+		tx, err := pool.Begin(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to rotate the key", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error starting transaction", "error", err)
+			return
+		}
+		defer tx.Rollback(context.Background())
+
+		old, err := scanAPIKey(tx.QueryRow(r.Context(), "SELECT "+apiKeyColumns+
+			" FROM api_keys WHERE id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now()) FOR UPDATE", id))
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "API key not found, revoked or expired", http.StatusNotFound)
+			return
+		}
+		var rotated APIKey
+		if err == nil {
+			rotated, err = scanAPIKey(tx.QueryRow(r.Context(),
+				"INSERT INTO api_keys (name, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4) RETURNING "+apiKeyColumns,
+				old.Name, hashToken(key), old.Scopes, old.ExpiresAt))
+		}
+		// Without a grace period the old key is revoked; otherwise it expires when the period ends
+		if err == nil && grace == 0 {
+			_, err = tx.Exec(r.Context(), "UPDATE api_keys SET revoked_at = now() WHERE id = $1", id)
+		} else if err == nil {
+			_, err = tx.Exec(r.Context(), "UPDATE api_keys SET expires_at = least(expires_at, $2) WHERE id = $1", id, time.Now().Add(grace))
+		}
+		if err == nil {
+			err = recordAudit(r.Context(), tx, auditAPIKeyRotate, strconv.Itoa(id), old, rotated)
+		}
+		if err == nil {
+			err = tx.Commit(r.Context())
+		}
+		if err != nil {
+			http.Error(w, "Failed to rotate the key", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error rotating API key", "error", err)
+			return
+		}
+		rotated.Key = key
+		writeJSONStatus(w, http.StatusCreated, rotated)
+	}
+}
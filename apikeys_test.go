@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateAPIKeyHandlerRejectsBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "empty",
+			body: `{}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"name","message":"is required"},{"field":"scopes","message":"must grant at least one scope"}]}`,
+		},
+		{
+			name: "unknown scope",
+			body: `{"name": "sensor-1", "scopes": ["ingest", "write"]}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"scopes[1]","message":"must be one of [read ingest admin]"}]}`,
+		},
+		{
+			name: "expired",
+			body: `{"name": "sensor-1", "scopes": ["ingest"], "expires_at": "2020-01-01T00:00:00Z"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"expires_at","message":"must lie in the future"}]}`,
+		},
+		{
+			name: "key given",
+			body: `{"name": "sensor-1", "scopes": ["ingest"], "key": "hunter2"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"","message":"only name, scopes and expires_at can be set"}]}`,
+		},
+		{
+			name: "unknown field",
+			body: `{"name": "sensor-1", "scopes": ["ingest"], "owner": "ops"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"owner","message":"is not a known field"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getCreateAPIKeyHandler(nil)(w, httptest.NewRequest("POST", "/api-keys", strings.NewReader(tt.body)))
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("got body %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyHandlersRejectID(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		id      string
+		query   string
+		body    string
+	}{
+		{name: "revoke", handler: getRevokeAPIKeyHandler(nil), id: "first", body: "invalid API key id 'first'"},
+		{name: "revoke 0", handler: getRevokeAPIKeyHandler(nil), id: "0", body: "invalid API key id '0'"},
+		{name: "rotate", handler: getRotateAPIKeyHandler(nil), id: "-2", body: "invalid API key id '-2'"},
+		{name: "rotate with invalid grace", handler: getRotateAPIKeyHandler(nil), id: "2", query: "grace=soon", body: "invalid 'grace' parameter: expected a duration of at least 1s, such as 5m or 1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api-keys/"+tt.id+"?"+tt.query, nil)
+			r.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
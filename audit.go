@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Audited actions
+const (
+	auditReadingCreate       = "reading.create"
+	auditReadingImport       = "reading.import"
+	auditReadingUpdate       = "reading.update"
+	auditReadingDelete       = "reading.delete"
+	auditWebhookCreate       = "webhook.create"
+	auditWebhookDelete       = "webhook.delete"
+	auditSourceCreate        = "source.create"
+	auditSourceDelete        = "source.delete"
+	auditSigningSecretCreate = "signing_secret.create"
+	auditSigningSecretDelete = "signing_secret.delete"
+)
+
+// AuditEntry is one change in the audit log; Before and After hold the affected record, if any
+type AuditEntry struct {
+	ID     int64           `json:"id"`
+	At     time.Time       `json:"at"`
+	Actor  string          `json:"actor"`
+	Action string          `json:"action"`
+	Target string          `json:"target"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// execer runs a statement on a pool or inside a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// recordAudit logs a change by the caller recorded in ctx; before and after are stored as JSON
+// and may be nil. Changes the server makes on its own, such as readings from the configured
+// sources, carry no caller and are not logged.
+func recordAudit(ctx context.Context, db execer, action, target string, before, after any) error {
+	actor := actorFrom(ctx)
+	if actor == "" {
+		return nil
+	}
+	values := make([]any, 2)
+	for i, v := range []any{before, after} {
+		if v == nil {
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		values[i] = data
+	}
+	_, err := db.Exec(ctx, "INSERT INTO audit_log (actor, action, target, before, after) VALUES ($1, $2, $3, $4, $5)",
+		actor, action, target, values[0], values[1])
+	return err
+}
+
+// logAudit records a change whose write has already been committed, so a failure is only logged
+func logAudit(ctx context.Context, db execer, action, target string, before, after any) {
+	if err := recordAudit(ctx, db, action, target, before, after); err != nil {
+		slog.Error("Error recording an audit log entry", "action", action, "target", target, "error", err)
+	}
+}
+
+// getAuditHandler handles /audit, listing the most recent changes first
+func getAuditHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, err := parsePagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, to, err := parseTimeRange(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter := &queryFilter{}
+		if from != nil {
+			filter.add("at >= $%d", *from)
+		}
+		if to != nil {
+			filter.add("at < $%d", *to)
+		}
+		for _, column := range []string{"actor", "action", "target"} {
+			if value := r.URL.Query().Get(column); value != "" {
+				filter.add(column+" = $%d", value)
+			}
+		}
+
+		var total int
+		if err := pool.QueryRow(r.Context(), "SELECT count(*) FROM audit_log"+filter.where(), filter.args...).Scan(&total); err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		rows, err := pool.Query(r.Context(), "SELECT id, at, actor, action, target, before, after FROM audit_log"+filter.where()+
+			" ORDER BY id DESC LIMIT "+filter.bind(page.Limit)+" OFFSET "+filter.bind(page.Offset), filter.args...)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		entries := []AuditEntry{}
+		for rows.Next() {
+			var e AuditEntry
+			if err := rows.Scan(&e.ID, &e.At, &e.Actor, &e.Action, &e.Target, &e.Before, &e.After); err != nil {
+				http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+				return
+			}
+			entries = append(entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+			return
+		}
+		setPaginationHeaders(w, r, page, total)
+		writeJSON(w, entries)
+	}
+}
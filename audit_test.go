@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// recordingExecer records the arguments of the statements it is given
+type recordingExecer struct {
+	calls [][]any
+	err   error
+}
+
+func (e *recordingExecer) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	e.calls = append(e.calls, args)
+	return pgconn.CommandTag{}, e.err
+}
+
+func TestRecordAudit(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		actor  string
+		before any
+		after  any
+		want   []any
+	}{
+		{name: "server's own change", after: DataPoint{ID: 1, Timestamp: at, Percentage: 40}},
+		{
+			name:  "create",
+			actor: "api-key:sensor-1",
+			after: DataPoint{ID: 1, Timestamp: at, Percentage: 40},
+			want:  []any{"api-key:sensor-1", auditReadingCreate, "1", nil, `{"id":1,"timestamp":"2024-05-01T10:00:00Z","percentage":40}`},
+		},
+		{
+			name:   "update",
+			actor:  "user:ops@pool.example",
+			before: DataPoint{ID: 1, Timestamp: at, Percentage: 40},
+			after:  DataPoint{ID: 1, Timestamp: at, Percentage: 45},
+			want: []any{"user:ops@pool.example", auditReadingCreate, "1",
+				`{"id":1,"timestamp":"2024-05-01T10:00:00Z","percentage":40}`, `{"id":1,"timestamp":"2024-05-01T10:00:00Z","percentage":45}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &recordingExecer{}
+			ctx := context.Background()
+			if tt.actor != "" {
+				ctx = withActorContext(ctx, tt.actor)
+			}
+			if err := recordAudit(ctx, db, auditReadingCreate, "1", tt.before, tt.after); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want == nil {
+				if len(db.calls) != 0 {
+					t.Fatalf("got %d entries, want none", len(db.calls))
+				}
+				return
+			}
+			if len(db.calls) != 1 {
+				t.Fatalf("got %d entries, want 1", len(db.calls))
+			}
+			args := db.calls[0]
+			for i, want := range tt.want {
+				got := args[i]
+				if data, ok := got.([]byte); ok {
+					got = string(data)
+				}
+				if got != want {
+					t.Errorf("argument %d: got %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordAuditError(t *testing.T) {
+	db := &recordingExecer{err: errors.New("connection refused")}
+	err := recordAudit(withActorContext(context.Background(), "token:admin"), db, auditWebhookDelete, "3", nil, nil)
+	if err == nil || err.Error() != "connection refused" {
+		t.Fatalf("got error %v, want %q", err, "connection refused")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Token scopes; each is unlocked by its own bearer token, by an API key granting it or by a JWT
+// with a role that includes it
+const (
+	// scopeAdmin covers managing subscriptions such as webhooks (ADMIN_TOKEN)
+	scopeAdmin = "admin"
+	// scopeIngest covers writing readings (INGEST_TOKEN)
+	scopeIngest = "ingest"
+	// scopeRead covers the read endpoints, which are public unless REQUIRE_READ_AUTH=true (READ_TOKEN)
+	scopeRead = "read"
+)
+
+// tokenVars names the variable holding the bearer token of every scope
+var tokenVars = map[string]string{
+	scopeAdmin:  "ADMIN_TOKEN",
+	scopeIngest: "INGEST_TOKEN",
+	scopeRead:   "READ_TOKEN",
+}
+
+// scopeToken returns the current bearer token of scope, re-read so rotated tokens apply without a restart
+func scopeToken(scope string) string {
+	token, err := secret(tokenVars[scope])
+	if err != nil {
+		slog.Error("Error reading a token", "name", tokenVars[scope], "error", err)
+	}
+	return token
+}
+
+// actorKey is the context key of the caller a request was authenticated as, e.g. "api-key:sensor-1"
+type actorKey struct{}
+
+// withActor records the authenticated caller in the request context for the audit log
+func withActor(r *http.Request, actor string) *http.Request {
+	return r.WithContext(withActorContext(r.Context(), actor))
+}
+
+// withActorContext records the caller in ctx, for callers that are not HTTP requests
+func withActorContext(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// actorFrom returns the caller recorded in ctx, or "" for the server's own work
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// apiKeyIDKey is the context key of the ID of the API key a request was authenticated with
+type apiKeyIDKey struct{}
+
+// withAPIKeyID records the API key the caller was authenticated with, for the per-key rate limit
+func withAPIKeyID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, apiKeyIDKey{}, id)
+}
+
+// apiKeyIDFrom returns the API key recorded in ctx, if the caller was authenticated with one
+func apiKeyIDFrom(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(apiKeyIDKey{}).(int)
+	return id, ok
+}
+
+// authenticator checks the credentials of requests to routes with a scope
+type authenticator struct {
+	pool *pgxpool.Pool
+	// jwt is nil unless JWTs from an identity provider are accepted
+	jwt *jwtVerifier
+	// oidc is nil unless staff can log in with the identity provider
+	oidc *oidcLogin
+	// requireRead restricts the read endpoints to callers with the read scope
+	requireRead bool
+}
+
+// newAuthenticator configures authentication from the environment
+func newAuthenticator(pool *pgxpool.Pool) (*authenticator, error) {
+	verifier, err := newJWTVerifier(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	login, err := newOIDCLogin(context.Background(), pool)
+	if err != nil {
+		return nil, err
+	}
+	return &authenticator{
+		pool:        pool,
+		jwt:         verifier,
+		oidc:        login,
+		requireRead: os.Getenv("REQUIRE_READ_AUTH") == "true",
+	}, nil
+}
+
+// authError is a refused credential, with the status and message the caller is answered with
+type authError struct {
+	status  int
+	message string
+}
+
+func (e *authError) Error() string {
+	return e.message
+}
+
+// errUnauthorized is returned when no credential was given or none was valid
+var errUnauthorized = &authError{status: http.StatusUnauthorized, message: "Unauthorized"}
+
+// require only lets requests through that carry an API key granting scope, the scope's bearer
+// token, or a JWT or login session with a role including scope. An endpoint without any of these
+// configured is reachable with API keys only. Devices with a verified client certificate may always ingest.
+// An API key is decided on alone; a session without the role still passes with a bearer token that has it.
+func (a *authenticator) require(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if device := verifiedDevice(r); scope == scopeIngest && device != "" {
+			next.ServeHTTP(w, withActor(r, "device:"+device))
+			return
+		}
+		if key := r.Header.Get(apiKeyHeader); key != "" {
+			actor, id, err := a.checkAPIKey(r.Context(), key, scope)
+			if err != nil {
+				writeAuthError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, withActor(r.WithContext(withAPIKeyID(r.Context(), id)), actor))
+			return
+		}
+		if a.oidc != nil {
+			session, err := a.oidc.session(r)
+			if err != nil {
+				http.Error(w, "Failed to query the database", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error loading session", "error", err)
+				return
+			}
+			if session != nil && rolesGrant(session.Roles, scope) {
+				next.ServeHTTP(w, withActor(r, "user:"+cmp.Or(session.Email, session.Subject)))
+				return
+			}
+			// A bearer token sent along may grant what the roles of the session do not
+			if session != nil && r.Header.Get("Authorization") == "" {
+				http.Error(w, "None of your roles grants the '"+scope+"' scope", http.StatusForbidden)
+				return
+			}
+		}
+		actor, err := a.checkBearer(scope, r.Header.Get("Authorization"))
+		if err != nil {
+			writeAuthError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, withActor(r, actor))
+	})
+}
+
+// checkAPIKey returns the caller and the ID of an API key if it grants scope. Refused keys are an
+// *authError; other errors mean the keys could not be queried.
+func (a *authenticator) checkAPIKey(ctx context.Context, key, scope string) (string, int, error) {
+	id, name, allowed, err := apiKeyAllows(ctx, a.pool, key, scope)
+	switch {
+	case errors.Is(err, errUnknownAPIKey):
+		return "", 0, errUnauthorized
+	case err != nil:
+		return "", 0, err
+	case !allowed:
+		return "", 0, &authError{status: http.StatusForbidden, message: "The API key does not grant the '" + scope + "' scope"}
+	}
+	return "api-key:" + name, id, nil
+}
+
+// checkBearer returns the caller of an Authorization header carrying the scope's bearer token or
+// a JWT with a role including scope
+func (a *authenticator) checkBearer(scope, header string) (string, error) {
+	token := scopeToken(scope)
+	if token == "" && a.jwt == nil && a.oidc == nil {
+		return "", &authError{status: http.StatusForbidden, message: "This endpoint requires an API key"}
+	}
+	given, ok := strings.CutPrefix(header, "Bearer ")
+	if ok && token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+		return "token:" + scope, nil
+	}
+	if ok && a.jwt != nil {
+		subject, roles, err := a.jwt.verify(given)
+		if err == nil {
+			if !rolesGrant(roles, scope) {
+				return "", &authError{status: http.StatusForbidden, message: "No role of the token grants the '" + scope + "' scope"}
+			}
+			return "jwt:" + subject, nil
+		}
+	}
+	return "", errUnauthorized
+}
+
+// writeAuthError answers a failed credential check
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	var refused *authError
+	if !errors.As(err, &refused) {
+		http.Error(w, "Failed to query the database", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error checking API key", "error", err)
+		return
+	}
+	if refused.status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	http.Error(w, refused.message, refused.status)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticatorRequire(t *testing.T) {
+	resetSecrets(t)
+	t.Setenv("INGEST_TOKEN", "s3cret")
+	t.Setenv("ADMIN_TOKEN", "")
+	tests := []struct {
+		name          string
+		scope         string
+		authorization string
+		status        int
+		body          string
+		actor         string
+	}{
+		{name: "token", scope: scopeIngest, authorization: "Bearer s3cret", status: http.StatusOK, actor: "token:ingest"},
+		{name: "no credentials", scope: scopeIngest, status: http.StatusUnauthorized, body: "Unauthorized"},
+		{name: "wrong token", scope: scopeIngest, authorization: "Bearer guess", status: http.StatusUnauthorized, body: "Unauthorized"},
+		{name: "not a bearer token", scope: scopeIngest, authorization: "Basic czNjcmV0", status: http.StatusUnauthorized, body: "Unauthorized"},
+		{name: "token of another scope", scope: scopeAdmin, authorization: "Bearer s3cret", status: http.StatusForbidden, body: "This endpoint requires an API key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actor string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actor = actorFrom(r.Context())
+			})
+			r := httptest.NewRequest("POST", "/pool-data", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			(&authenticator{}).require(tt.scope, next).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+			if got, want := w.Header().Get("WWW-Authenticate") != "", tt.status == http.StatusUnauthorized; got != want {
+				t.Errorf("got WWW-Authenticate %v, want %v", got, want)
+			}
+			if actor != tt.actor {
+				t.Errorf("got actor %q, want %q", actor, tt.actor)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorRequireSession(t *testing.T) {
+	resetSecrets(t)
+	t.Setenv("INGEST_TOKEN", "s3cret")
+	session := func(roles ...string) [][]any {
+		return [][]any{{"alice", "alice@example.com", roles, time.Now().Add(time.Hour)}}
+	}
+	tests := []struct {
+		name          string
+		sessions      [][]any
+		authorization string
+		status        int
+		body          string
+		actor         string
+	}{
+		{name: "role of the session", sessions: session(roleWriter), status: http.StatusOK, actor: "user:alice@example.com"},
+		{name: "session without the role", sessions: session(roleReader), status: http.StatusForbidden, body: "None of your roles grants the 'ingest' scope"},
+		{name: "token besides the session", sessions: session(roleReader), authorization: "Bearer s3cret", status: http.StatusOK, actor: "token:ingest"},
+		{name: "wrong token besides the session", sessions: session(roleReader), authorization: "Bearer guess", status: http.StatusUnauthorized, body: "Unauthorized"},
+		{name: "expired session", authorization: "Bearer s3cret", status: http.StatusOK, actor: "token:ingest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actor string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				actor = actorFrom(r.Context())
+			})
+			r := httptest.NewRequest("POST", "/pool-data", nil)
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "session-id"})
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			auth := &authenticator{oidc: &oidcLogin{db: &fakeQuerier{rows: tt.sessions}}}
+			auth.require(scopeIngest, next).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+			if actor != tt.actor {
+				t.Errorf("got actor %q, want %q", actor, tt.actor)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backfillStateFile is written to the dump directory unless -state is given
+const backfillStateFile = ".backfill-state.json"
+
+// backfillState records which files a backfill has loaded, so an interrupted run can resume
+type backfillState struct {
+	Files map[string]backfillFile `json:"files"`
+}
+
+// backfillFile is the outcome of loading one dump file
+type backfillFile struct {
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	Readings    int       `json:"readings"`
+	Inserted    int64     `json:"inserted"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+func loadBackfillState(path string) (*backfillState, error) {
+	state := &backfillState{Files: map[string]backfillFile{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	return state, nil
+}
+
+// save writes the state atomically, so a crash never leaves a truncated file behind
+func (s *backfillState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runBackfill implements the backfill subcommand:
+//
+//	pool-api backfill [flags] DIR
+//
+// It loads every .json, .ndjson/.jsonl and .csv file below DIR (optionally gzipped) in path order.
+// Each file is stored in one transaction with duplicate timestamps skipped, and recorded in the
+// state file afterwards; files already recorded there with the same size and modification time
+// are skipped, so an interrupted backfill is resumed by running it again.
+func runBackfill(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	statePath := flags.String("state", "", "state file for resuming (default DIR/"+backfillStateFile+")")
+	timestampField := flags.String("timestamp-field", "timestamp", "name of the timestamp field or column")
+	percentageField := flags.String("percentage-field", "percentage", "name of the percentage field or column")
+	timeFormat := flags.String("time-format", "auto", "rfc3339, unix, auto or a Go reference layout")
+	tz := flags.String("tz", "", "IANA time zone of timestamps without an offset (default POOL_TIMEZONE)")
+	delimiter := flags.String("delimiter", ",", "CSV field separator")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: pool-api backfill [flags] DIR")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	dir := flags.Arg(0)
+	if *statePath == "" {
+		*statePath = filepath.Join(dir, backfillStateFile)
+	}
+
+	loc, err := getPoolLocation()
+	if err != nil {
+		return err
+	}
+	if *tz != "" {
+		if loc, err = time.LoadLocation(*tz); err != nil {
+			return fmt.Errorf("invalid -tz: %v", err)
+		}
+	}
+	mapping := csvMapping{
+		timestampColumn:  *timestampField,
+		percentageColumn: *percentageField,
+		timeFormat:       *timeFormat,
+		loc:              loc,
+		delimiter:        []rune(*delimiter)[0],
+	}
+
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Dotfiles include the state file itself
+		if !d.IsDir() && !strings.HasPrefix(d.Name(), ".") && backfillFormat(path) != "" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list %s: %v", dir, err)
+	}
+	state, err := loadBackfillState(*statePath)
+	if err != nil {
+		return err
+	}
+
+	pool, err := getDatabasePool(context.Background())
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := migrateOnStart(pool); err != nil {
+		return err
+	}
+	store, err := newImportStore(pool)
+	if err != nil {
+		return err
+	}
+
+	var totalReadings int
+	var totalInserted int64
+	started := time.Now()
+	for i, path := range files {
+		rel, _ := filepath.Rel(dir, path)
+		progress := fmt.Sprintf("[%d/%d] %s", i+1, len(files), rel)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if done, ok := state.Files[rel]; ok && done.Size == info.Size() && done.ModTime.Equal(info.ModTime()) {
+			slog.Info("Already loaded, skipping", "range", progress)
+			continue
+		}
+
+		readings, err := readBackfillFile(path, mapping)
+		if err != nil {
+			return fmt.Errorf("%s: %v", rel, err)
+		}
+		rows := make([][]any, len(readings))
+		for j, in := range readings {
+			rows[j] = []any{in.Timestamp.UTC(), *in.Percentage}
+		}
+		inserted, err := store.storeAll(context.Background(), rows)
+		if err != nil {
+			return fmt.Errorf("%s: unable to store readings: %v", rel, err)
+		}
+
+		state.Files[rel] = backfillFile{Size: info.Size(), ModTime: info.ModTime(), Readings: len(rows), Inserted: inserted, CompletedAt: time.Now()}
+		if err := state.save(*statePath); err != nil {
+			return fmt.Errorf("unable to save %s: %v", *statePath, err)
+		}
+		totalReadings += len(rows)
+		totalInserted += inserted
+		slog.Info("Loaded", "range", progress, "readings", len(rows), "inserted", inserted, "duplicates", int64(len(rows))-inserted)
+	}
+	slog.Info("Backfill finished", "duration", time.Since(started).Round(time.Second), "readings", totalReadings, "inserted", totalInserted)
+	return nil
+}
+
+// backfillFormat returns "json", "ndjson" or "csv" for the dump files the backfill understands
+func backfillFormat(path string) string {
+	switch filepath.Ext(strings.TrimSuffix(strings.ToLower(path), ".gz")) {
+	case ".json":
+		return "json"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".csv":
+		return "csv"
+	}
+	return ""
+}
+
+// readBackfillFile parses one dump into validated readings
+func readBackfillFile(path string, m csvMapping) ([]ingestReading, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	format := backfillFormat(path)
+	if format == "csv" {
+		return readCSVReadings(r, m)
+	}
+	var records []map[string]any
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if format == "json" {
+		if err := decoder.Decode(&records); err != nil {
+			return nil, fmt.Errorf("expected an array of readings: %v", err)
+		}
+	} else {
+		for decoder.More() {
+			var record map[string]any
+			if err := decoder.Decode(&record); err != nil {
+				return nil, fmt.Errorf("line %d: %v", len(records)+1, err)
+			}
+			records = append(records, record)
+		}
+	}
+
+	now := time.Now()
+	readings := make([]ingestReading, len(records))
+	for i, record := range records {
+		in, err := m.jsonReading(record)
+		if err == nil {
+			err = in.validate(now)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %d: %v", i, err)
+		}
+		readings[i] = in
+	}
+	return readings, nil
+}
+
+// jsonReading maps a decoded JSON object onto a reading; timestamps may be strings in any
+// format the mapping accepts or Unix timestamps, percentages numbers or strings
+func (m csvMapping) jsonReading(record map[string]any) (ingestReading, error) {
+	var in ingestReading
+	switch v := record[m.timestampColumn].(type) {
+	case string:
+		t, err := m.parseTimestamp(v)
+		if err != nil {
+			return in, fmt.Errorf("invalid timestamp: %v", err)
+		}
+		in.Timestamp = &t
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return in, fmt.Errorf("invalid timestamp: %v", err)
+		}
+		t := unixTimestamp(n)
+		in.Timestamp = &t
+	default:
+		return in, fmt.Errorf("'%s' is missing", m.timestampColumn)
+	}
+
+	var value float64
+	var err error
+	switch v := record[m.percentageColumn].(type) {
+	case string:
+		value, err = parseDecimal(v)
+	case json.Number:
+		value, err = v.Float64()
+	default:
+		return in, fmt.Errorf("'%s' is missing", m.percentageColumn)
+	}
+	if err != nil {
+		return in, fmt.Errorf("invalid percentage: %v", err)
+	}
+	percentage := int(math.Round(value))
+	in.Percentage = &percentage
+	return in, nil
+}
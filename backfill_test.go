@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBackfillFormat(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "2023/01.json", want: "json"},
+		{path: "dump.JSON.GZ", want: "json"},
+		{path: "dump.ndjson", want: "ndjson"},
+		{path: "dump.jsonl.gz", want: "ndjson"},
+		{path: "export.csv", want: "csv"},
+		{path: "notes.txt", want: ""},
+		{path: "archive.tar.gz", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := backfillFormat(tt.path); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBackfillFile(t *testing.T) {
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(s))
+		gz.Close()
+		return buf.String()
+	}
+	tests := []struct {
+		name        string
+		file        string
+		content     string
+		percentages []int
+		err         string
+	}{
+		{name: "JSON", file: "a.json", content: `[{"timestamp": "2024-05-01T12:00:00Z", "percentage": 40}, {"timestamp": 1714565700, "percentage": "41,6"}]`, percentages: []int{40, 42}},
+		{name: "NDJSON", file: "a.ndjson", content: "{\"timestamp\": 1714564800000, \"percentage\": 12.4}\n{\"timestamp\": \"2024-05-01T12:15:00Z\", \"percentage\": 13}\n", percentages: []int{12, 13}},
+		{name: "gzipped CSV", file: "a.csv.gz", content: gzipped("timestamp,percentage\n2024-05-01T12:00:00Z,7\n"), percentages: []int{7}},
+		{name: "JSON object", file: "a.json", content: `{"timestamp": "2024-05-01T12:00:00Z", "percentage": 40}`, err: "expected an array of readings: json: cannot unmarshal object into Go value of type []map[string]interface {}"},
+		{name: "broken NDJSON line", file: "a.ndjson", content: "{\"percentage\": 1}\n{\"percentage\": \n", err: "line 2: unexpected EOF"},
+		{name: "missing timestamp", file: "a.json", content: `[{"percentage": 40}]`, err: "reading 0: 'timestamp' is missing"},
+		{name: "missing percentage", file: "a.json", content: `[{"timestamp": 1714564800}]`, err: "reading 0: 'percentage' is missing"},
+		{name: "invalid percentage", file: "a.json", content: `[{"timestamp": 1714564800, "percentage": 400}]`, err: "reading 0: 'percentage' must be between 0 and 100"},
+		{name: "fractional timestamp", file: "a.json", content: `[{"timestamp": 1714564800.5, "percentage": 4}]`, err: `reading 0: invalid timestamp: strconv.ParseInt: parsing "1714564800.5": invalid syntax`},
+	}
+	mapping := csvMapping{timestampColumn: "timestamp", percentageColumn: "percentage", timeFormat: "auto", loc: time.UTC, delimiter: ','}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			readings, err := readBackfillFile(path, mapping)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var percentages []int
+			for _, in := range readings {
+				percentages = append(percentages, *in.Percentage)
+			}
+			if !slices.Equal(percentages, tt.percentages) {
+				t.Errorf("got percentages %v, want %v", percentages, tt.percentages)
+			}
+		})
+	}
+}
+
+func TestBackfillStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), backfillStateFile)
+	state, err := loadBackfillState(path)
+	if err != nil {
+		t.Fatalf("loading a missing state: %v", err)
+	}
+	if len(state.Files) != 0 {
+		t.Fatalf("got %d files in a new state, want none", len(state.Files))
+	}
+	done := backfillFile{Size: 120, ModTime: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Readings: 3, Inserted: 2}
+	state.Files["2024/05.json"] = done
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadBackfillState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Files["2024/05.json"]; got != done {
+		t.Errorf("got %+v, want %+v", got, done)
+	}
+}
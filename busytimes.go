@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Slot is the average occupancy of one weekday/hour slot, e.g. Tuesday 07:00
+type Slot struct {
+	Weekday string  `json:"weekday"`
+	Hour    int     `json:"hour"`
+	Label   string  `json:"label"`
+	Avg     float64 `json:"avg"`
+	Samples int     `json:"samples"`
+}
+
+// BusyTimes lists the busiest slots (busiest first) and the quietest slots (quietest first)
+type BusyTimes struct {
+	Busiest  []Slot `json:"busiest"`
+	Quietest []Slot `json:"quietest"`
+}
+
+const (
+	defaultBusyTimesCount = 3
+	maxBusyTimesCount     = 7 * 24
+)
+
+// getBusyTimesHandler handles the /pool-data/busy-times endpoint and returns the top-N busiest
+// and quietest weekday/hour slots in the requested range
+func getBusyTimesHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := parseIntParam(r, "n", defaultBusyTimesCount)
+		if err != nil || n == 0 || n > maxBusyTimesCount {
+			http.Error(w, fmt.Sprintf("invalid 'n' parameter: must be between 1 and %d", maxBusyTimesCount), http.StatusBadRequest)
+			return
+		}
+
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		slots, err := querySlots(r.Context(), pool, filter, loc)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		writeJSON(w, pickBusyTimes(slots, n))
+	}
+}
+
+// pickBusyTimes takes the n busiest and n quietest of slots, which are sorted busiest first
+func pickBusyTimes(slots []Slot, n int) BusyTimes {
+	// The quietest are taken from the end
+	busy := BusyTimes{Busiest: slots[:min(n, len(slots))], Quietest: []Slot{}}
+	for i := len(slots) - 1; i >= max(len(slots)-n, 0); i-- {
+		busy.Quietest = append(busy.Quietest, slots[i])
+	}
+	return busy
+}
+
+// querySlots returns the average occupancy of every weekday/hour slot with readings, busiest first;
+// the slots follow the wall-clock time of loc when it is set
+func querySlots(ctx context.Context, db querier, filter *queryFilter, loc *time.Location) ([]Slot, error) {
+	local := filter.localTimestamp(loc)
+	query := `SELECT extract(isodow FROM ` + local + `)::int AS dow, extract(hour FROM ` + local + `)::int AS hour,
+		avg(percentage)::float8 AS avg, count(*)
+		FROM pool_usage` + filter.where() + ` GROUP BY dow, hour ORDER BY avg DESC, dow, hour`
+	rows, err := db.Query(ctx, query, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slots := []Slot{}
+	for rows.Next() {
+		var dow int
+		var s Slot
+		if err := rows.Scan(&dow, &s.Hour, &s.Avg, &s.Samples); err != nil {
+			return nil, err
+		}
+		s.Weekday = weekdayNames[dow-1]
+		s.Label = fmt.Sprintf("%s %02d:00", s.Weekday, s.Hour)
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBusyTimesHandlerRejectsParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{name: "zero", query: "n=0", body: "invalid 'n' parameter: must be between 1 and 168"},
+		{name: "more than every slot", query: "n=169", body: "invalid 'n' parameter: must be between 1 and 168"},
+		{name: "negative", query: "n=-1", body: "invalid 'n' parameter: must be between 1 and 168"},
+		{name: "not a number", query: "n=all", body: "invalid 'n' parameter: must be between 1 and 168"},
+		{name: "from after to", query: "from=2024-05-02T00:00:00Z&to=2024-05-01T00:00:00Z", body: "'from' must be before 'to'"},
+		{name: "unknown time zone", query: "n=3&tz=Mars/Olympus", body: "invalid 'tz' parameter: unknown time zone 'Mars/Olympus'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getBusyTimesHandler(&readPool{})(w, httptest.NewRequest("GET", "/pool-data/busy-times?"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestQuerySlots(t *testing.T) {
+	db := &fakeQuerier{rows: [][]any{{2, 7, 80.0, 12}, {7, 18, 35.5, 9}}}
+	slots, err := querySlots(context.Background(), db, rangeFilter(time.Now().Add(-time.Hour), time.Now()), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Slot{
+		{Weekday: "Tuesday", Hour: 7, Label: "Tuesday 07:00", Avg: 80, Samples: 12},
+		{Weekday: "Sunday", Hour: 18, Label: "Sunday 18:00", Avg: 35.5, Samples: 9},
+	}
+	if len(slots) != len(want) || slots[0] != want[0] || slots[1] != want[1] {
+		t.Errorf("got %+v, want %+v", slots, want)
+	}
+	if !strings.Contains(db.sql, "ORDER BY avg DESC, dow, hour") {
+		t.Errorf("got query %q, want the slots busiest first", db.sql)
+	}
+}
+
+func TestPickBusyTimes(t *testing.T) {
+	slot := func(label string) Slot { return Slot{Label: label} }
+	// Busiest first, as querySlots returns them
+	slots := []Slot{slot("Tue 07"), slot("Mon 18"), slot("Sat 10"), slot("Sun 20"), slot("Wed 06")}
+	tests := []struct {
+		name     string
+		slots    []Slot
+		n        int
+		busiest  []string
+		quietest []string
+	}{
+		{name: "top 2", slots: slots, n: 2, busiest: []string{"Tue 07", "Mon 18"}, quietest: []string{"Wed 06", "Sun 20"}},
+		{name: "every slot", slots: slots, n: 5, busiest: []string{"Tue 07", "Mon 18", "Sat 10", "Sun 20", "Wed 06"}, quietest: []string{"Wed 06", "Sun 20", "Sat 10", "Mon 18", "Tue 07"}},
+		{name: "more than there are", slots: slots[:2], n: 3, busiest: []string{"Tue 07", "Mon 18"}, quietest: []string{"Mon 18", "Tue 07"}},
+		{name: "no readings", slots: []Slot{}, n: 3, busiest: []string{}, quietest: []string{}},
+	}
+	labels := func(slots []Slot) string {
+		var labels []string
+		for _, s := range slots {
+			labels = append(labels, s.Label)
+		}
+		return strings.Join(labels, ", ")
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			busy := pickBusyTimes(tt.slots, tt.n)
+			if got, want := labels(busy.Busiest), strings.Join(tt.busiest, ", "); got != want {
+				t.Errorf("got busiest %q, want %q", got, want)
+			}
+			if got, want := labels(busy.Quietest), strings.Join(tt.quietest, ", "); got != want {
+				t.Errorf("got quietest %q, want %q", got, want)
+			}
+			// Both lists are arrays in the JSON, even without readings
+			if busy.Busiest == nil || busy.Quietest == nil {
+				t.Errorf("got nil lists %+v", busy)
+			}
+		})
+	}
+}
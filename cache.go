@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCacheTTL is how long a cached response is served when RESPONSE_CACHE_TTL is unset
+	defaultCacheTTL = 30 * time.Second
+	// maxCacheEntries bounds the number of responses cached in memory; distinct queries beyond it are not cached
+	maxCacheEntries = 1000
+)
+
+// responseCache keeps successful responses of read endpoints for a short time, keyed by the
+// request URL and the negotiated format. Every reading this process stores, corrects, deletes or
+// clears of the spike flag empties it, as does every new reading another process announces, so
+// a response never misses a change made through this process, or with Redis through any replica.
+// Corrections made by other processes without Redis show up once the entries expire.
+type responseCache struct {
+	ttl   time.Duration
+	store cacheStore
+}
+
+// cacheStore holds cached responses, either in this process or in Redis shared by all replicas.
+// Entries belong to a generation that invalidate advances; put drops responses computed for an
+// earlier one, so a response that raced with an insert is never served.
+type cacheStore interface {
+	get(ctx context.Context, key string) (cacheSlot, cachedResponse, bool, error)
+	put(ctx context.Context, slot cacheSlot, entry cachedResponse, ttl time.Duration) error
+	invalidate(ctx context.Context) error
+}
+
+// cacheSlot is where a response for key is stored in the generation current at lookup
+type cacheSlot struct {
+	generation int64
+	key        string
+}
+
+// cachedResponse is one stored response
+type cachedResponse struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// getResponseCache reads RESPONSE_CACHE_TTL, defaulting to defaultCacheTTL; 0 disables the cache
+// and makes it return nil. With REDIS_URL set, responses are kept in Redis instead of in memory.
+func getResponseCache() (*responseCache, error) {
+	ttl := defaultCacheTTL
+	if value := os.Getenv("RESPONSE_CACHE_TTL"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid RESPONSE_CACHE_TTL %q: expected a non-negative duration", value)
+		}
+	}
+	if ttl == 0 {
+		return nil, nil
+	}
+	store, err := newRedisStore()
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return &responseCache{ttl: ttl, store: &memoryStore{entries: map[string]memoryEntry{}}}, nil
+	}
+	return &responseCache{ttl: ttl, store: store}, nil
+}
+
+// invalidate drops every cached response; it is safe to call on a nil cache
+func (c *responseCache) invalidate() {
+	if c == nil {
+		return
+	}
+	if err := c.store.invalidate(context.Background()); err != nil {
+		slog.Error("Error invalidating the response cache", "error", err)
+	}
+}
+
+// watch empties the cache whenever the hub announces a new reading, which covers readings
+// written to the database by other processes
+func (c *responseCache) watch(ctx context.Context, points <-chan DataPoint) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-points:
+			if !ok {
+				return
+			}
+			c.invalidate()
+		}
+	}
+}
+
+// cached wraps a GET handler so its 200 responses are served from the cache until they expire.
+// Headers already set by outer handlers, such as rate limits, are kept on cache hits; X-Cache
+// tells whether the response came from the cache. A nil cache returns next unchanged, and the
+// handler is simply run when the cache cannot be reached.
+func (c *responseCache) cached(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.RequestURI() + "\x00" + r.Header.Get("Accept")
+		slot, entry, ok, err := c.store.get(r.Context(), key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading the response cache", "error", err)
+		}
+		if ok {
+			for name, values := range entry.Header {
+				if _, set := w.Header()[name]; !set {
+					w.Header()[name] = values
+				}
+			}
+			w.Header().Set("X-Cache", "HIT")
+			w.Write(entry.Body)
+			return
+		}
+
+		// Headers present before the handler runs belong to outer handlers and are not stored
+		before := w.Header().Clone()
+		w.Header().Set("X-Cache", "MISS")
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status != http.StatusOK || r.Context().Err() != nil || err != nil {
+			return
+		}
+		header := http.Header{}
+		for name, values := range w.Header() {
+			if _, outer := before[name]; !outer && name != "X-Cache" {
+				header[name] = values
+			}
+		}
+		if err := c.store.put(r.Context(), slot, cachedResponse{Header: header, Body: rec.body.Bytes()}, c.ttl); err != nil {
+			slog.ErrorContext(r.Context(), "Error writing the response cache", "error", err)
+		}
+	})
+}
+
+// memoryStore keeps cached responses in this process
+type memoryStore struct {
+	mu         sync.Mutex
+	generation int64
+	entries    map[string]memoryEntry
+}
+
+// memoryEntry is a cached response with the time it stops being served
+type memoryEntry struct {
+	cachedResponse
+	expires time.Time
+}
+
+func (s *memoryStore) get(ctx context.Context, key string) (cacheSlot, cachedResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := cacheSlot{generation: s.generation, key: key}
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return slot, cachedResponse{}, false, nil
+	}
+	return slot, entry.cachedResponse, true, nil
+}
+
+// put stores a response, first dropping expired entries if the cache is full
+func (s *memoryStore) put(ctx context.Context, slot cacheSlot, entry cachedResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slot.generation != s.generation {
+		return nil
+	}
+	now := time.Now()
+	if len(s.entries) >= maxCacheEntries {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= maxCacheEntries {
+			return nil
+		}
+	}
+	s.entries[slot.key] = memoryEntry{cachedResponse: entry, expires: now.Add(ttl)}
+	return nil
+}
+
+func (s *memoryStore) invalidate(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	clear(s.entries)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisGenerationKey holds a counter that is part of every entry key; bumping it invalidates
+	// the entries of all replicas at once, and the old ones expire on their own
+	redisGenerationKey = "pool-api:cache:generation"
+	// redisTimeout bounds each cache operation so a slow Redis falls back to the database quickly
+	redisTimeout = 100 * time.Millisecond
+)
+
+// redisStore keeps cached responses in Redis, shared by every replica
+type redisStore struct {
+	client *redis.Client
+}
+
+// newRedisStore connects to REDIS_URL, e.g. redis://:password@host:6379/0; it returns nil if unset
+func newRedisStore() (*redisStore, error) {
+	url, err := secret("REDIS_URL")
+	if err != nil {
+		return nil, err
+	}
+	if url == "" {
+		return nil, nil
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+	return &redisStore{client: redis.NewClient(opts)}, nil
+}
+
+// slot looks up the current generation
+func (s *redisStore) slot(ctx context.Context, key string) (cacheSlot, error) {
+	generation, err := s.client.Get(ctx, redisGenerationKey).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return cacheSlot{}, err
+	}
+	return cacheSlot{generation: generation, key: key}, nil
+}
+
+// entryKey returns the Redis key of a slot; cache keys are hashed to bound their length
+func entryKey(slot cacheSlot) string {
+	hash := sha256.Sum256([]byte(slot.key))
+	return "pool-api:cache:" + strconv.FormatInt(slot.generation, 10) + ":" + hex.EncodeToString(hash[:])
+}
+
+func (s *redisStore) get(ctx context.Context, key string) (cacheSlot, cachedResponse, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	slot, err := s.slot(ctx, key)
+	if err != nil {
+		return slot, cachedResponse{}, false, err
+	}
+	value, err := s.client.Get(ctx, entryKey(slot)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return slot, cachedResponse{}, false, nil
+	}
+	if err != nil {
+		return slot, cachedResponse{}, false, err
+	}
+	var entry cachedResponse
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return slot, cachedResponse{}, false, fmt.Errorf("unable to decode cached response: %v", err)
+	}
+	return slot, entry, true, nil
+}
+
+// put stores entry in the generation of slot; after an invalidation that generation is never read again
+func (s *redisStore) put(ctx context.Context, slot cacheSlot, entry cachedResponse, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, entryKey(slot), value, ttl).Err()
+}
+
+func (s *redisStore) invalidate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	return s.client.Incr(ctx, redisGenerationKey).Err()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetResponseCache(t *testing.T) {
+	resetSecrets(t)
+	t.Setenv("REDIS_URL", "")
+	tests := []struct {
+		value string
+		ttl   time.Duration
+		err   string
+	}{
+		{value: "", ttl: defaultCacheTTL},
+		{value: "0"},
+		{value: "5m", ttl: 5 * time.Minute},
+		{value: "-1s", err: `invalid RESPONSE_CACHE_TTL "-1s": expected a non-negative duration`},
+		{value: "30", err: `invalid RESPONSE_CACHE_TTL "30": expected a non-negative duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("RESPONSE_CACHE_TTL", tt.value)
+			cache, err := getResponseCache()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.ttl == 0 {
+				if cache != nil {
+					t.Fatalf("got a cache with TTL %v, want none", cache.ttl)
+				}
+				return
+			}
+			if cache.ttl != tt.ttl {
+				t.Errorf("got TTL %v, want %v", cache.ttl, tt.ttl)
+			}
+		})
+	}
+}
+
+func TestResponseCacheCached(t *testing.T) {
+	cache := &responseCache{ttl: time.Minute, store: &memoryStore{entries: map[string]memoryEntry{}}}
+	calls := 0
+	handler := cache.cached(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "Failed to query the database", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"call":%d}`, calls)
+	}))
+	tests := []struct {
+		name       string
+		target     string
+		accept     string
+		invalidate bool
+		xCache     string
+		body       string
+	}{
+		{name: "first request", target: "/pool-data/latest", xCache: "MISS", body: `{"call":1}`},
+		{name: "repeated request", target: "/pool-data/latest", xCache: "HIT", body: `{"call":1}`},
+		{name: "other format", target: "/pool-data/latest", accept: "text/csv", xCache: "MISS", body: `{"call":2}`},
+		{name: "other query", target: "/pool-data/latest?count=5", xCache: "MISS", body: `{"call":3}`},
+		{name: "error", target: "/pool-data/latest?fail=1", xCache: "MISS", body: "Failed to query the database\n"},
+		{name: "error again", target: "/pool-data/latest?fail=1", xCache: "MISS", body: "Failed to query the database\n"},
+		{name: "after a new reading", target: "/pool-data/latest", invalidate: true, xCache: "MISS", body: `{"call":6}`},
+		{name: "cached again", target: "/pool-data/latest", xCache: "HIT", body: `{"call":6}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.invalidate {
+				cache.invalidate()
+			}
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			// Set by an outer handler, so it must not be replayed from the cache
+			w.Header().Set("X-RateLimit-Remaining", tt.name)
+			handler.ServeHTTP(w, r)
+			if got := w.Header().Get("X-Cache"); got != tt.xCache {
+				t.Errorf("got X-Cache %q, want %q", got, tt.xCache)
+			}
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+			if got := w.Header().Get("X-RateLimit-Remaining"); got != tt.name {
+				t.Errorf("got X-RateLimit-Remaining %q from the cache, want %q", got, tt.name)
+			}
+		})
+	}
+}
+
+func TestMemoryStorePutAfterInvalidate(t *testing.T) {
+	store := &memoryStore{entries: map[string]memoryEntry{}}
+	ctx := context.Background()
+	slot, _, _, _ := store.get(ctx, "/pool-data/latest")
+	// A reading arrives while the response is computed
+	store.invalidate(ctx)
+	store.put(ctx, slot, cachedResponse{Body: []byte("stale")}, time.Minute)
+	if _, _, ok, _ := store.get(ctx, "/pool-data/latest"); ok {
+		t.Error("a response computed before the invalidation was cached")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// predictionWeeks is how much history the quiet-time predictions are based on
+	predictionWeeks = 8
+	// quietSlotsPerDay is the number of predicted quiet hours published per day
+	quietSlotsPerDay = 2
+)
+
+// getQuietTimesCalendarHandler handles the /calendar/quiet-times.ics endpoint and publishes an iCalendar
+// feed of the predicted least busy hours of each of the next seven days, based on the average
+// occupancy of the same weekday and hour in recent weeks
+func getQuietTimesCalendarHandler(reads *readPool, poolLocation *time.Location) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		now := time.Now().In(poolLocation)
+		slots, err := querySlots(r.Context(), pool, rangeFilter(now.AddDate(0, 0, -7*predictionWeeks), now), poolLocation)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		var b strings.Builder
+		line := func(format string, args ...any) {
+			fmt.Fprintf(&b, format+"\r\n", args...)
+		}
+		line("BEGIN:VCALENDAR")
+		line("VERSION:2.0")
+		line("PRODID:-//pool-api//Quiet times//EN")
+		line("CALSCALE:GREGORIAN")
+		line("X-WR-CALNAME:Quiet pool times")
+		stamp := now.UTC().Format("20060102T150405Z")
+
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, poolLocation)
+		for offset := 0; offset < 7; offset++ {
+			day := today.AddDate(0, 0, offset)
+			// Slots are sorted busiest first; only hours with historical readings count as open
+			daySlots := slices.DeleteFunc(slices.Clone(slots), func(s Slot) bool {
+				return s.Weekday != day.Weekday().String()
+			})
+			slices.Reverse(daySlots)
+			for _, slot := range daySlots[:min(quietSlotsPerDay, len(daySlots))] {
+				start := time.Date(day.Year(), day.Month(), day.Day(), slot.Hour, 0, 0, 0, poolLocation)
+				if start.Before(now) {
+					continue
+				}
+				line("BEGIN:VEVENT")
+				line("UID:%s-%02d@%s", start.Format("20060102"), slot.Hour, icalEscape(r.Host))
+				line("DTSTAMP:%s", stamp)
+				line("DTSTART:%s", start.UTC().Format("20060102T150405Z"))
+				line("DTEND:%s", start.Add(time.Hour).UTC().Format("20060102T150405Z"))
+				line("SUMMARY:%s", icalEscape(fmt.Sprintf("Quiet swim: about %.0f%% full", slot.Avg)))
+				line("DESCRIPTION:%s", icalEscape(fmt.Sprintf("Predicted from %d readings on %ss at %02d:00 over the last %d weeks.",
+					slot.Samples, slot.Weekday, slot.Hour, predictionWeeks)))
+				line("TRANSP:TRANSPARENT")
+				line("END:VEVENT")
+			}
+		}
+		line("END:VCALENDAR")
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write([]byte(b.String()))
+	}
+}
+
+// icalEscape escapes a TEXT value as required by RFC 5545
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestICalEscape(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "Quiet swim: about 12% full", want: "Quiet swim: about 12% full"},
+		{in: "Mondays, Tuesdays", want: `Mondays\, Tuesdays`},
+		{in: "lanes; sauna", want: `lanes\; sauna`},
+		{in: `C:\pool`, want: `C:\\pool`},
+		{in: "first line\nsecond line", want: `first line\nsecond line`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := icalEscape(tt.in); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
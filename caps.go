@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const (
+	// defaultMaxResultRows caps the rows of a list result, e.g. minute buckets over about ten weeks
+	defaultMaxResultRows = 100000
+	// defaultMaxResponseBytes caps the size of a response body before compression
+	defaultMaxResponseBytes = 32 << 20
+)
+
+var (
+	// errTooManyRows is returned by queries whose result exceeds the row cap of the request
+	errTooManyRows = errors.New("the result has too many rows")
+	// errResponseTooLarge is returned to handlers writing past the byte cap after the 413 went out
+	errResponseTooLarge = errors.New("the response is too large")
+)
+
+// resultCaps bounds what a single request may return, so one unbounded query cannot exhaust the
+// process's memory; zero disables a cap
+type resultCaps struct {
+	rows  int
+	bytes int
+}
+
+// getResultCaps reads MAX_RESULT_ROWS and MAX_RESPONSE_BYTES
+func getResultCaps() (resultCaps, error) {
+	rows, err := getCap("MAX_RESULT_ROWS", defaultMaxResultRows)
+	if err != nil {
+		return resultCaps{}, err
+	}
+	bytes, err := getCap("MAX_RESPONSE_BYTES", defaultMaxResponseBytes)
+	if err != nil {
+		return resultCaps{}, err
+	}
+	return resultCaps{rows: rows, bytes: bytes}, nil
+}
+
+// getCap reads one non-negative cap from the environment
+func getCap(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a non-negative integer", name, value)
+	}
+	return n, nil
+}
+
+// rowLimitKey is the context key of the row cap of a request
+type rowLimitKey struct{}
+
+// checkRowLimit returns errTooManyRows once a query has read more than the row cap in ctx
+func checkRowLimit(ctx context.Context, n int) error {
+	if limit, ok := ctx.Value(rowLimitKey{}).(int); ok && limit > 0 && n > limit {
+		return fmt.Errorf("%w (more than %d)", errTooManyRows, limit)
+	}
+	return nil
+}
+
+// limit wraps next so queries stop at the row cap and bodies beyond the byte cap are refused
+// with 413. A response that outgrows the byte cap only after part of it was sent is aborted,
+// so the client sees a failed transfer rather than a truncated body.
+func (c resultCaps) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.rows > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), rowLimitKey{}, c.rows))
+		}
+		if c.bytes > 0 {
+			w = &cappedWriter{ResponseWriter: w, ctx: r.Context(), max: c.bytes}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeTooLarge answers 413 with guidance on how to ask for less
+func writeTooLarge(w http.ResponseWriter, reason string) {
+	http.Error(w, reason+"; narrow the time range, use a coarser bucket or fetch the data in pages with 'limit' and 'cursor'",
+		http.StatusRequestEntityTooLarge)
+}
+
+// cappedWriter holds back the status until the first write, so a body that is too large from
+// the start can still be answered with 413
+type cappedWriter struct {
+	http.ResponseWriter
+	// ctx is the request's context, for the log attributes of the request
+	ctx     context.Context
+	max     int
+	written int
+	status  int
+	sent    bool
+	refused bool
+}
+
+func (w *cappedWriter) WriteHeader(status int) {
+	if w.sent || status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if w.refused {
+		return 0, errResponseTooLarge
+	}
+	w.written += len(p)
+	if w.written > w.max {
+		if !w.sent {
+			// The validators describe the refused body, not the error
+			w.Header().Del("ETag")
+			w.Header().Del("Last-Modified")
+			writeTooLarge(w.ResponseWriter, fmt.Sprintf("the response is larger than %d bytes", w.max))
+			w.sent, w.refused = true, true
+			return 0, errResponseTooLarge
+		}
+		slog.WarnContext(w.ctx, "Aborting an oversized response", "max_bytes", w.max)
+		panic(http.ErrAbortHandler)
+	}
+	w.sendHeader()
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends the held-back status before flushing
+func (w *cappedWriter) Flush() {
+	w.sendHeader()
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *cappedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *cappedWriter) sendHeader() {
+	if w.sent {
+		return
+	}
+	w.sent = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
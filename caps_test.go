@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetResultCaps(t *testing.T) {
+	tests := []struct {
+		name  string
+		rows  string
+		bytes string
+		want  resultCaps
+		err   string
+	}{
+		{name: "defaults", want: resultCaps{rows: defaultMaxResultRows, bytes: defaultMaxResponseBytes}},
+		{name: "set", rows: "500", bytes: "1024", want: resultCaps{rows: 500, bytes: 1024}},
+		{name: "disabled", rows: "0", bytes: "0", want: resultCaps{}},
+		{name: "negative rows", rows: "-1", err: `invalid MAX_RESULT_ROWS "-1": expected a non-negative integer`},
+		{name: "invalid bytes", bytes: "32MB", err: `invalid MAX_RESPONSE_BYTES "32MB": expected a non-negative integer`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAX_RESULT_ROWS", tt.rows)
+			t.Setenv("MAX_RESPONSE_BYTES", tt.bytes)
+			got, err := getResultCaps()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRowLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		n    int
+		err  string
+	}{
+		{name: "no cap", ctx: context.Background(), n: 1000000},
+		{name: "at the cap", ctx: context.WithValue(context.Background(), rowLimitKey{}, 100), n: 100},
+		{name: "over the cap", ctx: context.WithValue(context.Background(), rowLimitKey{}, 100), n: 101, err: "the result has too many rows (more than 100)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRowLimit(tt.ctx, tt.n)
+			if tt.err != "" {
+				if !errors.Is(err, errTooManyRows) || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestResultCapsLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		status int
+		body   string
+		etag   string
+	}{
+		{name: "within the cap", writes: []string{"12345", "67890"}, status: http.StatusCreated, body: "1234567890", etag: `"v1"`},
+		{name: "too large from the start", writes: []string{"12345678901"}, status: http.StatusRequestEntityTooLarge,
+			body: "the response is larger than 10 bytes; narrow the time range, use a coarser bucket or fetch the data in pages with 'limit' and 'cursor'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			resultCaps{bytes: 10}.limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"v1"`)
+				w.WriteHeader(http.StatusCreated)
+				for _, s := range tt.writes {
+					if _, err := w.Write([]byte(s)); err != nil {
+						return
+					}
+				}
+			})).ServeHTTP(w, httptest.NewRequest("GET", "/pool-data", nil))
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+			if got := w.Header().Get("ETag"); got != tt.etag {
+				t.Errorf("got ETag %q, want %q", got, tt.etag)
+			}
+		})
+	}
+}
+
+func TestResultCapsLimitAbortsAfterTheHeader(t *testing.T) {
+	defer func() {
+		if got := recover(); got != http.ErrAbortHandler {
+			t.Errorf("got panic %v, want http.ErrAbortHandler", got)
+		}
+	}()
+	resultCaps{bytes: 10}.limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("12345"))
+		w.Write([]byte("67890!"))
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/pool-data", nil))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultChangesLimit is the number of changes returned without a 'limit' parameter
+	defaultChangesLimit = 1000
+	// maxChangesLimit caps the 'limit' parameter
+	maxChangesLimit = 10000
+)
+
+// Change is one entry of the change feed; deletes only carry the id of the removed reading
+type Change struct {
+	Seq        int64      `json:"seq"`
+	Op         string     `json:"op"`
+	ID         int        `json:"id"`
+	Timestamp  *time.Time `json:"timestamp,omitempty"`
+	Percentage *int       `json:"percentage,omitempty"`
+	Suspect    *bool      `json:"suspect,omitempty"`
+	ChangedAt  time.Time  `json:"changed_at"`
+}
+
+// ChangeFeed is a page of the change feed. Next is the 'since' value for the following request,
+// and More reports whether further changes are already waiting. Sequence numbers are handed out
+// in commit order (see migration 0015), so paging with Next never skips a change.
+type ChangeFeed struct {
+	Changes []Change `json:"changes"`
+	Next    int64    `json:"next"`
+	More    bool     `json:"more"`
+}
+
+// getChangesHandler handles /changes, returning the committed changes with a sequence number above 'since' in order
+func getChangesHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		since, err := parseIntParam(r, "since", 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := parseIntParam(r, "limit", defaultChangesLimit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if limit == 0 || limit > maxChangesLimit {
+			http.Error(w, fmt.Sprintf("invalid 'limit' parameter: must be between 1 and %d", maxChangesLimit), http.StatusBadRequest)
+			return
+		}
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Fetch one extra row to learn whether another page follows
+		rows, err := pool.Query(r.Context(),
+			"SELECT seq, op, reading_id, timestamp, percentage, suspect, changed_at FROM pool_usage_changes WHERE seq > $1 ORDER BY seq LIMIT $2",
+			since, limit+1)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		feed := ChangeFeed{Changes: []Change{}, Next: int64(since)}
+		for rows.Next() {
+			var c Change
+			if err := rows.Scan(&c.Seq, &c.Op, &c.ID, &c.Timestamp, &c.Percentage, &c.Suspect, &c.ChangedAt); err != nil {
+				http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+				return
+			}
+			if len(feed.Changes) == limit {
+				feed.More = true
+				break
+			}
+			if c.Timestamp != nil {
+				ts := inLocation(*c.Timestamp, loc)
+				c.Timestamp = &ts
+			}
+			c.ChangedAt = inLocation(c.ChangedAt, loc)
+			feed.Changes = append(feed.Changes, c)
+			feed.Next = c.Seq
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+			return
+		}
+		writeJSON(w, feed)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChangesHandlerRejectsParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{name: "since not a number", query: "since=yesterday", body: "invalid 'since' parameter: expected a non-negative integer"},
+		{name: "negative since", query: "since=-1", body: "invalid 'since' parameter: expected a non-negative integer"},
+		{name: "limit of 0", query: "limit=0", body: "invalid 'limit' parameter: must be between 1 and 10000"},
+		{name: "limit too high", query: "since=5&limit=10001", body: "invalid 'limit' parameter: must be between 1 and 10000"},
+		{name: "unknown time zone", query: "tz=Mars/Olympus", body: "invalid 'tz' parameter: unknown time zone 'Mars/Olympus'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getChangesHandler(&readPool{})(w, httptest.NewRequest("GET", "/changes?"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
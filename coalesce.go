@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescedResponse is the response of a coalesced request, shared with the requests that waited for it
+type coalescedResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	cancelled bool
+}
+
+// coalescer runs concurrent identical requests once, so a dashboard refreshed by many clients
+// at the same moment costs a single round trip to the database
+type coalescer struct {
+	group singleflight.Group
+}
+
+// coalesceKey normalizes a request into the key shared by identical requests: the query string is
+// re-encoded with its parameters sorted, and the Accept header picks the format
+func coalesceKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode() + "\x00" + r.Header.Get("Accept")
+}
+
+// coalesced wraps a GET handler whose response depends only on the request's URL and Accept header.
+// The first request runs the handler and streams its response as usual; identical requests that
+// arrive while it runs wait and are sent a copy. Should the first client go away before the
+// response is complete, the others run the handler themselves.
+func (c *coalescer) coalesced(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leader := false
+		var panicked any
+		v, _, _ := c.group.Do(coalesceKey(r), func() (any, error) {
+			leader = true
+			// Headers present before the handler runs belong to outer handlers and are not shared
+			before := w.Header().Clone()
+			rec := &responseRecorder{ResponseWriter: w}
+			// A panic, such as the abort of an oversized response, is raised again for this request
+			// only; left to singleflight it would be wrapped and raised in every waiting request
+			func() {
+				defer func() { panicked = recover() }()
+				next.ServeHTTP(rec, r)
+			}()
+			if panicked != nil {
+				return &coalescedResponse{cancelled: true}, nil
+			}
+			header := http.Header{}
+			for name, values := range w.Header() {
+				if _, outer := before[name]; !outer {
+					header[name] = values
+				}
+			}
+			return &coalescedResponse{status: rec.status, header: header, body: rec.body.Bytes(), cancelled: r.Context().Err() != nil}, nil
+		})
+		if leader {
+			if panicked != nil {
+				panic(panicked)
+			}
+			return
+		}
+		resp := v.(*coalescedResponse)
+		if resp.cancelled || resp.status == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for name, values := range resp.header {
+			if _, set := w.Header()[name]; !set {
+				w.Header()[name] = values
+			}
+		}
+		w.WriteHeader(resp.status)
+		w.Write(resp.body)
+	})
+}
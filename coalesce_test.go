@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCoalesceKey(t *testing.T) {
+	tests := []struct {
+		name             string
+		a, b             string
+		acceptA, acceptB string
+		same             bool
+	}{
+		{name: "same request", a: "/pool-data?from=2024-05-01T00:00:00Z&limit=50", b: "/pool-data?from=2024-05-01T00:00:00Z&limit=50", same: true},
+		{name: "parameters in another order", a: "/pool-data?from=2024-05-01T00:00:00Z&limit=50", b: "/pool-data?limit=50&from=2024-05-01T00:00:00Z", same: true},
+		{name: "other parameters", a: "/pool-data?limit=50", b: "/pool-data?limit=51"},
+		{name: "other path", a: "/pool-data/latest", b: "/pool-data/stats"},
+		{name: "other format", a: "/pool-data", b: "/pool-data", acceptA: "application/json", acceptB: "text/csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := httptest.NewRequest("GET", tt.a, nil), httptest.NewRequest("GET", tt.b, nil)
+			a.Header.Set("Accept", tt.acceptA)
+			b.Header.Set("Accept", tt.acceptB)
+			if got := coalesceKey(a) == coalesceKey(b); got != tt.same {
+				t.Errorf("got same key %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
+
+func TestCoalescedLeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		body    string
+		panics  bool
+	}{
+		{name: "response", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/csv")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("timestamp,count\n"))
+		}, status: http.StatusOK, body: "timestamp,count\n"},
+		{name: "error", handler: func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid 'limit' parameter", http.StatusBadRequest)
+		}, status: http.StatusBadRequest, body: "invalid 'limit' parameter\n"},
+		{name: "panic", handler: func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}, panics: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if got := recover(); (got != nil) != tt.panics {
+					t.Errorf("got panic %v, want one %v", got, tt.panics)
+				}
+			}()
+			w := httptest.NewRecorder()
+			(&coalescer{}).coalesced(tt.handler).ServeHTTP(w, httptest.NewRequest("GET", "/pool-data", nil))
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Body.String(); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Period describes one side of a comparison
+type Period struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Stats Stats     `json:"stats"`
+}
+
+// ComparePoint pairs a bucket of the current period with the bucket one shift earlier
+type ComparePoint struct {
+	Start    time.Time `json:"start"`
+	Current  *float64  `json:"current"`
+	Previous *float64  `json:"previous"`
+	Delta    *float64  `json:"delta"`
+}
+
+// CompareDelta holds the differences between the current and previous period statistics
+type CompareDelta struct {
+	Mean *float64 `json:"mean"`
+	Min  *int     `json:"min"`
+	Max  *int     `json:"max"`
+	// MeanChange is the relative change of the mean, e.g. 0.1 for 10% busier
+	MeanChange *float64 `json:"mean_change"`
+}
+
+// Comparison is the response of the /pool-data/compare endpoint
+type Comparison struct {
+	Current  Period         `json:"current"`
+	Previous Period         `json:"previous"`
+	Shift    string         `json:"shift"`
+	Series   []ComparePoint `json:"series"`
+	Delta    CompareDelta   `json:"delta"`
+}
+
+// defaultComparePeriod is compared against the period before it when no range is given
+const defaultComparePeriod = 7 * 24 * time.Hour
+
+// parseCompareRanges resolves the current range and the shift back to the previous range.
+// The current range defaults to the last 7 days; the previous range is either given explicitly
+// through 'compare_from', or derived from 'shift' (defaulting to the length of the current range).
+func parseCompareRanges(r *http.Request) (from, to time.Time, shift time.Duration, err error) {
+	fromParam, toParam, err := parseTimeRange(r)
+	if err != nil {
+		return
+	}
+	to = time.Now().UTC()
+	if toParam != nil {
+		to = *toParam
+	}
+	from = to.Add(-defaultComparePeriod)
+	if fromParam != nil {
+		from = *fromParam
+	}
+	if !from.Before(to) {
+		err = fmt.Errorf("'from' must be before 'to'")
+		return
+	}
+
+	compareFrom, err := parseTimeParam(r, "compare_from")
+	if err != nil {
+		return
+	}
+	switch {
+	case compareFrom != nil && r.URL.Query().Has("shift"):
+		err = fmt.Errorf("'compare_from' and 'shift' are mutually exclusive")
+	case compareFrom != nil:
+		shift = from.Sub(*compareFrom)
+		if shift <= 0 {
+			err = fmt.Errorf("'compare_from' must be before 'from'")
+		}
+	case r.URL.Query().Has("shift"):
+		shift, err = parseSpan(r.URL.Query().Get("shift"))
+		if err != nil {
+			err = fmt.Errorf("invalid 'shift' parameter: expected a duration such as 7d or 24h")
+		}
+	default:
+		shift = to.Sub(from)
+	}
+	return
+}
+
+// rangeFilter builds a filter for the half-open range [from, to)
+func rangeFilter(from, to time.Time) *queryFilter {
+	filter := newQueryFilter()
+	filter.add("timestamp >= $%d", from)
+	filter.add("timestamp < $%d", to)
+	return filter
+}
+
+// getCompareHandler handles the /pool-data/compare endpoint; it returns the bucketed series of the
+// current range aligned with the same range shifted back in time, plus delta statistics
+func getCompareHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		from, to, shift, err := parseCompareRanges(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		unit, err := parseBucketUnit(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, to = inLocation(from, loc), inLocation(to, loc)
+
+		comparison := Comparison{
+			Current:  Period{From: from, To: to},
+			Previous: Period{From: from.Add(-shift), To: to.Add(-shift)},
+			Shift:    shift.String(),
+			Series:   []ComparePoint{},
+		}
+		var current, previous []Bucket
+		for _, q := range []struct {
+			period  *Period
+			buckets *[]Bucket
+		}{{&comparison.Current, &current}, {&comparison.Previous, &previous}} {
+			q.period.Stats, err = queryStats(r.Context(), pool, rangeFilter(q.period.From, q.period.To))
+			if err == nil {
+				*q.buckets, err = queryBuckets(r.Context(), pool, unit, rangeFilter(q.period.From, q.period.To), nil, loc)
+			}
+			if err != nil {
+				writeQueryError(w, r, err)
+				return
+			}
+		}
+
+		// Align the previous buckets onto the current period by shifting them forward
+		points := map[time.Time]*ComparePoint{}
+		point := func(start time.Time) *ComparePoint {
+			if _, ok := points[start]; !ok {
+				points[start] = &ComparePoint{Start: start}
+			}
+			return points[start]
+		}
+		for _, b := range current {
+			point(b.Start).Current = &b.Avg
+		}
+		for _, b := range previous {
+			point(b.Start.Add(shift)).Previous = &b.Avg
+		}
+		for _, p := range points {
+			if p.Current != nil && p.Previous != nil {
+				delta := *p.Current - *p.Previous
+				p.Delta = &delta
+			}
+			comparison.Series = append(comparison.Series, *p)
+		}
+		slices.SortFunc(comparison.Series, func(a, b ComparePoint) int { return a.Start.Compare(b.Start) })
+		comparison.Delta = compareStats(comparison.Current.Stats, comparison.Previous.Stats)
+
+		writeJSON(w, comparison)
+	}
+}
+
+// compareStats computes the deltas between two periods' statistics
+func compareStats(current, previous Stats) CompareDelta {
+	var delta CompareDelta
+	if current.Mean != nil && previous.Mean != nil {
+		mean := *current.Mean - *previous.Mean
+		delta.Mean = &mean
+		if *previous.Mean != 0 {
+			change := mean / *previous.Mean
+			delta.MeanChange = &change
+		}
+	}
+	if current.Min != nil && previous.Min != nil {
+		min := *current.Min - *previous.Min
+		delta.Min = &min
+	}
+	if current.Max != nil && previous.Max != nil {
+		max := *current.Max - *previous.Max
+		delta.Max = &max
+	}
+	return delta
+}
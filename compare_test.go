@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCompareRanges(t *testing.T) {
+	week := "from=2024-05-06T00:00:00Z&to=2024-05-13T00:00:00Z"
+	tests := []struct {
+		name  string
+		query string
+		shift time.Duration
+		err   string
+	}{
+		{name: "previous period by default", query: week, shift: 7 * 24 * time.Hour},
+		{name: "shift", query: week + "&shift=1d", shift: 24 * time.Hour},
+		{name: "compare_from", query: week + "&compare_from=2024-04-29T00:00:00Z", shift: 7 * 24 * time.Hour},
+		{name: "odd period", query: "from=2024-05-06T00:00:00Z&to=2024-05-06T06:00:00Z", shift: 6 * time.Hour},
+		{name: "compare_from and shift", query: week + "&compare_from=2024-04-29T00:00:00Z&shift=7d", err: "'compare_from' and 'shift' are mutually exclusive"},
+		{name: "compare_from after from", query: week + "&compare_from=2024-05-07T00:00:00Z", err: "'compare_from' must be before 'from'"},
+		{name: "invalid shift", query: week + "&shift=lastweek", err: "invalid 'shift' parameter: expected a duration such as 7d or 24h"},
+		{name: "from after a default to", query: "from=2999-01-01T00:00:00Z", err: "'from' must be before 'to'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, shift, err := parseCompareRanges(httptest.NewRequest("GET", "/pool-data/compare?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if shift != tt.shift {
+				t.Errorf("got shift %v, want %v", shift, tt.shift)
+			}
+		})
+	}
+}
+
+func TestCompareStats(t *testing.T) {
+	stats := func(min, max int, mean float64) Stats {
+		return Stats{Min: &min, Max: &max, Mean: &mean}
+	}
+	tests := []struct {
+		name     string
+		current  Stats
+		previous Stats
+		want     string
+	}{
+		{name: "busier", current: stats(10, 80, 44), previous: stats(5, 60, 40), want: `{"mean":4,"min":5,"max":20,"mean_change":0.1}`},
+		{name: "previous period empty", current: stats(10, 80, 44), previous: Stats{}, want: `{"mean":null,"min":null,"max":null,"mean_change":null}`},
+		{name: "previous mean of 0", current: stats(0, 10, 5), previous: stats(0, 0, 0), want: `{"mean":5,"min":0,"max":10,"mean_change":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(compareStats(tt.current, tt.previous))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
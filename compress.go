@@ -0,0 +1,207 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// minCompressSize is the body size below which responses are sent as they are; compressing
+// error messages and single readings costs more than it saves
+const minCompressSize = 1024
+
+// incompressibleTypes are media types whose content is already compressed
+var incompressibleTypes = []string{
+	"application/vnd.apache.parquet",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"application/gzip", "application/zip", "application/zstd",
+	"image/png", "image/jpeg", "image/gif", "image/webp",
+}
+
+// encoder is the part of gzip.Writer and zstd.Encoder used to compress responses
+type encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+	Reset(w io.Writer)
+}
+
+// encoderPools reuse encoders across responses, since each holds sizeable buffers
+var encoderPools = map[string]*sync.Pool{
+	"zstd": {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+		return zstdEncoder{enc}
+	}},
+	"gzip": {New: func() any { return gzip.NewWriter(nil) }},
+}
+
+// zstdEncoder adapts zstd.Encoder, whose Reset has no result, to the encoder interface
+type zstdEncoder struct{ *zstd.Encoder }
+
+func (e zstdEncoder) Reset(w io.Writer) { e.Encoder.Reset(w) }
+
+// negotiateEncoding picks zstd or gzip by the q-values of Accept-Encoding, preferring zstd on a
+// tie; it returns "" when the client accepts neither
+func negotiateEncoding(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		for _, encoding := range []string{"zstd", "gzip"} {
+			if (coding == encoding || coding == "*") && (q > bestQ || q == bestQ && encoding == "zstd" && q > 0) {
+				best, bestQ = encoding, q
+			}
+		}
+	}
+	return best
+}
+
+// compressResponses compresses response bodies with the encoding negotiated via Accept-Encoding.
+// Bodies are held back until minCompressSize bytes have been written, the handler flushes or it
+// returns, so small responses go out uncompressed. WebSocket upgrades and HEAD requests pass through.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r)
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of a response to decide whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	enc      encoder
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses such as 103 Early Hints go out at once and are followed by the real one
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if len(w.buf)+len(p) < minCompressSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		w.decide(true)
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been written so far, compressing streamed responses from the start
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to set deadlines
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide writes the header, compressed if compress is set and the content type allows it,
+// followed by the buffered start of the body
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// Sniff now; the server would otherwise sniff the compressed bytes
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if compress && header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) &&
+		w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		w.enc = encoderPools[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return
+	}
+	if w.enc != nil {
+		w.enc.Write(w.buf)
+	} else {
+		w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+}
+
+// close sends a response that stayed below minCompressSize, or finishes the compressed stream
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			return
+		}
+		w.decide(false)
+	}
+	if w.enc != nil {
+		_ = w.enc.Close()
+		w.enc.Reset(nil)
+		encoderPools[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
+
+// compressible reports whether responses of contentType are worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	for _, t := range incompressibleTypes {
+		if mediaType == t {
+			return false
+		}
+	}
+	return true
+}
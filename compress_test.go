@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "gzip", want: "gzip"},
+		{accept: "gzip, deflate, br, zstd", want: "zstd"},
+		{accept: "zstd;q=0.5, gzip", want: "gzip"},
+		{accept: "*", want: "zstd"},
+		{accept: "gzip;q=0", want: ""},
+		{accept: "*;q=0", want: ""},
+		{accept: "br, deflate", want: ""},
+		{accept: "zstd;q=high, gzip;q=0.2", want: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pool-data", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			if got := negotiateEncoding(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressResponses(t *testing.T) {
+	large := strings.Repeat(`{"id":1,"percentage":42},`, 100)
+	decode := map[string]func(r io.Reader) ([]byte, error){
+		"": io.ReadAll,
+		"gzip": func(r io.Reader) ([]byte, error) {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(zr)
+		},
+		"zstd": func(r io.Reader) ([]byte, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			return io.ReadAll(zr)
+		},
+	}
+	tests := []struct {
+		name        string
+		method      string
+		accept      string
+		contentType string
+		status      int
+		body        string
+		encoding    string
+	}{
+		{name: "gzip", accept: "gzip", contentType: "application/json", status: http.StatusOK, body: large, encoding: "gzip"},
+		{name: "zstd", accept: "zstd, gzip", contentType: "application/json", status: http.StatusOK, body: large, encoding: "zstd"},
+		{name: "not accepted", accept: "br", contentType: "application/json", status: http.StatusOK, body: large},
+		{name: "small body", accept: "gzip", contentType: "application/json", status: http.StatusOK, body: `{"id":1}`},
+		{name: "already compressed", accept: "gzip", contentType: "application/vnd.apache.parquet", status: http.StatusOK, body: large},
+		{name: "error with a large body", accept: "gzip", contentType: "text/plain; charset=utf-8", status: http.StatusBadRequest, body: large, encoding: "gzip"},
+		{name: "HEAD", method: "HEAD", accept: "gzip", contentType: "application/json", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				// Written in pieces, so the decision waits for the buffer to fill
+				for i := 0; i < len(tt.body); i += 100 {
+					io.WriteString(w, tt.body[i:min(i+100, len(tt.body))])
+				}
+			}))
+			r := httptest.NewRequest(cmp.Or(tt.method, "GET"), "/pool-data", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tt.encoding)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want Accept-Encoding", got)
+			}
+			body, err := decode[tt.encoding](bytes.NewReader(w.Body.Bytes()))
+			if err != nil {
+				t.Fatalf("unable to decode the body: %v", err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("got body of %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// dataVersion returns the version of the readings and the time it last changed. A trigger
+// advances it with every statement writing pool_usage (see migration 0016), so responses
+// computed from pool_usage can only differ once the version has moved.
+func dataVersion(ctx context.Context, pool *pgxpool.Pool) (version int64, changedAt *time.Time, err error) {
+	err = pool.QueryRow(ctx, "SELECT version, changed_at FROM pool_usage_version").Scan(&version, &changedAt)
+	return version, changedAt, err
+}
+
+// conditional wraps a GET handler over the readings with ETag and Last-Modified validators and
+// answers 304 Not Modified when the client's copy is still current. The ETag combines the data
+// version with the Accept and Accept-Encoding headers, since the same URL may be served in several
+// formats and encodings. Requests
+// with a 'last' range move with the clock rather than the data and are passed through as they are.
+func conditional(reads *readPool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("last") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		version, changedAt, err := dataVersion(r.Context(), reads.pool())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error reading the data version", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		variant := sha256.Sum256([]byte(r.Header.Get("Accept") + "\x00" + r.Header.Get("Accept-Encoding")))
+		etag := fmt.Sprintf(`"%d-%s"`, version, hex.EncodeToString(variant[:4]))
+		w.Header().Set("ETag", etag)
+		if changedAt != nil {
+			w.Header().Set("Last-Modified", changedAt.UTC().Format(http.TimeFormat))
+		}
+		if notModified(r, etag, changedAt) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when the request has no ETags to match
+func notModified(r *http.Request, etag string, changedAt *time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || changedAt == nil {
+		return false
+	}
+	return !changedAt.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	changedAt := time.Date(2024, 5, 1, 10, 0, 0, 500_000_000, time.UTC)
+	etag := `"42-1a2b3c4d"`
+	tests := []struct {
+		name            string
+		ifNoneMatch     string
+		ifModifiedSince string
+		changedAt       *time.Time
+		want            bool
+	}{
+		{name: "no validators", changedAt: &changedAt, want: false},
+		{name: "matching ETag", ifNoneMatch: etag, changedAt: &changedAt, want: true},
+		{name: "weak ETag in a list", ifNoneMatch: `"41-1a2b3c4d", W/"42-1a2b3c4d"`, changedAt: &changedAt, want: true},
+		{name: "any ETag", ifNoneMatch: "*", want: true},
+		{name: "older ETag", ifNoneMatch: `"41-1a2b3c4d"`, changedAt: &changedAt, want: false},
+		// A mismatching ETag wins over a date that would match
+		{name: "older ETag with a date", ifNoneMatch: `"41-1a2b3c4d"`, ifModifiedSince: "Wed, 01 May 2024 10:00:00 GMT", changedAt: &changedAt, want: false},
+		{name: "same second", ifModifiedSince: "Wed, 01 May 2024 10:00:00 GMT", changedAt: &changedAt, want: true},
+		{name: "changed since", ifModifiedSince: "Wed, 01 May 2024 09:59:59 GMT", changedAt: &changedAt, want: false},
+		{name: "invalid date", ifModifiedSince: "yesterday", changedAt: &changedAt, want: false},
+		{name: "never changed", ifModifiedSince: "Wed, 01 May 2024 10:00:00 GMT", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pool-data", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			if tt.ifModifiedSince != "" {
+				r.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			}
+			if got := notModified(r, etag, tt.changedAt); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionalPassesLastThrough(t *testing.T) {
+	called := false
+	handler := conditional(&readPool{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/pool-data?last=1h", nil))
+	if !called || w.Header().Get("ETag") != "" {
+		t.Errorf("got handler called %v and ETag %q, want the request passed through without validators", called, w.Header().Get("ETag"))
+	}
+}
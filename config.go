@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting is one configuration variable. Every setting can be given in the configuration file,
+// the environment or, unless it is secret, as a command-line flag named after it, e.g.
+// -http-addr for HTTP_ADDR.
+type setting struct {
+	name  string
+	usage string
+	// secret settings are redacted in the configuration dump and have no flag, so they don't
+	// end up in shell histories and process listings; <name>_FILE may be set instead
+	secret bool
+}
+
+// settings lists every variable the API reads, in the order of the configuration dump
+var settings = []setting{
+	{name: "DATABASE_URL", usage: "PostgreSQL connection string of the primary", secret: true},
+	{name: "DATABASE_REPLICA_URL", usage: "connection string of a read replica for the read endpoints", secret: true},
+	{name: "REPLICA_MAX_LAG", usage: "replication lag after which reads go back to the primary (default 10s)"},
+	{name: "DB_STARTUP_TIMEOUT", usage: "how long startup waits for the database to come up, 0 fails right away (default 1m)"},
+	{name: "MIGRATE_ON_START", usage: "apply the schema migrations at startup, true or false (default true)"},
+	{name: "DB_MAX_CONNS", usage: "maximum connections per pool"},
+	{name: "DB_MIN_CONNS", usage: "connections kept open per pool"},
+	{name: "DB_MAX_CONN_LIFETIME", usage: "duration after which connections are replaced"},
+	{name: "DB_MAX_CONN_IDLE_TIME", usage: "duration after which idle connections are closed"},
+	{name: "DB_HEALTH_CHECK_PERIOD", usage: "interval of the connection health checks"},
+	{name: "DB_QUERY_EXEC_MODE", usage: "pgx query execution mode, e.g. exec for PgBouncer in transaction mode"},
+	{name: "DB_STATEMENT_CACHE_CAPACITY", usage: "prepared statements cached per connection"},
+	{name: "DB_TAG_REQUESTS", usage: "set application_name to the request id, true or false"},
+	{name: "SLOW_QUERY_THRESHOLD", usage: "duration above which queries are logged, 0 disables (default 1s)"},
+	{name: "QUERY_TIMEOUT", usage: "timeout of the read endpoints, 0 disables (default 30s)"},
+	{name: "MAX_RESULT_ROWS", usage: "rows a response may hold, 0 disables"},
+	{name: "MAX_RESPONSE_BYTES", usage: "bytes a response may hold, 0 disables"},
+	{name: "TIMESCALEDB", usage: "convert pool_usage to a TimescaleDB hypertable when migrating, auto, on or off (default auto)"},
+	{name: "TIMESCALEDB_COMPRESS_AFTER", usage: "age after which chunks are compressed, 0 disables (default 720h)"},
+	{name: "ROLLUP_INTERVAL", usage: "how often hourly rollups are brought up to date (default 1m)"},
+	{name: "POOL_TIMEZONE", usage: "time zone of the pool for local days and hours (default UTC)"},
+	{name: "POLL_INTERVAL", usage: "how often the database is polled for new readings (default 10s)"},
+	{name: "DEDUP_WINDOW", usage: "duration within which repeated values are not stored, 0 disables (default 2m)"},
+	{name: "SPIKE_MODE", usage: "handling of implausible jumps, off, flag or reject (default off)"},
+	{name: "SPIKE_MAX_DELTA", usage: "largest plausible change in percentage points (default 40)"},
+	{name: "SPIKE_WINDOW", usage: "how far back the previous reading may lie to be compared with (default 30m)"},
+	{name: "RESPONSE_CACHE_TTL", usage: "lifetime of cached responses, 0 disables (default 30s)"},
+	{name: "REDIS_URL", usage: "Redis to share the response cache between instances", secret: true},
+
+	{name: "HTTP_ADDR", usage: "listen address of the HTTP server (default :8080, or :80 with autocert)"},
+	{name: "TLS_ADDR", usage: "listen address of the HTTPS server (default :8443, or :443 with autocert)"},
+	{name: "TLS_CERT_FILE", usage: "certificate of the HTTPS server"},
+	{name: "TLS_KEY_FILE", usage: "private key of the HTTPS server"},
+	{name: "AUTOCERT_DOMAINS", usage: "comma-separated domains to get certificates for from Let's Encrypt"},
+	{name: "AUTOCERT_EMAIL", usage: "contact address for Let's Encrypt"},
+	{name: "AUTOCERT_CACHE_DIR", usage: "directory the certificates from Let's Encrypt are kept in"},
+	{name: "GRPC_ADDR", usage: "listen address of the gRPC API, e.g. :9090; the API is off when unset"},
+	{name: "MTLS_ADDR", usage: "listen address of the ingest server for devices with client certificates"},
+	{name: "MTLS_CA_FILE", usage: "CA that issues the device certificates"},
+	{name: "MTLS_CERT_FILE", usage: "certificate of the ingest server (default TLS_CERT_FILE)"},
+	{name: "MTLS_KEY_FILE", usage: "private key of the ingest server (default TLS_KEY_FILE)"},
+	{name: "MTLS_REQUIRED", usage: "accept readings only on the mTLS listener, refusing gRPC Ingest and MQTT ingest, true or false"},
+	{name: "PPROF_ADDR", usage: "listen address of the profiling endpoints"},
+	{name: "SHUTDOWN_TIMEOUT", usage: "how long requests in flight may take to finish on shutdown (default 25s)"},
+	{name: "SWAGGER_UI", usage: "serve the API documentation on /docs, true or false"},
+
+	{name: "CORS_ALLOWED_ORIGINS", usage: "comma-separated origins allowed to call the API (default *)"},
+	{name: "CORS_ALLOWED_METHODS", usage: "comma-separated methods allowed across origins"},
+	{name: "CORS_ALLOWED_HEADERS", usage: "comma-separated request headers allowed across origins"},
+	{name: "CORS_ALLOW_CREDENTIALS", usage: "allow credentialed requests across origins, true or false"},
+	{name: "CORS_MAX_AGE", usage: "seconds browsers may cache preflight responses"},
+	{name: "RATE_LIMIT_IP", usage: "requests per minute per client address, 0 disables (default 600)"},
+	{name: "RATE_LIMIT_KEY", usage: "requests per minute per API key, 0 disables (default 6000)"},
+	{name: "TRUST_PROXY_HEADERS", usage: "believe X-Forwarded-For from anyone, true or false"},
+	{name: "TRUSTED_PROXIES", usage: "comma-separated networks of the reverse proxies in front of the API"},
+	{name: "INGEST_ALLOW_CIDRS", usage: "comma-separated networks allowed to ingest"},
+	{name: "INGEST_DENY_CIDRS", usage: "comma-separated networks denied ingesting"},
+	{name: "ADMIN_ALLOW_CIDRS", usage: "comma-separated networks allowed to use the admin routes"},
+	{name: "ADMIN_DENY_CIDRS", usage: "comma-separated networks denied the admin routes"},
+	{name: "READ_ALLOW_CIDRS", usage: "comma-separated networks allowed to read"},
+	{name: "READ_DENY_CIDRS", usage: "comma-separated networks denied reading"},
+
+	{name: "ADMIN_TOKEN", usage: "bearer token of the admin scope", secret: true},
+	{name: "INGEST_TOKEN", usage: "bearer token of the ingest scope", secret: true},
+	{name: "READ_TOKEN", usage: "bearer token of the read scope", secret: true},
+	{name: "REQUIRE_READ_AUTH", usage: "require the read scope for the read endpoints, true or false"},
+	{name: "INGEST_SIGNATURES", usage: "set to required to reject unsigned ingest requests"},
+	{name: "JWT_JWKS_URL", usage: "key set of the issuer of accepted JWTs"},
+	{name: "JWT_ISSUER", usage: "required iss claim of JWTs"},
+	{name: "JWT_AUDIENCE", usage: "required aud claim of JWTs"},
+	{name: "JWT_ROLES_CLAIM", usage: "claim of JWTs that lists the roles"},
+	{name: "OIDC_ISSUER", usage: "OpenID Connect provider for the browser login"},
+	{name: "OIDC_CLIENT_ID", usage: "client id at the OpenID Connect provider"},
+	{name: "OIDC_CLIENT_SECRET", usage: "client secret at the OpenID Connect provider", secret: true},
+	{name: "OIDC_REDIRECT_URL", usage: "public URL of /auth/callback"},
+	{name: "OIDC_GROUPS_CLAIM", usage: "claim that lists the groups of a user (default groups)"},
+	{name: "OIDC_ADMIN_GROUPS", usage: "comma-separated groups granted the admin role"},
+	{name: "OIDC_WRITER_GROUPS", usage: "comma-separated groups granted the writer role"},
+	{name: "OIDC_READER_GROUPS", usage: "comma-separated groups granted the reader role"},
+	{name: "SESSION_TTL", usage: "lifetime of login sessions (default 12h)"},
+	{name: "VAULT_ADDR", usage: "Vault to read secrets from"},
+	{name: "VAULT_SECRET_PATH", usage: "KV secret the secrets are read from, e.g. secret/data/pool-api"},
+	{name: "VAULT_TOKEN", usage: "token to read the Vault secret with", secret: true},
+
+	{name: "SCRAPE_URL", usage: "page to scrape the occupancy from"},
+	{name: "SCRAPE_INTERVAL", usage: "how often the page is scraped (default 15m)"},
+	{name: "SCRAPE_JSON_PATH", usage: "dot-separated path to the occupancy in a JSON response"},
+	{name: "SCRAPE_PATTERN", usage: "regular expression whose first group is the occupancy"},
+	{name: "INBOX_DIR", usage: "directory to load dropped dump files from"},
+	{name: "INBOX_INTERVAL", usage: "how often the directory is checked for new files (default 1m)"},
+	{name: "MQTT_BROKER", usage: "MQTT broker, e.g. tcp://localhost:1883"},
+	{name: "MQTT_TOPIC", usage: "topic new readings are published to"},
+	{name: "MQTT_INGEST_TOPIC", usage: "topic readings are ingested from"},
+	{name: "MQTT_CLIENT_ID", usage: "client id at the broker"},
+	{name: "MQTT_USERNAME", usage: "user name at the broker"},
+	{name: "MQTT_PASSWORD", usage: "password at the broker", secret: true},
+	{name: "KAFKA_BROKERS", usage: "comma-separated Kafka brokers to stream new readings to"},
+	{name: "KAFKA_TOPIC", usage: "Kafka topic of new readings"},
+	{name: "INFLUX_TOKEN", usage: "InfluxDB token of the import-influx subcommand", secret: true},
+
+	{name: "LOG_FORMAT", usage: "text or json (default text)"},
+	{name: "LOG_LEVEL", usage: "debug, info, warn or error (default info)"},
+	{name: "ACCESS_LOG", usage: "log requests at the info level, true, or at the debug level, false (default true)"},
+	{name: "SENTRY_DSN", usage: "Sentry project errors are reported to", secret: true},
+	{name: "SENTRY_ENVIRONMENT", usage: "environment errors are reported under"},
+	{name: "OTEL_EXPORTER_OTLP_ENDPOINT", usage: "OTLP/HTTP collector spans are exported to"},
+	{name: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", usage: "OTLP/HTTP endpoint for spans only"},
+}
+
+// config is where the effective settings came from
+type config struct {
+	// sources maps the name of every setting with a value to "file", "environment" or "flag"
+	sources map[string]string
+	// args are the arguments left after the flags, such as a subcommand
+	args []string
+	// print asks for the effective configuration to be printed instead of starting
+	print bool
+}
+
+// loadConfig gathers the settings from, in increasing precedence, the YAML file named by -config
+// or CONFIG_FILE, the environment and the command-line flags. The code reading a setting looks it
+// up in the environment, so values from the file and flags are put there.
+func loadConfig(args []string) (*config, error) {
+	cfg := &config{sources: map[string]string{}}
+	flags := flag.NewFlagSet("pool-api", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: pool-api [flags] [backfill|migrate|import-influx ...]\n\nEvery flag can also be set as the environment variable or configuration file key it is named after.\n\n")
+		flags.PrintDefaults()
+	}
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML `file` of settings, keyed by variable name")
+	flags.BoolVar(&cfg.print, "print-config", false, "validate and print the effective configuration, then exit")
+	values := map[string]*string{}
+	for _, s := range settings {
+		if !s.secret {
+			values[s.name] = flags.String(flagName(s.name), "", s.usage)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.args = flags.Args()
+
+	for _, s := range settings {
+		for _, name := range []string{s.name, s.name + "_FILE"} {
+			if _, ok := os.LookupEnv(name); ok {
+				cfg.sources[name] = "environment"
+			}
+		}
+	}
+	if *path != "" {
+		file, err := readConfigFile(*path)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range file {
+			if _, ok := cfg.sources[name]; !ok {
+				os.Setenv(name, value)
+				cfg.sources[name] = "file"
+			}
+		}
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if f.Name == flagName(s.name) {
+				err = errors.Join(err, os.Setenv(s.name, *values[s.name]))
+				cfg.sources[s.name] = "flag"
+			}
+		}
+	})
+	return cfg, err
+}
+
+// flagName turns a setting's name into its flag, e.g. HTTP_ADDR into http-addr
+func flagName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
+
+// findSetting looks up a setting by name, accepting <name>_FILE for secrets and any OTEL_
+// variable, which the OpenTelemetry SDK reads itself
+func findSetting(name string) (setting, bool) {
+	base, file := strings.CutSuffix(name, "_FILE")
+	for _, s := range settings {
+		if s.name == name || (file && s.secret && s.name == base) {
+			return s, true
+		}
+	}
+	if strings.HasPrefix(name, "OTEL_") {
+		return setting{name: name}, true
+	}
+	return setting{}, false
+}
+
+// readConfigFile reads a YAML mapping of settings. Keys are setting names in either case, with
+// dashes or underscores; lists are joined with commas, as list settings are comma-separated.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the configuration file: %v", err)
+	}
+	var doc map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %v", path, err)
+	}
+	values := map[string]string{}
+	for key, node := range doc {
+		name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if _, ok := findSetting(name); !ok {
+			return nil, fmt.Errorf("invalid configuration file %s: unknown setting %q", path, key)
+		}
+		switch node.Kind {
+		case yaml.ScalarNode:
+			values[name] = node.Value
+		case yaml.SequenceNode:
+			var items []string
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("invalid configuration file %s: %s must be a list of values", path, key)
+				}
+				items = append(items, item.Value)
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("invalid configuration file %s: %s must be a value or a list", path, key)
+		}
+	}
+	return values, nil
+}
+
+// validateConfig checks every setting that can be checked without connecting anywhere, so all
+// mistakes are reported at once rather than one per restart
+func validateConfig() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	check(second(getPoolLocation()))
+	check(second(getServeConfig()))
+	check(second(getMTLSConfig()))
+	check(second(getShutdownTimeout()))
+	check(second(getStartupTimeout()))
+	check(second(getCORSPolicy()))
+	check(second(getRateLimit("RATE_LIMIT_IP", 0)))
+	check(second(getRateLimit("RATE_LIMIT_KEY", 0)))
+	check(second(getIPFilters()))
+	check(second(getQueryTimeout()))
+	check(second(getResultCaps()))
+	check(second(getSlowQueryThreshold()))
+	check(second(getTimescaleMode()))
+	check(second(getCompressAfter()))
+	check(second(getRollupInterval()))
+	check(second(getDedupWindow()))
+	check(second(getSpikeConfig()))
+	check(second(getScraperConfig()))
+	check(second(getMQTTConfig()))
+	check(second(getAccessLog()))
+	check(second(getLogger(nil)))
+	return errors.Join(errs...)
+}
+
+// second returns the error of a getter, dropping its value
+func second[T any](_ T, err error) error {
+	return err
+}
+
+// printConfig writes every setting with a value as NAME=value, followed by where the value came
+// from; secrets are redacted. Unlisted settings take their defaults.
+func (cfg *config) printConfig(w io.Writer) {
+	names := slices.Sorted(maps.Keys(cfg.sources))
+	order := func(name string) int {
+		base := strings.TrimSuffix(name, "_FILE")
+		if i := slices.IndexFunc(settings, func(s setting) bool { return s.name == name || s.name == base }); i >= 0 {
+			return i
+		}
+		return len(settings)
+	}
+	slices.SortStableFunc(names, func(a, b string) int { return order(a) - order(b) })
+	for _, name := range names {
+		value := os.Getenv(name)
+		if s, _ := findSetting(name); s.secret && !strings.HasSuffix(name, "_FILE") && value != "" {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(w, "%s=%s # %s\n", name, value, cfg.sources[name])
+	}
+}
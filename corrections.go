@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// readingPatch is the body accepted by PATCH /pool-data/{id}; omitted fields are left unchanged
+type readingPatch struct {
+	Timestamp  *time.Time `json:"timestamp"`
+	Percentage *int       `json:"percentage"`
+	// Suspect clears or sets the spike flag, e.g. to restore a reading that was wrongly flagged
+	Suspect *bool `json:"suspect"`
+}
+
+// validate checks the fields that are present; invalid values give a *ValidationError
+func (p *readingPatch) validate(now time.Time) error {
+	if p.Timestamp == nil && p.Percentage == nil && p.Suspect == nil {
+		return fmt.Errorf("the body must set at least one of timestamp, percentage or suspect")
+	}
+	var errs fieldErrors
+	if p.Percentage != nil && (*p.Percentage < 0 || *p.Percentage > 100) {
+		errs.add("percentage", "must be between 0 and 100")
+	}
+	if p.Timestamp != nil && p.Timestamp.After(now.Add(maxClockSkew)) {
+		errs.add("timestamp", fmt.Sprintf("must not lie more than %s in the future", maxClockSkew))
+	}
+	return errs.err()
+}
+
+// readingRecord is a reading with its spike flag, as recorded in the audit log
+type readingRecord struct {
+	DataPoint
+	Suspect bool `json:"suspect"`
+}
+
+// parseReadingID reads the {id} path value
+func parseReadingID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid reading id '%s'", r.PathValue("id"))
+	}
+	return id, nil
+}
+
+// getPatchReadingHandler handles PATCH /pool-data/{id} and returns the corrected reading, emptying
+// the response cache
+func getPatchReadingHandler(pool *pgxpool.Pool, cache *responseCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseReadingID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var patch readingPatch
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&patch); err != nil {
+			writeBodyError(w, decodeError(err))
+			return
+		}
+		if err := patch.validate(time.Now()); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		tx, err := pool.Begin(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to update the reading", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error starting transaction", "error", err)
+			return
+		}
+		defer tx.Rollback(context.Background())
+
+		// The old values are locked and returned alongside the new ones for the audit log
+		var before, dp readingRecord
+		err = tx.QueryRow(r.Context(), `WITH old AS (SELECT id, timestamp, percentage, suspect FROM pool_usage WHERE id = $1 FOR UPDATE)
+			UPDATE pool_usage p SET
+			timestamp = coalesce($2, p.timestamp), percentage = coalesce($3, p.percentage), suspect = coalesce($4, p.suspect)
+			FROM old WHERE p.id = old.id
+			RETURNING old.id, old.timestamp, old.percentage, old.suspect, p.id, p.timestamp, p.percentage, p.suspect`,
+			id, patch.Timestamp, patch.Percentage, patch.Suspect).
+			Scan(&before.ID, &before.Timestamp, &before.Percentage, &before.Suspect, &dp.ID, &dp.Timestamp, &dp.Percentage, &dp.Suspect)
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, "Reading not found", http.StatusNotFound)
+			return
+		case errors.As(err, &pgErr) && pgErr.Code == "23505":
+			http.Error(w, "Another reading is already stored for this timestamp", http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, "Failed to update the reading", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error updating reading", "error", err)
+			return
+		}
+		err = recordAudit(r.Context(), tx, auditReadingUpdate, strconv.Itoa(id), before, dp)
+		if err == nil {
+			err = tx.Commit(r.Context())
+		}
+		if err != nil {
+			http.Error(w, "Failed to update the reading", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error updating reading", "error", err)
+			return
+		}
+		cache.invalidate()
+		writeJSON(w, dp.DataPoint)
+	}
+}
+
+// getDeleteReadingHandler handles DELETE /pool-data/{id}, emptying the response cache
+func getDeleteReadingHandler(pool *pgxpool.Pool, cache *responseCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseReadingID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tx, err := pool.Begin(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to delete the reading", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error starting transaction", "error", err)
+			return
+		}
+		defer tx.Rollback(context.Background())
+
+		var before readingRecord
+		err = tx.QueryRow(r.Context(), "DELETE FROM pool_usage WHERE id = $1 RETURNING id, timestamp, percentage, suspect", id).
+			Scan(&before.ID, &before.Timestamp, &before.Percentage, &before.Suspect)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Reading not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			err = recordAudit(r.Context(), tx, auditReadingDelete, strconv.Itoa(id), before, nil)
+		}
+		if err == nil {
+			err = tx.Commit(r.Context())
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete the reading", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error deleting reading", "error", err)
+			return
+		}
+		cache.invalidate()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
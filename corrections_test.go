@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadingPatchValidate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	percentage := func(n int) *int { return &n }
+	at := func(t time.Time) *time.Time { return &t }
+	suspect := false
+	tests := []struct {
+		name  string
+		patch readingPatch
+		err   string
+	}{
+		{name: "percentage", patch: readingPatch{Percentage: percentage(55)}},
+		{name: "timestamp", patch: readingPatch{Timestamp: at(now.Add(-time.Hour))}},
+		{name: "suspect", patch: readingPatch{Suspect: &suspect}},
+		{name: "within clock skew", patch: readingPatch{Timestamp: at(now.Add(maxClockSkew))}},
+		{name: "empty", patch: readingPatch{}, err: "the body must set at least one of timestamp, percentage or suspect"},
+		{name: "percentage too high", patch: readingPatch{Percentage: percentage(101)}, err: "'percentage' must be between 0 and 100"},
+		{name: "negative percentage", patch: readingPatch{Percentage: percentage(-1)}, err: "'percentage' must be between 0 and 100"},
+		{
+			name:  "every field wrong",
+			patch: readingPatch{Percentage: percentage(200), Timestamp: at(now.Add(time.Hour))},
+			err:   "'percentage' must be between 0 and 100; 'timestamp' must not lie more than 5m0s in the future",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.patch.validate(now)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseReadingID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want int
+		err  string
+	}{
+		{id: "1", want: 1},
+		{id: "4711", want: 4711},
+		{id: "0", err: "invalid reading id '0'"},
+		{id: "-3", err: "invalid reading id '-3'"},
+		{id: "latest", err: "invalid reading id 'latest'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/pool-data/"+tt.id, nil)
+			r.SetPathValue("id", tt.id)
+			got, err := parseReadingID(r)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
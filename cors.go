@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Headers browsers may read from responses: pagination, validators, idempotent replays, cache hits, rate limits,
+// request ids and the build
+var corsExposedHeaders = []string{
+	"X-Total-Count", "X-Limit", "X-Offset", "X-Next-Cursor", "Link", "ETag", "Idempotent-Replayed", "X-Cache",
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", "X-Request-ID", "X-Version",
+}
+
+// corsPolicy decides which browser origins may call the API and how
+type corsPolicy struct {
+	// origins lists the allowed origins; "*" allows every origin
+	origins []string
+	// methods restricts the methods allowed cross-origin; empty allows every method a route accepts
+	methods     []string
+	headers     []string
+	credentials bool
+	maxAge      int
+}
+
+// getCORSPolicy reads CORS_ALLOWED_ORIGINS (comma-separated, default *), CORS_ALLOWED_METHODS,
+// CORS_ALLOWED_HEADERS, CORS_ALLOW_CREDENTIALS and CORS_MAX_AGE (seconds) from the environment
+func getCORSPolicy() (*corsPolicy, error) {
+	policy := &corsPolicy{
+		origins: splitList(os.Getenv("CORS_ALLOWED_ORIGINS")),
+		methods: splitList(strings.ToUpper(os.Getenv("CORS_ALLOWED_METHODS"))),
+		headers: splitList(os.Getenv("CORS_ALLOWED_HEADERS")),
+		maxAge:  600,
+	}
+	if len(policy.origins) == 0 {
+		policy.origins = []string{"*"}
+	}
+	if len(policy.headers) == 0 {
+		policy.headers = []string{"Authorization", "Content-Type", "Idempotency-Key", "Last-Event-ID", "If-None-Match", "If-Modified-Since", "X-Request-ID", apiKeyHeader, signatureHeader, signatureSourceHeader}
+	}
+	if value := os.Getenv("CORS_ALLOW_CREDENTIALS"); value != "" {
+		credentials, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS_ALLOW_CREDENTIALS %q", value)
+		}
+		policy.credentials = credentials
+	}
+	// Browsers ignore credentials on wildcard responses, so the origin has to be named
+	if policy.credentials && slices.Contains(policy.origins, "*") {
+		return nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS requires explicit CORS_ALLOWED_ORIGINS")
+	}
+	if value := os.Getenv("CORS_MAX_AGE"); value != "" {
+		maxAge, err := strconv.Atoi(value)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("invalid CORS_MAX_AGE %q: expected seconds", value)
+		}
+		policy.maxAge = maxAge
+	}
+	return policy, nil
+}
+
+// splitList splits a comma-separated setting, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or "" if it is not allowed
+func (p *corsPolicy) allowOrigin(origin string) string {
+	if slices.Contains(p.origins, "*") {
+		return "*"
+	}
+	if slices.Contains(p.origins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// allowWebSocket reports whether a WebSocket handshake from the request's origin may proceed.
+// Browsers do not apply CORS to WebSockets, so the policy is enforced here instead: clients that
+// send no Origin, pages served by the API itself and the allowed origins may connect.
+func (p *corsPolicy) allowWebSocket(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return p.allowOrigin(origin) != ""
+}
+
+// handler adds CORS headers to every response of mux and answers preflight requests for any
+// route, allowing exactly the methods the route is registered for
+func (p *corsPolicy) handler(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := p.allowOrigin(origin)
+
+		requested := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requested != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if allowed == "" || !p.allowMethod(mux, r, requested) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			p.setOrigin(w, allowed)
+			w.Header().Set("Access-Control-Allow-Methods", requested)
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.headers, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(p.maxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed != "" {
+			p.setOrigin(w, allowed)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// setOrigin sets the allowed origin and, if enabled, lets the browser send credentials
+func (p *corsPolicy) setOrigin(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Access-Control-Allow-Origin", allowed)
+	if p.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowMethod reports whether the policy allows method and mux has a route for it at the request path
+func (p *corsPolicy) allowMethod(mux *http.ServeMux, r *http.Request, method string) bool {
+	if len(p.methods) > 0 && !slices.Contains(p.methods, method) {
+		return false
+	}
+	actual := r.Clone(r.Context())
+	actual.Method = method
+	_, pattern := mux.Handler(actual)
+	return pattern != ""
+}
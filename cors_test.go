@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestGetCORSPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		origins     []string
+		credentials bool
+		maxAge      int
+		err         string
+	}{
+		{name: "defaults", origins: []string{"*"}, maxAge: 600},
+		{
+			name:        "credentials",
+			env:         map[string]string{"CORS_ALLOWED_ORIGINS": "https://a.example, ,https://b.example", "CORS_ALLOW_CREDENTIALS": "true", "CORS_MAX_AGE": "60"},
+			origins:     []string{"https://a.example", "https://b.example"},
+			credentials: true,
+			maxAge:      60,
+		},
+		{name: "credentials with every origin", env: map[string]string{"CORS_ALLOW_CREDENTIALS": "true"}, err: "CORS_ALLOW_CREDENTIALS requires explicit CORS_ALLOWED_ORIGINS"},
+		{name: "invalid credentials", env: map[string]string{"CORS_ALLOW_CREDENTIALS": "sometimes"}, err: `invalid CORS_ALLOW_CREDENTIALS "sometimes"`},
+		{name: "invalid max age", env: map[string]string{"CORS_MAX_AGE": "10m"}, err: `invalid CORS_MAX_AGE "10m": expected seconds`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"CORS_ALLOWED_ORIGINS", "CORS_ALLOWED_METHODS", "CORS_ALLOWED_HEADERS", "CORS_ALLOW_CREDENTIALS", "CORS_MAX_AGE"} {
+				t.Setenv(name, tt.env[name])
+			}
+			policy, err := getCORSPolicy()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(policy.origins, tt.origins) || policy.credentials != tt.credentials || policy.maxAge != tt.maxAge {
+				t.Errorf("got origins %v, credentials %v, max age %d, want %v, %v, %d",
+					policy.origins, policy.credentials, policy.maxAge, tt.origins, tt.credentials, tt.maxAge)
+			}
+		})
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pool-data", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("POST /pool-data", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("DELETE /pool-data/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	policy := &corsPolicy{
+		origins:     []string{"https://dashboard.example"},
+		methods:     []string{"GET", "POST"},
+		headers:     []string{"Authorization"},
+		credentials: true,
+		maxAge:      600,
+	}
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		requested   string
+		status      int
+		allowOrigin string
+		allowMethod string
+	}{
+		{name: "same origin", method: "GET", path: "/pool-data", status: http.StatusOK},
+		{name: "allowed origin", method: "GET", path: "/pool-data", origin: "https://dashboard.example", status: http.StatusOK, allowOrigin: "https://dashboard.example"},
+		{name: "other origin", method: "GET", path: "/pool-data", origin: "https://evil.example", status: http.StatusOK},
+		{name: "preflight", method: "OPTIONS", path: "/pool-data", origin: "https://dashboard.example", requested: "POST", status: http.StatusNoContent, allowOrigin: "https://dashboard.example", allowMethod: "POST"},
+		{name: "preflight from another origin", method: "OPTIONS", path: "/pool-data", origin: "https://evil.example", requested: "POST", status: http.StatusNoContent},
+		{name: "preflight for a method without a route", method: "OPTIONS", path: "/pool-data", origin: "https://dashboard.example", requested: "PUT", status: http.StatusNoContent},
+		{name: "preflight for a method the policy excludes", method: "OPTIONS", path: "/pool-data/5", origin: "https://dashboard.example", requested: "DELETE", status: http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.requested != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.requested)
+			}
+			w := httptest.NewRecorder()
+			policy.handler(mux).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.allowOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.allowMethod {
+				t.Errorf("got Access-Control-Allow-Methods %q, want %q", got, tt.allowMethod)
+			}
+			if got, want := w.Header().Get("Access-Control-Allow-Credentials"), tt.allowOrigin != ""; (got == "true") != want {
+				t.Errorf("got Access-Control-Allow-Credentials %q, want it set %v", got, want)
+			}
+			if got, want := len(w.Header().Values("Vary")) > 0, tt.origin != ""; got != want {
+				t.Errorf("got Vary %v, want it set %v", w.Header().Values("Vary"), want)
+			}
+		})
+	}
+}
+
+func TestCORSAllowWebSocket(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{name: "no origin", origins: []string{"https://dashboard.example"}, want: true},
+		{name: "same origin", origins: []string{"https://dashboard.example"}, origin: "https://pool.example", want: true},
+		{name: "allowed origin", origins: []string{"https://dashboard.example"}, origin: "https://dashboard.example", want: true},
+		{name: "other origin", origins: []string{"https://dashboard.example"}, origin: "https://evil.example", want: false},
+		{name: "same host on another port", origins: []string{"https://dashboard.example"}, origin: "https://pool.example:8443", want: false},
+		{name: "every origin", origins: []string{"*"}, origin: "https://evil.example", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://pool.example/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := (&corsPolicy{origins: tt.origins}).allowWebSocket(r); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
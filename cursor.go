@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// errCursorFormat is returned for keyset pagination of JSON:API documents, whose links and meta assume offsets
+var errCursorFormat = errors.New("cursor pagination is not available with format=jsonapi")
+
+// pageCursor is the position of the last point of a page. Points are ordered by (timestamp, id),
+// so the next page starts right after it no matter how many points came before.
+type pageCursor struct {
+	Timestamp time.Time
+	// ID breaks ties between equal timestamps; nil when the client only gave after_timestamp
+	ID *int
+}
+
+// String encodes the cursor as an opaque URL-safe token
+func (c pageCursor) String() string {
+	value := strconv.FormatInt(c.Timestamp.UnixNano(), 10)
+	if c.ID != nil {
+		value += ":" + strconv.Itoa(*c.ID)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// parseCursorToken decodes a token made by pageCursor.String
+func parseCursorToken(token string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return pageCursor{}, err
+	}
+	nanos, id, hasID := strings.Cut(string(raw), ":")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return pageCursor{}, err
+	}
+	c := pageCursor{Timestamp: time.Unix(0, n)}
+	if hasID {
+		i, err := strconv.Atoi(id)
+		if err != nil {
+			return pageCursor{}, err
+		}
+		c.ID = &i
+	}
+	return c, nil
+}
+
+// parseCursor reads keyset pagination parameters: the opaque 'cursor' from a previous page, or
+// 'after_timestamp' with an optional 'after_id'. An empty 'cursor' asks for the first page with
+// a cursor to the next one. keyset reports whether any of them was given; they exclude 'offset'.
+func parseCursor(r *http.Request) (cursor *pageCursor, keyset bool, err error) {
+	q := r.URL.Query()
+	switch {
+	case q.Has("cursor") && (q.Has("after_timestamp") || q.Has("after_id")):
+		return nil, false, fmt.Errorf("'cursor' and 'after_timestamp' are mutually exclusive")
+	case q.Has("after_id") && !q.Has("after_timestamp"):
+		return nil, false, fmt.Errorf("'after_id' requires 'after_timestamp'")
+	case (q.Has("cursor") || q.Has("after_timestamp")) && q.Has("offset"):
+		return nil, false, fmt.Errorf("'offset' cannot be combined with a cursor")
+	case q.Get("cursor") != "":
+		c, err := parseCursorToken(q.Get("cursor"))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid 'cursor' parameter: pass the X-Next-Cursor of a previous page")
+		}
+		return &c, true, nil
+	case q.Has("cursor"):
+		return nil, true, nil
+	case q.Has("after_timestamp"):
+		t, err := parseTimeParam(r, "after_timestamp")
+		if err != nil {
+			return nil, false, err
+		}
+		if t == nil {
+			return nil, false, fmt.Errorf("invalid 'after_timestamp' parameter: expected an RFC3339 timestamp")
+		}
+		c := pageCursor{Timestamp: *t}
+		if q.Has("after_id") {
+			id, err := strconv.Atoi(q.Get("after_id"))
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid 'after_id' parameter: expected an integer")
+			}
+			c.ID = &id
+		}
+		return &c, true, nil
+	}
+	return nil, false, nil
+}
+
+// seek returns a WHERE clause restricting the series to the points after cursor in the order of
+// orderBy, or an empty string for the first page; it binds its arguments into the filter
+func (q *pointsQuery) seek(cursor *pageCursor) string {
+	if cursor == nil {
+		return ""
+	}
+	op := "<"
+	if q.ascending {
+		op = ">"
+	}
+	if cursor.ID == nil {
+		return fmt.Sprintf(" WHERE timestamp %s %s", op, q.filter.bind(cursor.Timestamp))
+	}
+	return fmt.Sprintf(" WHERE (timestamp, id) %s (%s, %s)", op, q.filter.bind(cursor.Timestamp), q.filter.bind(*cursor.ID))
+}
+
+// nextCursorQuery returns the query for the cursor of the last point of a page of limit points
+// from series. It reads the two points around the page boundary, so it costs a page worth of
+// index entries rather than a count of the whole series.
+func (q *pointsQuery) nextCursorQuery(series string, limit int) (string, []any) {
+	args := append(append([]any{}, q.filter.args...), limit-1)
+	return fmt.Sprintf("SELECT timestamp, id FROM %s%s OFFSET $%d LIMIT 2", series, q.orderBy(), len(args)), args
+}
+
+// scanNextCursor reads the result of the nextCursorQuery; it returns nil if no points follow the page
+func scanNextCursor(results pgx.BatchResults) (*pageCursor, error) {
+	rows, err := results.Query()
+	if err != nil {
+		return nil, err
+	}
+	boundary, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pageCursor, error) {
+		var c pageCursor
+		var id int
+		err := row.Scan(&c.Timestamp, &id)
+		c.ID = &id
+		return c, err
+	})
+	if err != nil || len(boundary) < 2 {
+		return nil, err
+	}
+	return &boundary[0], nil
+}
+
+// setCursorHeaders reports the page size and, when more points follow, the cursor of the next
+// page both as X-Next-Cursor and as a Link; keyset pages carry no total, which would cost a count
+func setCursorHeaders(w http.ResponseWriter, r *http.Request, page pagination, next *pageCursor) {
+	w.Header().Set("X-Limit", strconv.Itoa(page.Limit))
+	if next == nil {
+		return
+	}
+	w.Header().Set("X-Next-Cursor", next.String())
+	u := *r.URL
+	q := u.Query()
+	q.Del("after_timestamp")
+	q.Del("after_id")
+	q.Set("cursor", next.String())
+	u.RawQuery = q.Encode()
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
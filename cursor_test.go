@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPageCursorRoundTrip(t *testing.T) {
+	id := 4711
+	tests := []struct {
+		name   string
+		cursor pageCursor
+	}{
+		{name: "with id", cursor: pageCursor{Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC), ID: &id}},
+		{name: "timestamp only", cursor: pageCursor{Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCursorToken(tt.cursor.String())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Timestamp.Equal(tt.cursor.Timestamp) || (got.ID == nil) != (tt.cursor.ID == nil) || got.ID != nil && *got.ID != *tt.cursor.ID {
+				t.Errorf("got %v, want %v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	id := 4711
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	token := pageCursor{Timestamp: at, ID: &id}.String()
+	tests := []struct {
+		name   string
+		query  string
+		want   *pageCursor
+		keyset bool
+		err    string
+	}{
+		{name: "offset pagination", query: "offset=20"},
+		{name: "first page", query: "cursor=", keyset: true},
+		{name: "cursor", query: "cursor=" + token, want: &pageCursor{Timestamp: at, ID: &id}, keyset: true},
+		{name: "after_timestamp", query: "after_timestamp=2024-05-01T12:00:00Z", want: &pageCursor{Timestamp: at}, keyset: true},
+		{name: "after_timestamp and after_id", query: "after_timestamp=2024-05-01T12:00:00Z&after_id=4711", want: &pageCursor{Timestamp: at, ID: &id}, keyset: true},
+		{name: "cursor and after_timestamp", query: "cursor=" + token + "&after_timestamp=2024-05-01T12:00:00Z", err: "'cursor' and 'after_timestamp' are mutually exclusive"},
+		{name: "after_id alone", query: "after_id=4711", err: "'after_id' requires 'after_timestamp'"},
+		{name: "cursor and offset", query: "cursor=&offset=20", err: "'offset' cannot be combined with a cursor"},
+		{name: "made-up cursor", query: "cursor=page-2", err: "invalid 'cursor' parameter: pass the X-Next-Cursor of a previous page"},
+		{name: "invalid after_timestamp", query: "after_timestamp=yesterday", err: "invalid 'after_timestamp' parameter: expected an RFC3339 timestamp"},
+		{name: "empty after_timestamp", query: "after_timestamp=", err: "invalid 'after_timestamp' parameter: expected an RFC3339 timestamp"},
+		{name: "invalid after_id", query: "after_timestamp=2024-05-01T12:00:00Z&after_id=last", err: "invalid 'after_id' parameter: expected an integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, keyset, err := parseCursor(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if keyset != tt.keyset {
+				t.Errorf("got keyset %v, want %v", keyset, tt.keyset)
+			}
+			if (got == nil) != (tt.want == nil) || got != nil && got.String() != tt.want.String() {
+				t.Errorf("got cursor %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointsQuerySeek(t *testing.T) {
+	id := 4711
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		ascending bool
+		cursor    *pageCursor
+		want      string
+	}{
+		{name: "first page", want: ""},
+		{name: "newest first", cursor: &pageCursor{Timestamp: at, ID: &id}, want: " WHERE (timestamp, id) < ($1, $2)"},
+		{name: "oldest first", ascending: true, cursor: &pageCursor{Timestamp: at, ID: &id}, want: " WHERE (timestamp, id) > ($1, $2)"},
+		{name: "timestamp only", ascending: true, cursor: &pageCursor{Timestamp: at}, want: " WHERE timestamp > $1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &pointsQuery{filter: &queryFilter{}, ascending: tt.ascending}
+			if got := q.seek(tt.cursor); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetCursorHeaders(t *testing.T) {
+	id := 4711
+	next := &pageCursor{Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), ID: &id}
+	tests := []struct {
+		name   string
+		target string
+		next   *pageCursor
+		link   string
+	}{
+		{name: "last page", target: "/pool-data?cursor=&limit=50"},
+		{name: "next page", target: "/pool-data?after_timestamp=2024-05-01T00:00:00Z&after_id=3&limit=50", next: next, link: `</pool-data?cursor=` + next.String() + `&limit=50>; rel="next"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setCursorHeaders(w, httptest.NewRequest("GET", tt.target, nil), pagination{Limit: 50}, tt.next)
+			if got := w.Header().Get("X-Limit"); got != "50" {
+				t.Errorf("got X-Limit %q, want 50", got)
+			}
+			if got := w.Header().Get("Link"); got != tt.link {
+				t.Errorf("got Link %q, want %q", got, tt.link)
+			}
+			if got, want := w.Header().Get("X-Next-Cursor") != "", tt.next != nil; got != want {
+				t.Errorf("got X-Next-Cursor %q, want it set %v", w.Header().Get("X-Next-Cursor"), want)
+			}
+		})
+	}
+}
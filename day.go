@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// getDayHandler handles the /pool-data/{date} endpoint and returns all readings of one calendar day
+// (YYYY-MM-DD) in ascending order; the day follows the 'tz' parameter, or the pool's time zone
+func getDayHandler(reads *readPool, poolLocation *time.Location) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if loc == nil {
+			loc = poolLocation
+		}
+		day, err := time.ParseInLocation(time.DateOnly, r.PathValue("date"), loc)
+		if err != nil {
+			http.Error(w, "invalid date: expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+
+		// AddDate keeps the boundaries at local midnight across DST changes
+		rows, err := pool.Query(r.Context(),
+			"SELECT id, timestamp, percentage FROM pool_usage WHERE NOT suspect AND timestamp >= $1 AND timestamp < $2 ORDER BY timestamp, id",
+			day, day.AddDate(0, 0, 1))
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		dataPoints, err := scanDataPoints(rows)
+		if err != nil {
+			http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+			return
+		}
+		for i := range dataPoints {
+			dataPoints[i].Timestamp = dataPoints[i].Timestamp.In(loc)
+		}
+		if dataPoints == nil {
+			dataPoints = []DataPoint{}
+		}
+
+		writeJSON(w, dataPoints)
+	}
+}
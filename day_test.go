@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDayHandlerRejectsDate(t *testing.T) {
+	tests := []struct {
+		name  string
+		date  string
+		query string
+		body  string
+	}{
+		{name: "not a date", date: "today", body: "invalid date: expected YYYY-MM-DD"},
+		{name: "no leading zeros", date: "2024-5-1", body: "invalid date: expected YYYY-MM-DD"},
+		{name: "no such day", date: "2023-02-29", body: "invalid date: expected YYYY-MM-DD"},
+		{name: "with a time", date: "2024-05-01T00:00:00Z", body: "invalid date: expected YYYY-MM-DD"},
+		{name: "unknown time zone", date: "2024-05-01", query: "?tz=Nowhere", body: "invalid 'tz' parameter: unknown time zone 'Nowhere'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pool-data/"+tt.date+tt.query, nil)
+			r.SetPathValue("date", tt.date)
+			w := httptest.NewRecorder()
+			getDayHandler(&readPool{}, time.UTC)(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
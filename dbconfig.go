@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// queryExecModes maps the accepted DB_QUERY_EXEC_MODE values to pgx's modes. The default,
+// cache_statement, prepares every statement once per connection and reuses it; behind a
+// transaction-pooling PgBouncer, which cannot keep prepared statements, use exec or simple_protocol.
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// applyPoolSettings overrides the connection pool settings of DATABASE_URL (pool_max_conns etc.)
+// with DB_MAX_CONNS, DB_MIN_CONNS, DB_MAX_CONN_LIFETIME, DB_MAX_CONN_IDLE_TIME,
+// DB_HEALTH_CHECK_PERIOD, DB_QUERY_EXEC_MODE and DB_STATEMENT_CACHE_CAPACITY where they are set
+func applyPoolSettings(config *pgxpool.Config) error {
+	for name, target := range map[string]*int32{"DB_MAX_CONNS": &config.MaxConns, "DB_MIN_CONNS": &config.MinConns} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s %q: expected a non-negative integer", name, value)
+			}
+			*target = int32(n)
+		}
+	}
+	if config.MaxConns == 0 {
+		return fmt.Errorf("invalid DB_MAX_CONNS: must be at least 1")
+	}
+	if config.MinConns > config.MaxConns {
+		return fmt.Errorf("DB_MIN_CONNS must not exceed DB_MAX_CONNS")
+	}
+
+	durations := map[string]*time.Duration{
+		"DB_MAX_CONN_LIFETIME":   &config.MaxConnLifetime,
+		"DB_MAX_CONN_IDLE_TIME":  &config.MaxConnIdleTime,
+		"DB_HEALTH_CHECK_PERIOD": &config.HealthCheckPeriod,
+	}
+	for name, target := range durations {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid %s %q: expected a positive duration", name, value)
+			}
+			*target = d
+		}
+	}
+
+	if value := os.Getenv("DB_QUERY_EXEC_MODE"); value != "" {
+		mode, ok := queryExecModes[value]
+		if !ok {
+			return fmt.Errorf("invalid DB_QUERY_EXEC_MODE %q: expected cache_statement, cache_describe, describe_exec, exec or simple_protocol", value)
+		}
+		config.ConnConfig.DefaultQueryExecMode = mode
+	}
+	if value := os.Getenv("DB_STATEMENT_CACHE_CAPACITY"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid DB_STATEMENT_CACHE_CAPACITY %q: expected a non-negative integer", value)
+		}
+		config.ConnConfig.StatementCacheCapacity = n
+		config.ConnConfig.DescriptionCacheCapacity = n
+	}
+	return nil
+}
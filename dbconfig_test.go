@@ -0,0 +1,62 @@
+package main
+
+import (
+	"cmp"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestApplyPoolSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		env   map[string]string
+		check func(*pgxpool.Config) bool
+		err   string
+	}{
+		{name: "defaults of the URL", url: "postgres://localhost/pool?pool_max_conns=7", check: func(c *pgxpool.Config) bool { return c.MaxConns == 7 }},
+		{name: "environment overrides the URL", url: "postgres://localhost/pool?pool_max_conns=7", env: map[string]string{"DB_MAX_CONNS": "20", "DB_MIN_CONNS": "2"}, check: func(c *pgxpool.Config) bool { return c.MaxConns == 20 && c.MinConns == 2 }},
+		{name: "durations", env: map[string]string{"DB_MAX_CONN_LIFETIME": "30m", "DB_MAX_CONN_IDLE_TIME": "5m", "DB_HEALTH_CHECK_PERIOD": "10s"}, check: func(c *pgxpool.Config) bool {
+			return c.MaxConnLifetime == 30*time.Minute && c.MaxConnIdleTime == 5*time.Minute && c.HealthCheckPeriod == 10*time.Second
+		}},
+		{name: "exec mode", env: map[string]string{"DB_QUERY_EXEC_MODE": "simple_protocol"}, check: func(c *pgxpool.Config) bool {
+			return c.ConnConfig.DefaultQueryExecMode == pgx.QueryExecModeSimpleProtocol
+		}},
+		{name: "statement cache", env: map[string]string{"DB_STATEMENT_CACHE_CAPACITY": "64"}, check: func(c *pgxpool.Config) bool {
+			return c.ConnConfig.StatementCacheCapacity == 64 && c.ConnConfig.DescriptionCacheCapacity == 64
+		}},
+		{name: "no connections", env: map[string]string{"DB_MAX_CONNS": "0"}, err: "invalid DB_MAX_CONNS: must be at least 1"},
+		{name: "negative connections", env: map[string]string{"DB_MIN_CONNS": "-1"}, err: `invalid DB_MIN_CONNS "-1": expected a non-negative integer`},
+		{name: "min above max", env: map[string]string{"DB_MAX_CONNS": "2", "DB_MIN_CONNS": "3"}, err: "DB_MIN_CONNS must not exceed DB_MAX_CONNS"},
+		{name: "invalid duration", env: map[string]string{"DB_MAX_CONN_LIFETIME": "0s"}, err: `invalid DB_MAX_CONN_LIFETIME "0s": expected a positive duration`},
+		{name: "unknown exec mode", env: map[string]string{"DB_QUERY_EXEC_MODE": "prepared"}, err: `invalid DB_QUERY_EXEC_MODE "prepared": expected cache_statement, cache_describe, describe_exec, exec or simple_protocol`},
+		{name: "invalid statement cache", env: map[string]string{"DB_STATEMENT_CACHE_CAPACITY": "many"}, err: `invalid DB_STATEMENT_CACHE_CAPACITY "many": expected a non-negative integer`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"DB_MAX_CONNS", "DB_MIN_CONNS", "DB_MAX_CONN_LIFETIME", "DB_MAX_CONN_IDLE_TIME", "DB_HEALTH_CHECK_PERIOD", "DB_QUERY_EXEC_MODE", "DB_STATEMENT_CACHE_CAPACITY"} {
+				t.Setenv(name, tt.env[name])
+			}
+			config, err := pgxpool.ParseConfig(cmp.Or(tt.url, "postgres://localhost/pool"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = applyPoolSettings(config)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.check(config) {
+				t.Errorf("settings not applied: %+v", config)
+			}
+		})
+	}
+}
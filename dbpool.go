@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DBPoolStats is a snapshot of a connection pool, showing how close it is to running out of connections
+type DBPoolStats struct {
+	// Pool is "primary" or "replica"
+	Pool                 string  `json:"pool"`
+	MaxConns             int32   `json:"max_conns"`
+	TotalConns           int32   `json:"total_conns"`
+	AcquiredConns        int32   `json:"acquired_conns"`
+	IdleConns            int32   `json:"idle_conns"`
+	ConstructingConns    int32   `json:"constructing_conns"`
+	AcquireCount         int64   `json:"acquire_count"`
+	AcquireDurationSecs  float64 `json:"acquire_duration_seconds"`
+	EmptyAcquireCount    int64   `json:"empty_acquire_count"`
+	CanceledAcquireCount int64   `json:"canceled_acquire_count"`
+	NewConnsCount        int64   `json:"new_conns_count"`
+	MaxLifetimeDestroys  int64   `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroys      int64   `json:"max_idle_destroy_count"`
+}
+
+// poolStats takes a snapshot of the primary pool and the replica's, if there is one
+func poolStats(reads *readPool) []DBPoolStats {
+	pools := []struct {
+		name string
+		pool *pgxpool.Pool
+	}{{"primary", reads.primary}, {"replica", reads.replica}}
+	stats := []DBPoolStats{}
+	for _, p := range pools {
+		if p.pool == nil {
+			continue
+		}
+		s := p.pool.Stat()
+		stats = append(stats, DBPoolStats{
+			Pool:                 p.name,
+			MaxConns:             s.MaxConns(),
+			TotalConns:           s.TotalConns(),
+			AcquiredConns:        s.AcquiredConns(),
+			IdleConns:            s.IdleConns(),
+			ConstructingConns:    s.ConstructingConns(),
+			AcquireCount:         s.AcquireCount(),
+			AcquireDurationSecs:  s.AcquireDuration().Seconds(),
+			EmptyAcquireCount:    s.EmptyAcquireCount(),
+			CanceledAcquireCount: s.CanceledAcquireCount(),
+			NewConnsCount:        s.NewConnsCount(),
+			MaxLifetimeDestroys:  s.MaxLifetimeDestroyCount(),
+			MaxIdleDestroys:      s.MaxIdleDestroyCount(),
+		})
+	}
+	return stats
+}
+
+// getDBPoolHandler reports the connection pool statistics
+func getDBPoolHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, poolStats(reads))
+	}
+}
+
+// poolStatsMetric is one of the connection pool statistics published as a Prometheus metric
+type poolStatsMetric struct {
+	desc  *prometheus.Desc
+	kind  prometheus.ValueType
+	value func(DBPoolStats) float64
+}
+
+// poolStatsCollector publishes the connection pool statistics as Prometheus metrics, labelled by pool
+type poolStatsCollector struct {
+	reads   *readPool
+	metrics []poolStatsMetric
+}
+
+func newPoolStatsCollector(reads *readPool) *poolStatsCollector {
+	metric := func(name, help string, kind prometheus.ValueType, value func(DBPoolStats) float64) poolStatsMetric {
+		return poolStatsMetric{prometheus.NewDesc(name, help, []string{"pool"}, nil), kind, value}
+	}
+	gauge, counter := prometheus.GaugeValue, prometheus.CounterValue
+	return &poolStatsCollector{reads: reads, metrics: []poolStatsMetric{
+		metric("pgxpool_max_conns", "Maximum size of the connection pool.",
+			gauge, func(s DBPoolStats) float64 { return float64(s.MaxConns) }),
+		metric("pgxpool_total_conns", "Connections in the pool, whether acquired, idle or being established.",
+			gauge, func(s DBPoolStats) float64 { return float64(s.TotalConns) }),
+		metric("pgxpool_acquired_conns", "Connections in use.",
+			gauge, func(s DBPoolStats) float64 { return float64(s.AcquiredConns) }),
+		metric("pgxpool_idle_conns", "Connections waiting to be used.",
+			gauge, func(s DBPoolStats) float64 { return float64(s.IdleConns) }),
+		metric("pgxpool_constructing_conns", "Connections being established.",
+			gauge, func(s DBPoolStats) float64 { return float64(s.ConstructingConns) }),
+		metric("pgxpool_acquires_total", "Connections acquired from the pool.",
+			counter, func(s DBPoolStats) float64 { return float64(s.AcquireCount) }),
+		metric("pgxpool_acquire_duration_seconds_total", "Time spent acquiring connections from the pool.",
+			counter, func(s DBPoolStats) float64 { return s.AcquireDurationSecs }),
+		metric("pgxpool_empty_acquires_total", "Acquires that had to wait because no connection was idle.",
+			counter, func(s DBPoolStats) float64 { return float64(s.EmptyAcquireCount) }),
+		metric("pgxpool_canceled_acquires_total", "Acquires cancelled before a connection was available.",
+			counter, func(s DBPoolStats) float64 { return float64(s.CanceledAcquireCount) }),
+		metric("pgxpool_new_conns_total", "Connections opened.",
+			counter, func(s DBPoolStats) float64 { return float64(s.NewConnsCount) }),
+		metric("pgxpool_max_lifetime_destroys_total", "Connections closed for exceeding DB_MAX_CONN_LIFETIME.",
+			counter, func(s DBPoolStats) float64 { return float64(s.MaxLifetimeDestroys) }),
+		metric("pgxpool_max_idle_destroys_total", "Connections closed for exceeding DB_MAX_CONN_IDLE_TIME.",
+			counter, func(s DBPoolStats) float64 { return float64(s.MaxIdleDestroys) }),
+	}}
+}
+
+func (c *poolStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m.desc
+	}
+}
+
+func (c *poolStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range poolStats(c.reads) {
+		for _, m := range c.metrics {
+			ch <- prometheus.MustNewConstMetric(m.desc, m.kind, m.value(s), s.Pool)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPoolStats(t *testing.T) {
+	// Pools connect lazily, so these never reach a server
+	newPool := func(url string) *pgxpool.Pool {
+		pool, err := pgxpool.New(context.Background(), url)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		t.Cleanup(pool.Close)
+		return pool
+	}
+	primary := newPool("postgres://localhost:1/pool?pool_max_conns=8")
+	replica := newPool("postgres://localhost:1/pool?pool_max_conns=4")
+	tests := []struct {
+		name     string
+		reads    *readPool
+		pools    []string
+		maxConns []int32
+	}{
+		{name: "primary only", reads: &readPool{primary: primary}, pools: []string{"primary"}, maxConns: []int32{8}},
+		{name: "with a replica", reads: &readPool{primary: primary, replica: replica}, pools: []string{"primary", "replica"}, maxConns: []int32{8, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pools []string
+			var maxConns []int32
+			for _, s := range poolStats(tt.reads) {
+				pools = append(pools, s.Pool)
+				maxConns = append(maxConns, s.MaxConns)
+			}
+			if !slices.Equal(pools, tt.pools) || !slices.Equal(maxConns, tt.maxConns) {
+				t.Errorf("got pools %v with %v connections, want %v with %v", pools, maxConns, tt.pools, tt.maxConns)
+			}
+			collector := newPoolStatsCollector(tt.reads)
+			if got, want := testutil.CollectAndCount(collector), len(tt.pools)*len(collector.metrics); got != want {
+				t.Errorf("got %d metrics, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// exportBatchSize is the number of rows collected before they are handed to a columnar writer
+const exportBatchSize = 1000
+
+// queryExport queries every reading matching filter in ascending order for an /export endpoint
+func queryExport(ctx context.Context, pool *pgxpool.Pool, filter *queryFilter) (pgx.Rows, error) {
+	return pool.Query(ctx,
+		"SELECT id, timestamp, percentage FROM pool_usage"+filter.where()+" ORDER BY timestamp, id", filter.args...)
+}
+
+// setAttachment marks the response as a file download named after the export time
+func setAttachment(w http.ResponseWriter, contentType, extension string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="pool_usage_%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), extension))
+}
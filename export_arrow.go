@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/jackc/pgx/v5"
+)
+
+// arrowSchema is the Arrow schema of an exported reading
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}},
+	{Name: "percentage", Type: arrow.PrimitiveTypes.Int32},
+}, nil)
+
+// getArrowExportHandler handles the /export/arrow endpoint and streams the readings in the requested
+// range in the Arrow IPC stream format, one record batch per exportBatchSize rows
+func getArrowExportHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := queryExport(r.Context(), pool, filter)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		setAttachment(w, "application/vnd.apache.arrow.stream", "arrows")
+		rc := http.NewResponseController(w)
+		if err := writeArrow(w, rows, func() { _ = rc.Flush() }); err != nil {
+			slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+		}
+	}
+}
+
+// writeArrow writes rows of queryExport as an Arrow IPC stream of record batches of exportBatchSize
+// rows, calling flush after each batch so that it reaches the client
+func writeArrow(w io.Writer, rows pgx.Rows, flush func()) error {
+	mem := memory.NewGoAllocator()
+	writer := ipc.NewWriter(w, ipc.WithSchema(arrowSchema), ipc.WithAllocator(mem))
+	builder := array.NewRecordBuilder(mem, arrowSchema)
+	defer builder.Release()
+	ids := builder.Field(0).(*array.Int64Builder)
+	timestamps := builder.Field(1).(*array.TimestampBuilder)
+	percentages := builder.Field(2).(*array.Int32Builder)
+
+	// writeBatch sends the buffered rows as one record batch
+	writeBatch := func() error {
+		record := builder.NewRecord()
+		defer record.Release()
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+		flush()
+		return nil
+	}
+
+	for n := 1; rows.Next(); n++ {
+		var dp DataPoint
+		if err := rows.Scan(&dp.ID, &dp.Timestamp, &dp.Percentage); err != nil {
+			return err
+		}
+		ids.Append(int64(dp.ID))
+		timestamps.Append(arrow.Timestamp(dp.Timestamp.UnixMilli()))
+		percentages.Append(int32(dp.Percentage))
+		if n%exportBatchSize == 0 {
+			if err := writeBatch(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if ids.Len() > 0 {
+		if err := writeBatch(); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
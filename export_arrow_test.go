@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+)
+
+func TestWriteArrow(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		n    int
+		// batches are the lengths of the record batches of the stream
+		batches []int
+	}{
+		{name: "no readings"},
+		{name: "one reading", n: 1, batches: []int{1}},
+		{name: "a full batch", n: exportBatchSize, batches: []int{exportBatchSize}},
+		{name: "more than a batch", n: exportBatchSize + 1, batches: []int{exportBatchSize, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := make([][]any, tt.n)
+			for i := range rows {
+				rows[i] = []any{i + 1, start.Add(time.Duration(i) * time.Minute), i % 101}
+			}
+			var buf bytes.Buffer
+			flushes := 0
+			if err := writeArrow(&buf, &fakeRows{rows: rows}, func() { flushes++ }); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if flushes != len(tt.batches) {
+				t.Errorf("got %d flushes, want one per batch", flushes)
+			}
+
+			reader, err := ipc.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer reader.Release()
+			if !reader.Schema().Equal(arrowSchema) {
+				t.Errorf("got schema %v, want %v", reader.Schema(), arrowSchema)
+			}
+			var batches []int
+			i := 0
+			for reader.Next() {
+				record := reader.Record()
+				batches = append(batches, int(record.NumRows()))
+				ids := record.Column(0).(*array.Int64)
+				timestamps := record.Column(1).(*array.Timestamp)
+				percentages := record.Column(2).(*array.Int32)
+				for j := range int(record.NumRows()) {
+					want := start.Add(time.Duration(i) * time.Minute)
+					if ids.Value(j) != int64(i+1) || timestamps.Value(j) != arrow.Timestamp(want.UnixMilli()) || percentages.Value(j) != int32(i%101) {
+						t.Fatalf("got row %d (%d, %v, %d), want (%d, %v, %d)",
+							i, ids.Value(j), timestamps.Value(j).ToTime(arrow.Millisecond), percentages.Value(j), i+1, want, i%101)
+					}
+					i++
+				}
+			}
+			if err := reader.Err(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(batches, tt.batches) {
+				t.Errorf("got batches %v, want %v", batches, tt.batches)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// influxPrecisions maps the accepted 'precision' values to the timestamp unit they write
+var influxPrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// lineProtocolEncoder writes data points in InfluxDB line protocol, e.g.
+// "pool_usage percentage=62i,id=1234i 1717243200000000000"
+type lineProtocolEncoder struct {
+	buf       *bufio.Writer
+	precision time.Duration
+}
+
+func newLineProtocolEncoder(w io.Writer, precision time.Duration) pointEncoder {
+	return &lineProtocolEncoder{buf: bufio.NewWriter(w), precision: precision}
+}
+
+func (e *lineProtocolEncoder) Begin() error {
+	return nil
+}
+
+func (e *lineProtocolEncoder) Encode(dp DataPoint) error {
+	_, err := fmt.Fprintf(e.buf, "pool_usage percentage=%di,id=%di %s\n",
+		dp.Percentage, dp.ID, strconv.FormatInt(dp.Timestamp.UnixNano()/int64(e.precision), 10))
+	return err
+}
+
+func (e *lineProtocolEncoder) Flush() error {
+	return e.buf.Flush()
+}
+
+func (e *lineProtocolEncoder) End() error {
+	return e.buf.Flush()
+}
+
+// getInfluxExportHandler handles the /export/influx endpoint and streams the readings in the requested
+// range as InfluxDB line protocol, with timestamps in the requested precision (default ns)
+func getInfluxExportHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := r.URL.Query().Get("precision")
+		if name == "" {
+			name = "ns"
+		}
+		precision, ok := influxPrecisions[name]
+		if !ok {
+			http.Error(w, "invalid 'precision' parameter: expected ns, us, ms or s", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := queryExport(r.Context(), pool, filter)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		streamPoints(w, rows, dataFields, nil, pointFormat{
+			contentType: "text/plain; charset=utf-8",
+			newEncoder: func(w io.Writer, _ fieldSet) pointEncoder {
+				return newLineProtocolEncoder(w, precision)
+			},
+		})
+	}
+}
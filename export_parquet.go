@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetRow is the Parquet schema of an exported reading
+type parquetRow struct {
+	ID         int64     `parquet:"id"`
+	Timestamp  time.Time `parquet:"timestamp,timestamp(millisecond:utc)"`
+	Percentage int32     `parquet:"percentage"`
+}
+
+// getParquetExportHandler handles the /export/parquet endpoint and streams the readings in the
+// requested range as a Snappy-compressed Parquet file
+func getParquetExportHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rows, err := queryExport(r.Context(), pool, filter)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		setAttachment(w, "application/vnd.apache.parquet", "parquet")
+		if err := writeParquet(w, rows); err != nil {
+			slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+		}
+	}
+}
+
+// writeParquet writes rows of queryExport as a Snappy-compressed Parquet file. The footer is
+// written last, so the file can be produced without seeking.
+func writeParquet(w io.Writer, rows pgx.Rows) error {
+	writer := parquet.NewGenericWriter[parquetRow](w, parquet.Compression(&parquet.Snappy))
+	batch := make([]parquetRow, 0, exportBatchSize)
+	for rows.Next() {
+		var row parquetRow
+		if err := rows.Scan(&row.ID, &row.Timestamp, &row.Percentage); err != nil {
+			return err
+		}
+		batch = append(batch, row)
+		if len(batch) == cap(batch) {
+			if _, err := writer.Write(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if _, err := writer.Write(batch); err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestWriteParquet(t *testing.T) {
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{name: "no readings", n: 0},
+		{name: "one reading", n: 1},
+		// The rows are written in batches, the last of them partial
+		{name: "more than a batch", n: exportBatchSize + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := make([][]any, tt.n)
+			for i := range rows {
+				rows[i] = []any{i + 1, start.Add(time.Duration(i) * time.Minute), i % 101}
+			}
+			var buf bytes.Buffer
+			if err := writeParquet(&buf, &fakeRows{rows: rows}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := `message parquetRow {
+	required int64 id (INT(64,true));
+	required int64 timestamp (TIMESTAMP(isAdjustedToUTC=true,unit=MILLIS));
+	required int32 percentage (INT(32,true));
+}`
+			if got := file.Schema().String(); got != want {
+				t.Errorf("got schema\n%s\nwant\n%s", got, want)
+			}
+			if got := file.NumRows(); got != int64(tt.n) {
+				t.Fatalf("got %d rows, want %d", got, tt.n)
+			}
+
+			reader := parquet.NewGenericReader[parquetRow](file)
+			defer reader.Close()
+			got := make([]parquetRow, tt.n)
+			if n, err := reader.Read(got); n != tt.n || err != nil && !errors.Is(err, io.EOF) {
+				t.Fatalf("read %d rows, want %d: %v", n, tt.n, err)
+			}
+			for i, row := range got {
+				want := parquetRow{ID: int64(i + 1), Timestamp: start.Add(time.Duration(i) * time.Minute), Percentage: int32(i % 101)}
+				if row.ID != want.ID || !row.Timestamp.Equal(want.Timestamp) || row.Percentage != want.Percentage {
+					t.Fatalf("got row %d %+v, want %+v", i, row, want)
+				}
+			}
+		})
+	}
+}
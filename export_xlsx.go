@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxSheet is the name of the single worksheet of an Excel export
+const xlsxSheet = "Sheet1"
+
+// getXLSXExportHandler handles the /export/xlsx endpoint and returns the readings in the requested range,
+// or their per-bucket aggregates when 'type=aggregate', as an Excel workbook with a header row and
+// typed date and number columns. Timestamps are written as local times of the 'tz' parameter or the pool.
+func getXLSXExportHandler(reads *readPool, poolLocation *time.Location) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if loc == nil {
+			loc = poolLocation
+		}
+		exportType := r.URL.Query().Get("type")
+		if exportType != "" && exportType != "raw" && exportType != "aggregate" {
+			http.Error(w, "invalid 'type' parameter: expected raw or aggregate", http.StatusBadRequest)
+			return
+		}
+		unit, err := parseBucketUnit(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The readings are queried before the workbook is started, so a failing query is reported as such
+		var write func(f *excelize.File, sw *excelize.StreamWriter) error
+		if exportType == "aggregate" {
+			buckets, err := queryBuckets(r.Context(), pool, unit, filter, nil, loc)
+			if err != nil {
+				writeQueryError(w, r, err)
+				return
+			}
+			write = func(f *excelize.File, sw *excelize.StreamWriter) error { return writeXLSXBuckets(f, sw, buckets, loc) }
+		} else {
+			rows, err := queryExport(r.Context(), pool, filter)
+			if err != nil {
+				writeQueryError(w, r, err)
+				return
+			}
+			defer rows.Close()
+			write = func(f *excelize.File, sw *excelize.StreamWriter) error { return writeXLSXPoints(f, sw, rows, loc) }
+		}
+
+		f := excelize.NewFile()
+		defer f.Close()
+		sw, err := f.NewStreamWriter(xlsxSheet)
+		if err == nil {
+			err = write(f, sw)
+		}
+		if err == nil {
+			err = sw.Flush()
+		}
+		if err != nil {
+			http.Error(w, "Failed to build the spreadsheet", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error building spreadsheet", "error", err)
+			return
+		}
+
+		setAttachment(w, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "xlsx")
+		if err := f.Write(w); err != nil {
+			slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+		}
+	}
+}
+
+// xlsxStyles registers the header and date-time cell styles of a workbook
+func xlsxStyles(f *excelize.File) (header, dateTime int, err error) {
+	header, err = f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return 0, 0, err
+	}
+	format := "yyyy-mm-dd hh:mm:ss"
+	dateTime, err = f.NewStyle(&excelize.Style{CustomNumFmt: &format})
+	return header, dateTime, err
+}
+
+// xlsxHeader writes a bold header row
+func xlsxHeader(sw *excelize.StreamWriter, style int, names ...string) error {
+	cells := make([]any, len(names))
+	for i, name := range names {
+		cells[i] = excelize.Cell{StyleID: style, Value: name}
+	}
+	return sw.SetRow("A1", cells)
+}
+
+// localWallClock returns t's wall-clock time in loc as a zone-less time, which is how Excel stores dates
+func localWallClock(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// writeXLSXPoints streams one row per reading of queryExport into the sheet
+func writeXLSXPoints(f *excelize.File, sw *excelize.StreamWriter, rows pgx.Rows, loc *time.Location) error {
+	header, dateTime, err := xlsxStyles(f)
+	if err != nil {
+		return err
+	}
+	if err := sw.SetColWidth(2, 2, 20); err != nil {
+		return err
+	}
+	if err := xlsxHeader(sw, header, "ID", "Timestamp", "Percentage"); err != nil {
+		return err
+	}
+
+	for row := 2; rows.Next(); row++ {
+		var dp DataPoint
+		if err := rows.Scan(&dp.ID, &dp.Timestamp, &dp.Percentage); err != nil {
+			return err
+		}
+		cell := fmt.Sprintf("A%d", row)
+		err := sw.SetRow(cell, []any{dp.ID, excelize.Cell{StyleID: dateTime, Value: localWallClock(dp.Timestamp, loc)}, dp.Percentage})
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// writeXLSXBuckets writes one row per aggregate bucket into the sheet
+func writeXLSXBuckets(f *excelize.File, sw *excelize.StreamWriter, buckets []Bucket, loc *time.Location) error {
+	header, dateTime, err := xlsxStyles(f)
+	if err != nil {
+		return err
+	}
+	if err := sw.SetColWidth(1, 2, 20); err != nil {
+		return err
+	}
+	if err := xlsxHeader(sw, header, "Start", "End", "Average", "Minimum", "Maximum", "Readings"); err != nil {
+		return err
+	}
+
+	for i, b := range buckets {
+		cell := fmt.Sprintf("A%d", i+2)
+		err := sw.SetRow(cell, []any{
+			excelize.Cell{StyleID: dateTime, Value: localWallClock(b.Start, loc)},
+			excelize.Cell{StyleID: dateTime, Value: localWallClock(b.End, loc)},
+			b.Avg, b.Min, b.Max, b.Count,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// readXLSX writes a workbook by calling write on its stream writer and opens the resulting file
+func readXLSX(t *testing.T, write func(f *excelize.File, sw *excelize.StreamWriter) error) *excelize.File {
+	t.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+	sw, err := f.NewStreamWriter(xlsxSheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := write(f, sw); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file, err := excelize.OpenReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+// checkXLSXCells compares the formatted rows of the sheet and the types of its cells. Numbers carry
+// no type attribute, and neither do dates, which Excel stores as styled serial numbers.
+func checkXLSXCells(t *testing.T, f *excelize.File, rows [][]string, types map[string]excelize.CellType) {
+	t.Helper()
+	got, err := f.GetRows(xlsxSheet)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("got rows %q, want %q", got, rows)
+	}
+	for cell, want := range types {
+		got, err := f.GetCellType(xlsxSheet, cell)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != want {
+			t.Errorf("got type %d for %s, want %d", got, cell, want)
+		}
+	}
+	// Both the header and the timestamps are styled
+	for cell, want := range map[string]bool{"A1": true, "B2": true} {
+		style, err := f.GetCellStyle(xlsxSheet, cell)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := style != 0; got != want {
+			t.Errorf("got style %d for %s, want one", style, cell)
+		}
+	}
+}
+
+func TestWriteXLSXPoints(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := &fakeRows{rows: [][]any{
+		{7, time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC), 42},
+		{8, time.Date(2024, 5, 1, 8, 45, 15, 0, time.UTC), 0},
+	}}
+	f := readXLSX(t, func(f *excelize.File, sw *excelize.StreamWriter) error { return writeXLSXPoints(f, sw, rows, berlin) })
+	// Timestamps are the wall-clock times of the location, formatted by the date-time style
+	checkXLSXCells(t, f, [][]string{
+		{"ID", "Timestamp", "Percentage"},
+		{"7", "2024-05-01 10:30:00", "42"},
+		{"8", "2024-05-01 10:45:15", "0"},
+	}, map[string]excelize.CellType{
+		"A1": excelize.CellTypeInlineString,
+		"A2": excelize.CellTypeUnset,
+		"B2": excelize.CellTypeUnset,
+		"C2": excelize.CellTypeUnset,
+	})
+	if got, err := f.GetCellValue(xlsxSheet, "B2", excelize.Options{RawCellValue: true}); err != nil || got != "45413.4375" {
+		t.Errorf("got raw timestamp %q (%v), want the serial number 45413.4375", got, err)
+	}
+}
+
+func TestWriteXLSXBuckets(t *testing.T) {
+	buckets := []Bucket{{
+		Start: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+		Avg: 37.5, Min: 3, Max: 81, Count: 96,
+	}}
+	f := readXLSX(t, func(f *excelize.File, sw *excelize.StreamWriter) error {
+		return writeXLSXBuckets(f, sw, buckets, time.UTC)
+	})
+	checkXLSXCells(t, f, [][]string{
+		{"Start", "End", "Average", "Minimum", "Maximum", "Readings"},
+		{"2024-05-01 00:00:00", "2024-05-02 00:00:00", "37.5", "3", "81", "96"},
+	}, map[string]excelize.CellType{
+		"A1": excelize.CellTypeInlineString,
+		"A2": excelize.CellTypeUnset,
+		"C2": excelize.CellTypeUnset,
+		"F2": excelize.CellTypeUnset,
+	})
+}
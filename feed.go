@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// feedDays is the number of completed days published in the feed
+const feedDays = 30
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// getFeedHandler handles the /feed endpoint and publishes an Atom feed with one entry per
+// completed day, newest first, summarizing its average and peak occupancy
+func getFeedHandler(reads *readPool, poolLocation *time.Location) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		now := time.Now().In(poolLocation)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, poolLocation)
+		days, err := queryBuckets(r.Context(), pool, "day", rangeFilter(today.AddDate(0, 0, -feedDays), today), nil, poolLocation)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		if err := writeFeed(w, buildFeed(scheme+"://"+r.Host, r.Host, today, days)); err != nil {
+			slog.ErrorContext(r.Context(), "Error encoding response", "error", err)
+		}
+	}
+}
+
+// buildFeed turns the daily buckets of the days before today, oldest first as queryBuckets returns
+// them, into the feed of the server at base; entry ids are tag URIs of host and the date
+func buildFeed(base, host string, today time.Time, days []Bucket) atomFeed {
+	feed := atomFeed{
+		ID:      base + "/feed",
+		Title:   "Pool occupancy: daily summary",
+		Updated: today.Format(time.RFC3339),
+		Link:    atomLink{Href: base + "/feed", Rel: "self"},
+	}
+	for _, day := range slices.Backward(days) {
+		date := day.Start.Format(time.DateOnly)
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("tag:%s,%s:daily", host, date),
+			Title:   fmt.Sprintf("%s: average %.0f%%, peak %d%%", day.Start.Format("Monday, 2 January 2006"), day.Avg, day.Max),
+			Updated: day.End.Format(time.RFC3339),
+			Link:    atomLink{Href: base + "/pool-data/" + date},
+			Summary: fmt.Sprintf("Average occupancy %.1f%%, peak %d%%, lowest %d%% across %d readings.",
+				day.Avg, day.Max, day.Min, day.Count),
+		})
+	}
+	return feed
+}
+
+// writeFeed writes feed as an indented Atom document
+func writeFeed(w io.Writer, feed atomFeed) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(feed)
+}
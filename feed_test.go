@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildFeed(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 0, 0, 0, 0, berlin) }
+	days := []Bucket{
+		{Start: day(1), End: day(2), Avg: 41.25, Min: 5, Max: 80, Count: 96},
+		{Start: day(2), End: day(3), Avg: 12.5, Min: 0, Max: 35, Count: 90},
+	}
+	feed := buildFeed("https://pool.example", "pool.example", day(3), days)
+
+	want := atomFeed{
+		ID:      "https://pool.example/feed",
+		Title:   "Pool occupancy: daily summary",
+		Updated: "2024-05-03T00:00:00+02:00",
+		Link:    atomLink{Href: "https://pool.example/feed", Rel: "self"},
+		Entries: []atomEntry{
+			{
+				ID:      "tag:pool.example,2024-05-02:daily",
+				Title:   "Thursday, 2 May 2024: average 12%, peak 35%",
+				Updated: "2024-05-03T00:00:00+02:00",
+				Link:    atomLink{Href: "https://pool.example/pool-data/2024-05-02"},
+				Summary: "Average occupancy 12.5%, peak 35%, lowest 0% across 90 readings.",
+			},
+			{
+				ID:      "tag:pool.example,2024-05-01:daily",
+				Title:   "Wednesday, 1 May 2024: average 41%, peak 80%",
+				Updated: "2024-05-02T00:00:00+02:00",
+				Link:    atomLink{Href: "https://pool.example/pool-data/2024-05-01"},
+				Summary: "Average occupancy 41.2%, peak 80%, lowest 5% across 96 readings.",
+			},
+		},
+	}
+	if !reflect.DeepEqual(feed, want) {
+		t.Errorf("got %+v, want %+v", feed, want)
+	}
+	// The buckets are left in the order they were queried
+	if !days[0].Start.Equal(day(1)) {
+		t.Errorf("got the buckets reordered: %+v", days)
+	}
+}
+
+func TestWriteFeed(t *testing.T) {
+	tests := []struct {
+		name string
+		days []Bucket
+	}{
+		{name: "no days"},
+		{name: "days", days: []Bucket{
+			{Start: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), Avg: 30, Max: 60, Count: 4},
+			{Start: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC), Avg: 20, Max: 50, Count: 4},
+		}},
+	}
+	tagURI := regexp.MustCompile(`^tag:pool\.example,\d{4}-\d{2}-\d{2}:daily$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed := buildFeed("http://pool.example", "pool.example", time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC), tt.days)
+			var buf bytes.Buffer
+			if err := writeFeed(&buf, feed); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(buf.String(), xml.Header+`<feed xmlns="http://www.w3.org/2005/Atom">`) {
+				t.Errorf("got document %q, want an Atom feed", buf.String())
+			}
+
+			var got atomFeed
+			if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.ID != feed.ID || len(got.Entries) != len(tt.days) {
+				t.Fatalf("got feed %q with %d entries, want %q with %d", got.ID, len(got.Entries), feed.ID, len(tt.days))
+			}
+			if _, err := time.Parse(time.RFC3339, got.Updated); err != nil {
+				t.Errorf("got feed updated %q: %v", got.Updated, err)
+			}
+			ids := map[string]bool{}
+			for _, entry := range got.Entries {
+				if !tagURI.MatchString(entry.ID) || ids[entry.ID] {
+					t.Errorf("got entry id %q, want a unique tag URI", entry.ID)
+				}
+				ids[entry.ID] = true
+				if _, err := time.Parse(time.RFC3339, entry.Updated); err != nil {
+					t.Errorf("got entry updated %q: %v", entry.Updated, err)
+				}
+			}
+		})
+	}
+}
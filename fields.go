@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// dataFields lists the DataPoint fields a client may select, in output order
+var dataFields = []string{"id", "timestamp", "percentage"}
+
+// fieldSet is a validated subset of dataFields, kept in output order
+type fieldSet []string
+
+// parseFields reads the optional comma-separated 'fields' parameter; all fields are selected by default
+func parseFields(r *http.Request) (fieldSet, error) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return dataFields, nil
+	}
+	requested := strings.Split(value, ",")
+	for _, name := range requested {
+		if !slices.Contains(dataFields, strings.TrimSpace(name)) {
+			return nil, fmt.Errorf("invalid 'fields' parameter: unknown field '%s', expected a subset of %s",
+				name, strings.Join(dataFields, ","))
+		}
+	}
+	var fields fieldSet
+	for _, name := range dataFields {
+		if slices.ContainsFunc(requested, func(s string) bool { return strings.TrimSpace(s) == name }) {
+			fields = append(fields, name)
+		}
+	}
+	return fields, nil
+}
+
+// all reports whether every field is selected
+func (fs fieldSet) all() bool {
+	return len(fs) == len(dataFields)
+}
+
+// columns returns the SELECT list for the selected fields
+func (fs fieldSet) columns() string {
+	return strings.Join(fs, ", ")
+}
+
+// scanDest returns the scan destinations in dp matching the SELECT list
+func (fs fieldSet) scanDest(dp *DataPoint) []any {
+	dest := make([]any, len(fs))
+	for i, name := range fs {
+		switch name {
+		case "id":
+			dest[i] = &dp.ID
+		case "timestamp":
+			dest[i] = &dp.Timestamp
+		case "percentage":
+			dest[i] = &dp.Percentage
+		}
+	}
+	return dest
+}
+
+// value returns the value of the named field of dp
+func (dp DataPoint) value(name string) any {
+	switch name {
+	case "id":
+		return dp.ID
+	case "timestamp":
+		return dp.Timestamp
+	default:
+		return dp.Percentage
+	}
+}
+
+// projectedPoint marshals only the selected fields of a data point
+type projectedPoint struct {
+	point  DataPoint
+	fields fieldSet
+}
+
+func (p projectedPoint) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range p.fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		value, err := json.Marshal(p.point.value(name))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%q:%s", name, value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
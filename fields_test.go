@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  fieldSet
+		err   string
+	}{
+		{name: "all by default", query: "", want: fieldSet{"id", "timestamp", "percentage"}},
+		{name: "single", query: "fields=percentage", want: fieldSet{"percentage"}},
+		{name: "kept in output order", query: "fields=percentage,timestamp", want: fieldSet{"timestamp", "percentage"}},
+		{name: "spaces", query: "fields=timestamp,%20percentage", want: fieldSet{"timestamp", "percentage"}},
+		{name: "repeated", query: "fields=id,id", want: fieldSet{"id"}},
+		{name: "unknown", query: "fields=id,suspect", err: "invalid 'fields' parameter: unknown field 'suspect', expected a subset of id,timestamp,percentage"},
+		{name: "trailing comma", query: "fields=id,", err: "invalid 'fields' parameter: unknown field '', expected a subset of id,timestamp,percentage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFields(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectedPointMarshalJSON(t *testing.T) {
+	point := DataPoint{ID: 7, Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Percentage: 42}
+	tests := []struct {
+		name   string
+		fields fieldSet
+		want   string
+	}{
+		{name: "all", fields: dataFields, want: `{"id":7,"timestamp":"2024-05-01T12:00:00Z","percentage":42}`},
+		{name: "percentage", fields: fieldSet{"percentage"}, want: `{"percentage":42}`},
+		{name: "timestamp and percentage", fields: fieldSet{"timestamp", "percentage"}, want: `{"timestamp":"2024-05-01T12:00:00Z","percentage":42}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(projectedPoint{point: point, fields: tt.fields})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
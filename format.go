@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pointEncoder writes a series of data points in one output format, one point at a time
+type pointEncoder interface {
+	// Begin writes anything that precedes the first point, such as a header row
+	Begin() error
+	// Encode writes a single point
+	Encode(dp DataPoint) error
+	// Flush writes out any points buffered by the encoder
+	Flush() error
+	// End writes anything that follows the last point and flushes buffered output
+	End() error
+}
+
+// pointFormat describes an output format for series of data points
+type pointFormat struct {
+	contentType string
+	newEncoder  func(w io.Writer, fields fieldSet) pointEncoder
+}
+
+// pointFormats lists the streaming formats selectable through the 'format' parameter or the Accept header;
+// JSON is handled separately and is the default
+var pointFormats = map[string]pointFormat{
+	"csv":     {contentType: "text/csv", newEncoder: newCSVEncoder},
+	"ndjson":  {contentType: "application/x-ndjson", newEncoder: newNDJSONEncoder},
+	"msgpack": {contentType: "application/msgpack", newEncoder: newMsgpackEncoder},
+	"xml":     {contentType: "application/xml", newEncoder: newXMLEncoder},
+}
+
+// flushEvery is the number of streamed points after which the response is flushed to the client
+const flushEvery = 500
+
+// negotiateFormat picks the response format of a data point series; besides the streaming
+// formats, a series can be returned as a JSON:API document
+func negotiateFormat(r *http.Request) (string, error) {
+	contentTypes := map[string]string{"jsonapi": jsonAPIContentType}
+	for name, format := range pointFormats {
+		contentTypes[name] = format.contentType
+	}
+	return negotiate(r, contentTypes)
+}
+
+// negotiate picks the response format from the 'format' parameter, falling back to the first
+// media type of the Accept header that matches one of contentTypes (keyed by format name),
+// and finally to JSON
+func negotiate(r *http.Request, contentTypes map[string]string) (string, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		if _, ok := contentTypes[name]; !ok && name != "json" {
+			return "", fmt.Errorf("invalid 'format' parameter: unknown format '%s'", name)
+		}
+		return name, nil
+	}
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/json" {
+			return "json", nil
+		}
+		for name, contentType := range contentTypes {
+			if contentType == mediaType {
+				return name, nil
+			}
+		}
+	}
+	return "json", nil
+}
+
+// streamPoints encodes the rows of a query selecting fields straight to the response as they are read,
+// flushing periodically so clients can start processing early. Once the first byte is written the
+// status can no longer change, so later failures are only logged.
+func streamPoints(w http.ResponseWriter, rows pgx.Rows, fields fieldSet, loc *time.Location, format pointFormat) {
+	w.Header().Set("Content-Type", format.contentType)
+	rc := http.NewResponseController(w)
+	enc := format.newEncoder(w, fields)
+	if err := enc.Begin(); err != nil {
+		slog.Error("Error encoding response", "error", err)
+		return
+	}
+	for n := 1; rows.Next(); n++ {
+		var dp DataPoint
+		if err := rows.Scan(fields.scanDest(&dp)...); err != nil {
+			slog.Error("Error scanning row", "error", err)
+			return
+		}
+		dp.Timestamp = inLocation(dp.Timestamp, loc)
+		if err := enc.Encode(dp); err != nil {
+			slog.Error("Error encoding response", "error", err)
+			return
+		}
+		if n%flushEvery == 0 {
+			if err := enc.Flush(); err != nil {
+				slog.Error("Error encoding response", "error", err)
+				return
+			}
+			// Not every ResponseWriter supports flushing; the data then simply goes out at the end
+			_ = rc.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error reading rows", "error", err)
+		return
+	}
+	if err := enc.End(); err != nil {
+		slog.Error("Error encoding response", "error", err)
+	}
+}
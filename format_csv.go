@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// csvEncoder writes data points as CSV with a header row naming the selected fields
+type csvEncoder struct {
+	w      *csv.Writer
+	fields fieldSet
+	record []string
+}
+
+func newCSVEncoder(w io.Writer, fields fieldSet) pointEncoder {
+	return &csvEncoder{w: csv.NewWriter(w), fields: fields, record: make([]string, len(fields))}
+}
+
+func (e *csvEncoder) Begin() error {
+	return e.w.Write(e.fields)
+}
+
+func (e *csvEncoder) Encode(dp DataPoint) error {
+	for i, name := range e.fields {
+		switch name {
+		case "id":
+			e.record[i] = strconv.Itoa(dp.ID)
+		case "timestamp":
+			e.record[i] = dp.Timestamp.Format(time.RFC3339)
+		case "percentage":
+			e.record[i] = strconv.Itoa(dp.Percentage)
+		}
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) End() error {
+	return e.Flush()
+}
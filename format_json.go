@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// jsonFormat streams data points as a JSON array; it is the default and therefore not in pointFormats
+var jsonFormat = pointFormat{contentType: "application/json", newEncoder: newJSONEncoder}
+
+// jsonEncoder writes data points as the elements of a JSON array, so a response never has to be
+// held in memory as a whole
+type jsonEncoder struct {
+	buf    *bufio.Writer
+	fields fieldSet
+	n      int
+}
+
+func newJSONEncoder(w io.Writer, fields fieldSet) pointEncoder {
+	return &jsonEncoder{buf: bufio.NewWriter(w), fields: fields}
+}
+
+func (e *jsonEncoder) Begin() error {
+	return e.buf.WriteByte('[')
+}
+
+// Encode writes one array element, preceded by a comma unless it is the first
+func (e *jsonEncoder) Encode(dp DataPoint) error {
+	var v any = dp
+	if !e.fields.all() {
+		v = projectedPoint{point: dp, fields: e.fields}
+	}
+	element, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if e.n > 0 {
+		e.buf.WriteByte(',')
+	}
+	e.n++
+	_, err = e.buf.Write(element)
+	return err
+}
+
+func (e *jsonEncoder) Flush() error {
+	return e.buf.Flush()
+}
+
+func (e *jsonEncoder) End() error {
+	e.buf.WriteString("]\n")
+	return e.buf.Flush()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackEncoder writes data points as a MessagePack array of maps keyed by field name.
+// MessagePack arrays are length-prefixed, so the points of a page are collected and written at the end.
+type msgpackEncoder struct {
+	enc    *msgpack.Encoder
+	fields fieldSet
+	points []DataPoint
+}
+
+func newMsgpackEncoder(w io.Writer, fields fieldSet) pointEncoder {
+	return &msgpackEncoder{enc: msgpack.NewEncoder(w), fields: fields}
+}
+
+func (e *msgpackEncoder) Begin() error {
+	return nil
+}
+
+func (e *msgpackEncoder) Encode(dp DataPoint) error {
+	e.points = append(e.points, dp)
+	return nil
+}
+
+// Flush is a no-op: nothing can be written before the array length is known
+func (e *msgpackEncoder) Flush() error {
+	return nil
+}
+
+// End writes the collected points; timestamps use the MessagePack timestamp extension type
+func (e *msgpackEncoder) End() error {
+	if err := e.enc.EncodeArrayLen(len(e.points)); err != nil {
+		return err
+	}
+	for _, dp := range e.points {
+		if err := e.enc.EncodeMapLen(len(e.fields)); err != nil {
+			return err
+		}
+		for _, name := range e.fields {
+			if err := e.enc.EncodeString(name); err != nil {
+				return err
+			}
+			if err := e.enc.Encode(dp.value(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
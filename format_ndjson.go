@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// ndjsonEncoder writes data points as newline-delimited JSON, one object per line
+type ndjsonEncoder struct {
+	buf    *bufio.Writer
+	enc    *json.Encoder
+	fields fieldSet
+}
+
+func newNDJSONEncoder(w io.Writer, fields fieldSet) pointEncoder {
+	buf := bufio.NewWriter(w)
+	return &ndjsonEncoder{buf: buf, enc: json.NewEncoder(buf), fields: fields}
+}
+
+func (e *ndjsonEncoder) Begin() error {
+	return nil
+}
+
+// Encode writes one line; json.Encoder terminates every value with a newline
+func (e *ndjsonEncoder) Encode(dp DataPoint) error {
+	return e.enc.Encode(projectedPoint{point: dp, fields: e.fields})
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return e.buf.Flush()
+}
+
+func (e *ndjsonEncoder) End() error {
+	return e.buf.Flush()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// testPoints is the series the encoder tests write
+var testPoints = []DataPoint{
+	{ID: 1, Timestamp: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Percentage: 42},
+	{ID: 2, Timestamp: time.Date(2024, 5, 1, 12, 5, 0, 0, time.UTC), Percentage: 45},
+}
+
+// encodePoints writes points through the encoder newEncoder returns and returns the output
+func encodePoints(t *testing.T, newEncoder func(w *bytes.Buffer) pointEncoder, points []DataPoint) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := newEncoder(&buf)
+	if err := enc.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dp := range points {
+		if err := enc.Encode(dp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.End(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		want   string
+		err    string
+	}{
+		{name: "JSON by default", want: "json"},
+		{name: "format parameter", query: "format=csv", want: "csv"},
+		{name: "format parameter wins over Accept", query: "format=json", accept: "text/csv", want: "json"},
+		{name: "Accept", accept: "text/csv", want: "csv"},
+		{name: "Accept with parameters", accept: "text/csv; charset=utf-8", want: "csv"},
+		{name: "first acceptable type", accept: "image/png, text/csv, application/json", want: "csv"},
+		{name: "JSON accepted first", accept: "application/json, text/csv", want: "json"},
+		{name: "nothing acceptable", accept: "image/png", want: "json"},
+		{name: "unknown format", query: "format=yaml", err: "invalid 'format' parameter: unknown format 'yaml'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pool-data?"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			got, err := negotiateFormat(r)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields fieldSet
+		points []DataPoint
+		want   string
+	}{
+		{name: "no points", fields: dataFields, want: "id,timestamp,percentage\n"},
+		{name: "all fields", fields: dataFields, points: testPoints, want: "id,timestamp,percentage\n1,2024-05-01T12:00:00Z,42\n2,2024-05-01T12:05:00Z,45\n"},
+		{name: "selected fields", fields: fieldSet{"timestamp", "percentage"}, points: testPoints[:1], want: "timestamp,percentage\n2024-05-01T12:00:00Z,42\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodePoints(t, func(w *bytes.Buffer) pointEncoder { return newCSVEncoder(w, tt.fields) }, tt.points)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields fieldSet
+		points []DataPoint
+		want   string
+	}{
+		{name: "no points", fields: dataFields, want: "[]\n"},
+		{
+			name:   "all fields",
+			fields: dataFields,
+			points: testPoints,
+			want:   `[{"id":1,"timestamp":"2024-05-01T12:00:00Z","percentage":42},{"id":2,"timestamp":"2024-05-01T12:05:00Z","percentage":45}]` + "\n",
+		},
+		{name: "selected fields", fields: fieldSet{"timestamp"}, points: testPoints, want: `[{"timestamp":"2024-05-01T12:00:00Z"},{"timestamp":"2024-05-01T12:05:00Z"}]` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodePoints(t, func(w *bytes.Buffer) pointEncoder { return newJSONEncoder(w, tt.fields) }, tt.points)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNDJSONEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields fieldSet
+		points []DataPoint
+		want   string
+	}{
+		{name: "no points", fields: dataFields, want: ""},
+		{
+			name:   "all fields",
+			fields: dataFields,
+			points: testPoints,
+			want: `{"id":1,"timestamp":"2024-05-01T12:00:00Z","percentage":42}` + "\n" +
+				`{"id":2,"timestamp":"2024-05-01T12:05:00Z","percentage":45}` + "\n",
+		},
+		{name: "selected fields", fields: fieldSet{"percentage"}, points: testPoints, want: "{\"percentage\":42}\n{\"percentage\":45}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodePoints(t, func(w *bytes.Buffer) pointEncoder { return newNDJSONEncoder(w, tt.fields) }, tt.points)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMsgpackEncoder(t *testing.T) {
+	// decodedPoint leaves the fields that were not written nil
+	type decodedPoint struct {
+		ID         *int       `msgpack:"id"`
+		Timestamp  *time.Time `msgpack:"timestamp"`
+		Percentage *int       `msgpack:"percentage"`
+	}
+	id, percentage, timestamp := 1, 42, testPoints[0].Timestamp
+	tests := []struct {
+		name   string
+		fields fieldSet
+		points []DataPoint
+		want   []decodedPoint
+	}{
+		{name: "no points", fields: dataFields, want: []decodedPoint{}},
+		{name: "all fields", fields: dataFields, points: testPoints[:1], want: []decodedPoint{{ID: &id, Timestamp: &timestamp, Percentage: &percentage}}},
+		{name: "selected fields", fields: fieldSet{"percentage"}, points: testPoints[:1], want: []decodedPoint{{Percentage: &percentage}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodePoints(t, func(w *bytes.Buffer) pointEncoder { return newMsgpackEncoder(w, tt.fields) }, tt.points)
+			var got []decodedPoint
+			if err := msgpack.Unmarshal([]byte(encoded), &got); err != nil {
+				t.Fatalf("unable to decode: %v", err)
+			}
+			for i := range got {
+				if got[i].Timestamp != nil {
+					*got[i].Timestamp = got[i].Timestamp.UTC()
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineProtocolEncoder(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision time.Duration
+		want      string
+	}{
+		{name: "nanoseconds", precision: time.Nanosecond, want: "pool_usage percentage=42i,id=1i 1714564800000000000\npool_usage percentage=45i,id=2i 1714565100000000000\n"},
+		{name: "milliseconds", precision: time.Millisecond, want: "pool_usage percentage=42i,id=1i 1714564800000\npool_usage percentage=45i,id=2i 1714565100000\n"},
+		{name: "seconds", precision: time.Second, want: "pool_usage percentage=42i,id=1i 1714564800\npool_usage percentage=45i,id=2i 1714565100\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodePoints(t, func(w *bytes.Buffer) pointEncoder { return newLineProtocolEncoder(w, tt.precision) }, testPoints)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXMLEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields fieldSet
+		points []DataPoint
+		want   string
+	}{
+		{name: "no points", fields: dataFields, want: xml.Header + "<dataPoints></dataPoints>"},
+		{
+			name:   "all fields",
+			fields: dataFields,
+			points: testPoints[:1],
+			want:   xml.Header + "<dataPoints><dataPoint><id>1</id><timestamp>2024-05-01T12:00:00Z</timestamp><percentage>42</percentage></dataPoint></dataPoints>",
+		},
+		{
+			name:   "selected fields",
+			fields: fieldSet{"percentage"},
+			points: testPoints,
+			want:   xml.Header + "<dataPoints><dataPoint><percentage>42</percentage></dataPoint><dataPoint><percentage>45</percentage></dataPoint></dataPoints>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := encodePoints(t, func(w *bytes.Buffer) pointEncoder { return newXMLEncoder(w, tt.fields) }, tt.points)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewXMLBuckets(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	bucket := Bucket{Start: start, End: start.Add(time.Hour), Avg: 40, Min: 20, Max: 60, Count: 12, Percentiles: map[string]float64{"p50": 41, "p95": 58}}
+	tests := []struct {
+		name        string
+		percentiles []percentileParam
+		want        []xmlPercentile
+	}{
+		{name: "no percentiles"},
+		{name: "in request order", percentiles: []percentileParam{{Name: "p95"}, {Name: "p50"}}, want: []xmlPercentile{{Name: "p95", Value: 58}, {Name: "p50", Value: 41}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := newXMLBuckets([]Bucket{bucket}, tt.percentiles)
+			if len(doc.Buckets) != 1 || doc.Buckets[0].Count != 12 || !doc.Buckets[0].End.Equal(bucket.End) {
+				t.Fatalf("got %+v, want the bucket %+v", doc.Buckets, bucket)
+			}
+			if got := doc.Buckets[0].Percentiles; !slices.Equal(got, tt.want) {
+				t.Errorf("got percentiles %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// xmlEncoder writes data points as <dataPoints><dataPoint><id>…</id>…</dataPoint>…</dataPoints>
+type xmlEncoder struct {
+	w      io.Writer
+	enc    *xml.Encoder
+	fields fieldSet
+}
+
+var (
+	xmlDataPoints = xml.StartElement{Name: xml.Name{Local: "dataPoints"}}
+	xmlDataPoint  = xml.StartElement{Name: xml.Name{Local: "dataPoint"}}
+)
+
+func newXMLEncoder(w io.Writer, fields fieldSet) pointEncoder {
+	return &xmlEncoder{w: w, enc: xml.NewEncoder(w), fields: fields}
+}
+
+func (e *xmlEncoder) Begin() error {
+	if _, err := io.WriteString(e.w, xml.Header); err != nil {
+		return err
+	}
+	return e.enc.EncodeToken(xmlDataPoints)
+}
+
+func (e *xmlEncoder) Encode(dp DataPoint) error {
+	if err := e.enc.EncodeToken(xmlDataPoint); err != nil {
+		return err
+	}
+	for _, name := range e.fields {
+		if err := e.enc.EncodeElement(dp.value(name), xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.enc.EncodeToken(xmlDataPoint.End())
+}
+
+func (e *xmlEncoder) Flush() error {
+	return e.enc.Flush()
+}
+
+func (e *xmlEncoder) End() error {
+	if err := e.enc.EncodeToken(xmlDataPoints.End()); err != nil {
+		return err
+	}
+	return e.enc.Flush()
+}
+
+// xmlBuckets is the XML representation of an aggregate response
+type xmlBuckets struct {
+	XMLName xml.Name    `xml:"buckets"`
+	Buckets []xmlBucket `xml:"bucket"`
+}
+
+type xmlBucket struct {
+	Start       time.Time       `xml:"start"`
+	End         time.Time       `xml:"end"`
+	Avg         float64         `xml:"avg"`
+	Min         int             `xml:"min"`
+	Max         int             `xml:"max"`
+	Count       int             `xml:"count"`
+	Percentiles []xmlPercentile `xml:"percentile"`
+}
+
+type xmlPercentile struct {
+	Name  string  `xml:"name,attr"`
+	Value float64 `xml:",chardata"`
+}
+
+// newXMLBuckets converts buckets to their XML representation, listing percentiles in request order
+func newXMLBuckets(buckets []Bucket, percentiles []percentileParam) xmlBuckets {
+	doc := xmlBuckets{Buckets: make([]xmlBucket, len(buckets))}
+	for i, b := range buckets {
+		doc.Buckets[i] = xmlBucket{Start: b.Start, End: b.End, Avg: b.Avg, Min: b.Min, Max: b.Max, Count: b.Count}
+		for _, p := range percentiles {
+			doc.Buckets[i].Percentiles = append(doc.Buckets[i].Percentiles, xmlPercentile{Name: p.Name, Value: b.Percentiles[p.Name]})
+		}
+	}
+	return doc
+}
+
+// writeXML encodes v as the XML response body
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	body, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		slog.Error("Error encoding response", "error", err)
+		return
+	}
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Gap is a window between two consecutive readings that is longer than the expected sampling period
+type Gap struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+	// Missing estimates the number of readings that should have been collected in the window
+	Missing int `json:"missing"`
+}
+
+// defaultSamplingPeriod is the expected interval between readings when no 'interval' is given
+const defaultSamplingPeriod = 15 * time.Minute
+
+// getGapsHandler handles the /pool-data/gaps endpoint and returns the windows in which readings are missing
+func getGapsHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval, err := parseDurationParam(r, "interval")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if interval == 0 {
+			interval = defaultSamplingPeriod
+		}
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		gaps, err := queryGaps(r.Context(), pool, filter, interval)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		for i := range gaps {
+			gaps[i].Start, gaps[i].End = inLocation(gaps[i].Start, loc), inLocation(gaps[i].End, loc)
+		}
+
+		writeJSON(w, gaps)
+	}
+}
+
+// queryGaps returns the windows between consecutive readings matching filter that exceed interval
+func queryGaps(ctx context.Context, db querier, filter *queryFilter, interval time.Duration) ([]Gap, error) {
+	// Pair every reading with its predecessor and keep the pairs that are too far apart
+	query := `SELECT prev, timestamp FROM (
+			SELECT lag(timestamp) OVER (ORDER BY timestamp) AS prev, timestamp FROM pool_usage` + filter.where() + `
+		) AS pairs
+		WHERE extract(epoch FROM timestamp - prev) > ` + filter.bind(interval.Seconds()) + `::float8
+		ORDER BY prev LIMIT ` + filter.bind(maxPageSize)
+	rows, err := db.Query(ctx, query, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gaps := []Gap{}
+	for rows.Next() {
+		var g Gap
+		if err := rows.Scan(&g.Start, &g.End); err != nil {
+			return nil, err
+		}
+		length := g.End.Sub(g.Start)
+		g.Duration = length.String()
+		g.Missing = int(length/interval) - 1
+		if length%interval != 0 {
+			g.Missing++
+		}
+		gaps = append(gaps, g)
+	}
+	return gaps, rows.Err()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryGaps(t *testing.T) {
+	from, to := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		length   time.Duration
+		duration string
+		missing  int
+	}{
+		// Readings exactly one period apart never come back, as the query only keeps longer windows
+		{name: "just over one period", length: 15*time.Minute + time.Second, duration: "15m1s", missing: 1},
+		{name: "two periods", length: 30 * time.Minute, duration: "30m0s", missing: 1},
+		{name: "just over two periods", length: 30*time.Minute + time.Second, duration: "30m1s", missing: 2},
+		{name: "two and a half periods", length: 37*time.Minute + 30*time.Second, duration: "37m30s", missing: 2},
+		{name: "a day", length: 24 * time.Hour, duration: "24h0m0s", missing: 95},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeQuerier{rows: [][]any{{start, start.Add(tt.length)}}}
+			gaps, err := queryGaps(context.Background(), db, rangeFilter(from, to), defaultSamplingPeriod)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := Gap{Start: start, End: start.Add(tt.length), Duration: tt.duration, Missing: tt.missing}
+			if len(gaps) != 1 || gaps[0] != want {
+				t.Errorf("got %+v, want %+v", gaps, want)
+			}
+			if !strings.Contains(db.sql, "WHERE extract(epoch FROM timestamp - prev) > $3::float8") {
+				t.Errorf("got query %q, want only the windows longer than the period", db.sql)
+			}
+			if args := []any{from, to, 900.0, maxPageSize}; !slices.Equal(db.args, args) {
+				t.Errorf("got arguments %v, want %v", db.args, args)
+			}
+		})
+	}
+}
+
+func TestQueryGapsWithoutGaps(t *testing.T) {
+	gaps, err := queryGaps(context.Background(), &fakeQuerier{}, rangeFilter(time.Now().Add(-time.Hour), time.Now()), time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// An empty array rather than null
+	if gaps == nil || len(gaps) != 0 {
+		t.Errorf("got %#v, want no gaps", gaps)
+	}
+}
+
+func TestGapsHandlerRejectsParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{name: "interval below a second", query: "interval=500ms", body: "invalid 'interval' parameter: expected a duration of at least 1s, such as 5m or 1h"},
+		{name: "interval without unit", query: "interval=15", body: "invalid 'interval' parameter: expected a duration of at least 1s, such as 5m or 1h"},
+		{name: "from after to", query: "from=2024-05-02T00:00:00Z&to=2024-05-01T00:00:00Z", body: "'from' must be before 'to'"},
+		{name: "unknown time zone", query: "tz=Mars/Olympus", body: "invalid 'tz' parameter: unknown time zone 'Mars/Olympus'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getGapsHandler(&readPool{})(w, httptest.NewRequest("GET", "/pool-data/gaps?"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// grafanaTargets maps the series offered to Grafana's JSON datasource to their bucket aggregate
+var grafanaTargets = map[string]string{
+	"occupancy":     "avg(percentage)::float8",
+	"occupancy_min": "min(percentage)::float8",
+	"occupancy_max": "max(percentage)::float8",
+}
+
+// grafanaRange is the dashboard time range sent with queries and annotation requests
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// grafanaQuery is the body of a /grafana/query request
+type grafanaQuery struct {
+	Range         grafanaRange `json:"range"`
+	IntervalMs    int64        `json:"intervalMs"`
+	MaxDataPoints int          `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+// GrafanaSeries is a time series in the JSON datasource format; each datapoint is [value, unix ms]
+type GrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaAnnotation marks a region of a Grafana graph
+type GrafanaAnnotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd"`
+	Title   string   `json:"title"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags"`
+}
+
+// decodeGrafanaRequest reads a JSON request body, answering with 400 on malformed input
+func decodeGrafanaRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// getGrafanaTestHandler handles /grafana/, which the datasource calls to test the connection
+func getGrafanaTestHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// getGrafanaSearchHandler handles /grafana/search and lists the available targets
+func getGrafanaSearchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, slices.Sorted(maps.Keys(grafanaTargets)))
+	}
+}
+
+// getGrafanaQueryHandler handles /grafana/query and returns each target bucketed to the panel's interval
+func getGrafanaQueryHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		var q grafanaQuery
+		if !decodeGrafanaRequest(w, r, &q) {
+			return
+		}
+		interval := time.Duration(q.IntervalMs) * time.Millisecond
+		if interval < time.Second {
+			interval = time.Second
+		}
+
+		series := []GrafanaSeries{}
+		for _, target := range q.Targets {
+			aggregate, ok := grafanaTargets[target.Target]
+			if !ok {
+				http.Error(w, fmt.Sprintf("Unknown target '%s'", target.Target), http.StatusBadRequest)
+				return
+			}
+			filter := rangeFilter(q.Range.From, q.Range.To)
+			width := filter.bind(interval.Seconds()) + "::float8"
+			query := fmt.Sprintf(`SELECT floor(extract(epoch FROM timestamp) / %[1]s) * %[1]s * 1000 AS bucket, %[2]s
+				FROM pool_usage%[3]s GROUP BY bucket ORDER BY bucket`, width, aggregate, filter.where())
+			if q.MaxDataPoints > 0 {
+				query += " LIMIT " + filter.bind(q.MaxDataPoints)
+			}
+			rows, err := pool.Query(r.Context(), query, filter.args...)
+			if err != nil {
+				writeQueryError(w, r, err)
+				return
+			}
+			s := GrafanaSeries{Target: target.Target, Datapoints: [][2]float64{}}
+			for rows.Next() {
+				var bucket, value float64
+				if err := rows.Scan(&bucket, &value); err != nil {
+					rows.Close()
+					http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+					slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+					return
+				}
+				s.Datapoints = append(s.Datapoints, [2]float64{value, bucket})
+				if err := checkRowLimit(r.Context(), len(s.Datapoints)); err != nil {
+					rows.Close()
+					writeQueryError(w, r, err)
+					return
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				http.Error(w, "Failed to read rows", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error reading rows", "error", err)
+				return
+			}
+			series = append(series, s)
+		}
+
+		writeJSON(w, series)
+	}
+}
+
+// getGrafanaAnnotationsHandler handles /grafana/annotations and marks collection outages in the dashboard range
+func getGrafanaAnnotationsHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		var q struct {
+			Range grafanaRange `json:"range"`
+		}
+		if !decodeGrafanaRequest(w, r, &q) {
+			return
+		}
+
+		gaps, err := queryGaps(r.Context(), pool, rangeFilter(q.Range.From, q.Range.To), defaultSamplingPeriod)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		annotations := []GrafanaAnnotation{}
+		for _, g := range gaps {
+			annotations = append(annotations, GrafanaAnnotation{
+				Time:    g.Start.UnixMilli(),
+				TimeEnd: g.End.UnixMilli(),
+				Title:   "Missing readings",
+				Text:    fmt.Sprintf("No readings for %s", g.Duration),
+				Tags:    []string{"gap"},
+			})
+		}
+
+		writeJSON(w, annotations)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGrafanaSearchHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	getGrafanaSearchHandler()(w, httptest.NewRequest("POST", "/grafana/search", strings.NewReader(`{"target":""}`)))
+	if got, want := strings.TrimSpace(w.Body.String()), `["occupancy","occupancy_max","occupancy_min"]`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestGrafanaQueryHandlerRejectsBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "malformed", body: `{"targets": [`, want: "Invalid request body"},
+		{name: "wrong type", body: `{"intervalMs": "1m"}`, want: "Invalid request body"},
+		{
+			name: "unknown target",
+			body: `{"range": {"from": "2024-05-01T00:00:00Z", "to": "2024-05-02T00:00:00Z"}, "targets": [{"target": "temperature"}]}`,
+			want: "Unknown target 'temperature'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getGrafanaQueryHandler(&readPool{})(w, httptest.NewRequest("POST", "/grafana/query", strings.NewReader(tt.body)))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("got body %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
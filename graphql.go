@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+// graphqlSchema exposes the same series, latest readings and aggregates as the REST endpoints
+const graphqlSchema = `
+	schema {
+		query: Query
+	}
+
+	scalar Time
+
+	enum Order {
+		ASC
+		DESC
+	}
+
+	enum BucketUnit {
+		MINUTE
+		HOUR
+		DAY
+		WEEK
+		MONTH
+	}
+
+	type DataPoint {
+		id: Int!
+		timestamp: Time!
+		percentage: Int!
+	}
+
+	type Percentile {
+		name: String!
+		value: Float!
+	}
+
+	type Bucket {
+		start: Time!
+		end: Time!
+		avg: Float!
+		min: Int!
+		max: Int!
+		count: Int!
+		percentiles: [Percentile!]!
+	}
+
+	type Query {
+		# Readings in a range; 'last' (e.g. "24h", "7d") and 'resolution' (e.g. "5m") use duration syntax
+		dataPoints(from: Time, to: Time, last: String, resolution: String, order: Order = DESC, limit: Int = 1000, offset: Int = 0): [DataPoint!]!
+		# The newest readings, newest first
+		latest(count: Int = 1): [DataPoint!]!
+		# Per-bucket statistics, with optional percentiles between 0 and 100
+		aggregates(from: Time, to: Time, last: String, bucket: BucketUnit = HOUR, percentiles: [Float!], tz: String): [Bucket!]!
+	}
+`
+
+// getGraphQLHandler handles the /graphql endpoint
+func getGraphQLHandler(reads *readPool) http.Handler {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{reads: reads})
+	handler := &relay.Handler{Schema: schema}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// graphqlResolver is the root resolver of graphqlSchema
+type graphqlResolver struct {
+	reads *readPool
+}
+
+// graphqlRange is the time range shared by the dataPoints and aggregates fields
+type graphqlRange struct {
+	From *graphql.Time
+	To   *graphql.Time
+	Last *string
+}
+
+// filter validates the range and builds the matching filter
+func (a graphqlRange) filter() (*queryFilter, error) {
+	var from, to *time.Time
+	if a.From != nil {
+		from = &a.From.Time
+	}
+	if a.To != nil {
+		to = &a.To.Time
+	}
+	if a.Last != nil {
+		if from != nil {
+			return nil, fmt.Errorf("'from' and 'last' are mutually exclusive")
+		}
+		span, err := parseSpan(*a.Last)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'last' argument: expected a duration such as 24h or 7d")
+		}
+		start := time.Now()
+		if to != nil {
+			start = *to
+		}
+		start = start.Add(-span)
+		from = &start
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, fmt.Errorf("'from' must be before 'to'")
+	}
+
+	filter := newQueryFilter()
+	if from != nil {
+		filter.add("timestamp >= $%d", *from)
+	}
+	if to != nil {
+		filter.add("timestamp < $%d", *to)
+	}
+	return filter, nil
+}
+
+func (g *graphqlResolver) DataPoints(ctx context.Context, args struct {
+	graphqlRange
+	Resolution *string
+	Order      string
+	Limit      int32
+	Offset     int32
+}) ([]*dataPointResolver, error) {
+	filter, err := args.filter()
+	if err != nil {
+		return nil, err
+	}
+	if args.Limit < 1 || args.Limit > maxPageSize {
+		return nil, fmt.Errorf("'limit' must be between 1 and %d", maxPageSize)
+	}
+	if args.Offset < 0 {
+		return nil, fmt.Errorf("'offset' must not be negative")
+	}
+	points := &pointsQuery{filter: filter, ascending: args.Order == "ASC", fields: dataFields}
+	if args.Resolution != nil {
+		points.resolution, err = time.ParseDuration(*args.Resolution)
+		if err != nil || points.resolution < time.Second {
+			return nil, fmt.Errorf("invalid 'resolution' argument: expected a duration of at least 1s, such as 5m or 1h")
+		}
+	}
+
+	source := points.source()
+	query := fmt.Sprintf("SELECT id, timestamp, percentage FROM %s%s LIMIT %s OFFSET %s",
+		source, points.orderBy(), filter.bind(args.Limit), filter.bind(args.Offset))
+	return g.queryPoints(ctx, query, filter.args...)
+}
+
+func (g *graphqlResolver) Latest(ctx context.Context, args struct{ Count int32 }) ([]*dataPointResolver, error) {
+	if args.Count < 1 || args.Count > maxLatestCount {
+		return nil, fmt.Errorf("'count' must be between 1 and %d", maxLatestCount)
+	}
+	return g.queryPoints(ctx, "SELECT id, timestamp, percentage FROM pool_usage WHERE NOT suspect ORDER BY timestamp DESC, id DESC LIMIT $1", args.Count)
+}
+
+func (g *graphqlResolver) Aggregates(ctx context.Context, args struct {
+	graphqlRange
+	Bucket      string
+	Percentiles *[]float64
+	Tz          *string
+}) ([]*bucketResolver, error) {
+	filter, err := args.filter()
+	if err != nil {
+		return nil, err
+	}
+	var loc *time.Location
+	if args.Tz != nil {
+		if loc, err = time.LoadLocation(*args.Tz); err != nil {
+			return nil, fmt.Errorf("unknown time zone '%s'", *args.Tz)
+		}
+	}
+	var percentiles []percentileParam
+	if args.Percentiles != nil {
+		for _, p := range *args.Percentiles {
+			if p < 0 || p > 100 {
+				return nil, fmt.Errorf("percentile %v is not between 0 and 100", p)
+			}
+			percentiles = append(percentiles, percentileParam{Name: fmt.Sprintf("p%v", p), Fraction: p / 100})
+		}
+	}
+
+	buckets, err := queryBuckets(ctx, g.reads.pool(), bucketUnits[strings.ToLower(args.Bucket)], filter, percentiles, loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the database")
+	}
+	resolvers := make([]*bucketResolver, len(buckets))
+	for i := range buckets {
+		resolvers[i] = &bucketResolver{b: buckets[i], percentiles: percentiles}
+	}
+	return resolvers, nil
+}
+
+// queryPoints runs a query selecting (id, timestamp, percentage) and wraps the rows in resolvers
+func (g *graphqlResolver) queryPoints(ctx context.Context, query string, args ...any) ([]*dataPointResolver, error) {
+	rows, err := g.reads.pool().Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the database")
+	}
+	defer rows.Close()
+	dataPoints, err := scanDataPoints(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan row")
+	}
+	resolvers := make([]*dataPointResolver, len(dataPoints))
+	for i := range dataPoints {
+		resolvers[i] = &dataPointResolver{dp: dataPoints[i]}
+	}
+	return resolvers, nil
+}
+
+type dataPointResolver struct {
+	dp DataPoint
+}
+
+func (r *dataPointResolver) ID() int32               { return int32(r.dp.ID) }
+func (r *dataPointResolver) Timestamp() graphql.Time { return graphql.Time{Time: r.dp.Timestamp} }
+func (r *dataPointResolver) Percentage() int32       { return int32(r.dp.Percentage) }
+
+type bucketResolver struct {
+	b           Bucket
+	percentiles []percentileParam
+}
+
+func (r *bucketResolver) Start() graphql.Time { return graphql.Time{Time: r.b.Start} }
+func (r *bucketResolver) End() graphql.Time   { return graphql.Time{Time: r.b.End} }
+func (r *bucketResolver) Avg() float64        { return r.b.Avg }
+func (r *bucketResolver) Min() int32          { return int32(r.b.Min) }
+func (r *bucketResolver) Max() int32          { return int32(r.b.Max) }
+func (r *bucketResolver) Count() int32        { return int32(r.b.Count) }
+
+// Percentiles returns the percentiles in the order they were requested
+func (r *bucketResolver) Percentiles() []*percentileResolver {
+	resolvers := make([]*percentileResolver, 0, len(r.percentiles))
+	for _, p := range r.percentiles {
+		resolvers = append(resolvers, &percentileResolver{name: p.Name, value: r.b.Percentiles[p.Name]})
+	}
+	return resolvers
+}
+
+type percentileResolver struct {
+	name  string
+	value float64
+}
+
+func (r *percentileResolver) Name() string   { return r.name }
+func (r *percentileResolver) Value() float64 { return r.value }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+)
+
+func TestGraphQLRangeFilter(t *testing.T) {
+	at := func(day int) *graphql.Time {
+		return &graphql.Time{Time: time.Date(2024, 5, day, 0, 0, 0, 0, time.UTC)}
+	}
+	span := func(s string) *string { return &s }
+	tests := []struct {
+		name  string
+		rng   graphqlRange
+		where string
+		args  []any
+		err   string
+	}{
+		{name: "no range", where: " WHERE NOT suspect"},
+		{name: "from and to", rng: graphqlRange{From: at(1), To: at(2)}, where: " WHERE NOT suspect AND timestamp >= $1 AND timestamp < $2", args: []any{at(1).Time, at(2).Time}},
+		{name: "last before to", rng: graphqlRange{To: at(8), Last: span("7d")}, where: " WHERE NOT suspect AND timestamp >= $1 AND timestamp < $2", args: []any{at(1).Time, at(8).Time}},
+		{name: "from and last", rng: graphqlRange{From: at(1), Last: span("7d")}, err: "'from' and 'last' are mutually exclusive"},
+		{name: "invalid last", rng: graphqlRange{Last: span("a week")}, err: "invalid 'last' argument: expected a duration such as 24h or 7d"},
+		{name: "reversed", rng: graphqlRange{From: at(2), To: at(1)}, err: "'from' must be before 'to'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := tt.rng.filter()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := filter.where(); got != tt.where {
+				t.Errorf("got where %q, want %q", got, tt.where)
+			}
+			for i, arg := range filter.args {
+				if !arg.(time.Time).Equal(tt.args[i].(time.Time)) {
+					t.Errorf("got arg %d %v, want %v", i+1, arg, tt.args[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGraphQLRejectsArguments(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{name: "limit of 0", query: "{ dataPoints(limit: 0) { id } }", err: "'limit' must be between 1 and 10000"},
+		{name: "negative offset", query: "{ dataPoints(offset: -1) { id } }", err: "'offset' must not be negative"},
+		{name: "short resolution", query: `{ dataPoints(resolution: "10ms") { id } }`, err: "invalid 'resolution' argument: expected a duration of at least 1s, such as 5m or 1h"},
+		{name: "latest count of 0", query: "{ latest(count: 0) { id } }", err: "'count' must be between 1 and 1000"},
+		{name: "unknown field", query: "{ readings { id } }", err: `Cannot query field "readings" on type "Query".`},
+	}
+	handler := getGraphQLHandler(&readPool{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(map[string]string{"query": tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body))))
+			var resp struct {
+				Errors []struct{ Message string }
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unable to decode %s: %v", w.Body, err)
+			}
+			if len(resp.Errors) != 1 || resp.Errors[0].Message != tt.err {
+				t.Errorf("got errors %+v, want %q", resp.Errors, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"igor.am/pool-api/poolpb"
+)
+
+// grpcServer implements poolpb.PoolServiceServer on top of the same queries as the HTTP handlers
+type grpcServer struct {
+	poolpb.UnimplementedPoolServiceServer
+	pool  *pgxpool.Pool
+	store *readingStore
+}
+
+// serveGRPC runs the gRPC API on addr until the listener fails or ctx is done, then lets the
+// calls in flight finish for up to timeout
+func serveGRPC(ctx context.Context, timeout time.Duration, store *readingStore, guard *grpcGuard, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %v", addr, err)
+	}
+	server := grpc.NewServer(grpc.UnaryInterceptor(guard.unary), grpc.StreamInterceptor(guard.stream))
+	poolpb.RegisterPoolServiceServer(server, &grpcServer{pool: store.pool, store: store})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(lis)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Serve returns as soon as the listener closes, so the drain is waited for here
+	timer := time.AfterFunc(timeout, server.Stop)
+	defer timer.Stop()
+	server.GracefulStop()
+	return nil
+}
+
+// grpcRangeFilter builds a filter from optional protobuf range bounds
+func grpcRangeFilter(from, to *timestamppb.Timestamp) (*queryFilter, error) {
+	filter := newQueryFilter()
+	if from != nil {
+		filter.add("timestamp >= $%d", from.AsTime())
+	}
+	if to != nil {
+		filter.add("timestamp < $%d", to.AsTime())
+	}
+	if from != nil && to != nil && !from.AsTime().Before(to.AsTime()) {
+		return nil, status.Error(codes.InvalidArgument, "'from' must be before 'to'")
+	}
+	return filter, nil
+}
+
+func toProtoPoint(dp DataPoint) *poolpb.DataPoint {
+	return &poolpb.DataPoint{Id: int64(dp.ID), Timestamp: timestamppb.New(dp.Timestamp), Percentage: int32(dp.Percentage)}
+}
+
+// GetData streams the readings in the requested range
+func (s *grpcServer) GetData(req *poolpb.GetDataRequest, stream grpc.ServerStreamingServer[poolpb.DataPoint]) error {
+	filter, err := grpcRangeFilter(req.From, req.To)
+	if err != nil {
+		return err
+	}
+	if req.Limit < 0 {
+		return status.Error(codes.InvalidArgument, "'limit' must not be negative")
+	}
+	order := " ORDER BY timestamp, id"
+	if req.Descending {
+		order = " ORDER BY timestamp DESC, id DESC"
+	}
+	query := "SELECT id, timestamp, percentage FROM pool_usage" + filter.where() + order
+	if req.Limit > 0 {
+		query += " LIMIT " + filter.bind(req.Limit)
+	}
+
+	rows, err := s.pool.Query(stream.Context(), query, filter.args...)
+	if err != nil {
+		slog.Error("Error querying database", "error", err)
+		return status.Error(codes.Internal, "failed to query the database")
+	}
+	defer rows.Close()
+	for n := 1; rows.Next(); n++ {
+		if err := checkRowLimit(stream.Context(), n); err != nil {
+			return status.Error(codes.ResourceExhausted, err.Error()+"; narrow the time range or set 'limit'")
+		}
+		var dp DataPoint
+		if err := rows.Scan(&dp.ID, &dp.Timestamp, &dp.Percentage); err != nil {
+			slog.Error("Error scanning row", "error", err)
+			return status.Error(codes.Internal, "failed to scan row")
+		}
+		if err := stream.Send(toProtoPoint(dp)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error reading rows", "error", err)
+		return status.Error(codes.Internal, "failed to read rows")
+	}
+	return nil
+}
+
+// GetLatest returns the newest readings, newest first
+func (s *grpcServer) GetLatest(ctx context.Context, req *poolpb.GetLatestRequest) (*poolpb.GetLatestResponse, error) {
+	if req.Count < 1 || req.Count > maxLatestCount {
+		return nil, status.Errorf(codes.InvalidArgument, "'count' must be between 1 and %d", maxLatestCount)
+	}
+	rows, err := s.pool.Query(ctx,
+		"SELECT id, timestamp, percentage FROM pool_usage WHERE NOT suspect ORDER BY timestamp DESC, id DESC LIMIT $1", req.Count)
+	if err != nil {
+		slog.Error("Error querying database", "error", err)
+		return nil, status.Error(codes.Internal, "failed to query the database")
+	}
+	defer rows.Close()
+	dataPoints, err := scanDataPoints(rows)
+	if err != nil {
+		slog.Error("Error scanning row", "error", err)
+		return nil, status.Error(codes.Internal, "failed to scan row")
+	}
+
+	resp := &poolpb.GetLatestResponse{}
+	for _, dp := range dataPoints {
+		resp.DataPoints = append(resp.DataPoints, toProtoPoint(dp))
+	}
+	return resp, nil
+}
+
+// GetAggregate returns per-bucket statistics for the requested range
+func (s *grpcServer) GetAggregate(ctx context.Context, req *poolpb.GetAggregateRequest) (*poolpb.GetAggregateResponse, error) {
+	filter, err := grpcRangeFilter(req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+	bucket := req.Bucket
+	if bucket == "" {
+		bucket = "hour"
+	}
+	unit, ok := bucketUnits[bucket]
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "'bucket' must be one of minute, hour, day, week or month")
+	}
+	var loc *time.Location
+	if req.Tz != "" {
+		if loc, err = time.LoadLocation(req.Tz); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown time zone '%s'", req.Tz)
+		}
+	}
+	var percentiles []percentileParam
+	for _, p := range req.Percentiles {
+		if p < 0 || p > 100 {
+			return nil, status.Errorf(codes.InvalidArgument, "percentile %v is not between 0 and 100", p)
+		}
+		percentiles = append(percentiles, percentileParam{Name: "p" + strconv.FormatFloat(p, 'f', -1, 64), Fraction: p / 100})
+	}
+
+	buckets, err := queryBuckets(ctx, s.pool, unit, filter, percentiles, loc)
+	if errors.Is(err, errTooManyRows) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error()+"; narrow the time range or use a coarser bucket")
+	}
+	if err != nil {
+		slog.Error("Error querying database", "error", err)
+		return nil, status.Error(codes.Internal, "failed to query the database")
+	}
+	resp := &poolpb.GetAggregateResponse{}
+	for _, b := range buckets {
+		resp.Buckets = append(resp.Buckets, &poolpb.Bucket{
+			Start:       timestamppb.New(b.Start),
+			End:         timestamppb.New(b.End),
+			Avg:         b.Avg,
+			Min:         int32(b.Min),
+			Max:         int32(b.Max),
+			Count:       int64(b.Count),
+			Percentiles: b.Percentiles,
+		})
+	}
+	return resp, nil
+}
+
+// Ingest stores the readings of the stream one after the other and acknowledges each. Invalid
+// readings are rejected individually; only database failures end the stream, so the gateway
+// keeps the unacknowledged readings buffered and resends them after reconnecting.
+func (s *grpcServer) Ingest(stream grpc.BidiStreamingServer[poolpb.IngestRequest, poolpb.IngestAck]) error {
+	ctx := stream.Context()
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		percentage := int(req.GetPercentage())
+		in := ingestReading{Percentage: &percentage}
+		if req.GetTimestamp() != nil {
+			timestamp := req.GetTimestamp().AsTime()
+			in.Timestamp = &timestamp
+		}
+		ack := &poolpb.IngestAck{Sequence: req.GetSequence()}
+		if err := in.validate(time.Now()); err != nil {
+			ack.Status = poolpb.IngestStatus_INGEST_STATUS_REJECTED
+			ack.Error = err.Error()
+			if err := stream.Send(ack); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dp, err := s.store.store(ctx, *in.Timestamp, *in.Percentage)
+		switch {
+		case err == nil:
+			ack.Status = poolpb.IngestStatus_INGEST_STATUS_STORED
+			ack.DataPoint = toProtoPoint(dp)
+		case errors.Is(err, errDuplicateReading):
+			ack.Status = poolpb.IngestStatus_INGEST_STATUS_DUPLICATE
+			ack.DataPoint = toProtoPoint(dp)
+		case errors.Is(err, errConflictingReading), errors.Is(err, errSpikeReading):
+			ack.Status = poolpb.IngestStatus_INGEST_STATUS_REJECTED
+			ack.Error = err.Error()
+		default:
+			slog.Error("Error inserting reading", "error", err)
+			return status.Error(codes.Unavailable, "failed to store the reading")
+		}
+		if err := stream.Send(ack); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"igor.am/pool-api/poolpb"
+)
+
+// grpcGuard applies the checks of the HTTP routes to gRPC calls: the network filter of the route
+// group, the rate limit, the scope and the row cap
+type grpcGuard struct {
+	auth *authenticator
+	// limits is nil without rate limits
+	limits  *rateLimiter
+	filters map[string]*ipFilter
+	caps    resultCaps
+	// mtlsOnly refuses the ingest calls, since readings are accepted over mTLS only
+	mtlsOnly bool
+}
+
+// grpcScopes names the scope of the calls that require one, which also picks their network
+// filter; the others read and require the read scope only with REQUIRE_READ_AUTH=true
+var grpcScopes = map[string]string{
+	poolpb.PoolService_Ingest_FullMethodName: scopeIngest,
+}
+
+// unary guards the unary calls
+func (g *grpcGuard) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := g.check(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// stream guards the streaming calls
+func (g *grpcGuard) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := g.check(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &guardedStream{ServerStream: ss, ctx: ctx})
+}
+
+// guardedStream replaces the context of a stream with the one the guard admitted the call with
+type guardedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *guardedStream) Context() context.Context {
+	return s.ctx
+}
+
+// check admits a call to method or returns the status refusing it, in the order the HTTP routes
+// are wrapped in
+func (g *grpcGuard) check(ctx context.Context, method string) (context.Context, error) {
+	scope := grpcScopes[method]
+	if scope == scopeIngest && g.mtlsOnly {
+		return nil, status.Error(codes.FailedPrecondition, "readings are accepted over the mTLS listener only")
+	}
+	ip := peerIP(ctx)
+	if filter := g.filters[cmp.Or(scope, scopeRead)]; filter != nil && !filter.allowsIP(ip) {
+		slog.InfoContext(ctx, "Refused a call", "client_ip", ip, "method", method)
+		return nil, status.Error(codes.PermissionDenied, "calls from your network are not allowed")
+	}
+	if g.limits != nil {
+		if err := g.take("ip:"+ip, g.limits.perIP); err != nil {
+			return nil, err
+		}
+	}
+	if scope == "" && g.auth.requireRead {
+		scope = scopeRead
+	}
+	if scope != "" {
+		var err error
+		if ctx, err = g.authenticate(ctx, scope); err != nil {
+			return nil, err
+		}
+		if id, ok := apiKeyIDFrom(ctx); ok && g.limits != nil {
+			if err := g.take("key:"+strconv.Itoa(id), g.limits.perKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if g.caps.rows > 0 {
+		ctx = context.WithValue(ctx, rowLimitKey{}, g.caps.rows)
+	}
+	return ctx, nil
+}
+
+// take counts a call against the bucket of client, refusing it if the bucket is empty
+func (g *grpcGuard) take(client string, perMinute int) error {
+	if perMinute == 0 {
+		return nil
+	}
+	if _, delay := g.limits.take(client, perMinute, time.Now()); delay > 0 {
+		return status.Errorf(codes.ResourceExhausted, "too many requests, retry in %v", delay.Round(time.Second))
+	}
+	return nil
+}
+
+// authenticate checks the API key or bearer token in the call's metadata for scope and returns
+// the context with the caller
+func (g *grpcGuard) authenticate(ctx context.Context, scope string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var actor string
+	var err error
+	if keys := md.Get(apiKeyHeader); len(keys) > 0 {
+		var id int
+		if actor, id, err = g.auth.checkAPIKey(ctx, keys[0], scope); err == nil {
+			ctx = withAPIKeyID(ctx, id)
+		}
+	} else {
+		var authorization string
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+		actor, err = g.auth.checkBearer(scope, authorization)
+	}
+	var refused *authError
+	switch {
+	case errors.As(err, &refused) && refused.status == http.StatusUnauthorized:
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid credentials")
+	case errors.As(err, &refused):
+		return nil, status.Error(codes.PermissionDenied, refused.message)
+	case err != nil:
+		slog.ErrorContext(ctx, "Error checking API key", "error", err)
+		return nil, status.Error(codes.Internal, "failed to query the database")
+	}
+	return withActorContext(ctx, actor), nil
+}
+
+// peerIP returns the address a call came from, or "" if it is not an IP address
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"igor.am/pool-api/poolpb"
+)
+
+// resetSecrets empties the credential cache so a test sees the variables it sets
+func resetSecrets(t *testing.T) {
+	t.Helper()
+	clear := func() {
+		secretCache.Lock()
+		secretCache.values = map[string]cachedSecret{}
+		secretCache.Unlock()
+	}
+	clear()
+	t.Cleanup(clear)
+}
+
+func TestGRPCRangeFilter(t *testing.T) {
+	from := timestamppb.New(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	to := timestamppb.New(time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name  string
+		from  *timestamppb.Timestamp
+		to    *timestamppb.Timestamp
+		where string
+		code  codes.Code
+	}{
+		{name: "no range", where: " WHERE NOT suspect"},
+		{name: "from", from: from, where: " WHERE NOT suspect AND timestamp >= $1"},
+		{name: "from and to", from: from, to: to, where: " WHERE NOT suspect AND timestamp >= $1 AND timestamp < $2"},
+		{name: "reversed", from: to, to: from, code: codes.InvalidArgument},
+		{name: "empty", from: from, to: from, code: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := grpcRangeFilter(tt.from, tt.to)
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("got code %v, want %v", got, tt.code)
+			}
+			if err == nil && filter.where() != tt.where {
+				t.Errorf("got where %q, want %q", filter.where(), tt.where)
+			}
+		})
+	}
+}
+
+func TestGRPCGetLatestRejectsCount(t *testing.T) {
+	for _, count := range []int32{-1, 0, maxLatestCount + 1} {
+		_, err := (&grpcServer{}).GetLatest(context.Background(), &poolpb.GetLatestRequest{Count: count})
+		if got := status.Code(err); got != codes.InvalidArgument {
+			t.Errorf("count %d: got code %v, want %v", count, got, codes.InvalidArgument)
+		}
+	}
+}
+
+func TestGRPCGuardCheck(t *testing.T) {
+	resetSecrets(t)
+	t.Setenv("INGEST_TOKEN", "s3cret")
+	office := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name     string
+		guard    grpcGuard
+		method   string
+		ip       string
+		metadata metadata.MD
+		code     codes.Code
+		actor    string
+	}{
+		{name: "read", method: poolpb.PoolService_GetLatest_FullMethodName, ip: "192.0.2.1"},
+		{
+			name:   "read with REQUIRE_READ_AUTH",
+			guard:  grpcGuard{auth: &authenticator{requireRead: true}},
+			method: poolpb.PoolService_GetLatest_FullMethodName,
+			ip:     "192.0.2.1",
+			code:   codes.PermissionDenied,
+		},
+		{name: "ingest without credentials", method: poolpb.PoolService_Ingest_FullMethodName, ip: "192.0.2.1", code: codes.Unauthenticated},
+		{
+			name:     "ingest with a wrong token",
+			method:   poolpb.PoolService_Ingest_FullMethodName,
+			ip:       "192.0.2.1",
+			metadata: metadata.Pairs("authorization", "Bearer guess"),
+			code:     codes.Unauthenticated,
+		},
+		{
+			name:     "ingest with the token",
+			method:   poolpb.PoolService_Ingest_FullMethodName,
+			ip:       "192.0.2.1",
+			metadata: metadata.Pairs("authorization", "Bearer s3cret"),
+			actor:    "token:ingest",
+		},
+		{
+			name:     "ingest when mTLS is required",
+			guard:    grpcGuard{mtlsOnly: true},
+			method:   poolpb.PoolService_Ingest_FullMethodName,
+			ip:       "192.0.2.1",
+			metadata: metadata.Pairs("authorization", "Bearer s3cret"),
+			code:     codes.FailedPrecondition,
+		},
+		{
+			name:     "ingest from outside the allowed networks",
+			guard:    grpcGuard{filters: map[string]*ipFilter{scopeIngest: {allow: office}}},
+			method:   poolpb.PoolService_Ingest_FullMethodName,
+			ip:       "192.0.2.1",
+			metadata: metadata.Pairs("authorization", "Bearer s3cret"),
+			code:     codes.PermissionDenied,
+		},
+		{
+			name:     "ingest from an allowed network",
+			guard:    grpcGuard{filters: map[string]*ipFilter{scopeIngest: {allow: office}}},
+			method:   poolpb.PoolService_Ingest_FullMethodName,
+			ip:       "10.1.2.3",
+			metadata: metadata.Pairs("authorization", "Bearer s3cret"),
+			actor:    "token:ingest",
+		},
+		{
+			name:   "read from a denied network",
+			guard:  grpcGuard{filters: map[string]*ipFilter{scopeRead: {deny: office}}},
+			method: poolpb.PoolService_GetData_FullMethodName,
+			ip:     "10.1.2.3",
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "reads from one IP beyond the limit",
+			guard:  grpcGuard{limits: &rateLimiter{perIP: 1, buckets: map[string]*rateBucket{}}},
+			method: poolpb.PoolService_GetLatest_FullMethodName,
+			ip:     "192.0.2.1",
+			code:   codes.ResourceExhausted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.guard.auth == nil {
+				tt.guard.auth = &authenticator{}
+			}
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(tt.ip), Port: 50000}})
+			ctx = metadata.NewIncomingContext(ctx, tt.metadata)
+			if tt.guard.limits != nil {
+				// Use up the bucket, so the call under test is the one over the limit
+				if _, err := tt.guard.check(ctx, tt.method); err != nil {
+					t.Fatalf("first call refused: %v", err)
+				}
+			}
+			ctx, err := tt.guard.check(ctx, tt.method)
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("got code %v (%v), want %v", got, err, tt.code)
+			}
+			if err == nil && actorFrom(ctx) != tt.actor {
+				t.Errorf("got actor %q, want %q", actorFrom(ctx), tt.actor)
+			}
+		})
+	}
+}
+
+// ingestStream replays requests to Ingest and collects its acks
+type ingestStream struct {
+	grpc.ServerStream
+	requests []*poolpb.IngestRequest
+	acks     []*poolpb.IngestAck
+}
+
+func (s *ingestStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *ingestStream) Recv() (*poolpb.IngestRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *ingestStream) Send(ack *poolpb.IngestAck) error {
+	s.acks = append(s.acks, ack)
+	return nil
+}
+
+func TestGRPCIngestRejectsReadings(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *poolpb.IngestRequest
+		err  string
+	}{
+		{name: "percentage too high", req: &poolpb.IngestRequest{Sequence: 7, Percentage: 140}, err: "'percentage' must be between 0 and 100"},
+		{name: "negative percentage", req: &poolpb.IngestRequest{Sequence: 8, Percentage: -5}, err: "'percentage' must be between 0 and 100"},
+		{
+			name: "timestamp in the future",
+			req:  &poolpb.IngestRequest{Sequence: 9, Percentage: 40, Timestamp: timestamppb.New(time.Now().Add(time.Hour))},
+			err:  "'timestamp' must not lie more than 5m0s in the future",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &ingestStream{requests: []*poolpb.IngestRequest{tt.req}}
+			if err := (&grpcServer{}).Ingest(stream); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(stream.acks) != 1 {
+				t.Fatalf("got %d acks, want 1", len(stream.acks))
+			}
+			ack := stream.acks[0]
+			if ack.GetSequence() != tt.req.GetSequence() || ack.GetStatus() != poolpb.IngestStatus_INGEST_STATUS_REJECTED || ack.GetError() != tt.err {
+				t.Errorf("got ack %v, want sequence %d rejected with %q", ack, tt.req.GetSequence(), tt.err)
+			}
+		})
+	}
+}
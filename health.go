@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// readinessTimeout bounds the database ping of the readiness probe, well below the probe
+// timeouts of orchestrators
+const readinessTimeout = 2 * time.Second
+
+// getLivenessHandler answers as long as the process serves HTTP, so a restart only happens when it is stuck
+func getLivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// getReadinessHandler answers 503 when the primary database does not answer a ping in time, so
+// load balancers send requests to other instances until the pool recovers. A replica that is
+// down does not count, since reads fall back to the primary.
+func getReadinessHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		if err := pool.Ping(ctx); err != nil {
+			slog.WarnContext(r.Context(), "Not ready, the database does not answer", "error", err)
+			http.Error(w, "The database is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Heatmap holds the average occupancy per weekday (Monday first) and hour of day;
+// cells without readings are null
+type Heatmap struct {
+	Weekdays []string        `json:"weekdays"`
+	Values   [7][24]*float64 `json:"values"`
+}
+
+var weekdayNames = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// getHeatmapHandler handles the /pool-data/heatmap endpoint and returns a weekday × hour matrix of average occupancy
+func getHeatmapHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		heatmap, err := queryHeatmap(r.Context(), pool, filter, loc)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		writeJSON(w, heatmap)
+	}
+}
+
+// queryHeatmap averages the readings matching filter per weekday and hour of the wall-clock time
+// of loc, or of the database session when it is nil
+func queryHeatmap(ctx context.Context, db querier, filter *queryFilter, loc *time.Location) (Heatmap, error) {
+	// isodow numbers the days 1 (Monday) to 7 (Sunday); both fields follow the requested time zone
+	local := filter.localTimestamp(loc)
+	query := `SELECT extract(isodow FROM ` + local + `)::int AS dow, extract(hour FROM ` + local + `)::int AS hour, avg(percentage)::float8
+		FROM pool_usage` + filter.where() + ` GROUP BY dow, hour`
+	rows, err := db.Query(ctx, query, filter.args...)
+	if err != nil {
+		return Heatmap{}, err
+	}
+	defer rows.Close()
+
+	heatmap := Heatmap{Weekdays: weekdayNames}
+	for rows.Next() {
+		var dow, hour int
+		var avg float64
+		if err := rows.Scan(&dow, &hour, &avg); err != nil {
+			return Heatmap{}, err
+		}
+		heatmap.Values[dow-1][hour] = &avg
+	}
+	return heatmap, rows.Err()
+}
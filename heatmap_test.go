@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryHeatmap(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		loc   *time.Location
+		rows  [][]any
+		cells map[[2]int]float64
+		// local is the expression the weekday and hour are taken from
+		local string
+		args  []any
+	}{
+		{name: "empty range", local: "timestamp", args: []any{from, to}},
+		{name: "first and last cell", rows: [][]any{{1, 0, 10.0}, {7, 23, 90.0}}, cells: map[[2]int]float64{{0, 0}: 10, {6, 23}: 90},
+			local: "timestamp", args: []any{from, to}},
+		{name: "time zone", loc: berlin, rows: [][]any{{3, 12, 50.5}}, cells: map[[2]int]float64{{2, 12}: 50.5},
+			local: "(timestamp AT TIME ZONE $3::text)", args: []any{from, to, "Europe/Berlin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeQuerier{rows: tt.rows}
+			heatmap, err := queryHeatmap(context.Background(), db, rangeFilter(from, to), tt.loc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for day := range 7 {
+				for hour := range 24 {
+					got := heatmap.Values[day][hour]
+					want, ok := tt.cells[[2]int{day, hour}]
+					if ok != (got != nil) || ok && *got != want {
+						t.Errorf("got %v on %s at %d:00, want %v (set %v)", got, weekdayNames[day], hour, want, ok)
+					}
+				}
+			}
+			if !strings.Contains(db.sql, "extract(isodow FROM "+tt.local+")") || !strings.Contains(db.sql, "extract(hour FROM "+tt.local+")") {
+				t.Errorf("got query %q, want the weekday and hour of %s", db.sql, tt.local)
+			}
+			if !slices.Equal(db.args, tt.args) {
+				t.Errorf("got arguments %v, want %v", db.args, tt.args)
+			}
+		})
+	}
+}
+
+func TestHeatmapJSON(t *testing.T) {
+	avg := 42.5
+	heatmap := Heatmap{Weekdays: weekdayNames}
+	heatmap.Values[1][7] = &avg
+	data, err := json.Marshal(heatmap)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got struct {
+		Weekdays []string     `json:"weekdays"`
+		Values   [][]*float64 `json:"values"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(got.Weekdays, weekdayNames) {
+		t.Errorf("got weekdays %v, want %v", got.Weekdays, weekdayNames)
+	}
+	if len(got.Values) != 7 || len(got.Values[0]) != 24 {
+		t.Fatalf("got %d rows of %d values, want 7 of 24", len(got.Values), len(got.Values[0]))
+	}
+	if got.Values[1][7] == nil || *got.Values[1][7] != avg || got.Values[1][8] != nil {
+		t.Errorf("got Tuesday 07:00 %v and 08:00 %v, want %v and null", got.Values[1][7], got.Values[1][8], avg)
+	}
+}
+
+func TestHeatmapHandlerRejectsParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{name: "unknown time zone", query: "tz=Mars/Olympus", body: "invalid 'tz' parameter: unknown time zone 'Mars/Olympus'"},
+		{name: "from after to", query: "from=2024-05-02T00:00:00Z&to=2024-05-01T00:00:00Z", body: "'from' must be before 'to'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getHeatmapHandler(&readPool{})(w, httptest.NewRequest("GET", "/pool-data/heatmap?"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// HAState is the shape Home Assistant's REST sensor reads with value_template and json_attributes
+type HAState struct {
+	State      int          `json:"state"`
+	Attributes HAAttributes `json:"attributes"`
+}
+
+// HAAttributes are the extra sensor attributes exposed to Home Assistant
+type HAAttributes struct {
+	UnitOfMeasurement string    `json:"unit_of_measurement"`
+	FriendlyName      string    `json:"friendly_name"`
+	Icon              string    `json:"icon"`
+	StateClass        string    `json:"state_class"`
+	LastUpdated       time.Time `json:"last_updated"`
+	AgeSeconds        int       `json:"age_seconds"`
+	Trend             string    `json:"trend"`
+}
+
+// getHAStateHandler handles the /ha/state endpoint, e.g. for a sensor configured as
+//
+//	sensor:
+//	  - platform: rest
+//	    resource: http://pool-api:8080/ha/state
+//	    value_template: "{{ value_json.state }}"
+//	    json_attributes_path: "$.attributes"
+//	    json_attributes: [last_updated, age_seconds, trend]
+//	    unit_of_measurement: "%"
+func getHAStateHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		s, err := queryStatus(r.Context(), pool)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "No data available", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		writeJSON(w, haState(s))
+	}
+}
+
+// haState describes the latest status as a Home Assistant sensor
+func haState(s Status) HAState {
+	return HAState{
+		State: s.Percentage,
+		Attributes: HAAttributes{
+			UnitOfMeasurement: "%",
+			FriendlyName:      "Pool occupancy",
+			Icon:              "mdi:pool",
+			StateClass:        "measurement",
+			LastUpdated:       s.Timestamp.UTC(),
+			AgeSeconds:        s.AgeSeconds,
+			Trend:             s.Trend,
+		},
+	}
+}
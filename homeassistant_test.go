@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHAState(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Status{
+		Percentage:    42,
+		Timestamp:     time.Date(2024, 5, 1, 10, 30, 0, 0, berlin),
+		AgeSeconds:    90,
+		Trend:         "rising",
+		ChangePerHour: 7.5,
+	}
+	got, err := json.Marshal(haState(s))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The REST sensor reads value_json.state and the attributes by these exact names
+	want := `{"state":42,"attributes":{"unit_of_measurement":"%","friendly_name":"Pool occupancy","icon":"mdi:pool",` +
+		`"state_class":"measurement","last_updated":"2024-05-01T08:30:00Z","age_seconds":90,"trend":"rising"}}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// httpMetrics counts and times the requests of every route, labelled by the route's pattern so
+// that identifiers in paths do not multiply the series
+type httpMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	size     *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+// newHTTPMetrics creates the request metrics and registers them with reg
+func newHTTPMetrics(reg prometheus.Registerer) *httpMetrics {
+	m := &httpMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Requests served, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time until the response was complete, by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_response_size_bytes",
+			Help:    "Size of the response bodies before compression, by route, method and status code.",
+			Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+		}, []string{"route", "method", "code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Requests being served, by route.",
+		}, []string{"route"}),
+	}
+	reg.MustRegister(m.requests, m.duration, m.size, m.inFlight)
+	return m
+}
+
+// instrument wraps the handler of the route registered under the path pattern with the request metrics
+func (m *httpMetrics) instrument(path string, next http.Handler) http.Handler {
+	route := prometheus.Labels{"route": path}
+	next = promhttp.InstrumentHandlerResponseSize(m.size.MustCurryWith(route), next)
+	next = promhttp.InstrumentHandlerDuration(m.duration.MustCurryWith(route), next)
+	next = promhttp.InstrumentHandlerCounter(m.requests.MustCurryWith(route), next)
+	return promhttp.InstrumentHandlerInFlight(m.inFlight.With(route), next)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHTTPMetricsInstrument(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{name: "ok", status: http.StatusOK, body: `{"count":3}`},
+		{name: "bad request", status: http.StatusBadRequest, body: "invalid 'limit' parameter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newHTTPMetrics(prometheus.NewRegistry())
+			handler := m.instrument("/pool-data/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := testutil.ToFloat64(m.inFlight.WithLabelValues("/pool-data/{id}")); got != 1 {
+					t.Errorf("got %v requests in flight, want 1", got)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/pool-data/42", nil))
+
+			labels := prometheus.Labels{"route": "/pool-data/{id}", "method": "get", "code": strconv.Itoa(tt.status)}
+			if got := testutil.ToFloat64(m.requests.With(labels)); got != 1 {
+				t.Errorf("got %v requests, want 1", got)
+			}
+			if got := testutil.CollectAndCount(m.size); got != 1 {
+				t.Errorf("got %d size series, want 1", got)
+			}
+			if got := testutil.ToFloat64(m.inFlight.WithLabelValues("/pool-data/{id}")); got != 0 {
+				t.Errorf("got %v requests in flight after the response, want 0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// subscriberBuffer is the number of data points buffered per subscriber before new ones are dropped
+const subscriberBuffer = 64
+
+// hub fans newly stored data points out to in-process subscribers such as the webhook dispatcher
+type hub struct {
+	mu   sync.Mutex
+	subs map[chan DataPoint]struct{}
+	// lastID is the highest id published so far
+	lastID int
+	// closed is set on shutdown, after which subscribers get a closed channel
+	closed bool
+}
+
+func newHub() *hub {
+	return &hub{subs: map[chan DataPoint]struct{}{}}
+}
+
+// subscribe registers a subscriber; the returned function unsubscribes and closes the channel
+func (h *hub) subscribe() (<-chan DataPoint, func()) {
+	ch := make(chan DataPoint, subscriberBuffer)
+	h.mu.Lock()
+	if h.closed {
+		close(ch)
+	} else {
+		h.subs[ch] = struct{}{}
+	}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish hands dp to every subscriber without blocking; a subscriber that has fallen
+// behind by more than subscriberBuffer points misses it. Points are published both by the
+// ingest endpoint and by the poller, so ids that were already published are ignored.
+func (h *hub) publish(dp DataPoint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if dp.ID <= h.lastID {
+		return
+	}
+	h.lastID = dp.ID
+	for ch := range h.subs {
+		select {
+		case ch <- dp:
+		default:
+			slog.Warn("Dropping a data point for a slow subscriber", "id", dp.ID)
+		}
+	}
+}
+
+// close closes the channel of every subscriber, so streams and long polls end on shutdown
+func (h *hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// watchNewReadings polls pool_usage for rows with a higher id than the last one seen and publishes
+// them in id order, both every interval and whenever wake fires. Readings written directly to the
+// database by an external collector are only noticed this way. Rows older than the newest reading seen, as written by a backfill, are history
+// rather than news and are not published.
+func watchNewReadings(ctx context.Context, pool *pgxpool.Pool, h *hub, interval time.Duration, wake <-chan struct{}) {
+	var lastID int
+	var newest *time.Time
+	err := pool.QueryRow(ctx, "SELECT coalesce(max(id), 0), max(timestamp) FROM pool_usage").Scan(&lastID, &newest)
+	if err != nil {
+		slog.Error("Error reading the latest id, watching from the beginning", "error", err)
+	}
+	var latest time.Time
+	if newest != nil {
+		latest = *newest
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+
+		rows, err := pool.Query(ctx, "SELECT id, timestamp, percentage FROM pool_usage WHERE id > $1 AND NOT suspect ORDER BY id", lastID)
+		if err != nil {
+			slog.Error("Error polling for new readings", "error", err)
+			continue
+		}
+		dataPoints, err := scanDataPoints(rows)
+		rows.Close()
+		if err != nil {
+			slog.Error("Error scanning new readings", "error", err)
+			continue
+		}
+		for _, dp := range dataPoints {
+			lastID = dp.ID
+			if dp.Timestamp.After(latest) {
+				latest = dp.Timestamp
+				h.publish(dp)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// idempotencyKeyTTL is how long a key is remembered; retries after that are treated as new requests
+	idempotencyKeyTTL = 24 * time.Hour
+	// maxIdempotencyKeyLength bounds the length of the Idempotency-Key header
+	maxIdempotencyKeyLength = 255
+)
+
+// responseRecorder passes a response through while keeping a copy of its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush streamed responses
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// idempotent makes a write endpoint safe to retry. When a request carries an Idempotency-Key
+// header, the first response for that key is stored and replayed for every retry with the
+// same body; reusing a key for a different body is rejected with 422 and a retry while the
+// first request is still running with 409. Server errors are not stored so they can be retried.
+// Keys are scoped to the caller, so different callers may use the same key independently.
+func idempotent(db database, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("The Idempotency-Key header must be at most %d characters", maxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		// The body is buffered to be hashed, so it is bounded by the largest the endpoints accept
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+		actor := actorFrom(r.Context())
+
+		// Claim the key; if another request already did, replay or reject. The bookkeeping must
+		// finish even if the client goes away, so it does not use the request's context.
+		ctx := context.Background()
+		_, err = db.Exec(ctx, "DELETE FROM idempotency_keys WHERE created_at < now() - make_interval(secs => $1)", idempotencyKeyTTL.Seconds())
+		if err == nil {
+			var claimed bool
+			err = db.QueryRow(ctx, `INSERT INTO idempotency_keys (actor, key, request_hash) VALUES ($1, $2, $3)
+				ON CONFLICT (actor, key) DO NOTHING RETURNING true`, actor, key, hash).Scan(&claimed)
+			if errors.Is(err, pgx.ErrNoRows) {
+				replayIdempotent(w, r, db, actor, key, hash)
+				return
+			}
+		}
+		if err != nil {
+			http.Error(w, "Failed to query the database", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error claiming idempotency key", "error", err)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status >= 500 {
+			_, err = db.Exec(ctx, "DELETE FROM idempotency_keys WHERE actor = $1 AND key = $2", actor, key)
+		} else {
+			_, err = db.Exec(ctx, "UPDATE idempotency_keys SET status_code = $3, content_type = $4, body = $5 WHERE actor = $1 AND key = $2",
+				actor, key, recorder.status, w.Header().Get("Content-Type"), recorder.body.Bytes())
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error storing idempotent response", "error", err)
+		}
+	})
+}
+
+// replayIdempotent answers a retried request from the stored response of the caller's key
+func replayIdempotent(w http.ResponseWriter, r *http.Request, db querier, actor, key, hash string) {
+	var storedHash string
+	var status *int
+	var contentType *string
+	var body []byte
+	err := db.QueryRow(r.Context(), "SELECT request_hash, status_code, content_type, body FROM idempotency_keys WHERE actor = $1 AND key = $2", actor, key).
+		Scan(&storedHash, &status, &contentType, &body)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		// The first request failed and released the key in the meantime
+		http.Error(w, "The request with this Idempotency-Key failed, retry it", http.StatusConflict)
+		return
+	case err != nil:
+		writeQueryError(w, r, err)
+		return
+	case storedHash != hash:
+		http.Error(w, "The Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return
+	case status == nil:
+		http.Error(w, "A request with this Idempotency-Key is still being processed", http.StatusConflict)
+		return
+	}
+
+	if contentType != nil && *contentType != "" {
+		w.Header().Set("Content-Type", *contentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*status)
+	w.Write(body)
+}
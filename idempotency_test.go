@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIdempotentWithoutDatabase(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "no key", key: "", body: `{"percentage": 5}`, status: http.StatusCreated, want: `{"percentage": 5}`},
+		{name: "key too long", key: strings.Repeat("k", maxIdempotencyKeyLength+1), body: `{}`, status: http.StatusBadRequest, want: "The Idempotency-Key header must be at most 255 characters"},
+		{name: "body too large", key: "retry-1", body: strings.Repeat(" ", maxBatchBytes+1), status: http.StatusRequestEntityTooLarge, want: "The request body is larger than 33554432 bytes"},
+	}
+	// The handler echoes the body, which it can only read if the middleware let the request through
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/pool-data", strings.NewReader(tt.body))
+			if tt.key != "" {
+				r.Header.Set("Idempotency-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			idempotent(nil, next).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("got body %.100s, want %.100s", got, tt.want)
+			}
+		})
+	}
+}
+
+// storedKey is a row of keyTable
+type storedKey struct {
+	hash        string
+	status      any
+	contentType any
+	body        []byte
+}
+
+// keyTable is an idempotency_keys table in memory, keyed by the actor and key of the statements
+type keyTable map[[2]string]*storedKey
+
+func (k keyTable) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	switch {
+	case strings.HasPrefix(sql, "DELETE FROM idempotency_keys WHERE actor"):
+		delete(k, [2]string{args[0].(string), args[1].(string)})
+	case strings.HasPrefix(sql, "UPDATE idempotency_keys"):
+		stored := k[[2]string{args[0].(string), args[1].(string)}]
+		stored.status, stored.contentType, stored.body = args[2], args[3], args[4].([]byte)
+	}
+	return pgconn.CommandTag{}, nil
+}
+
+func (k keyTable) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	id := [2]string{args[0].(string), args[1].(string)}
+	stored, ok := k[id]
+	switch {
+	case strings.HasPrefix(sql, "INSERT INTO idempotency_keys"):
+		if ok {
+			return &fakeRows{}, nil
+		}
+		k[id] = &storedKey{hash: args[2].(string)}
+		return &fakeRows{rows: [][]any{{true}}}, nil
+	case ok:
+		return &fakeRows{rows: [][]any{{stored.hash, stored.status, stored.contentType, stored.body}}}, nil
+	}
+	return &fakeRows{}, nil
+}
+
+func (k keyTable) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	rows, err := k.Query(ctx, sql, args...)
+	return fakeRow{rows: rows, err: err}
+}
+
+func TestIdempotent(t *testing.T) {
+	type request struct {
+		actor    string
+		body     string
+		status   int
+		want     string
+		replayed bool
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{name: "retry", requests: []request{
+			{actor: "api-key:sensor-1", body: "a", status: http.StatusCreated, want: `{"call":1}`},
+			{actor: "api-key:sensor-1", body: "a", status: http.StatusCreated, want: `{"call":1}`, replayed: true},
+		}},
+		{name: "different body", requests: []request{
+			{actor: "api-key:sensor-1", body: "a", status: http.StatusCreated, want: `{"call":1}`},
+			{actor: "api-key:sensor-1", body: "b", status: http.StatusUnprocessableEntity, want: "The Idempotency-Key was already used for a different request"},
+		}},
+		{name: "two actors share a key", requests: []request{
+			{actor: "api-key:sensor-1", body: "a", status: http.StatusCreated, want: `{"call":1}`},
+			{actor: "api-key:sensor-2", body: "a", status: http.StatusCreated, want: `{"call":2}`},
+			{actor: "api-key:sensor-1", body: "a", status: http.StatusCreated, want: `{"call":1}`, replayed: true},
+			{actor: "api-key:sensor-2", body: "b", status: http.StatusUnprocessableEntity, want: "The Idempotency-Key was already used for a different request"},
+		}},
+		{name: "anonymous and authenticated caller", requests: []request{
+			{body: "a", status: http.StatusCreated, want: `{"call":1}`},
+			{actor: "token:admin", body: "a", status: http.StatusCreated, want: `{"call":2}`},
+			{body: "a", status: http.StatusCreated, want: `{"call":1}`, replayed: true},
+		}},
+		{name: "server error", requests: []request{
+			{actor: "api-key:sensor-1", body: "fail", status: http.StatusInternalServerError, want: `{"call":1}`},
+			{actor: "api-key:sensor-1", body: "fail", status: http.StatusInternalServerError, want: `{"call":2}`},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The handler numbers its calls, so a replayed response repeats an earlier number
+			calls := 0
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				calls++
+				w.Header().Set("Content-Type", "application/json")
+				if string(body) == "fail" {
+					w.WriteHeader(http.StatusInternalServerError)
+				} else {
+					w.WriteHeader(http.StatusCreated)
+				}
+				fmt.Fprintf(w, `{"call":%d}`, calls)
+			})
+			handler := idempotent(keyTable{}, next)
+			for i, req := range tt.requests {
+				r := httptest.NewRequest("POST", "/pool-data", strings.NewReader(req.body))
+				r.Header.Set("Idempotency-Key", "retry-1")
+				if req.actor != "" {
+					r = withActor(r, req.actor)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if w.Code != req.status {
+					t.Fatalf("request %d: got status %d, want %d", i, w.Code, req.status)
+				}
+				if got := strings.TrimSpace(w.Body.String()); got != req.want {
+					t.Errorf("request %d: got body %s, want %s", i, got, req.want)
+				}
+				if got := w.Header().Get("Idempotent-Replayed") == "true"; got != req.replayed {
+					t.Errorf("request %d: got replayed %v, want %v", i, got, req.replayed)
+				}
+			}
+		})
+	}
+}
+
+func TestResponseRecorder(t *testing.T) {
+	tests := []struct {
+		name   string
+		write  func(w http.ResponseWriter)
+		status int
+		body   string
+	}{
+		{name: "implicit 200", write: func(w http.ResponseWriter) { w.Write([]byte("ok")) }, status: http.StatusOK, body: "ok"},
+		{
+			name: "explicit status",
+			write: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":1}`))
+			},
+			status: http.StatusCreated,
+			body:   `{"id":1}`,
+		},
+		{name: "error", write: func(w http.ResponseWriter) { http.Error(w, "conflict", http.StatusConflict) }, status: http.StatusConflict, body: "conflict\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			recorder := &responseRecorder{ResponseWriter: w}
+			tt.write(recorder)
+			if recorder.status != tt.status || recorder.body.String() != tt.body {
+				t.Errorf("recorded %d %q, want %d %q", recorder.status, recorder.body.String(), tt.status, tt.body)
+			}
+			if w.Code != tt.status || w.Body.String() != tt.body {
+				t.Errorf("passed through %d %q, want %d %q", w.Code, w.Body.String(), tt.status, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxImportBytes bounds the size of an uploaded CSV file
+const maxImportBytes = 64 << 20
+
+// ImportResult reports what a CSV import stored and skipped
+type ImportResult struct {
+	Rows       int   `json:"rows"`
+	Imported   int64 `json:"imported"`
+	Duplicates int64 `json:"duplicates"`
+}
+
+// csvMapping describes how the columns of an uploaded file map onto readings
+type csvMapping struct {
+	timestampColumn  string
+	percentageColumn string
+	// timeFormat is "rfc3339", "unix", "auto" or a Go reference layout such as "02.01.2006 15:04"
+	timeFormat string
+	// loc interprets timestamps without a zone offset
+	loc       *time.Location
+	delimiter rune
+}
+
+// parseCSVMapping reads the column mapping from the query parameters
+func parseCSVMapping(r *http.Request, poolLocation *time.Location) (csvMapping, error) {
+	query := r.URL.Query()
+	m := csvMapping{
+		timestampColumn:  query.Get("timestamp_column"),
+		percentageColumn: query.Get("percentage_column"),
+		timeFormat:       query.Get("time_format"),
+		loc:              poolLocation,
+		delimiter:        ',',
+	}
+	if m.timestampColumn == "" {
+		m.timestampColumn = "timestamp"
+	}
+	if m.percentageColumn == "" {
+		m.percentageColumn = "percentage"
+	}
+	if m.timeFormat == "" {
+		m.timeFormat = "rfc3339"
+	}
+	loc, err := parseLocation(r)
+	if err != nil {
+		return m, err
+	}
+	if loc != nil {
+		m.loc = loc
+	}
+	if value := query.Get("delimiter"); value != "" {
+		if value == `\t` {
+			value = "\t"
+		}
+		d, size := utf8.DecodeRuneInString(value)
+		if size != len(value) || d == '"' || d == '\r' || d == '\n' {
+			return m, fmt.Errorf("invalid 'delimiter' parameter: expected a single character")
+		}
+		m.delimiter = d
+	}
+	return m, nil
+}
+
+// autoTimeLayouts are tried in order by the "auto" time format
+var autoTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+}
+
+// parseTimestamp parses a timestamp cell according to the mapping
+func (m csvMapping) parseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	switch m.timeFormat {
+	case "auto":
+		for _, layout := range autoTimeLayouts {
+			if t, err := time.ParseInLocation(layout, value, m.loc); err == nil {
+				return t, nil
+			}
+		}
+		if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return unixTimestamp(seconds), nil
+		}
+		return time.Time{}, fmt.Errorf("'%s' is not in a known timestamp format", value)
+	case "rfc3339":
+		return time.Parse(time.RFC3339, value)
+	case "unix":
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("'%s' is not a Unix timestamp", value)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	return time.ParseInLocation(m.timeFormat, value, m.loc)
+}
+
+// unixTimestamp converts a Unix timestamp in seconds, or milliseconds as written by JavaScript
+// dumps, to a time
+func unixTimestamp(n int64) time.Time {
+	if n > 1e11 {
+		return time.UnixMilli(n)
+	}
+	return time.Unix(n, 0)
+}
+
+// readCSVReadings parses the uploaded file into validated readings; a header row names the columns
+func readCSVReadings(file io.Reader, m csvMapping) ([]ingestReading, error) {
+	reader := csv.NewReader(file)
+	reader.Comma = m.delimiter
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header row: %v", err)
+	}
+	// Spreadsheet exports often start with a byte order mark
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	timestampIndex := slices.Index(header, m.timestampColumn)
+	if timestampIndex < 0 {
+		return nil, fmt.Errorf("column '%s' not found in the header row", m.timestampColumn)
+	}
+	percentageIndex := slices.Index(header, m.percentageColumn)
+	if percentageIndex < 0 {
+		return nil, fmt.Errorf("column '%s' not found in the header row", m.percentageColumn)
+	}
+
+	now := time.Now()
+	var readings []ingestReading
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) <= max(timestampIndex, percentageIndex) {
+			return nil, fmt.Errorf("line %d: expected at least %d columns", line, max(timestampIndex, percentageIndex)+1)
+		}
+		timestamp, err := m.parseTimestamp(record[timestampIndex])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp: %v", line, err)
+		}
+		value, err := parseDecimal(record[percentageIndex])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid percentage: %v", line, err)
+		}
+		percentage := int(math.Round(value))
+		in := ingestReading{Timestamp: &timestamp, Percentage: &percentage}
+		if err := in.validate(now); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		readings = append(readings, in)
+	}
+	return readings, nil
+}
+
+// getCSVImportHandler handles POST /import/csv. The file is uploaded as the 'file' field of a
+// multipart form; readings whose timestamp already exists, in the table or earlier in the
+// file, are skipped, so an export can be imported repeatedly.
+func getCSVImportHandler(store *readingStore, poolLocation *time.Location) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mapping, err := parseCSVMapping(r, poolLocation)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Expected a multipart form with a 'file' field", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		readings, err := readCSVReadings(file, mapping)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rows := make([][]any, len(readings))
+		for i, in := range readings {
+			rows[i] = []any{*in.Timestamp, *in.Percentage}
+		}
+		imported, err := store.storeAll(r.Context(), rows)
+		if err != nil {
+			http.Error(w, "Failed to import the readings", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error importing readings", "error", err)
+			return
+		}
+		result := ImportResult{Rows: len(rows), Imported: imported, Duplicates: int64(len(rows)) - imported}
+
+		writeJSON(w, result)
+	}
+}
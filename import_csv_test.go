@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCSVMapping(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		want      csvMapping
+		err       string
+		locString string
+	}{
+		{
+			name:      "defaults",
+			query:     "",
+			want:      csvMapping{timestampColumn: "timestamp", percentageColumn: "percentage", timeFormat: "rfc3339", delimiter: ','},
+			locString: "UTC",
+		},
+		{
+			name:      "columns, format and zone",
+			query:     "timestamp_column=Zeit&percentage_column=Auslastung&time_format=auto&tz=Europe/Berlin&delimiter=;",
+			want:      csvMapping{timestampColumn: "Zeit", percentageColumn: "Auslastung", timeFormat: "auto", delimiter: ';'},
+			locString: "Europe/Berlin",
+		},
+		{
+			name:      "escaped tab",
+			query:     `delimiter=\t`,
+			want:      csvMapping{timestampColumn: "timestamp", percentageColumn: "percentage", timeFormat: "rfc3339", delimiter: '\t'},
+			locString: "UTC",
+		},
+		{name: "several characters", query: "delimiter=;;", err: "invalid 'delimiter' parameter: expected a single character"},
+		{name: "quote", query: `delimiter="`, err: "invalid 'delimiter' parameter: expected a single character"},
+		{name: "unknown time zone", query: "tz=Pool/Deep", err: "invalid 'tz' parameter: unknown time zone 'Pool/Deep'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCSVMapping(httptest.NewRequest("POST", "/import/csv?"+strings.ReplaceAll(tt.query, ";", "%3B"), nil), time.UTC)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.loc.String() != tt.locString {
+				t.Errorf("got location %v, want %s", got.loc, tt.locString)
+			}
+			got.loc = nil
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVMappingParseTimestamp(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		format string
+		value  string
+		want   time.Time
+		err    bool
+	}{
+		{name: "RFC3339", format: "rfc3339", value: "2024-05-01T12:00:00+02:00", want: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)},
+		{name: "RFC3339 without zone", format: "rfc3339", value: "2024-05-01T12:00:00", err: true},
+		{name: "Unix", format: "unix", value: "1714564800", want: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "Unix, not a number", format: "unix", value: "yesterday", err: true},
+		{name: "layout", format: "02.01.2006 15:04", value: "01.05.2024 14:00", want: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "auto, local time", format: "auto", value: "2024-05-01 14:00:00", want: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "auto, with zone", format: "auto", value: "2024-05-01T12:00:00Z", want: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "auto, German", format: "auto", value: " 01.05.2024 14:00 ", want: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "auto, Unix seconds", format: "auto", value: "1714564800", want: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "auto, Unix milliseconds", format: "auto", value: "1714564800000", want: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "auto, unknown", format: "auto", value: "May 1st", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := csvMapping{timeFormat: tt.format, loc: berlin}.parseTimestamp(tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCSVReadings(t *testing.T) {
+	mapping := csvMapping{timestampColumn: "timestamp", percentageColumn: "percentage", timeFormat: "rfc3339", loc: time.UTC, delimiter: ','}
+	tests := []struct {
+		name        string
+		file        string
+		percentages []int
+		err         string
+	}{
+		{name: "readings", file: "timestamp,percentage\n2024-05-01T12:00:00Z,42\n2024-05-01T12:15:00Z,37.6\n", percentages: []int{42, 38}},
+		{name: "byte order mark and extra columns", file: "\ufefflane, timestamp,percentage\n3,2024-05-01T12:00:00Z,42 %\n", percentages: []int{42}},
+		{name: "header only", file: "timestamp,percentage\n"},
+		{name: "empty file", file: "", err: "unable to read the header row: EOF"},
+		{name: "missing column", file: "time,percentage\n", err: "column 'timestamp' not found in the header row"},
+		{name: "short row", file: "timestamp,percentage\n2024-05-01T12:00:00Z\n", err: "line 2: expected at least 2 columns"},
+		{name: "bad timestamp", file: "timestamp,percentage\nnoon,42\n", err: `line 2: invalid timestamp: parsing time "noon" as "2006-01-02T15:04:05Z07:00": cannot parse "noon" as "2006"`},
+		{name: "bad percentage", file: "timestamp,percentage\n2024-05-01T12:00:00Z,full\n", err: "line 2: invalid percentage: 'full' is not a number"},
+		{name: "out of range", file: "timestamp,percentage\n2024-05-01T12:00:00Z,42\n2024-05-01T12:15:00Z,140\n", err: "line 3: 'percentage' must be between 0 and 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readings, err := readCSVReadings(strings.NewReader(tt.file), mapping)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var percentages []int
+			for _, in := range readings {
+				percentages = append(percentages, *in.Percentage)
+			}
+			if !slices.Equal(percentages, tt.percentages) {
+				t.Errorf("got percentages %v, want %v", percentages, tt.percentages)
+			}
+		})
+	}
+}
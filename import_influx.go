@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxImport holds the options of the import-influx subcommand
+type influxImport struct {
+	url         string
+	token       string
+	org         string
+	bucket      string
+	measurement string
+	field       string
+	tags        map[string]string
+	// unit is percent, ratio (0 to 1) or count (visitors, divided by capacity)
+	unit      string
+	capacity  float64
+	precision time.Duration
+	client    *http.Client
+}
+
+// runInfluxImport implements the import-influx subcommand:
+//
+//	pool-api import-influx -url http://influx:8086 -org ORG -bucket BUCKET -from 2022-01-01T00:00:00Z [flags]
+//
+// It queries the bucket through the InfluxDB 2 Flux API (InfluxDB 1.8 accepts the same with
+// -token user:password) one chunk at a time, converts the values to percentages and the timestamps
+// to the requested precision, and stores them with duplicate timestamps skipped, so an interrupted
+// import can simply be run again.
+func runInfluxImport(args []string) error {
+	flags := flag.NewFlagSet("import-influx", flag.ExitOnError)
+	imp := influxImport{client: &http.Client{Timeout: 5 * time.Minute}}
+	token, err := secret("INFLUX_TOKEN")
+	if err != nil {
+		return err
+	}
+	flags.StringVar(&imp.url, "url", "http://localhost:8086", "InfluxDB base URL")
+	flags.StringVar(&imp.token, "token", token, "API token (default INFLUX_TOKEN)")
+	flags.StringVar(&imp.org, "org", "", "organization")
+	flags.StringVar(&imp.bucket, "bucket", "", "bucket to read from")
+	flags.StringVar(&imp.measurement, "measurement", "pool_usage", "measurement holding the readings")
+	flags.StringVar(&imp.field, "field", "percentage", "field holding the occupancy")
+	tags := flags.String("tags", "", "comma-separated key=value tag filters, e.g. pool=main")
+	flags.StringVar(&imp.unit, "unit", "percent", "unit of the field: percent, ratio or count")
+	flags.Float64Var(&imp.capacity, "capacity", 0, "capacity to divide counts by, required with -unit count")
+	precision := flags.String("precision", "s", "precision to truncate timestamps to: ns, us, ms or s")
+	fromFlag := flags.String("from", "", "start of the range (RFC3339, required)")
+	toFlag := flags.String("to", "", "end of the range (RFC3339, default now)")
+	chunk := flags.Duration("chunk", 24*time.Hour, "length of the range queried at once")
+	flags.Parse(args)
+
+	if imp.bucket == "" || *fromFlag == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	from, err := time.Parse(time.RFC3339, *fromFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %v", err)
+	}
+	to := time.Now()
+	if *toFlag != "" {
+		if to, err = time.Parse(time.RFC3339, *toFlag); err != nil {
+			return fmt.Errorf("invalid -to: %v", err)
+		}
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("-from must be before -to")
+	}
+	if *chunk <= 0 {
+		return fmt.Errorf("-chunk must be positive")
+	}
+	var ok bool
+	if imp.precision, ok = influxPrecisions[*precision]; !ok {
+		return fmt.Errorf("invalid -precision %q: expected ns, us, ms or s", *precision)
+	}
+	switch imp.unit {
+	case "percent", "ratio":
+	case "count":
+		if imp.capacity <= 0 {
+			return fmt.Errorf("-capacity is required with -unit count")
+		}
+	default:
+		return fmt.Errorf("invalid -unit %q: expected percent, ratio or count", imp.unit)
+	}
+	imp.tags = map[string]string{}
+	for _, pair := range strings.Split(*tags, ",") {
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid -tags entry %q: expected key=value", pair)
+		}
+		imp.tags[key] = value
+	}
+
+	pool, err := getDatabasePool(context.Background())
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := migrateOnStart(pool); err != nil {
+		return err
+	}
+	store, err := newImportStore(pool)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var total, inserted int64
+	for start := from; start.Before(to); start = start.Add(*chunk) {
+		stop := start.Add(*chunk)
+		if stop.After(to) {
+			stop = to
+		}
+		rows, err := imp.query(ctx, start, stop)
+		if err != nil {
+			return fmt.Errorf("querying %s to %s: %v", start.Format(time.RFC3339), stop.Format(time.RFC3339), err)
+		}
+		n, err := store.storeAll(ctx, rows)
+		if err != nil {
+			return fmt.Errorf("storing %s to %s: %v", start.Format(time.RFC3339), stop.Format(time.RFC3339), err)
+		}
+		total += int64(len(rows))
+		inserted += n
+		slog.Info("Imported", "from", start.Format(time.RFC3339), "to", stop.Format(time.RFC3339), "points", len(rows), "inserted", n)
+	}
+	slog.Info("Import finished", "points", total, "inserted", inserted, "duplicates", total-inserted)
+	return nil
+}
+
+// fluxString quotes s as a Flux string literal; Flux understands the escapes Go emits for quotes,
+// backslashes and control characters
+func fluxString(s string) string {
+	return strconv.Quote(s)
+}
+
+// flux builds the query for one chunk
+func (imp *influxImport) flux(start, stop time.Time) string {
+	predicates := []string{
+		"r._measurement == " + fluxString(imp.measurement),
+		"r._field == " + fluxString(imp.field),
+	}
+	keys := make([]string, 0, len(imp.tags))
+	for key := range imp.tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		predicates = append(predicates, fmt.Sprintf("r[%s] == %s", fluxString(key), fluxString(imp.tags[key])))
+	}
+	return fmt.Sprintf(`from(bucket: %s)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => %s)
+  |> keep(columns: ["_time", "_value"])
+  |> group()
+  |> sort(columns: ["_time"])`,
+		fluxString(imp.bucket), start.UTC().Format(time.RFC3339Nano), stop.UTC().Format(time.RFC3339Nano), strings.Join(predicates, " and "))
+}
+
+// query fetches one chunk and returns {timestamp, percentage} rows for storeAll
+func (imp *influxImport) query(ctx context.Context, start, stop time.Time) ([][]any, error) {
+	endpoint := strings.TrimSuffix(imp.url, "/") + "/api/v2/query?org=" + url.QueryEscape(imp.org)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(imp.flux(start, stop)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.flux")
+	req.Header.Set("Accept", "application/csv")
+	if imp.token != "" {
+		req.Header.Set("Authorization", "Token "+imp.token)
+	}
+	resp, err := imp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("unexpected response status %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	// The response holds one CSV table per series, each starting with its own header row
+	reader := csv.NewReader(resp.Body)
+	reader.FieldsPerRecord = -1
+	timeIndex, valueIndex := -1, -1
+	var rows [][]any
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the response: %v", err)
+		}
+		if i := slices.Index(record, "_time"); i >= 0 {
+			timeIndex, valueIndex = i, slices.Index(record, "_value")
+			continue
+		}
+		// Errors during the query arrive as a table of their own
+		if i := slices.Index(record, "error"); i >= 0 && slices.Contains(record, "reference") {
+			message, _ := reader.Read()
+			if i < len(message) {
+				return nil, fmt.Errorf("query failed: %s", message[i])
+			}
+			return nil, fmt.Errorf("query failed")
+		}
+		if timeIndex < 0 || valueIndex < 0 || len(record) <= max(timeIndex, valueIndex) {
+			continue
+		}
+
+		timestamp, err := time.Parse(time.RFC3339Nano, record[timeIndex])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q: %v", record[timeIndex], err)
+		}
+		value, err := strconv.ParseFloat(record[valueIndex], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q at %s: %v", record[valueIndex], record[timeIndex], err)
+		}
+		percentage, err := imp.percentage(value)
+		if err != nil {
+			return nil, fmt.Errorf("at %s: %v", record[timeIndex], err)
+		}
+		rows = append(rows, []any{timestamp.Truncate(imp.precision).UTC(), percentage})
+	}
+	return rows, nil
+}
+
+// percentage converts a field value in the import's unit to a whole percentage
+func (imp *influxImport) percentage(value float64) (int, error) {
+	switch imp.unit {
+	case "ratio":
+		value *= 100
+	case "count":
+		value = value / imp.capacity * 100
+	}
+	percentage := int(math.Round(value))
+	if percentage < 0 || percentage > 100 {
+		return 0, fmt.Errorf("value %v converts to %d%%, outside 0 to 100", value, percentage)
+	}
+	return percentage, nil
+}
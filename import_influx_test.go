@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInfluxFlux(t *testing.T) {
+	imp := &influxImport{
+		bucket:      "pool",
+		measurement: "occupancy",
+		field:       "value",
+		tags:        map[string]string{"site": `main "hall"`, "area": "indoor"},
+	}
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := `from(bucket: "pool")
+  |> range(start: 2022-01-01T00:00:00Z, stop: 2022-01-08T00:00:00Z)
+  |> filter(fn: (r) => r._measurement == "occupancy" and r._field == "value" and r["area"] == "indoor" and r["site"] == "main \"hall\"")
+  |> keep(columns: ["_time", "_value"])
+  |> group()
+  |> sort(columns: ["_time"])`
+	if got := imp.flux(start, start.Add(7*24*time.Hour)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestInfluxPercentage(t *testing.T) {
+	tests := []struct {
+		name  string
+		imp   influxImport
+		value float64
+		want  int
+		err   string
+	}{
+		{name: "percent", imp: influxImport{unit: "percent"}, value: 42.4, want: 42},
+		{name: "ratio", imp: influxImport{unit: "ratio"}, value: 0.425, want: 43},
+		{name: "count", imp: influxImport{unit: "count", capacity: 350}, value: 70, want: 20},
+		{name: "count over capacity", imp: influxImport{unit: "count", capacity: 350}, value: 400, err: "value 114.28571428571428 converts to 114%, outside 0 to 100"},
+		{name: "negative", imp: influxImport{unit: "percent"}, value: -3, err: "value -3 converts to -3%, outside 0 to 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.imp.percentage(tt.value)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfluxQuery(t *testing.T) {
+	twoTables := ",result,table,_time,_value\r\n" +
+		",_result,0,2022-01-01T10:00:20Z,41\r\n" +
+		",_result,0,2022-01-01T10:15:00Z,44.6\r\n" +
+		"\r\n" +
+		",result,table,_value,_time\r\n" +
+		",_result,1,50,2022-01-01T10:30:00Z\r\n"
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   [][]any
+		err    string
+	}{
+		{
+			name:   "tables",
+			status: http.StatusOK,
+			body:   twoTables,
+			want: [][]any{
+				{time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC), 41},
+				{time.Date(2022, 1, 1, 10, 15, 0, 0, time.UTC), 45},
+				{time.Date(2022, 1, 1, 10, 30, 0, 0, time.UTC), 50},
+			},
+		},
+		{name: "empty", status: http.StatusOK, body: ""},
+		{name: "query error", status: http.StatusOK, body: ",error,reference\r\n,bucket not found,897\r\n", err: "query failed: bucket not found"},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{"code":"unauthorized"}`, err: `unexpected response status 401 Unauthorized: {"code":"unauthorized"}`},
+		{name: "invalid value", status: http.StatusOK, body: ",result,table,_time,_value\r\n,_result,0,2022-01-01T10:00:00Z,full\r\n", err: `invalid value "full" at 2022-01-01T10:00:00Z: strconv.ParseFloat: parsing "full": invalid syntax`},
+		{name: "out of range", status: http.StatusOK, body: ",result,table,_time,_value\r\n,_result,0,2022-01-01T10:00:00Z,120\r\n", err: "at 2022-01-01T10:00:00Z: value 120 converts to 120%, outside 0 to 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request *http.Request
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				request = r
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			imp := &influxImport{url: server.URL + "/", token: "t0ken", org: "my org", unit: "percent", precision: time.Minute, client: server.Client()}
+
+			start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			got, err := imp.query(context.Background(), start, start.Add(24*time.Hour))
+			if got := request.URL.String(); got != "/api/v2/query?org=my+org" {
+				t.Errorf("got request to %s", got)
+			}
+			if got := request.Header.Get("Authorization"); got != "Token t0ken" {
+				t.Errorf("got Authorization %q", got)
+			}
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxClockSkew is how far in the future an ingested timestamp may lie
+	maxClockSkew = 5 * time.Minute
+	// maxBatchSize bounds the number of readings accepted by one batch request
+	maxBatchSize = 100000
+	// maxBatchBytes bounds the size of a batch request body
+	maxBatchBytes = 32 << 20
+	// maxReadingBytes bounds the size of a request body carrying a single reading
+	maxReadingBytes = 64 << 10
+)
+
+// BatchResult reports how many readings a batch request stored and how many it skipped
+// because their timestamp was already stored
+type BatchResult struct {
+	Inserted   int64 `json:"inserted"`
+	Duplicates int64 `json:"duplicates"`
+}
+
+// ingestReading is the body accepted by POST /pool-data
+type ingestReading struct {
+	Timestamp  *time.Time `json:"timestamp"`
+	Percentage *int       `json:"percentage"`
+}
+
+// validate checks the reading and fills in the default timestamp; it returns a *ValidationError
+func (in *ingestReading) validate(now time.Time) error {
+	var errs fieldErrors
+	if in.Percentage == nil {
+		errs.add("percentage", "is required")
+	} else if *in.Percentage < 0 || *in.Percentage > 100 {
+		errs.add("percentage", "must be between 0 and 100")
+	}
+	if in.Timestamp == nil {
+		in.Timestamp = &now
+	} else if in.Timestamp.After(now.Add(maxClockSkew)) {
+		errs.add("timestamp", fmt.Sprintf("must not lie more than %s in the future", maxClockSkew))
+	}
+	return errs.err()
+}
+
+// getIngestHandler handles POST /pool-data; it stores one reading and publishes it to the hub.
+// Repeats of the previous reading are answered with the stored reading and 200 instead of 201.
+func getIngestHandler(store *readingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in ingestReading
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReadingBytes))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&in); err != nil {
+			writeBodyError(w, decodeError(err))
+			return
+		}
+		if err := in.validate(time.Now()); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		dp, err := store.store(r.Context(), *in.Timestamp, *in.Percentage)
+		if errors.Is(err, errDuplicateReading) {
+			writeJSON(w, dp)
+			return
+		}
+		if errors.Is(err, errConflictingReading) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, errSpikeReading) {
+			writeBodyError(w, store.spikes.rejection())
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to store the reading", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error inserting reading", "error", err)
+			return
+		}
+
+		writeJSONStatus(w, http.StatusCreated, dp)
+	}
+}
+
+// decodeBatch reads a JSON array of readings, or one reading per line for NDJSON bodies
+func decodeBatch(w http.ResponseWriter, r *http.Request) ([]ingestReading, error) {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	decoder.DisallowUnknownFields()
+	var readings []ingestReading
+	if contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); contentType == "application/x-ndjson" {
+		for decoder.More() {
+			var in ingestReading
+			if err := decoder.Decode(&in); err != nil {
+				return nil, prefixFieldErrors(decodeError(err), fmt.Sprintf("[%d]", len(readings)))
+			}
+			readings = append(readings, in)
+			if len(readings) > maxBatchSize {
+				break
+			}
+		}
+	} else if err := decoder.Decode(&readings); err != nil {
+		return nil, decodeError(err)
+	}
+	if len(readings) == 0 {
+		return nil, fmt.Errorf("the batch contains no readings")
+	}
+	if len(readings) > maxBatchSize {
+		return nil, fmt.Errorf("the batch contains more than %d readings", maxBatchSize)
+	}
+	return readings, nil
+}
+
+// getBatchIngestHandler handles POST /pool-data/batch; the readings are validated up front
+// and copied into pool_usage in a single transaction, so either all or none are stored
+func getBatchIngestHandler(store *readingStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		readings, err := decodeBatch(w, r)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		now := time.Now()
+		rows := make([][]any, len(readings))
+		var errs fieldErrors
+		for i := range readings {
+			var verr *ValidationError
+			if errors.As(prefixFieldErrors(readings[i].validate(now), fmt.Sprintf("[%d]", i)), &verr) {
+				errs = append(errs, verr.Errors...)
+				continue
+			}
+			rows[i] = []any{*readings[i].Timestamp, *readings[i].Percentage}
+		}
+		if err := errs.err(); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		inserted, err := store.storeAll(r.Context(), rows)
+		if err != nil {
+			http.Error(w, "Failed to store the readings", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error copying readings", "error", err)
+			return
+		}
+
+		writeJSONStatus(w, http.StatusCreated, BatchResult{Inserted: inserted, Duplicates: int64(len(rows)) - inserted})
+	}
+}
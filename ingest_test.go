@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIngestReadingValidate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	percentage := func(n int) *int { return &n }
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	tests := []struct {
+		name      string
+		in        ingestReading
+		timestamp time.Time
+		err       string
+	}{
+		{name: "valid", in: ingestReading{Timestamp: at(-time.Minute), Percentage: percentage(42)}, timestamp: now.Add(-time.Minute)},
+		{name: "timestamp defaults to now", in: ingestReading{Percentage: percentage(0)}, timestamp: now},
+		{name: "full", in: ingestReading{Percentage: percentage(100)}, timestamp: now},
+		{name: "within the clock skew", in: ingestReading{Timestamp: at(maxClockSkew), Percentage: percentage(5)}, timestamp: now.Add(maxClockSkew)},
+		{name: "percentage missing", in: ingestReading{}, err: "'percentage' is required"},
+		{name: "percentage too high", in: ingestReading{Percentage: percentage(101)}, err: "'percentage' must be between 0 and 100"},
+		{name: "percentage negative", in: ingestReading{Percentage: percentage(-1)}, err: "'percentage' must be between 0 and 100"},
+		{
+			name: "every field wrong",
+			in:   ingestReading{Timestamp: at(time.Hour), Percentage: percentage(200)},
+			err:  "'percentage' must be between 0 and 100; 'timestamp' must not lie more than 5m0s in the future",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.in.validate(now)
+			if tt.err != "" {
+				var verr *ValidationError
+				if !errors.As(err, &verr) || err.Error() != tt.err {
+					t.Fatalf("got error %v, want a ValidationError %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.in.Timestamp.Equal(tt.timestamp) {
+				t.Errorf("got timestamp %v, want %v", tt.in.Timestamp, tt.timestamp)
+			}
+		})
+	}
+}
+
+func TestIngestHandlerRejectsBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "not an object", body: "42", status: http.StatusBadRequest, want: "invalid JSON: json: cannot unmarshal number into Go value of type main.ingestReading"},
+		{name: "malformed", body: `{"percentage": `, status: http.StatusBadRequest, want: "invalid JSON: unexpected EOF"},
+		{name: "wrong type", body: `{"percentage": "42"}`, status: http.StatusUnprocessableEntity, want: `{"message":"The request body is invalid","errors":[{"field":"percentage","message":"must be of type integer"}]}`},
+		{name: "unknown field", body: `{"percentage": 42, "lane": 3}`, status: http.StatusUnprocessableEntity, want: `{"message":"The request body is invalid","errors":[{"field":"lane","message":"is not a known field"}]}`},
+		{name: "invalid", body: `{"percentage": 142}`, status: http.StatusUnprocessableEntity, want: `{"message":"The request body is invalid","errors":[{"field":"percentage","message":"must be between 0 and 100"}]}`},
+		{name: "too large", body: `{"percentage": 42` + strings.Repeat(" ", maxReadingBytes) + "}", status: http.StatusRequestEntityTooLarge, want: "The request body is larger than 65536 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getIngestHandler(nil)(w, httptest.NewRequest("POST", "/pool-data", strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("got body %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		count       int
+		err         string
+	}{
+		{name: "array", body: `[{"percentage": 1}, {"percentage": 2, "timestamp": "2024-05-01T12:00:00Z"}]`, count: 2},
+		{name: "NDJSON", contentType: "application/x-ndjson", body: "{\"percentage\": 1}\n{\"percentage\": 2}\n{\"percentage\": 3}\n", count: 3},
+		{name: "NDJSON with parameters", contentType: "application/x-ndjson; charset=utf-8", body: `{"percentage": 1}`, count: 1},
+		{name: "empty array", body: `[]`, err: "the batch contains no readings"},
+		{name: "empty NDJSON", contentType: "application/x-ndjson", body: "", err: "the batch contains no readings"},
+		{name: "not an array", body: `{"percentage": 1}`, err: "invalid JSON: json: cannot unmarshal object into Go value of type []main.ingestReading"},
+		{name: "unknown field", body: `[{"percentage": 1, "lane": 2}]`, err: "'lane' is not a known field"},
+		{name: "NDJSON line with a wrong type", contentType: "application/x-ndjson", body: "{\"percentage\": 1}\n{\"percentage\": true}\n", err: "'[1].percentage' must be of type integer"},
+		{name: "NDJSON line with a bad timestamp", contentType: "application/x-ndjson", body: `{"timestamp": "yesterday"}`, err: "'[0].timestamp' must be an RFC3339 timestamp"},
+		{name: "too many", body: "[" + strings.Repeat(`{},`, maxBatchSize) + "{}]", err: "the batch contains more than 100000 readings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/pool-data/batch", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			readings, err := decodeBatch(httptest.NewRecorder(), r)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(readings) != tt.count {
+				t.Errorf("got %d readings, want %d", len(readings), tt.count)
+			}
+		})
+	}
+}
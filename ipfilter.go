@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ipFilter admits or refuses requests by the network they come from. Denied networks win over
+// allowed ones; an empty allowlist admits every network that is not denied.
+type ipFilter struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	proxies proxyTrust
+}
+
+// getIPFilters reads <GROUP>_ALLOW_CIDRS and <GROUP>_DENY_CIDRS for the ingest, admin and read
+// route groups, e.g. INGEST_ALLOW_CIDRS=10.20.0.0/16. Groups with neither list are left out.
+func getIPFilters() (map[string]*ipFilter, error) {
+	proxies, err := getProxyTrust()
+	if err != nil {
+		return nil, err
+	}
+	filters := map[string]*ipFilter{}
+	for _, group := range []string{scopeIngest, scopeAdmin, scopeRead} {
+		prefix := strings.ToUpper(group)
+		allow, err := parseCIDRs(prefix + "_ALLOW_CIDRS")
+		if err != nil {
+			return nil, err
+		}
+		deny, err := parseCIDRs(prefix + "_DENY_CIDRS")
+		if err != nil {
+			return nil, err
+		}
+		if allow == nil && deny == nil {
+			continue
+		}
+		filters[group] = &ipFilter{allow: allow, deny: deny, proxies: proxies}
+	}
+	return filters, nil
+}
+
+// parseCIDRs reads a comma-separated list of networks from the environment; a bare address
+// stands for itself alone
+func parseCIDRs(name string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range splitList(os.Getenv(name)) {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q: %v", name, value, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %v", name, value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// allows reports whether requests from addr are admitted
+func (f *ipFilter) allows(addr netip.Addr) bool {
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+// allowsIP is allows for an address in text form; addresses that cannot be parsed are refused
+func (f *ipFilter) allowsIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && f.allows(addr.Unmap())
+}
+
+// containsAddr reports whether any of prefixes contains addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter wraps next so requests from refused networks, or whose address cannot be read, get a 403
+func (f *ipFilter) filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := f.proxies.clientIP(r)
+		if !f.allowsIP(ip) {
+			slog.InfoContext(r.Context(), "Refused a request", "client_ip", ip)
+			http.Error(w, "Requests from your network are not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+		err   string
+	}{
+		{value: ""},
+		{value: "10.20.0.0/16", want: []string{"10.20.0.0/16"}},
+		{value: "10.20.1.7/16, 192.0.2.1", want: []string{"10.20.0.0/16", "192.0.2.1/32"}},
+		{value: "::ffff:192.0.2.1,2001:db8::/32", want: []string{"192.0.2.1/32", "2001:db8::/32"}},
+		{value: "10.20.0.0/33", err: `invalid INGEST_ALLOW_CIDRS entry "10.20.0.0/33": netip.ParsePrefix("10.20.0.0/33"): prefix length out of range`},
+		{value: "office", err: `invalid INGEST_ALLOW_CIDRS entry "office": ParseAddr("office"): unable to parse IP`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("INGEST_ALLOW_CIDRS", tt.value)
+			got, err := parseCIDRs("INGEST_ALLOW_CIDRS")
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var prefixes []string
+			for _, prefix := range got {
+				prefixes = append(prefixes, prefix.String())
+			}
+			if !slices.Equal(prefixes, tt.want) {
+				t.Errorf("got %v, want %v", prefixes, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPFilterAllowsIP(t *testing.T) {
+	office := netip.MustParsePrefix("10.20.0.0/16")
+	printer := netip.MustParsePrefix("10.20.9.9/32")
+	tests := []struct {
+		name   string
+		filter ipFilter
+		ip     string
+		want   bool
+	}{
+		{name: "no lists", ip: "192.0.2.1", want: true},
+		{name: "allowed", filter: ipFilter{allow: []netip.Prefix{office}}, ip: "10.20.3.4", want: true},
+		{name: "not allowed", filter: ipFilter{allow: []netip.Prefix{office}}, ip: "192.0.2.1", want: false},
+		{name: "mapped IPv4", filter: ipFilter{allow: []netip.Prefix{office}}, ip: "::ffff:10.20.3.4", want: true},
+		{name: "denied", filter: ipFilter{deny: []netip.Prefix{office}}, ip: "10.20.3.4", want: false},
+		{name: "denied within allowed", filter: ipFilter{allow: []netip.Prefix{office}, deny: []netip.Prefix{printer}}, ip: "10.20.9.9", want: false},
+		{name: "unreadable address", ip: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.allowsIP(tt.ip); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetIPFilters(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	t.Setenv("TRUST_PROXY_HEADERS", "")
+	t.Setenv("INGEST_ALLOW_CIDRS", "10.20.0.0/16")
+	t.Setenv("INGEST_DENY_CIDRS", "")
+	t.Setenv("ADMIN_ALLOW_CIDRS", "")
+	t.Setenv("ADMIN_DENY_CIDRS", "")
+	t.Setenv("READ_ALLOW_CIDRS", "")
+	t.Setenv("READ_DENY_CIDRS", "192.0.2.0/24")
+	filters, err := getIPFilters()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(filters) != 2 || filters[scopeIngest] == nil || filters[scopeRead] == nil {
+		t.Fatalf("got filters for %v, want ingest and read", filters)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/pool-data", nil)
+	r.RemoteAddr = "192.0.2.1:40000"
+	filters[scopeIngest].filter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// jsonAPIContentType is the media type of JSON:API documents
+const jsonAPIContentType = "application/vnd.api+json"
+
+// jsonAPIResource is a data point as a JSON:API resource object
+type jsonAPIResource struct {
+	Type       string         `json:"type"`
+	ID         string         `json:"id"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// jsonAPIDocument is a JSON:API top-level document
+type jsonAPIDocument struct {
+	Data  any               `json:"data"`
+	Links map[string]string `json:"links,omitempty"`
+	Meta  map[string]any    `json:"meta,omitempty"`
+}
+
+// newJSONAPIResource converts a data point; the id always identifies the resource, so only the
+// remaining selected fields become attributes
+func newJSONAPIResource(dp DataPoint, fields fieldSet) jsonAPIResource {
+	attributes := make(map[string]any, len(fields))
+	for _, name := range fields {
+		if name != "id" {
+			attributes[name] = dp.value(name)
+		}
+	}
+	return jsonAPIResource{Type: "dataPoints", ID: strconv.Itoa(dp.ID), Attributes: attributes}
+}
+
+// writeJSONAPIPage writes a page of data points as a JSON:API collection with pagination links and meta
+func writeJSONAPIPage(w http.ResponseWriter, r *http.Request, dataPoints []DataPoint, fields fieldSet, page pagination, total int) {
+	resources := make([]jsonAPIResource, len(dataPoints))
+	for i, dp := range dataPoints {
+		resources[i] = newJSONAPIResource(dp, fields)
+	}
+	links := map[string]string{
+		"self":  pageURL(r, page.Offset),
+		"first": pageURL(r, 0),
+		"last":  pageURL(r, max(total-1, 0)/page.Limit*page.Limit),
+	}
+	if page.Offset+page.Limit < total {
+		links["next"] = pageURL(r, page.Offset+page.Limit)
+	}
+	if page.Offset > 0 {
+		links["prev"] = pageURL(r, max(page.Offset-page.Limit, 0))
+	}
+	writeJSONAPI(w, jsonAPIDocument{
+		Data:  resources,
+		Links: links,
+		Meta:  map[string]any{"total": total, "limit": page.Limit, "offset": page.Offset},
+	})
+}
+
+// writeJSONAPI encodes doc as a JSON:API response body
+func writeJSONAPI(w http.ResponseWriter, doc jsonAPIDocument) {
+	w.Header().Set("Content-Type", jsonAPIContentType)
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		slog.Error("Error encoding response", "error", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteJSONAPIPage(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		page   pagination
+		total  int
+		fields fieldSet
+		want   string
+	}{
+		{
+			name:   "first of three pages",
+			query:  "limit=1",
+			page:   pagination{Limit: 1},
+			total:  3,
+			fields: dataFields,
+			want: `{"data":[{"type":"dataPoints","id":"1","attributes":{"percentage":42,"timestamp":"2024-05-01T12:00:00Z"}}],` +
+				`"links":{"first":"/pool-data?limit=1\u0026offset=0","last":"/pool-data?limit=1\u0026offset=2","next":"/pool-data?limit=1\u0026offset=1","self":"/pool-data?limit=1\u0026offset=0"},` +
+				`"meta":{"limit":1,"offset":0,"total":3}}`,
+		},
+		{
+			name:   "last page, id only",
+			query:  "limit=2&offset=2",
+			page:   pagination{Limit: 2, Offset: 2},
+			total:  3,
+			fields: fieldSet{"id"},
+			want: `{"data":[{"type":"dataPoints","id":"1","attributes":{}}],` +
+				`"links":{"first":"/pool-data?limit=2\u0026offset=0","last":"/pool-data?limit=2\u0026offset=2","prev":"/pool-data?limit=2\u0026offset=0","self":"/pool-data?limit=2\u0026offset=2"},` +
+				`"meta":{"limit":2,"offset":2,"total":3}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeJSONAPIPage(w, httptest.NewRequest("GET", "/pool-data?"+tt.query, nil), testPoints[:1], tt.fields, tt.page, tt.total)
+			if got := w.Header().Get("Content-Type"); got != jsonAPIContentType {
+				t.Errorf("got Content-Type %q, want %q", got, jsonAPIContentType)
+			}
+			if w.Body.String() != tt.want+"\n" {
+				t.Errorf("got %s, want %s", w.Body, tt.want)
+			}
+		})
+	}
+}
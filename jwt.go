@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles an identity provider may grant in a JWT; each role includes the scopes of the ones before it
+const (
+	roleReader = "reader"
+	roleWriter = "writer"
+	roleAdmin  = "admin"
+)
+
+// roleScopes lists the scopes every role grants
+var roleScopes = map[string][]string{
+	roleReader: {scopeRead},
+	roleWriter: {scopeRead, scopeIngest},
+	roleAdmin:  {scopeRead, scopeIngest, scopeAdmin},
+}
+
+// jwtVerifier validates JWTs signed by one of the identity provider's published keys
+type jwtVerifier struct {
+	keys     keyfunc.Keyfunc
+	parser   *jwt.Parser
+	rolesKey []string
+}
+
+// newJWTVerifier returns a verifier configured by JWT_JWKS_URL, JWT_ISSUER, JWT_AUDIENCE and
+// JWT_ROLES_CLAIM, or nil if JWT_JWKS_URL is unset. The key set is refreshed in the background.
+func newJWTVerifier(ctx context.Context) (*jwtVerifier, error) {
+	jwksURL := os.Getenv("JWT_JWKS_URL")
+	if jwksURL == "" {
+		return nil, nil
+	}
+	keys, err := keyfunc.NewDefaultCtx(ctx, []string{jwksURL})
+	if err != nil {
+		return nil, fmt.Errorf("unable to load JWKS from %s: %v", jwksURL, err)
+	}
+
+	// Nested claims such as Keycloak's realm_access.roles are addressed with dots
+	rolesClaim := os.Getenv("JWT_ROLES_CLAIM")
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	parser := newJWTParser(os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"))
+	return &jwtVerifier{keys: keys, parser: parser, rolesKey: strings.Split(rolesClaim, ".")}, nil
+}
+
+// jwtMethods are the signing algorithms accepted from the identity provider. Only asymmetric ones
+// are allowed, so a token cannot be signed with a shared secret taken from the key set.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// newJWTParser returns a parser that requires an expiry and, when set, the issuer and audience
+func newJWTParser(issuer, audience string) *jwt.Parser {
+	options := []jwt.ParserOption{jwt.WithExpirationRequired(), jwt.WithValidMethods(jwtMethods)}
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	return jwt.NewParser(options...)
+}
+
+// verify checks a raw token and returns its subject and the roles it grants
+func (v *jwtVerifier) verify(raw string) (string, []string, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(raw, claims, v.keys.Keyfunc); err != nil {
+		return "", nil, err
+	}
+	subject, _ := claims.GetSubject()
+	var value any = map[string]any(claims)
+	for _, key := range v.rolesKey {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return subject, nil, nil
+		}
+		value = object[key]
+	}
+	switch value := value.(type) {
+	case string:
+		return subject, strings.Fields(value), nil
+	case []any:
+		var roles []string
+		for _, role := range value {
+			if role, ok := role.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+		return subject, roles, nil
+	}
+	return subject, nil, nil
+}
+
+// rolesGrant reports whether any of the roles grants scope
+func rolesGrant(roles []string, scope string) bool {
+	for _, role := range roles {
+		if slices.Contains(roleScopes[role], scope) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cmp"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v3"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRolesGrant(t *testing.T) {
+	tests := []struct {
+		roles []string
+		scope string
+		want  bool
+	}{
+		{roles: []string{roleReader}, scope: scopeRead, want: true},
+		{roles: []string{roleReader}, scope: scopeIngest, want: false},
+		{roles: []string{roleWriter}, scope: scopeIngest, want: true},
+		{roles: []string{roleWriter}, scope: scopeAdmin, want: false},
+		{roles: []string{roleAdmin}, scope: scopeAdmin, want: true},
+		{roles: []string{"auditor", roleWriter}, scope: scopeRead, want: true},
+		{roles: []string{"auditor"}, scope: scopeRead, want: false},
+		{roles: nil, scope: scopeRead, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.roles, ",")+"/"+tt.scope, func(t *testing.T) {
+			if got := rolesGrant(tt.roles, tt.scope); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	b64 := base64.RawURLEncoding.EncodeToString
+	// The key set of the identity provider, including a shared secret that must not be accepted
+	keys, err := keyfunc.NewJWKSetJSON([]byte(`{"keys":[` +
+		`{"kty":"RSA","kid":"rsa","alg":"RS256","n":"` + b64(rsaKey.N.Bytes()) + `","e":"` + b64(big.NewInt(int64(rsaKey.E)).Bytes()) + `"},` +
+		`{"kty":"EC","kid":"ec","alg":"ES256","crv":"P-256","x":"` + b64(ecKey.X.FillBytes(make([]byte, 32))) + `","y":"` + b64(ecKey.Y.FillBytes(make([]byte, 32))) + `"},` +
+		`{"kty":"oct","kid":"hmac","alg":"HS256","k":"` + b64(secret) + `"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signWith := func(method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	sign := func(claims jwt.MapClaims) string { return signWith(jwt.SigningMethodRS256, "rsa", rsaKey, claims) }
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name       string
+		rolesClaim string
+		token      string
+		subject    string
+		roles      []string
+		invalid    bool
+	}{
+		{name: "roles array", token: sign(jwt.MapClaims{"sub": "alice", "exp": exp, "iss": "idp", "roles": []string{"writer", "auditor"}}), subject: "alice", roles: []string{"writer", "auditor"}},
+		{name: "roles string", token: sign(jwt.MapClaims{"sub": "alice", "exp": exp, "iss": "idp", "roles": "reader admin"}), subject: "alice", roles: []string{"reader", "admin"}},
+		{
+			name:       "nested claim",
+			rolesClaim: "realm_access.roles",
+			token:      sign(jwt.MapClaims{"sub": "bob", "exp": exp, "iss": "idp", "realm_access": map[string]any{"roles": []string{"admin"}}}),
+			subject:    "bob",
+			roles:      []string{"admin"},
+		},
+		{name: "no roles", token: sign(jwt.MapClaims{"sub": "carol", "exp": exp, "iss": "idp"}), subject: "carol"},
+		{name: "expired", token: sign(jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(-time.Minute).Unix(), "iss": "idp"}), invalid: true},
+		{name: "no expiry", token: sign(jwt.MapClaims{"sub": "alice", "iss": "idp"}), invalid: true},
+		{name: "other issuer", token: sign(jwt.MapClaims{"sub": "alice", "exp": exp, "iss": "elsewhere"}), invalid: true},
+		{name: "ES256", token: signWith(jwt.SigningMethodES256, "ec", ecKey, jwt.MapClaims{"sub": "dave", "exp": exp, "iss": "idp"}), subject: "dave"},
+		{name: "other key", token: signWith(jwt.SigningMethodES256, "ec", otherKey, jwt.MapClaims{"sub": "alice", "exp": exp, "iss": "idp"}), invalid: true},
+		{name: "shared secret", token: signWith(jwt.SigningMethodHS256, "hmac", secret, jwt.MapClaims{"sub": "alice", "exp": exp, "iss": "idp"}), invalid: true},
+		{name: "unsigned", token: signWith(jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, jwt.MapClaims{"sub": "alice", "exp": exp, "iss": "idp"}), invalid: true},
+		{name: "not a token", token: "s3cret", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &jwtVerifier{
+				keys:     keys,
+				parser:   newJWTParser("idp", ""),
+				rolesKey: strings.Split(cmp.Or(tt.rolesClaim, "roles"), "."),
+			}
+			subject, roles, err := v.verify(tt.token)
+			if tt.invalid {
+				if err == nil {
+					t.Fatal("got no error for an invalid token")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if subject != tt.subject || !slices.Equal(roles, tt.roles) {
+				t.Errorf("got %q with roles %v, want %q with %v", subject, roles, tt.subject, tt.roles)
+			}
+		})
+	}
+}
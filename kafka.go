@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultKafkaTopic = "pool.occupancy"
+	// kafkaSchemaVersion is bumped whenever KafkaReading changes incompatibly
+	kafkaSchemaVersion = 1
+)
+
+// KafkaReading is the value of every message produced to the Kafka topic. Messages are keyed by
+// the reading id, so all versions of a reading land in the same partition, and carry a
+// content-type header of application/json.
+//
+//	{"schema_version": 1, "id": 42, "timestamp": "2024-06-01T12:00:00Z", "percentage": 37, "produced_at": "2024-06-01T12:00:03Z"}
+type KafkaReading struct {
+	SchemaVersion int       `json:"schema_version"`
+	ID            int       `json:"id"`
+	Timestamp     time.Time `json:"timestamp"`
+	Percentage    int       `json:"percentage"`
+	ProducedAt    time.Time `json:"produced_at"`
+}
+
+// newKafkaWriter configures a producer from KAFKA_BROKERS (comma-separated host:port list) and
+// KAFKA_TOPIC; it returns nil when no brokers are configured
+func newKafkaWriter() *kafka.Writer {
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		return nil
+	}
+	topic := os.Getenv("KAFKA_TOPIC")
+	if topic == "" {
+		topic = defaultKafkaTopic
+	}
+	return &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+}
+
+// produceKafka writes every data point received from points to the writer's topic
+func produceKafka(ctx context.Context, writer *kafka.Writer, points <-chan DataPoint) {
+	for dp := range points {
+		msg, err := kafkaMessage(dp, time.Now().UTC())
+		if err != nil {
+			slog.Error("Error encoding Kafka message", "error", err)
+			continue
+		}
+		if err := writer.WriteMessages(ctx, msg); err != nil {
+			slog.Error("Error producing a data point to Kafka", "id", dp.ID, "error", err)
+		}
+	}
+}
+
+// kafkaMessage encodes dp as a KafkaReading message produced at producedAt
+func kafkaMessage(dp DataPoint, producedAt time.Time) (kafka.Message, error) {
+	value, err := json.Marshal(KafkaReading{
+		SchemaVersion: kafkaSchemaVersion,
+		ID:            dp.ID,
+		Timestamp:     dp.Timestamp,
+		Percentage:    dp.Percentage,
+		ProducedAt:    producedAt,
+	})
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	return kafka.Message{
+		Key:     []byte(strconv.Itoa(dp.ID)),
+		Value:   value,
+		Headers: []kafka.Header{{Key: "content-type", Value: []byte("application/json")}},
+	}, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaMessage(t *testing.T) {
+	dp := DataPoint{ID: 42, Timestamp: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), Percentage: 37}
+	msg, err := kafkaMessage(dp, time.Date(2024, 6, 1, 12, 0, 3, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The documented schema of KafkaReading
+	want := `{"schema_version":1,"id":42,"timestamp":"2024-06-01T12:00:00Z","percentage":37,"produced_at":"2024-06-01T12:00:03Z"}`
+	if string(msg.Value) != want {
+		t.Errorf("got value %s, want %s", msg.Value, want)
+	}
+	if string(msg.Key) != "42" {
+		t.Errorf("got key %q, want the reading id", msg.Key)
+	}
+	if len(msg.Headers) != 1 || msg.Headers[0].Key != "content-type" || string(msg.Headers[0].Value) != "application/json" {
+		t.Errorf("got headers %v, want a JSON content-type", msg.Headers)
+	}
+}
+
+func TestNewKafkaWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		brokers string
+		topic   string
+		addr    string
+		want    string
+	}{
+		{name: "no brokers"},
+		{name: "default topic", brokers: "kafka:9092", addr: "kafka:9092", want: "pool.occupancy"},
+		{name: "topic", brokers: "kafka-1:9092,kafka-2:9092", topic: "home.pool", addr: "kafka-1:9092,kafka-2:9092", want: "home.pool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KAFKA_BROKERS", tt.brokers)
+			t.Setenv("KAFKA_TOPIC", tt.topic)
+			writer := newKafkaWriter()
+			if tt.brokers == "" {
+				if writer != nil {
+					t.Errorf("got a writer for %v, want none", writer.Addr)
+				}
+				return
+			}
+			if writer.Topic != tt.want || writer.Addr.String() != tt.addr {
+				t.Errorf("got topic %q on %s, want %q on %s", writer.Topic, writer.Addr, tt.want, tt.addr)
+			}
+			// Messages of one reading are hashed to one partition by their key
+			if _, ok := writer.Balancer.(*kafka.Hash); !ok || writer.RequiredAcks != kafka.RequireAll {
+				t.Errorf("got balancer %T and acks %v, want hashing and all acks", writer.Balancer, writer.RequiredAcks)
+			}
+		})
+	}
+}
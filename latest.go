@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// maxLatestCount bounds the 'count' parameter of the /pool-data/latest endpoint
+const maxLatestCount = 1000
+
+// getLatestHandler handles the /pool-data/latest endpoint; it returns the newest data point,
+// or the N newest (newest first) when a 'count' parameter is given
+func getLatestHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		count, err := parseIntParam(r, "count", 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Has("count") && (count < 1 || count > maxLatestCount) {
+			http.Error(w, fmt.Sprintf("invalid 'count' parameter: must be between 1 and %d", maxLatestCount), http.StatusBadRequest)
+			return
+		}
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Walk the timestamp index backwards and stop after the requested rows
+		rows, err := pool.Query(r.Context(),
+			"SELECT id, timestamp, percentage FROM pool_usage WHERE NOT suspect ORDER BY timestamp DESC, id DESC LIMIT $1", max(count, 1))
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		dataPoints, err := scanDataPoints(rows)
+		if err != nil {
+			http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+			return
+		}
+		for i := range dataPoints {
+			dataPoints[i].Timestamp = inLocation(dataPoints[i].Timestamp, loc)
+		}
+
+		if r.Header.Get("Accept") == jsonAPIContentType {
+			writeLatestJSONAPI(w, r, dataPoints)
+			return
+		}
+		if r.URL.Query().Has("count") {
+			if dataPoints == nil {
+				dataPoints = []DataPoint{}
+			}
+			writeJSON(w, dataPoints)
+			return
+		}
+		if len(dataPoints) == 0 {
+			http.Error(w, "No data available", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, dataPoints[0])
+	}
+}
+
+// writeLatestJSONAPI writes the latest readings as a JSON:API document: a single resource
+// (or null) by default, or a collection when 'count' is given
+func writeLatestJSONAPI(w http.ResponseWriter, r *http.Request, dataPoints []DataPoint) {
+	if r.URL.Query().Has("count") {
+		resources := make([]jsonAPIResource, len(dataPoints))
+		for i, dp := range dataPoints {
+			resources[i] = newJSONAPIResource(dp, dataFields)
+		}
+		writeJSONAPI(w, jsonAPIDocument{Data: resources, Links: map[string]string{"self": r.URL.RequestURI()}})
+		return
+	}
+	var data any
+	if len(dataPoints) > 0 {
+		data = newJSONAPIResource(dataPoints[0], dataFields)
+	}
+	writeJSONAPI(w, jsonAPIDocument{Data: data, Links: map[string]string{"self": r.URL.RequestURI()}})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLatestHandlerRejectsCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{name: "zero", query: "count=0", body: "invalid 'count' parameter: must be between 1 and 1000"},
+		{name: "too many", query: "count=1001", body: "invalid 'count' parameter: must be between 1 and 1000"},
+		{name: "empty", query: "count=", body: "invalid 'count' parameter: must be between 1 and 1000"},
+		{name: "negative", query: "count=-1", body: "invalid 'count' parameter: expected a non-negative integer"},
+		{name: "not a number", query: "count=all", body: "invalid 'count' parameter: expected a non-negative integer"},
+		{name: "unknown time zone", query: "count=5&tz=Mars/Olympus", body: "invalid 'tz' parameter: unknown time zone 'Mars/Olympus'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getLatestHandler(&readPool{})(w, httptest.NewRequest("GET", "/pool-data/latest?"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
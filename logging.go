@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logAttrsKey is the context key of the attributes added to every record logged for a request
+type logAttrsKey struct{}
+
+// contextHandler adds the attributes stored in the context to each record, so that records
+// logged with slog.ErrorContext and friends while serving a request carry its fields, along
+// with the trace id to find the request's trace. Errors also go to the reporter, if any.
+type contextHandler struct {
+	slog.Handler
+	reporter errorReporter
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	if h.reporter != nil && record.Level >= slog.LevelError {
+		reportRecord(ctx, h.reporter, record)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs), h.reporter}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name), h.reporter}
+}
+
+// withLogAttrs returns a copy of ctx whose log records carry attrs in addition to those already in ctx
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, logAttrsKey{}, append(existing[:len(existing):len(existing)], attrs...))
+}
+
+// getLogger builds the logger configured by LOG_FORMAT ("text", the default, or "json") and
+// LOG_LEVEL ("debug", "info", the default, "warn" or "error"), reporting errors to reporter
+func getLogger(reporter errorReporter) (*slog.Logger, error) {
+	var level slog.Level
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q: expected debug, info, warn or error", value)
+		}
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: expected text or json", format)
+	}
+	return slog.New(contextHandler{handler, reporter}), nil
+}
+
+// fatal logs msg with the attributes at the error level and exits, as log.Fatal does
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// statusWriter records the status code and body size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over to the WebSocket endpoint
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// accessLog logs every request once it is done, with its method, path, status, response size,
+// duration and client address
+type accessLog struct {
+	// level is info, or debug when ACCESS_LOG is false
+	level   slog.Level
+	proxies proxyTrust
+}
+
+// getAccessLog reads ACCESS_LOG, which is on unless set to false, and the trusted proxies
+func getAccessLog() (accessLog, error) {
+	proxies, err := getProxyTrust()
+	if err != nil {
+		return accessLog{}, err
+	}
+	level := slog.LevelInfo
+	if os.Getenv("ACCESS_LOG") == "false" {
+		level = slog.LevelDebug
+	}
+	return accessLog{level: level, proxies: proxies}, nil
+}
+
+// handler adds the method and path to every record logged while serving a request and writes
+// the access log entry once it is done
+func (a accessLog) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		ctx := withLogAttrs(r.Context(), slog.String("method", r.Method), slog.String("path", r.URL.Path))
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		slog.Log(ctx, a.level, "Request",
+			"status", cmp.Or(sw.status, http.StatusOK),
+			"bytes", sw.bytes,
+			"duration", time.Since(started),
+			"client_ip", a.proxies.clientIP(r),
+		)
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  string
+		format string
+		debug  bool
+		err    string
+	}{
+		{name: "defaults"},
+		{name: "debug json", level: "debug", format: "json", debug: true},
+		{name: "upper case", level: "WARN", format: "JSON"},
+		{name: "unknown level", level: "verbose", err: `invalid LOG_LEVEL "verbose": expected debug, info, warn or error`},
+		{name: "unknown format", format: "logfmt", err: `invalid LOG_FORMAT "logfmt": expected text or json`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LOG_LEVEL", tt.level)
+			t.Setenv("LOG_FORMAT", tt.format)
+			logger, err := getLogger(nil)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := logger.Enabled(context.Background(), slog.LevelDebug); got != tt.debug {
+				t.Errorf("got debug enabled %v, want %v", got, tt.debug)
+			}
+		})
+	}
+}
+
+// logRecords makes the default logger write JSON records to the returned buffer for the rest of the test
+func logRecords(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), nil}))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestContextHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs [][]slog.Attr
+		want  map[string]any
+	}{
+		{name: "no attributes", want: map[string]any{}},
+		{name: "attributes", attrs: [][]slog.Attr{{slog.String("method", "GET")}}, want: map[string]any{"method": "GET"}},
+		{name: "nested", attrs: [][]slog.Attr{{slog.String("method", "GET")}, {slog.String("request_id", "abc")}}, want: map[string]any{"method": "GET", "request_id": "abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := logRecords(t)
+			ctx := context.Background()
+			for _, attrs := range tt.attrs {
+				ctx = withLogAttrs(ctx, attrs...)
+			}
+			slog.InfoContext(ctx, "Request")
+			var record map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for key, want := range tt.want {
+				if record[key] != want {
+					t.Errorf("got %s %v, want %v", key, record[key], want)
+				}
+			}
+			if len(record) != 3+len(tt.want) {
+				t.Errorf("got record %v, want the attributes %v", record, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		log    accessLog
+		status int
+		want   map[string]any
+	}{
+		{name: "implicit status", log: accessLog{level: slog.LevelInfo}, want: map[string]any{"level": "INFO", "status": 200.0, "bytes": 2.0, "method": "GET", "path": "/pool-data/latest", "client_ip": "192.0.2.1"}},
+		{name: "error at debug", log: accessLog{level: slog.LevelDebug}, status: http.StatusNotFound, want: map[string]any{"level": "DEBUG", "status": 404.0, "bytes": 2.0}},
+		{name: "behind a proxy", log: accessLog{level: slog.LevelInfo, proxies: proxyTrust{all: true}}, want: map[string]any{"client_ip": "203.0.113.7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := logRecords(t)
+			r := httptest.NewRequest("GET", "/pool-data/latest", nil)
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			tt.log.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				w.Write([]byte("{}"))
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			var record map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for key, want := range tt.want {
+				if record[key] != want {
+					t.Errorf("got %s %v, want %v", key, record[key], want)
+				}
+			}
+		})
+	}
+}
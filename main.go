@@ -1,15 +1,27 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	// Embed the time zone database so the tz parameter works in minimal container images
+	_ "time/tzdata"
 )
 
 // DataPoint represents a single record from the pool_usage table
@@ -19,16 +31,70 @@ type DataPoint struct {
 	Percentage int       `json:"percentage"`
 }
 
-// getDatabasePool initializes a connection pool to the PostgreSQL database
-func getDatabasePool() (*pgxpool.Pool, error) {
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
+// getDatabasePool initializes a connection pool to the PostgreSQL database, waiting for it to
+// come up for DB_STARTUP_TIMEOUT
+func getDatabasePool(ctx context.Context) (*pgxpool.Pool, error) {
+	timeout, err := getStartupTimeout()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := openDatabasePool("DATABASE_URL")
+	if err != nil {
+		return nil, err
+	}
+	if pool == nil {
 		return nil, fmt.Errorf("DATABASE_URL environment variable is not set")
 	}
+	if err := waitForDatabase(ctx, pool, timeout); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// openDatabasePool connects to the database at the URL in the secret name, returning nil when
+// it is unset
+func openDatabasePool(name string) (*pgxpool.Pool, error) {
+	dbURL, err := secret(name)
+	if err != nil {
+		return nil, err
+	}
+	if dbURL == "" {
+		return nil, nil
+	}
 
 	config, err := pgxpool.ParseConfig(dbURL)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse DATABASE_URL: %v", err)
+		return nil, fmt.Errorf("unable to parse %s: %v", name, err)
+	}
+	if err := applyPoolSettings(config); err != nil {
+		return nil, err
+	}
+	slow, err := getSlowQueryThreshold()
+	if err != nil {
+		return nil, err
+	}
+	if config.ConnConfig.ConnectTimeout == 0 {
+		config.ConnConfig.ConnectTimeout = defaultConnectTimeout
+	}
+	config.ConnConfig.Tracer = queryTracer{slow: slow}
+	if os.Getenv("DB_TAG_REQUESTS") == "true" {
+		tagConnections(config)
+	}
+	// New connections log in with the current credentials, so a rotated password needs no restart
+	config.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+		current, err := secret(name)
+		if err != nil || current == dbURL {
+			return nil
+		}
+		rotated, err := pgx.ParseConfig(current)
+		if err != nil {
+			slog.Error("Error parsing the rotated database URL, keeping the old credentials", "name", name, "error", err)
+			return nil
+		}
+		cc.User = rotated.User
+		cc.Password = rotated.Password
+		return nil
 	}
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
@@ -39,58 +105,443 @@ func getDatabasePool() (*pgxpool.Pool, error) {
 	return pool, nil
 }
 
-// getDataHandler handles the /data endpoint and returns all data points as JSON
-func getDataHandler(pool *pgxpool.Pool) http.HandlerFunc {
+// getPoolLocation loads the pool's local time zone from POOL_TIMEZONE, defaulting to UTC
+func getPoolLocation() (*time.Location, error) {
+	name := os.Getenv("POOL_TIMEZONE")
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load POOL_TIMEZONE: %v", err)
+	}
+	return loc, nil
+}
+
+// getDataHandler handles the /pool-data endpoint and returns the data points in the requested range as JSON
+func getDataHandler(reads *readPool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		// Query the database for all data points, ordered by timestamp
-		rows, err := pool.Query(context.Background(), "SELECT id, timestamp, percentage FROM pool_usage ORDER BY timestamp")
+		pool := reads.pool()
+		points, err := parsePointsQuery(r)
 		if err != nil {
-			http.Error(w, "Failed to query the database", http.StatusInternalServerError)
-			log.Println("Error querying database:", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer rows.Close()
+		page, err := parsePagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format, err := negotiateFormat(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		cursor, keyset, err := parseCursor(r)
+		if err == nil && keyset && format == "jsonapi" {
+			err = errCursorFormat
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The page is sent in one batch with either the query for the next cursor or the count,
+		// saving a round trip to the database
+		source := points.source()
+		batch := &pgx.Batch{}
+		if keyset {
+			// Seek past the cursor instead of counting and skipping rows, so deep pages cost no more than the first
+			source += points.seek(cursor)
+			query, args := points.nextCursorQuery(source, page.Limit)
+			batch.Queue(query, args...)
+		} else {
+			// Count the matching rows so clients know how many pages there are
+			batch.Queue("SELECT count(*) FROM "+source, points.filter.args...)
+		}
+		args := append(points.filter.args, page.Limit, page.Offset)
+		batch.Queue(fmt.Sprintf("SELECT %s FROM %s%s LIMIT $%d OFFSET $%d",
+			points.fields.columns(), source, points.orderBy(), len(args)-1, len(args)), args...)
+		results := pool.SendBatch(r.Context(), batch)
+		defer results.Close()
 
-		// Collect all data points into a slice
-		var dataPoints []DataPoint
-		for rows.Next() {
-			var dp DataPoint
-			err := rows.Scan(&dp.ID, &dp.Timestamp, &dp.Percentage)
+		var total int
+		if keyset {
+			next, err := scanNextCursor(results)
 			if err != nil {
-				http.Error(w, "Failed to scan row", http.StatusInternalServerError)
-				log.Println("Error scanning row:", err)
+				writeQueryError(w, r, err)
+				return
+			}
+			setCursorHeaders(w, r, page, next)
+		} else {
+			if err := results.QueryRow().Scan(&total); err != nil {
+				writeQueryError(w, r, err)
 				return
 			}
-			dataPoints = append(dataPoints, dp)
+			setPaginationHeaders(w, r, page, total)
 		}
 
-		// Encode the result as JSON and write to the response
-		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(dataPoints)
+		// Read the requested page of data points in the requested order
+		rows, err := results.Query()
 		if err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-			log.Println("Error encoding response:", err)
+			writeQueryError(w, r, err)
 			return
 		}
+		defer rows.Close()
+
+		if format != "jsonapi" {
+			encoding := jsonFormat
+			if format != "json" {
+				encoding = pointFormats[format]
+			}
+			streamPoints(w, rows, points.fields, points.loc, encoding)
+			return
+		}
+
+		// JSON:API documents carry their links and totals next to the data, so the page is collected first
+		dataPoints, err := scanFields(rows, points.fields)
+		if err != nil {
+			http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+			return
+		}
+		for i := range dataPoints {
+			dataPoints[i].Timestamp = inLocation(dataPoints[i].Timestamp, points.loc)
+		}
+		writeJSONAPIPage(w, r, dataPoints, points.fields, page, total)
+	}
+}
+
+// scanDataPoints collects all rows of an (id, timestamp, percentage) query into a slice
+func scanDataPoints(rows pgx.Rows) ([]DataPoint, error) {
+	return scanFields(rows, dataFields)
+}
+
+// scanFields collects all rows of a query selecting the given fields into a slice
+func scanFields(rows pgx.Rows, fields fieldSet) ([]DataPoint, error) {
+	var dataPoints []DataPoint
+	for rows.Next() {
+		var dp DataPoint
+		if err := rows.Scan(fields.scanDest(&dp)...); err != nil {
+			return nil, err
+		}
+		dataPoints = append(dataPoints, dp)
 	}
+	return dataPoints, rows.Err()
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v any) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus encodes v as the JSON response body with the given status code
+func writeJSONStatus(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		slog.Error("Error encoding response", "error", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
 }
 
 func main() {
+	// Settings come from the configuration file, the environment and the flags
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("Error loading the configuration", "error", err)
+	}
+	if cfg.print {
+		if err := validateConfig(); err != nil {
+			fatal("Invalid configuration", "error", err)
+		}
+		cfg.printConfig(os.Stdout)
+		return
+	}
+
+	// Everything, including the standard logger used by libraries, logs through slog, and
+	// errors are reported to Sentry when it is configured
+	build := getBuildInfo()
+	reporter, err := getErrorReporter(build)
+	if err != nil {
+		fatal("Error configuring error reporting", "error", err)
+	}
+	if reporter != nil {
+		defer reporter.flush()
+	}
+	logger, err := getLogger(reporter)
+	if err != nil {
+		fatal("Error configuring logging", "error", err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("Starting pool-api", "version", build.Version, "commit", build.Commit, "build_time", build.BuildTime)
+
+	stopTracing, err := startTracing(context.Background(), build)
+	if err != nil {
+		fatal("Error configuring tracing", "error", err)
+	}
+	defer stopTracing(context.Background())
+
+	// Subcommands run a one-off job instead of the server
+	if len(cfg.args) > 0 {
+		switch cfg.args[0] {
+		case "backfill":
+			if err := runBackfill(cfg.args[1:]); err != nil {
+				fatal("Backfill failed", "error", err)
+			}
+			return
+		case "migrate":
+			if err := runMigrate(cfg.args[1:]); err != nil {
+				fatal("Migration failed", "error", err)
+			}
+			return
+		case "import-influx":
+			if err := runInfluxImport(cfg.args[1:]); err != nil {
+				fatal("Import failed", "error", err)
+			}
+			return
+		}
+	}
+
+	// SIGTERM and SIGINT stop the listeners and background jobs; requests in flight get
+	// SHUTDOWN_TIMEOUT to finish before the deferred cleanup closes the database pool
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, func() { slog.Info("Shutting down") })
+	shutdownTimeout, err := getShutdownTimeout()
+	if err != nil {
+		fatal("Error configuring shutdown", "error", err)
+	}
+
 	// Get a connection pool to the database
-	pool, err := getDatabasePool()
+	pool, err := getDatabasePool(ctx)
 	if err != nil {
-		log.Fatalf("Error initializing database connection: %v", err)
+		fatal("Error initializing database connection", "error", err)
 	}
 	defer pool.Close()
 
-	// Set up the HTTP server
-	http.HandleFunc("/pool-data", getDataHandler(pool))
+	// Read-only endpoints go to the read replica while it is up and caught up
+	reads, err := getReadPool(pool)
+	if err != nil {
+		fatal("Error initializing the read replica", "error", err)
+	}
+	if reads.replica != nil {
+		defer reads.replica.Close()
+		go reads.watch(ctx)
+	}
+
+	if err := migrateOnStart(pool); err != nil {
+		fatal("Error migrating database", "error", err)
+	}
+	// Calendar-day routes use the pool's local time zone unless a request overrides it
+	poolLocation, err := getPoolLocation()
+	if err != nil {
+		fatal("Error loading time zone", "error", err)
+	}
+
+	compressAfter, err := getCompressAfter()
+	if err != nil {
+		fatal("Error configuring TimescaleDB", "error", err)
+	}
+	timescale, err := useTimescale(pool, compressAfter)
+	if err != nil {
+		fatal("Error preparing database", "error", err)
+	}
+
+	// New readings are picked up as soon as Postgres announces them, with polling as a fallback
+	// should notifications be lost, and fanned out to the webhook dispatcher
+	pollInterval := 10 * time.Second
+	if value := os.Getenv("POLL_INTERVAL"); value != "" {
+		pollInterval, err = time.ParseDuration(value)
+		if err != nil || pollInterval <= 0 {
+			fatal("Invalid POLL_INTERVAL", "value", value)
+		}
+	}
+	readings := newHub()
+	context.AfterFunc(ctx, readings.close)
+	wake := make(chan struct{}, 1)
+	go listenNewReadings(ctx, pool, wake)
+	go watchNewReadings(ctx, pool, readings, pollInterval, wake)
+	webhookPoints, _ := readings.subscribe()
+	go newWebhookDispatcher(pool).run(ctx, webhookPoints)
+
+	// Aggregates over whole hours are served from a rollup kept up to date in the background,
+	// by the worker or by TimescaleDB's continuous aggregate policy
+	rollupInterval, err := getRollupInterval()
+	if err != nil {
+		fatal("Error configuring rollups", "error", err)
+	}
+	if !timescale {
+		go watchRollups(ctx, pool, rollupInterval)
+	}
+	// Signatures of signed requests are only remembered for as long as they could be replayed
+	go sweepUsedSignatures(ctx, pool)
+
+	// Every ingestion path writes through the store, which drops repeated readings
+	dedupWindow, err := getDedupWindow()
+	if err != nil {
+		fatal("Error configuring deduplication", "error", err)
+	}
+	spikes, err := getSpikeConfig()
+	if err != nil {
+		fatal("Error configuring spike detection", "error", err)
+	}
+	cache, err := getResponseCache()
+	if err != nil {
+		fatal("Error configuring the response cache", "error", err)
+	}
+	if cache != nil {
+		cachePoints, _ := readings.subscribe()
+		go cache.watch(ctx, cachePoints)
+	}
+	store := &readingStore{pool: pool, readings: readings, dedupWindow: dedupWindow, spikes: spikes, cache: cache}
+
+	// Start the configured ingestion sources, e.g. the scraper or the MQTT subscriber
+	sources, err := configuredSources()
+	if err != nil {
+		fatal("Error configuring sources", "error", err)
+	}
+	runSources(ctx, sources, store)
+
+	// Optionally push new readings to an MQTT broker
+	mqttCfg, err := getMQTTConfig()
+	if err != nil {
+		fatal("Error configuring MQTT", "error", err)
+	}
+	if mqttCfg.Broker != "" {
+		mqttClient, err := connectMQTT(mqttCfg, nil)
+		if err != nil {
+			fatal("Error initializing MQTT", "error", err)
+		}
+		defer mqttClient.Disconnect(250)
+		mqttPoints, _ := readings.subscribe()
+		go publishMQTT(mqttClient, mqttCfg.Topic, mqttPoints)
+		slog.Info("Publishing new readings to MQTT", "topic", mqttCfg.Topic)
+	}
+
+	// Optionally stream new readings into Kafka
+	if kafkaWriter := newKafkaWriter(); kafkaWriter != nil {
+		defer kafkaWriter.Close()
+		kafkaPoints, _ := readings.subscribe()
+		go produceKafka(ctx, kafkaWriter, kafkaPoints)
+		slog.Info("Producing new readings to Kafka", "topic", kafkaWriter.Topic)
+	}
+
+	// Set up the HTTP server; the OpenAPI document is generated from the same route table.
+	// Routes with a scope need its bearer token, an API key or a JWT granting it.
+	auth, err := newAuthenticator(pool)
+	if err != nil {
+		fatal("Error configuring authentication", "error", err)
+	}
+	serveCfg, err := getServeConfig()
+	if err != nil {
+		fatal("Error configuring the server", "error", err)
+	}
+	cors, err := getCORSPolicy()
+	if err != nil {
+		fatal("Error configuring CORS", "error", err)
+	}
+	limits, err := getRateLimiter()
+	if err != nil {
+		fatal("Error configuring rate limits", "error", err)
+	}
+	filters, err := getIPFilters()
+	if err != nil {
+		fatal("Error configuring IP filters", "error", err)
+	}
+	queryTimeout, err := getQueryTimeout()
+	if err != nil {
+		fatal("Error configuring the query timeout", "error", err)
+	}
+	caps, err := getResultCaps()
+	if err != nil {
+		fatal("Error configuring result caps", "error", err)
+	}
+	routes := apiRoutes(pool, reads, poolLocation, store, auth, limits, filters, cors, queryTimeout, caps)
+	mtlsCfg, err := getMTLSConfig()
+	if err != nil {
+		fatal("Error configuring mTLS", "error", err)
+	}
+	access, err := getAccessLog()
+	if err != nil {
+		fatal("Error configuring the access log", "error", err)
+	}
+
+	// The listeners besides the main one are drained before main returns
+	var servers sync.WaitGroup
+
+	// Every route is counted and timed for the SLO dashboards
+	metrics := newHTTPMetrics(prometheus.DefaultRegisterer)
+	mux := http.NewServeMux()
+	publicRoutes := routes
+	if mtlsCfg != nil {
+		// Devices push readings to their own listener; the ingest routes, including the webhook
+		// sources, can be restricted to it
+		var ingestRoutes, otherRoutes []apiRoute
+		for _, route := range routes {
+			if cmp.Or(route.Group, route.Scope) == scopeIngest {
+				ingestRoutes = append(ingestRoutes, route)
+			} else {
+				otherRoutes = append(otherRoutes, route)
+			}
+		}
+		ingestMux := http.NewServeMux()
+		registerRoutes(ingestMux, ingestRoutes, metrics)
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			if err := mtlsCfg.serve(ctx, shutdownTimeout, withVersion(build, traceRequests(requestIDs(access.handler(recoverPanics(ingestMux)))))); err != nil {
+				fatal("Failed to start mTLS ingest server", "error", err)
+			}
+		}()
+		if mtlsCfg.required {
+			publicRoutes = otherRoutes
+		}
+	}
+	registerRoutes(mux, publicRoutes, metrics)
+	mux.Handle("GET /openapi.json", metrics.instrument("/openapi.json", getOpenAPIHandler(routes)))
+	if os.Getenv("SWAGGER_UI") == "true" {
+		mux.Handle("GET /docs", metrics.instrument("/docs", getSwaggerUIHandler()))
+	}
+
+	// Expose occupancy gauges and connection pool statistics for Prometheus alongside the request
+	// and Go runtime metrics
+	prometheus.MustRegister(newOccupancyCollector(pool), newPoolStatsCollector(reads))
+	mux.Handle("GET /metrics", metrics.instrument("/metrics", promhttp.Handler()))
+
+	// Profiles can be captured on a separate port
+	servePprof()
+
+	// Probes for orchestrators skip authentication, rate limits and network filters
+	mux.Handle("GET /healthz", metrics.instrument("/healthz", getLivenessHandler()))
+	mux.Handle("GET /readyz", metrics.instrument("/readyz", getReadinessHandler(pool)))
+	mux.Handle("GET /version", metrics.instrument("/version", getVersionHandler(build)))
+
+	// Start the gRPC API alongside the HTTP server if it has an address, with the checks of the
+	// HTTP routes
+	if grpcAddr := os.Getenv("GRPC_ADDR"); grpcAddr != "" {
+		guard := &grpcGuard{auth: auth, limits: limits, filters: filters, caps: caps, mtlsOnly: mtlsRequired()}
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			slog.Info("Starting gRPC server", "addr", grpcAddr)
+			if err := serveGRPC(ctx, shutdownTimeout, store, guard, grpcAddr); err != nil {
+				fatal("Failed to start gRPC server", "error", err)
+			}
+		}()
+	}
 
-	// Start the server
-	port := ":8080"
-	log.Printf("Starting server on port %s...", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Start the server, over HTTPS if a certificate or autocert domains are configured
+	if err := serveCfg.serve(ctx, shutdownTimeout, withVersion(build, traceRequests(requestIDs(access.handler(recoverPanics(compressResponses(cors.handler(mux)))))))); err != nil {
+		fatal("Failed to start server", "error", err)
 	}
+	servers.Wait()
+	slog.Info("Server stopped")
 }
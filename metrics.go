@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// occupancyCollector publishes the latest reading as Prometheus gauges, querying it on every scrape
+type occupancyCollector struct {
+	db         querier
+	percentage *prometheus.Desc
+	age        *prometheus.Desc
+	timestamp  *prometheus.Desc
+	up         *prometheus.Desc
+}
+
+func newOccupancyCollector(db querier) *occupancyCollector {
+	return &occupancyCollector{
+		db: db,
+		percentage: prometheus.NewDesc("pool_occupancy_percentage",
+			"Occupancy percentage of the latest reading.", nil, nil),
+		age: prometheus.NewDesc("pool_last_reading_age_seconds",
+			"Seconds since the latest reading was taken.", nil, nil),
+		timestamp: prometheus.NewDesc("pool_last_reading_timestamp_seconds",
+			"Unix time of the latest reading.", nil, nil),
+		up: prometheus.NewDesc("pool_database_up",
+			"Whether the latest reading could be read from the database.", nil, nil),
+	}
+}
+
+func (c *occupancyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.percentage
+	ch <- c.age
+	ch <- c.timestamp
+	ch <- c.up
+}
+
+// Collect reports the reading gauges only when there is a reading, so alerts on absent() still fire
+func (c *occupancyCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var dp DataPoint
+	err := c.db.QueryRow(ctx, "SELECT timestamp, percentage FROM pool_usage WHERE NOT suspect ORDER BY timestamp DESC, id DESC LIMIT 1").
+		Scan(&dp.Timestamp, &dp.Percentage)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		slog.Error("Error querying database for metrics", "error", err)
+		ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 0)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, 1)
+	if err != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.percentage, prometheus.GaugeValue, float64(dp.Percentage))
+	ch <- prometheus.MustNewConstMetric(c.age, prometheus.GaugeValue, time.Since(dp.Timestamp).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.timestamp, prometheus.GaugeValue, float64(dp.Timestamp.UnixNano())/1e9)
+}
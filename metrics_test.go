@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestOccupancyCollector(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		db   *fakeQuerier
+		// values are the gauges expected in the scrape; the age is compared to within a minute
+		values map[string]float64
+	}{
+		{name: "fresh reading", db: &fakeQuerier{rows: [][]any{{now.Add(-30 * time.Second), 40}}}, values: map[string]float64{
+			"pool_database_up":                    1,
+			"pool_occupancy_percentage":           40,
+			"pool_last_reading_age_seconds":       30,
+			"pool_last_reading_timestamp_seconds": float64(now.Add(-30*time.Second).UnixNano()) / 1e9,
+		}},
+		// A stalled source keeps its last percentage, but its age grows
+		{name: "stale reading", db: &fakeQuerier{rows: [][]any{{now.Add(-3 * time.Hour), 85}}}, values: map[string]float64{
+			"pool_database_up":                    1,
+			"pool_occupancy_percentage":           85,
+			"pool_last_reading_age_seconds":       3 * 60 * 60,
+			"pool_last_reading_timestamp_seconds": float64(now.Add(-3*time.Hour).UnixNano()) / 1e9,
+		}},
+		{name: "no readings", db: &fakeQuerier{}, values: map[string]float64{"pool_database_up": 1}},
+		{name: "database down", db: &fakeQuerier{err: errors.New("connection refused")}, values: map[string]float64{"pool_database_up": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewPedanticRegistry()
+			registry.MustRegister(newOccupancyCollector(tt.db))
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := map[string]float64{}
+			for _, family := range families {
+				if family.GetType().String() != "GAUGE" || len(family.Metric) != 1 || len(family.Metric[0].Label) != 0 {
+					t.Errorf("got %v, want a single unlabelled gauge", family)
+					continue
+				}
+				got[family.GetName()] = family.Metric[0].GetGauge().GetValue()
+			}
+			if len(got) != len(tt.values) {
+				t.Errorf("got gauges %v, want %v", got, tt.values)
+			}
+			for name, want := range tt.values {
+				value, ok := got[name]
+				tolerance := 1e-3
+				if name == "pool_last_reading_age_seconds" {
+					tolerance = 60
+				}
+				if !ok || math.Abs(value-want) > tolerance {
+					t.Errorf("got %s %v (reported %v), want %v", name, value, ok, want)
+				}
+			}
+		})
+	}
+}
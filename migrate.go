@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<description>.sql. New tables and
+// changes to existing ones go here as a new file; applied files must never be edited.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock key that keeps instances starting together from applying
+// the same migration twice
+const migrationLock = 0x706f6f6c
+
+// migrationsSchema records the migrations applied to the database
+const migrationsSchema = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// migration is one embedded schema migration
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations reads the migrations in the migrations directory of files, migrationFiles
+// outside of tests, in version order
+func loadMigrations(files fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration name %s: expected <version>_<description>.sql", entry.Name())
+		}
+		if len(migrations) > 0 && migrations[len(migrations)-1].version == version {
+			return nil, fmt.Errorf("duplicate migration version %d", version)
+		}
+		data, err := fs.ReadFile(files, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: strings.TrimSuffix(entry.Name(), ".sql"), sql: string(data)})
+	}
+	return migrations, nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations
+func appliedMigrations(ctx context.Context, conn *pgxpool.Conn) (map[int]bool, error) {
+	if _, err := conn.Exec(ctx, migrationsSchema); err != nil {
+		return nil, fmt.Errorf("unable to create schema_migrations table: %v", err)
+	}
+	rows, err := conn.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+// migrate applies the migrations the database is missing, each in its own transaction
+func migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+	timescaleMode, err := getTimescaleMode()
+	if err != nil {
+		return err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	// A session lock, since it has to outlast the transactions of the migrations
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return fmt.Errorf("unable to lock migrations: %v", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock)
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			// Settings a migration depends on are passed as transaction-local variables
+			if _, err := tx.Exec(ctx, "SELECT set_config('pool_api.timescaledb', $1, true)", timescaleMode); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(ctx, m.sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.version, m.name)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("unable to apply migration %s: %v", m.name, err)
+		}
+		slog.Info("Applied migration", "migration", m.name)
+	}
+	return nil
+}
+
+// migrateOnStart applies the migrations at startup unless MIGRATE_ON_START is false, for
+// deployments that run the migrate subcommand as a separate step
+func migrateOnStart(pool *pgxpool.Pool) error {
+	if os.Getenv("MIGRATE_ON_START") == "false" {
+		return nil
+	}
+	return migrate(context.Background(), pool)
+}
+
+// runMigrate implements the migrate subcommand:
+//
+//	pool-api migrate [-status]
+//
+// It applies the pending migrations, or with -status lists every migration and whether it was applied.
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	status := flags.Bool("status", false, "list the migrations instead of applying them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: pool-api migrate [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	ctx := context.Background()
+	pool, err := getDatabasePool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if !*status {
+		return migrate(ctx, pool)
+	}
+
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		state := "pending"
+		if applied[m.version] {
+			state = "applied"
+		}
+		fmt.Printf("%s\t%s\n", m.name, state)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(sql string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(sql)} }
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  []string
+		err   string
+	}{
+		{name: "in version order", files: fstest.MapFS{
+			"migrations/0002_add_suspect_column.sql": file("ALTER TABLE pool_usage ADD suspect boolean"),
+			"migrations/0001_create_pool_usage.sql":  file("CREATE TABLE pool_usage ()"),
+			"migrations/0010_create_sessions.sql":    file("CREATE TABLE sessions ()"),
+		}, want: []string{"0001_create_pool_usage", "0002_add_suspect_column", "0010_create_sessions"}},
+		{name: "no description", files: fstest.MapFS{"migrations/0001.sql": file("")}, err: "invalid migration name 0001.sql: expected <version>_<description>.sql"},
+		{name: "no version", files: fstest.MapFS{"migrations/init_schema.sql": file("")}, err: "invalid migration name init_schema.sql: expected <version>_<description>.sql"},
+		{name: "version 0", files: fstest.MapFS{"migrations/0000_init.sql": file("")}, err: "invalid migration name 0000_init.sql: expected <version>_<description>.sql"},
+		{name: "duplicate version", files: fstest.MapFS{
+			"migrations/0003_create_index.sql": file(""),
+			"migrations/0003_create_view.sql":  file(""),
+		}, err: "duplicate migration version 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadMigrations(tt.files)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, m := range got {
+				names = append(names, m.name)
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("got %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("got migration %s at position %d, want versions without gaps", m.name, i+1)
+		}
+		if m.sql == "" {
+			t.Errorf("migration %s is empty", m.name)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+-- The readings table. Deployments that predate migrations already have it.
+CREATE TABLE IF NOT EXISTS pool_usage (
+	id serial PRIMARY KEY,
+	timestamp timestamptz NOT NULL,
+	percentage integer NOT NULL
+);
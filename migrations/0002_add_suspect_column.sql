@@ -0,0 +1,2 @@
+-- Marks readings flagged as implausible jumps, which queries leave out
+ALTER TABLE pool_usage ADD COLUMN IF NOT EXISTS suspect boolean NOT NULL DEFAULT false;
@@ -0,0 +1,12 @@
+-- The query endpoints walk pool_usage by timestamp. The index is unique so concurrent writers
+-- cannot store a timestamp twice; while the table still holds duplicate timestamps a plain index
+-- is created instead, and the unique one has to be created by hand once they are removed.
+DO $$
+BEGIN
+	CREATE UNIQUE INDEX IF NOT EXISTS pool_usage_timestamp_key ON pool_usage (timestamp);
+	DROP INDEX IF EXISTS pool_usage_timestamp_idx;
+EXCEPTION WHEN unique_violation THEN
+	RAISE WARNING 'pool_usage holds duplicate timestamps, creating a non-unique index';
+	CREATE INDEX IF NOT EXISTS pool_usage_timestamp_idx ON pool_usage (timestamp);
+END;
+$$;
@@ -0,0 +1,23 @@
+-- Webhook subscriptions and their delivery log
+CREATE TABLE IF NOT EXISTS webhooks (
+	id bigserial PRIMARY KEY,
+	url text NOT NULL,
+	secret text NOT NULL,
+	events text[] NOT NULL,
+	threshold int,
+	active boolean NOT NULL DEFAULT true,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id bigserial PRIMARY KEY,
+	webhook_id bigint NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event text NOT NULL,
+	payload jsonb NOT NULL,
+	status text NOT NULL DEFAULT 'pending',
+	attempts int NOT NULL DEFAULT 0,
+	response_status int,
+	last_error text,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	delivered_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at DESC);
@@ -0,0 +1,10 @@
+-- The responses to requests sent with an Idempotency-Key
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key text PRIMARY KEY,
+	request_hash text NOT NULL,
+	status_code int,
+	content_type text,
+	body bytea,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
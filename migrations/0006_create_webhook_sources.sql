@@ -0,0 +1,8 @@
+-- Third-party systems allowed to POST readings
+CREATE TABLE IF NOT EXISTS webhook_sources (
+	name text PRIMARY KEY,
+	token_hash text NOT NULL,
+	percentage_template text NOT NULL,
+	timestamp_template text NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL DEFAULT now()
+);
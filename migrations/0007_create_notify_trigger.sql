@@ -0,0 +1,13 @@
+-- Every insert into pool_usage, from this API or from an external collector, sends a NOTIFY on
+-- the channel the API listens on (readingsChannel). The trigger fires once per statement so bulk
+-- loads send a single notification, and the payload is left empty because listeners reload the
+-- new rows anyway.
+CREATE OR REPLACE FUNCTION notify_pool_usage_inserted() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('pool_usage_inserted', '');
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS pool_usage_inserted ON pool_usage;
+CREATE TRIGGER pool_usage_inserted AFTER INSERT ON pool_usage
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_pool_usage_inserted();
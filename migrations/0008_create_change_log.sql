@@ -0,0 +1,26 @@
+-- Every insert, update and delete on pool_usage is recorded in an append-only log replicas sync
+-- from with /changes?since=<seq>. Rows already present when the log was created are not in it;
+-- replicas start from a full export.
+CREATE TABLE IF NOT EXISTS pool_usage_changes (
+	seq bigserial PRIMARY KEY,
+	op text NOT NULL,
+	reading_id integer NOT NULL,
+	timestamp timestamptz,
+	percentage integer,
+	suspect boolean,
+	changed_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE OR REPLACE FUNCTION record_pool_usage_change() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		INSERT INTO pool_usage_changes (op, reading_id) VALUES ('delete', OLD.id);
+	ELSE
+		INSERT INTO pool_usage_changes (op, reading_id, timestamp, percentage, suspect)
+		VALUES (lower(TG_OP), NEW.id, NEW.timestamp, NEW.percentage, NEW.suspect);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS pool_usage_changed ON pool_usage;
+CREATE TRIGGER pool_usage_changed AFTER INSERT OR UPDATE OR DELETE ON pool_usage
+	FOR EACH ROW EXECUTE FUNCTION record_pool_usage_change();
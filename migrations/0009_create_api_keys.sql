@@ -0,0 +1,12 @@
+-- API keys. Only the hex SHA-256 of a key is stored; revoked keys are kept so the audit log can
+-- still be traced back to them.
+CREATE TABLE IF NOT EXISTS api_keys (
+	id serial PRIMARY KEY,
+	name text NOT NULL,
+	key_hash text NOT NULL UNIQUE,
+	scopes text[] NOT NULL DEFAULT '{}',
+	created_at timestamptz NOT NULL DEFAULT now(),
+	last_used_at timestamptz
+);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at timestamptz;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS revoked_at timestamptz;
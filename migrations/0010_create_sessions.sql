@@ -0,0 +1,11 @@
+-- Browser sessions started by an OIDC login; like API keys, sessions are stored by the hash of
+-- their cookie value
+CREATE TABLE IF NOT EXISTS sessions (
+	id_hash text PRIMARY KEY,
+	subject text NOT NULL,
+	email text NOT NULL DEFAULT '',
+	roles text[] NOT NULL DEFAULT '{}',
+	created_at timestamptz NOT NULL DEFAULT now(),
+	expires_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
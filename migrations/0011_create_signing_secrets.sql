@@ -0,0 +1,7 @@
+-- Per-source secrets ingest payloads are signed with. Unlike tokens the secrets are kept in the
+-- clear, since verifying an HMAC needs them.
+CREATE TABLE IF NOT EXISTS signing_secrets (
+	source text PRIMARY KEY,
+	secret text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,41 @@
+-- The hourly rollup of pool_usage and the table of hours whose rollup is out of date. A row
+-- trigger marks the hours touched by every insert, update and delete; the refresh worker
+-- recomputes them, and the pool_usage_hours view computes them from the raw readings meanwhile,
+-- so results never lag behind the worker. An hourly table stays small enough, some 9000 rows a
+-- year, to be re-aggregated into days, weeks and months on the calendar of any time zone.
+CREATE TABLE IF NOT EXISTS pool_usage_hourly (
+	bucket timestamptz PRIMARY KEY,
+	readings integer NOT NULL,
+	total bigint NOT NULL,
+	min integer NOT NULL,
+	max integer NOT NULL
+);
+CREATE TABLE IF NOT EXISTS pool_usage_rollup_dirty (
+	hour timestamptz PRIMARY KEY
+);
+CREATE OR REPLACE FUNCTION mark_pool_usage_rollup_dirty() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP <> 'INSERT' THEN
+		INSERT INTO pool_usage_rollup_dirty VALUES (date_trunc('hour', OLD.timestamp)) ON CONFLICT DO NOTHING;
+	END IF;
+	IF TG_OP <> 'DELETE' THEN
+		INSERT INTO pool_usage_rollup_dirty VALUES (date_trunc('hour', NEW.timestamp)) ON CONFLICT DO NOTHING;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS pool_usage_rollup_dirty ON pool_usage;
+CREATE TRIGGER pool_usage_rollup_dirty AFTER INSERT OR UPDATE OR DELETE ON pool_usage
+	FOR EACH ROW EXECUTE FUNCTION mark_pool_usage_rollup_dirty();
+DROP VIEW IF EXISTS pool_usage_hours;
+CREATE VIEW pool_usage_hours AS
+	SELECT bucket AS timestamp, readings::bigint, total, min, max FROM pool_usage_hourly h
+	WHERE NOT EXISTS (SELECT 1 FROM pool_usage_rollup_dirty d WHERE d.hour = h.bucket)
+	UNION ALL
+	SELECT d.hour, count(*), sum(p.percentage), min(p.percentage), max(p.percentage)
+	FROM pool_usage_rollup_dirty d JOIN pool_usage p ON p.timestamp >= d.hour AND p.timestamp < d.hour + interval '1 hour'
+	WHERE NOT p.suspect GROUP BY d.hour;
+INSERT INTO pool_usage_rollup_dirty
+	SELECT DISTINCT date_trunc('hour', timestamp) FROM pool_usage
+	WHERE NOT EXISTS (SELECT 1 FROM pool_usage_hourly)
+	ON CONFLICT DO NOTHING;
@@ -0,0 +1,52 @@
+-- With TimescaleDB, pool_usage becomes a hypertable of weekly chunks and a continuous aggregate
+-- replaces the trigger-maintained rollup. pool_api.timescaledb holds TIMESCALEDB as it was when
+-- this migration ran: auto converts when the extension is installed, on installs it first and
+-- off leaves the table alone.
+DO $$
+DECLARE
+	mode text := coalesce(nullif(current_setting('pool_api.timescaledb', true), ''), 'auto');
+	pk text;
+BEGIN
+	IF mode = 'on' THEN
+		CREATE EXTENSION IF NOT EXISTS timescaledb;
+	END IF;
+	IF mode = 'off' OR NOT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb') THEN
+		RETURN;
+	END IF;
+
+	-- Unique constraints of a hypertable must include its time column, so the id primary key is
+	-- widened to (id, timestamp)
+	SELECT conname INTO pk FROM pg_constraint c
+	WHERE conrelid = 'pool_usage'::regclass AND contype = 'p' AND NOT EXISTS (
+		SELECT 1 FROM unnest(c.conkey) AS k(attnum)
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+		WHERE a.attname = 'timestamp'
+	);
+	IF pk IS NOT NULL THEN
+		EXECUTE format('ALTER TABLE pool_usage DROP CONSTRAINT %I, ADD PRIMARY KEY (id, timestamp)', pk);
+	END IF;
+	PERFORM create_hypertable('pool_usage', 'timestamp', chunk_time_interval => interval '7 days', migrate_data => true, if_not_exists => true);
+
+	-- Chunks are compressed ordered by time as the queries read them; the age after which they
+	-- are is the policy set at startup from TIMESCALEDB_COMPRESS_AFTER
+	IF NOT (SELECT compression_enabled FROM timescaledb_information.hypertables WHERE hypertable_name = 'pool_usage') THEN
+		ALTER TABLE pool_usage SET (timescaledb.compress, timescaledb.compress_orderby = 'timestamp DESC');
+	END IF;
+
+	-- Real-time aggregation adds the hours not yet materialized, and the policy refreshes every
+	-- hour invalidated by a change, however old, so pool_usage_hours stays exact
+	CREATE MATERIALIZED VIEW IF NOT EXISTS pool_usage_hourly_cagg
+		WITH (timescaledb.continuous, timescaledb.materialized_only = false) AS
+		SELECT time_bucket(interval '1 hour', timestamp) AS bucket, count(*) AS readings,
+			sum(percentage) AS total, min(percentage) AS min, max(percentage) AS max
+		FROM pool_usage WHERE NOT suspect GROUP BY bucket
+		WITH NO DATA;
+	PERFORM add_continuous_aggregate_policy('pool_usage_hourly_cagg', start_offset => NULL,
+		end_offset => interval '1 hour', schedule_interval => interval '5 minutes', if_not_exists => true);
+	DROP TRIGGER IF EXISTS pool_usage_rollup_dirty ON pool_usage;
+	DROP VIEW IF EXISTS pool_usage_hours;
+	DROP TABLE IF EXISTS pool_usage_rollup_dirty, pool_usage_hourly;
+	CREATE VIEW pool_usage_hours AS
+		SELECT bucket AS timestamp, readings, total, min, max FROM pool_usage_hourly_cagg;
+END;
+$$;
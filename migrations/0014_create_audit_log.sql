@@ -0,0 +1,11 @@
+-- The append-only log of changes made by authenticated callers
+CREATE TABLE IF NOT EXISTS audit_log (
+	id bigserial PRIMARY KEY,
+	at timestamptz NOT NULL DEFAULT now(),
+	actor text NOT NULL,
+	action text NOT NULL,
+	target text NOT NULL DEFAULT '',
+	before jsonb,
+	after jsonb
+);
+CREATE INDEX IF NOT EXISTS audit_log_at_idx ON audit_log (at);
@@ -0,0 +1,16 @@
+-- Sequence numbers were taken when a change was written, not when it committed, so a
+-- transaction could commit seq 5 after a reader had already paged past seq 6 and the reader
+-- would never see it. Writers of the log now take a transaction-level lock first, so numbers are
+-- handed out in commit order and every change below the newest visible one is visible too.
+CREATE OR REPLACE FUNCTION record_pool_usage_change() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_advisory_xact_lock(hashtext('pool_usage_changes'));
+	IF TG_OP = 'DELETE' THEN
+		INSERT INTO pool_usage_changes (op, reading_id) VALUES ('delete', OLD.id);
+	ELSE
+		INSERT INTO pool_usage_changes (op, reading_id, timestamp, percentage, suspect)
+		VALUES (lower(TG_OP), NEW.id, NEW.timestamp, NEW.percentage, NEW.suspect);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,21 @@
+-- A counter every statement writing pool_usage advances, as the validator of the responses
+-- computed from the readings. Writers update its single row, so they take turns and the counter
+-- moves with every commit, in commit order, whether it inserts, corrects, unflags or deletes
+-- readings. changed_at is taken once the row is locked and so never goes backwards.
+CREATE TABLE IF NOT EXISTS pool_usage_version (
+	id boolean PRIMARY KEY DEFAULT true CHECK (id),
+	version bigint NOT NULL,
+	changed_at timestamptz NOT NULL
+);
+INSERT INTO pool_usage_version (version, changed_at)
+	SELECT coalesce(max(seq), 0), coalesce(max(changed_at), now()) FROM pool_usage_changes
+	ON CONFLICT DO NOTHING;
+CREATE OR REPLACE FUNCTION bump_pool_usage_version() RETURNS trigger AS $$
+BEGIN
+	UPDATE pool_usage_version SET version = version + 1, changed_at = clock_timestamp();
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS pool_usage_version ON pool_usage;
+CREATE TRIGGER pool_usage_version AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON pool_usage
+	FOR EACH STATEMENT EXECUTE FUNCTION bump_pool_usage_version();
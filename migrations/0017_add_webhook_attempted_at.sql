@@ -0,0 +1,3 @@
+-- When a delivery was last tried or claimed, so the sweep can tell deliveries an instance that
+-- stopped left pending from the ones still being retried
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS attempted_at timestamptz;
@@ -0,0 +1,9 @@
+-- Signing secrets of authenticated ingest callers are bound to the caller, e.g. api-key:sensor-1,
+-- so a caller cannot pick another source's secret. Every signature is accepted once; it is kept
+-- until its timestamp is too old to pass the check anyway.
+ALTER TABLE signing_secrets ADD COLUMN IF NOT EXISTS actor text UNIQUE;
+CREATE TABLE IF NOT EXISTS used_signatures (
+	signature text PRIMARY KEY,
+	signed_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS used_signatures_signed_at_idx ON used_signatures (signed_at);
@@ -0,0 +1,6 @@
+-- Idempotency keys are scoped to the authenticated caller, e.g. api-key:sensor-1, so two callers
+-- that happen to pick the same key neither see each other's responses nor block each other.
+-- Requests without credentials share the empty actor.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS actor text NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD CONSTRAINT idempotency_keys_pkey PRIMARY KEY (actor, key);
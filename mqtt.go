@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTTopic = "pool/occupancy"
+	// mqttTimeout bounds how long connecting and publishing may block
+	mqttTimeout = 10 * time.Second
+)
+
+// mqttConfig is read from MQTT_BROKER (e.g. tcp://localhost:1883), MQTT_TOPIC, MQTT_INGEST_TOPIC,
+// MQTT_CLIENT_ID, MQTT_USERNAME and MQTT_PASSWORD; MQTT is disabled without a broker
+type mqttConfig struct {
+	Broker string
+	// Topic receives every new reading
+	Topic string
+	// IngestTopic, when set, is subscribed to and every message on it is stored as a reading
+	IngestTopic string
+	ClientID    string
+	Username    string
+	Password    string
+}
+
+func getMQTTConfig() (mqttConfig, error) {
+	cfg := mqttConfig{
+		Broker:      os.Getenv("MQTT_BROKER"),
+		Topic:       os.Getenv("MQTT_TOPIC"),
+		IngestTopic: os.Getenv("MQTT_INGEST_TOPIC"),
+		ClientID:    os.Getenv("MQTT_CLIENT_ID"),
+		Username:    os.Getenv("MQTT_USERNAME"),
+	}
+	password, err := secret("MQTT_PASSWORD")
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Password = password
+	if cfg.Topic == "" {
+		cfg.Topic = defaultMQTTTopic
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "pool-api"
+	}
+	if cfg.IngestTopic != "" && cfg.IngestTopic == cfg.Topic {
+		return cfg, fmt.Errorf("MQTT_INGEST_TOPIC must differ from MQTT_TOPIC, or published readings would be stored again")
+	}
+	return cfg, nil
+}
+
+// connectMQTT connects to the broker; the client reconnects on its own after connection loss.
+// onConnect, if not nil, runs after every (re)connect, which is where subscriptions have to be made.
+func connectMQTT(cfg mqttConfig, onConnect mqtt.OnConnectHandler) (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		// Reconnects log in with the current password, so a rotated one is picked up
+		SetCredentialsProvider(func() (string, string) {
+			password, err := secret("MQTT_PASSWORD")
+			if err != nil || password == "" {
+				password = cfg.Password
+			}
+			return cfg.Username, password
+		}).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("MQTT connection lost", "error", err)
+		})
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", cfg.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("unable to connect to MQTT broker %s: %v", cfg.Broker, err)
+	}
+	return client, nil
+}
+
+// publishMQTT publishes every data point received from points as JSON to topic. Messages are
+// retained so that consumers subscribing later immediately receive the latest reading.
+func publishMQTT(client mqtt.Client, topic string, points <-chan DataPoint) {
+	for dp := range points {
+		payload, err := json.Marshal(dp)
+		if err != nil {
+			slog.Error("Error encoding MQTT payload", "error", err)
+			continue
+		}
+		token := client.Publish(topic, 1, true, payload)
+		if !token.WaitTimeout(mqttTimeout) {
+			slog.Warn("Timed out publishing a data point to MQTT", "id", dp.ID)
+			continue
+		}
+		if err := token.Error(); err != nil {
+			slog.Error("Error publishing a data point to MQTT", "id", dp.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestGetMQTTConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		topic       string
+		ingestTopic string
+		want        mqttConfig
+		err         string
+	}{
+		{name: "defaults", want: mqttConfig{Broker: "tcp://broker:1883", Topic: "pool/occupancy", ClientID: "pool-api"}},
+		{name: "topics", topic: "home/pool", ingestTopic: "home/pool/sensor",
+			want: mqttConfig{Broker: "tcp://broker:1883", Topic: "home/pool", IngestTopic: "home/pool/sensor", ClientID: "pool-api"}},
+		{name: "ingest topic is the default topic", ingestTopic: "pool/occupancy",
+			err: "MQTT_INGEST_TOPIC must differ from MQTT_TOPIC, or published readings would be stored again"},
+		{name: "ingest topic is the topic", topic: "home/pool", ingestTopic: "home/pool",
+			err: "MQTT_INGEST_TOPIC must differ from MQTT_TOPIC, or published readings would be stored again"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSecrets(t)
+			t.Setenv("MQTT_BROKER", "tcp://broker:1883")
+			t.Setenv("MQTT_TOPIC", tt.topic)
+			t.Setenv("MQTT_INGEST_TOPIC", tt.ingestTopic)
+			t.Setenv("MQTT_CLIENT_ID", "")
+			t.Setenv("MQTT_USERNAME", "")
+			t.Setenv("MQTT_PASSWORD", "")
+			cfg, err := getMQTTConfig()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg != tt.want {
+				t.Errorf("got %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+// doneToken is the token of an operation that has already completed
+type doneToken struct{ err error }
+
+func (t doneToken) Wait() bool                     { return true }
+func (t doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t doneToken) Error() error                   { return t.err }
+func (t doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// mqttMessage is a message handed to publishingClient.Publish
+type mqttMessage struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  string
+}
+
+// publishingClient records what is published and fails the failAt-th publish
+type publishingClient struct {
+	mqtt.Client
+	failAt    int
+	published []mqttMessage
+}
+
+func (c *publishingClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.published = append(c.published, mqttMessage{topic, qos, retained, string(payload.([]byte))})
+	if len(c.published) == c.failAt {
+		return doneToken{errors.New("not connected")}
+	}
+	return doneToken{}
+}
+
+func TestPublishMQTT(t *testing.T) {
+	points := make(chan DataPoint, 3)
+	points <- DataPoint{ID: 1, Timestamp: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), Percentage: 37}
+	points <- DataPoint{ID: 2, Timestamp: time.Date(2024, 6, 1, 12, 15, 0, 0, time.UTC), Percentage: 0}
+	points <- DataPoint{ID: 3, Timestamp: time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC), Percentage: 100}
+	close(points)
+
+	// A failed publish is logged and the next reading still goes out
+	client := &publishingClient{failAt: 2}
+	publishMQTT(client, "home/pool", points)
+	want := []mqttMessage{
+		{"home/pool", 1, true, `{"id":1,"timestamp":"2024-06-01T12:00:00Z","percentage":37}`},
+		{"home/pool", 1, true, `{"id":2,"timestamp":"2024-06-01T12:15:00Z","percentage":0}`},
+		{"home/pool", 1, true, `{"id":3,"timestamp":"2024-06-01T12:30:00Z","percentage":100}`},
+	}
+	if len(client.published) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(client.published), len(want))
+	}
+	for i := range want {
+		if client.published[i] != want[i] {
+			t.Errorf("got message %+v, want %+v", client.published[i], want[i])
+		}
+	}
+}
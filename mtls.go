@@ -0,0 +1,87 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// mtlsConfig describes the listener for devices that authenticate with a client certificate
+type mtlsConfig struct {
+	addr     string
+	certFile string
+	keyFile  string
+	clientCA *x509.CertPool
+	// required takes the ingest routes off the main listener, so readings can only arrive over mTLS
+	required bool
+}
+
+// getMTLSConfig reads MTLS_ADDR, the address of the mTLS ingest listener, and MTLS_CA_FILE, the
+// CA that issues device certificates. The listener presents MTLS_CERT_FILE and MTLS_KEY_FILE,
+// defaulting to the main TLS certificate. It returns nil if MTLS_ADDR is unset.
+func getMTLSConfig() (*mtlsConfig, error) {
+	addr := os.Getenv("MTLS_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+	cfg := &mtlsConfig{
+		addr:     addr,
+		certFile: cmp.Or(os.Getenv("MTLS_CERT_FILE"), os.Getenv("TLS_CERT_FILE")),
+		keyFile:  cmp.Or(os.Getenv("MTLS_KEY_FILE"), os.Getenv("TLS_KEY_FILE")),
+		required: mtlsRequired(),
+	}
+	if cfg.certFile == "" || cfg.keyFile == "" {
+		return nil, fmt.Errorf("MTLS_ADDR needs a server certificate in MTLS_CERT_FILE and MTLS_KEY_FILE")
+	}
+	caFile := os.Getenv("MTLS_CA_FILE")
+	if caFile == "" {
+		return nil, fmt.Errorf("MTLS_ADDR needs the device CA in MTLS_CA_FILE")
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read MTLS_CA_FILE: %v", err)
+	}
+	cfg.clientCA = x509.NewCertPool()
+	if !cfg.clientCA.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in MTLS_CA_FILE %s", caFile)
+	}
+	return cfg, nil
+}
+
+// mtlsRequired reports whether readings may only arrive over the mTLS listener, so the other ways
+// in for devices (gRPC Ingest and the MQTT ingest topic) are refused
+func mtlsRequired() bool {
+	return os.Getenv("MTLS_ADDR") != "" && os.Getenv("MTLS_REQUIRED") == "true"
+}
+
+// serve runs the mTLS listener, which rejects every connection without a certificate issued by the CA
+func (cfg *mtlsConfig) serve(ctx context.Context, timeout time.Duration, handler http.Handler) error {
+	server := &http.Server{
+		Addr:    cfg.addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  cfg.clientCA,
+		},
+	}
+	slog.Info("Starting mTLS ingest server", "addr", cfg.addr)
+	return serveUntil(ctx, timeout, server, func() error {
+		return server.ListenAndServeTLS(cfg.certFile, cfg.keyFile)
+	})
+}
+
+// verifiedDevice returns the common name of the client certificate the request was made with, or
+// "" if there is none. Only the mTLS listener asks for certificates, so only its requests have one.
+func verifiedDevice(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return cmp.Or(r.TLS.VerifiedChains[0][0].Subject.CommonName, "unnamed device")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetMTLSConfig(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Device CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		none     bool
+		certFile string
+		required bool
+		err      string
+	}{
+		{name: "not configured", env: map[string]string{"MTLS_REQUIRED": "true"}, none: true},
+		{name: "main certificate", env: map[string]string{"MTLS_ADDR": ":8444", "TLS_CERT_FILE": "tls.pem", "TLS_KEY_FILE": "tls.key", "MTLS_CA_FILE": caFile}, certFile: "tls.pem"},
+		{
+			name:     "own certificate",
+			env:      map[string]string{"MTLS_ADDR": ":8444", "TLS_CERT_FILE": "tls.pem", "TLS_KEY_FILE": "tls.key", "MTLS_CERT_FILE": "mtls.pem", "MTLS_KEY_FILE": "mtls.key", "MTLS_CA_FILE": caFile, "MTLS_REQUIRED": "true"},
+			certFile: "mtls.pem",
+			required: true,
+		},
+		{name: "no certificate", env: map[string]string{"MTLS_ADDR": ":8444", "MTLS_CA_FILE": caFile}, err: "MTLS_ADDR needs a server certificate in MTLS_CERT_FILE and MTLS_KEY_FILE"},
+		{name: "no CA", env: map[string]string{"MTLS_ADDR": ":8444", "MTLS_CERT_FILE": "mtls.pem", "MTLS_KEY_FILE": "mtls.key"}, err: "MTLS_ADDR needs the device CA in MTLS_CA_FILE"},
+		{name: "CA without certificates", env: map[string]string{"MTLS_ADDR": ":8444", "MTLS_CERT_FILE": "mtls.pem", "MTLS_KEY_FILE": "mtls.key", "MTLS_CA_FILE": notPEM}, err: "no certificates found in MTLS_CA_FILE " + notPEM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"MTLS_ADDR", "MTLS_CERT_FILE", "MTLS_KEY_FILE", "MTLS_CA_FILE", "MTLS_REQUIRED", "TLS_CERT_FILE", "TLS_KEY_FILE"} {
+				t.Setenv(name, tt.env[name])
+			}
+			cfg, err := getMTLSConfig()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.none {
+				if cfg != nil || mtlsRequired() {
+					t.Fatalf("got %+v, want no mTLS listener", cfg)
+				}
+				return
+			}
+			if cfg.certFile != tt.certFile || cfg.required != tt.required || cfg.clientCA == nil {
+				t.Errorf("got certificate %q, required %v, want %q, %v", cfg.certFile, cfg.required, tt.certFile, tt.required)
+			}
+		})
+	}
+}
+
+func TestVerifiedDevice(t *testing.T) {
+	device := &x509.Certificate{Subject: pkix.Name{CommonName: "gate-1"}}
+	unnamed := &x509.Certificate{}
+	tests := []struct {
+		name string
+		tls  *tls.ConnectionState
+		want string
+	}{
+		{name: "plain HTTP"},
+		{name: "no client certificate", tls: &tls.ConnectionState{}},
+		{name: "device", tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{device}}}, want: "gate-1"},
+		{name: "no common name", tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{unnamed}}}, want: "unnamed device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/pool-data", nil)
+			r.TLS = tt.tls
+			if got := verifiedDevice(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
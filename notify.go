@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// readingsChannel is the channel pool_usage inserts are announced on by the trigger of migration 0007
+const readingsChannel = "pool_usage_inserted"
+
+// listenNewReadings holds a dedicated connection that LISTENs on readingsChannel and signals wake
+// for every notification, so the watcher picks up new rows immediately instead of at its next
+// poll. After reconnecting it signals once as well, in case a notification was missed meanwhile.
+// Reconnecting backs off while the database stays down.
+func listenNewReadings(ctx context.Context, pool *pgxpool.Pool, wake chan<- struct{}) {
+	attempt := 0
+	for {
+		started := time.Now()
+		err := listen(ctx, pool, wake)
+		if ctx.Err() != nil {
+			return
+		}
+		// A session that lasted a while was a working connection, so the backoff starts over
+		if time.Since(started) > maxRetryDelay {
+			attempt = 0
+		}
+		delay := retryDelay(attempt)
+		attempt++
+		slog.Warn("Lost the notification listener, retrying", "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// listen runs one LISTEN session until its connection fails
+func listen(ctx context.Context, pool *pgxpool.Pool, wake chan<- struct{}) error {
+	pooled, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The session state makes the connection unfit for reuse, so take it out of the pool for good
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+readingsChannel); err != nil {
+		return err
+	}
+	wakeWatcher(wake)
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		wakeWatcher(wake)
+	}
+}
+
+// wakeWatcher wakes the watcher without blocking; a pending wake-up already covers this one
+func wakeWatcher(wake chan<- struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestWakeWatcher(t *testing.T) {
+	wake := make(chan struct{}, 1)
+	// A second notification while the watcher is busy must neither block nor queue another poll
+	wakeWatcher(wake)
+	wakeWatcher(wake)
+	if len(wake) != 1 {
+		t.Fatalf("got %d pending wake-ups, want 1", len(wake))
+	}
+	<-wake
+	wakeWatcher(wake)
+	if len(wake) != 1 {
+		t.Errorf("got %d pending wake-ups after the watcher woke, want 1", len(wake))
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// sessionCookie holds the session id of a logged-in browser
+	sessionCookie = "pool_session"
+	// loginCookie carries the state of a login between /auth/login and /auth/callback
+	loginCookie = "pool_login"
+	// loginTimeout bounds how long a user may take at the identity provider
+	loginTimeout = 10 * time.Minute
+	// defaultSessionTTL is the session lifetime without SESSION_TTL
+	defaultSessionTTL = 12 * time.Hour
+)
+
+// Session describes the logged-in user of a browser session
+type Session struct {
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	Roles     []string  `json:"roles"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// loginState is remembered in loginCookie while the user is at the identity provider
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	ReturnTo string `json:"return_to"`
+}
+
+// oidcLogin signs staff in with the authorization code flow and maps their groups to roles
+type oidcLogin struct {
+	db       database
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	// groupsClaim is the ID token claim listing the user's groups
+	groupsClaim string
+	// groupRoles maps identity provider groups to the roles they grant
+	groupRoles map[string][]string
+	ttl        time.Duration
+	secure     bool
+}
+
+// newOIDCLogin configures the login from OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET and
+// OIDC_REDIRECT_URL, the public URL of /auth/callback. Members of the groups listed in
+// OIDC_ADMIN_GROUPS, OIDC_WRITER_GROUPS and OIDC_READER_GROUPS get the matching role; the groups
+// are read from the OIDC_GROUPS_CLAIM claim (default groups). It returns nil if OIDC_ISSUER is unset.
+func newOIDCLogin(ctx context.Context, pool *pgxpool.Pool) (*oidcLogin, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+	clientSecret, err := secret("OIDC_CLIENT_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("unable to discover OIDC provider %s: %v", issuer, err)
+	}
+
+	ttl := defaultSessionTTL
+	if value := os.Getenv("SESSION_TTL"); value != "" {
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid SESSION_TTL %q", value)
+		}
+	}
+	groupsClaim := os.Getenv("OIDC_GROUPS_CLAIM")
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	groupRoles := map[string][]string{}
+	for role, name := range map[string]string{roleAdmin: "OIDC_ADMIN_GROUPS", roleWriter: "OIDC_WRITER_GROUPS", roleReader: "OIDC_READER_GROUPS"} {
+		for _, group := range splitList(os.Getenv(name)) {
+			groupRoles[group] = append(groupRoles[group], role)
+		}
+	}
+	if len(groupRoles) == 0 {
+		slog.Warn("No OIDC_*_GROUPS are set, so logged-in users get no roles")
+	}
+
+	return &oidcLogin{
+		db: pool,
+		oauth: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: clientID}),
+		groupsClaim: groupsClaim,
+		groupRoles:  groupRoles,
+		ttl:         ttl,
+		secure:      strings.HasPrefix(redirectURL, "https://"),
+	}, nil
+}
+
+// randomToken returns 32 random bytes as hex
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// localPath returns target if it is a path on this server, so logins cannot redirect elsewhere
+func localPath(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+// setCookie sets an HttpOnly cookie; SameSite=Lax keeps it off cross-site POST, PATCH and DELETE requests
+func (o *oidcLogin) setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   o.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearCookie removes a cookie from the browser
+func (o *oidcLogin) clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{Name: name, Path: "/", MaxAge: -1, HttpOnly: true, Secure: o.secure, SameSite: http.SameSiteLaxMode})
+}
+
+// session returns the live session of the request, or nil if it has none
+func (o *oidcLogin) session(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+	var s Session
+	err = o.db.QueryRow(r.Context(), "SELECT subject, email, roles, expires_at FROM sessions WHERE id_hash = $1 AND expires_at > now()",
+		hashToken(cookie.Value)).Scan(&s.Subject, &s.Email, &s.Roles, &s.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// roles maps the groups of a user to the roles they grant
+func (o *oidcLogin) roles(groups []string) []string {
+	var roles []string
+	for _, group := range groups {
+		for _, role := range o.groupRoles[group] {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// getLoginHandler handles /auth/login, sending the browser to the identity provider
+func (o *oidcLogin) getLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		login := loginState{Verifier: oauth2.GenerateVerifier(), ReturnTo: localPath(r.URL.Query().Get("return_to"))}
+		var err error
+		if login.State, err = randomToken(); err == nil {
+			login.Nonce, err = randomToken()
+		}
+		if err != nil {
+			http.Error(w, "Failed to start the login", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error generating login state", "error", err)
+			return
+		}
+		value, _ := json.Marshal(login)
+		o.setCookie(w, loginCookie, base64.RawURLEncoding.EncodeToString(value), loginTimeout)
+		http.Redirect(w, r, o.oauth.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier)), http.StatusFound)
+	}
+}
+
+// getCallbackHandler handles /auth/callback, where the identity provider returns the browser
+// with an authorization code that is exchanged for an ID token and then a session
+func (o *oidcLogin) getCallbackHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var login loginState
+		cookie, err := r.Cookie(loginCookie)
+		if err == nil {
+			var value []byte
+			value, err = base64.RawURLEncoding.DecodeString(cookie.Value)
+			if err == nil {
+				err = json.Unmarshal(value, &login)
+			}
+		}
+		if err != nil || login.State == "" || r.URL.Query().Get("state") != login.State {
+			http.Error(w, "The login expired or was started elsewhere; please log in again", http.StatusBadRequest)
+			return
+		}
+		o.clearCookie(w, loginCookie)
+		if reason := r.URL.Query().Get("error"); reason != "" {
+			http.Error(w, "The identity provider refused the login: "+reason, http.StatusForbidden)
+			return
+		}
+
+		token, err := o.oauth.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(login.Verifier))
+		if err != nil {
+			http.Error(w, "Failed to redeem the authorization code", http.StatusBadGateway)
+			slog.ErrorContext(r.Context(), "Error exchanging OIDC code", "error", err)
+			return
+		}
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			http.Error(w, "The identity provider returned no ID token", http.StatusBadGateway)
+			return
+		}
+		idToken, err := o.verifier.Verify(r.Context(), rawIDToken)
+		if err != nil || idToken.Nonce != login.Nonce {
+			http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+			slog.ErrorContext(r.Context(), "Error verifying ID token", "error", err)
+			return
+		}
+		var claims map[string]any
+		if err := idToken.Claims(&claims); err != nil {
+			http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+			return
+		}
+		email, _ := claims["email"].(string)
+		var groups []string
+		if values, ok := claims[o.groupsClaim].([]any); ok {
+			for _, value := range values {
+				if group, ok := value.(string); ok {
+					groups = append(groups, group)
+				}
+			}
+		}
+
+		id, err := randomToken()
+		if err != nil {
+			http.Error(w, "Failed to start the session", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error generating session id", "error", err)
+			return
+		}
+		// Expired sessions are cleared whenever a new one starts
+		_, err = o.db.Exec(r.Context(), "DELETE FROM sessions WHERE expires_at < now()")
+		if err == nil {
+			_, err = o.db.Exec(r.Context(), "INSERT INTO sessions (id_hash, subject, email, roles, expires_at) VALUES ($1, $2, $3, $4, $5)",
+				hashToken(id), idToken.Subject, email, o.roles(groups), time.Now().Add(o.ttl))
+		}
+		if err != nil {
+			http.Error(w, "Failed to start the session", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error storing session", "error", err)
+			return
+		}
+		o.setCookie(w, sessionCookie, id, o.ttl)
+		http.Redirect(w, r, login.ReturnTo, http.StatusFound)
+	}
+}
+
+// getLogoutHandler handles /auth/logout, ending the session of the browser
+func (o *oidcLogin) getLogoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie(sessionCookie); err == nil {
+			if _, err := o.db.Exec(r.Context(), "DELETE FROM sessions WHERE id_hash = $1", hashToken(cookie.Value)); err != nil {
+				http.Error(w, "Failed to end the session", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error deleting session", "error", err)
+				return
+			}
+		}
+		o.clearCookie(w, sessionCookie)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getSessionHandler handles /auth/session, describing the logged-in user
+func (o *oidcLogin) getSessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := o.session(r)
+		if err != nil {
+			http.Error(w, "Failed to query the database", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error loading session", "error", err)
+			return
+		}
+		if session == nil {
+			http.Error(w, "Not logged in", http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, session)
+	}
+}
+
+// routes returns the login endpoints
+func (o *oidcLogin) routes() []apiRoute {
+	return []apiRoute{
+		{
+			Method: "GET", Path: "/auth/login", Summary: "Log in with the identity provider, then return to 'return_to'",
+			Params:       []apiParam{queryParam("return_to", "string", "Path on this server to return to after the login")},
+			ContentTypes: []string{"text/html"},
+			OwnAuth:      true,
+			Handler:      o.getLoginHandler(),
+		},
+		{
+			Method: "GET", Path: "/auth/callback", Summary: "Redirect target of the identity provider; starts the session",
+			Params: []apiParam{
+				queryParam("code", "string", "Authorization code"),
+				queryParam("state", "string", "State passed to the identity provider"),
+			},
+			ContentTypes: []string{"text/html"},
+			OwnAuth:      true,
+			Handler:      o.getCallbackHandler(),
+		},
+		{
+			Method: "POST", Path: "/auth/logout", Summary: "End the browser session",
+			OwnAuth: true,
+			Handler: o.getLogoutHandler(),
+		},
+		{
+			Method: "GET", Path: "/auth/session", Summary: "The logged-in user and their roles",
+			Response: Session{},
+			OwnAuth:  true,
+			Handler:  o.getSessionHandler(),
+		},
+	}
+}
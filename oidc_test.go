@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "", want: "/"},
+		{target: "/admin/readings?page=2", want: "/admin/readings?page=2"},
+		{target: "https://evil.example/", want: "/"},
+		{target: "//evil.example/", want: "/"},
+		{target: `/\evil.example`, want: "/"},
+		{target: "admin", want: "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := localPath(tt.target); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCRoles(t *testing.T) {
+	o := &oidcLogin{groupRoles: map[string][]string{
+		"pool-staff":  {roleWriter},
+		"pool-admins": {roleAdmin, roleWriter},
+		"everyone":    {roleReader},
+	}}
+	tests := []struct {
+		name   string
+		groups []string
+		want   []string
+	}{
+		{name: "no groups"},
+		{name: "unmapped group", groups: []string{"finance"}},
+		{name: "one group", groups: []string{"pool-staff"}, want: []string{roleWriter}},
+		{name: "overlapping groups", groups: []string{"pool-staff", "pool-admins", "everyone"}, want: []string{roleWriter, roleAdmin, roleReader}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := o.roles(tt.groups); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginHandler(t *testing.T) {
+	o := &oidcLogin{oauth: oauth2.Config{
+		ClientID:    "pool-api",
+		RedirectURL: "https://pool.example/auth/callback",
+		Endpoint:    oauth2.Endpoint{AuthURL: "https://idp.example/authorize"},
+	}, secure: true}
+	w := httptest.NewRecorder()
+	o.getLoginHandler()(w, httptest.NewRequest("GET", "/auth/login?return_to=//evil.example", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusFound)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != loginCookie || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("got cookies %v, want a secure HttpOnly %s cookie", cookies, loginCookie)
+	}
+	value, err := base64.RawURLEncoding.DecodeString(cookies[0].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var login loginState
+	if err := json.Unmarshal(value, &login); err != nil {
+		t.Fatal(err)
+	}
+	if login.ReturnTo != "/" {
+		t.Errorf("got return_to %q, want /", login.ReturnTo)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if query.Get("state") != login.State || query.Get("nonce") != login.Nonce || query.Get("code_challenge") != oauth2.S256ChallengeFromVerifier(login.Verifier) {
+		t.Errorf("the redirect %s does not carry the state of the login cookie", location)
+	}
+}
+
+func TestOIDCCallbackHandlerRejectsLogin(t *testing.T) {
+	cookie := func(state string) string {
+		value, _ := json.Marshal(loginState{State: state, ReturnTo: "/"})
+		return base64.RawURLEncoding.EncodeToString(value)
+	}
+	expired := "The login expired or was started elsewhere; please log in again"
+	tests := []struct {
+		name   string
+		query  string
+		cookie string
+		status int
+		body   string
+	}{
+		{name: "no login cookie", query: "state=abc&code=xyz", status: http.StatusBadRequest, body: expired},
+		{name: "corrupt cookie", query: "state=abc&code=xyz", cookie: "%%%", status: http.StatusBadRequest, body: expired},
+		{name: "other state", query: "state=abc&code=xyz", cookie: cookie("def"), status: http.StatusBadRequest, body: expired},
+		{name: "empty state", query: "state=&code=xyz", cookie: cookie(""), status: http.StatusBadRequest, body: expired},
+		{name: "refused", query: "state=abc&error=access_denied", cookie: cookie("abc"), status: http.StatusForbidden, body: "The identity provider refused the login: access_denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/auth/callback?"+tt.query, nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: loginCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			(&oidcLogin{}).getCallbackHandler()(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
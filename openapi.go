@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// openAPIDocument builds an OpenAPI 3 document from the route table
+func openAPIDocument(routes []apiRoute) map[string]any {
+	b := &openAPIBuilder{schemas: map[string]any{}}
+	paths := map[string]map[string]any{}
+	for _, route := range routes {
+		// ServeMux marks exact-match paths with {$}, which is not part of the URL
+		path := strings.TrimSuffix(route.Path, "{$}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method)] = b.operation(route)
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Pool API",
+			"description": "Occupancy readings of the pool, as recorded in the pool_usage table",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]any{
+				"bearerToken": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey":      map[string]any{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+	}
+}
+
+// openAPIBuilder collects the component schemas referenced by the operations
+type openAPIBuilder struct {
+	schemas map[string]any
+}
+
+func (b *openAPIBuilder) operation(route apiRoute) map[string]any {
+	parameters := []any{}
+	for _, p := range route.Params {
+		schema := map[string]any{"type": p.Type}
+		if p.Format != "" {
+			schema["format"] = p.Format
+		}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		parameters = append(parameters, map[string]any{
+			"name":        p.Name,
+			"in":          p.In,
+			"description": p.Description,
+			"required":    p.Required,
+			"schema":      schema,
+		})
+	}
+
+	contentTypes := route.ContentTypes
+	if contentTypes == nil {
+		contentTypes = []string{"application/json"}
+	}
+	content := map[string]any{}
+	for _, contentType := range contentTypes {
+		schema := map[string]any{"type": "string", "format": "binary"}
+		if contentType == "application/json" && route.Response != nil {
+			schema = b.schema(reflect.TypeOf(route.Response))
+		}
+		content[contentType] = map[string]any{"schema": schema}
+	}
+
+	responses := map[string]any{
+		"200": map[string]any{"description": "OK", "content": content},
+		"400": map[string]any{"description": "Invalid parameters"},
+		"429": map[string]any{"description": "Rate limit exceeded; see Retry-After"},
+		"500": map[string]any{"description": "Database failure"},
+	}
+	if !route.LongRunning {
+		responses["413"] = map[string]any{"description": "The result exceeds the server's row or size cap; ask for less or paginate"}
+		responses["504"] = map[string]any{"description": "The query took longer than the server's query timeout"}
+	}
+	operation := map[string]any{
+		"summary":    route.Summary,
+		"parameters": parameters,
+		"responses":  responses,
+	}
+	if route.Conditional {
+		responses["304"] = map[string]any{"description": "Not modified since the ETag or date the client sent"}
+	}
+	if route.Scope != "" {
+		responses["401"] = map[string]any{"description": "Missing or invalid bearer token or API key"}
+		responses["403"] = map[string]any{"description": "The API key lacks the '" + route.Scope + "' scope, or no bearer token is configured for it"}
+		operation["security"] = []any{
+			map[string]any{"bearerToken": []string{route.Scope}},
+			map[string]any{"apiKey": []string{route.Scope}},
+		}
+	}
+	return operation
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schema derives a JSON schema for t from its Go type and json tags; named structs become components
+func (b *openAPIBuilder) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]any{"type": "object"}
+	case t.Kind() == reflect.Pointer:
+		schema := b.schema(t.Elem())
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return map[string]any{"type": "object"}
+		}
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := b.schemas[t.Name()]; ok {
+			return ref
+		}
+		// Reserve the name first so recursive types terminate
+		b.schemas[t.Name()] = nil
+		properties := map[string]any{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = b.schema(field.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		b.schemas[t.Name()] = map[string]any{"type": "object", "properties": properties, "required": required}
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+// getOpenAPIHandler handles the /openapi.json endpoint
+func getOpenAPIHandler(routes []apiRoute) http.HandlerFunc {
+	document := openAPIDocument(routes)
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, document)
+	}
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at /openapi.json
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>Pool API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+	<script>
+		window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+	</script>
+</body>
+</html>
+`
+
+// getSwaggerUIHandler handles the /docs endpoint
+func getSwaggerUIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(swaggerUIPage))
+	}
+}
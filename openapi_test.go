@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOpenAPISchema(t *testing.T) {
+	type reading struct {
+		Percentage int       `json:"percentage"`
+		Note       *string   `json:"note,omitempty"`
+		Internal   string    `json:"-"`
+		At         time.Time `json:"at"`
+	}
+	tests := []struct {
+		name    string
+		value   any
+		want    string
+		schemas string
+	}{
+		{name: "integer", value: 0, want: `{"type":"integer"}`},
+		{name: "number", value: 0.5, want: `{"type":"number"}`},
+		{name: "time", value: time.Time{}, want: `{"format":"date-time","type":"string"}`},
+		{name: "nullable integer", value: new(int), want: `{"nullable":true,"type":"integer"}`},
+		{name: "array", value: []string{}, want: `{"items":{"type":"string"},"type":"array"}`},
+		{name: "map", value: map[string]float64{}, want: `{"additionalProperties":{"type":"number"},"type":"object"}`},
+		{name: "raw JSON", value: json.RawMessage{}, want: `{"type":"object"}`},
+		{
+			name:    "struct",
+			value:   reading{},
+			want:    `{"$ref":"#/components/schemas/reading"}`,
+			schemas: `{"reading":{"properties":{"at":{"format":"date-time","type":"string"},"note":{"nullable":true,"type":"string"},"percentage":{"type":"integer"}},"required":["percentage","at"],"type":"object"}}`,
+		},
+		{name: "nullable struct", value: &reading{}, want: `{"allOf":[{"$ref":"#/components/schemas/reading"}],"nullable":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &openAPIBuilder{schemas: map[string]any{}}
+			got, err := json.Marshal(b.schema(reflect.TypeOf(tt.value)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if tt.schemas == "" {
+				return
+			}
+			schemas, err := json.Marshal(b.schemas)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(schemas) != tt.schemas {
+				t.Errorf("got components %s, want %s", schemas, tt.schemas)
+			}
+		})
+	}
+}
+
+func TestOpenAPIOperation(t *testing.T) {
+	tests := []struct {
+		name      string
+		route     apiRoute
+		responses []string
+		security  bool
+	}{
+		{name: "public", route: apiRoute{Method: "GET", Path: "/status"}, responses: []string{"200", "400", "413", "429", "500", "504"}},
+		{name: "long running", route: apiRoute{Method: "GET", Path: "/events", LongRunning: true}, responses: []string{"200", "400", "429", "500"}},
+		{name: "conditional", route: apiRoute{Method: "GET", Path: "/pool-data", Conditional: true}, responses: []string{"200", "304", "400", "413", "429", "500", "504"}},
+		{
+			name:      "scoped",
+			route:     apiRoute{Method: "POST", Path: "/pool-data", Scope: scopeIngest},
+			responses: []string{"200", "400", "401", "403", "413", "429", "500", "504"},
+			security:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation := (&openAPIBuilder{schemas: map[string]any{}}).operation(tt.route)
+			var responses []string
+			for status := range operation["responses"].(map[string]any) {
+				responses = append(responses, status)
+			}
+			slices.Sort(responses)
+			if !slices.Equal(responses, tt.responses) {
+				t.Errorf("got responses %v, want %v", responses, tt.responses)
+			}
+			if _, ok := operation["security"]; ok != tt.security {
+				t.Errorf("got security %v, want %v", ok, tt.security)
+			}
+		})
+	}
+}
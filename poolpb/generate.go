@@ -0,0 +1,4 @@
+// Package poolpb contains the generated protobuf and gRPC code for proto/pool.proto
+package poolpb
+
+//go:generate protoc -I ../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pool.proto
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+)
+
+// servePprof serves the profiling endpoints of net/http/pprof under /debug/pprof/ on PPROF_ADDR,
+// e.g. localhost:6060, when it is set. The listener is separate from the API so profiles are
+// never exposed publicly; bind it to localhost or a port the orchestrator does not route to.
+func servePprof() {
+	addr := os.Getenv("PPROF_ADDR")
+	if addr == "" {
+		return
+	}
+	go func() {
+		slog.Info("Starting pprof server", "addr", addr)
+		if err := http.ListenAndServe(addr, pprofHandler()); err != nil {
+			slog.Error("The pprof server stopped", "error", err)
+		}
+	}()
+}
+
+// pprofHandler routes the profiling endpoints
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
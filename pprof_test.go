@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPprofHandler(t *testing.T) {
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		// body is a part of the response that shows it is the profile asked for
+		body string
+	}{
+		{path: "/debug/pprof/", status: http.StatusOK, contentType: "text/html", body: "<a href='heap?debug=1'>heap</a>"},
+		// Profiles are gzipped protocol buffers
+		{path: "/debug/pprof/heap", status: http.StatusOK, contentType: "application/octet-stream", body: "\x1f\x8b"},
+		{path: "/debug/pprof/goroutine?debug=1", status: http.StatusOK, contentType: "text/plain", body: "TestPprofHandler"},
+		{path: "/debug/pprof/cmdline", status: http.StatusOK, contentType: "text/plain", body: os.Args[0]},
+		{path: "/debug/pprof/symbol", status: http.StatusOK, contentType: "text/plain", body: "num_symbols: 1"},
+		{path: "/pool-data", status: http.StatusNotFound, contentType: "text/plain", body: "404 page not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			pprofHandler().ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.contentType) {
+				t.Errorf("got Content-Type %q, want %s", got, tt.contentType)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("got body %.200q, want it to contain %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
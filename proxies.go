@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// proxyTrust decides whether the client address in X-Forwarded-For can be believed, for
+// deployments behind reverse proxies
+type proxyTrust struct {
+	// all believes the first address of every X-Forwarded-For header
+	all bool
+	// proxies lists the networks of the proxies whose X-Forwarded-For entries are believed
+	proxies []netip.Prefix
+}
+
+// getProxyTrust reads TRUST_PROXY_HEADERS, which believes X-Forwarded-For from anyone, and
+// TRUSTED_PROXIES, the comma-separated networks of the reverse proxies in front of the API
+func getProxyTrust() (proxyTrust, error) {
+	proxies, err := parseCIDRs("TRUSTED_PROXIES")
+	if err != nil {
+		return proxyTrust{}, err
+	}
+	return proxyTrust{all: os.Getenv("TRUST_PROXY_HEADERS") == "true", proxies: proxies}, nil
+}
+
+// trusted reports whether addr belongs to a trusted proxy
+func (t proxyTrust) trusted(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range t.proxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address a request came from. With TRUSTED_PROXIES, X-Forwarded-For is
+// read from the right, where each trusted proxy appended the address it was called from, and
+// the first untrusted address is the client; clients can put anything to the left of it.
+func (t proxyTrust) clientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		return remote
+	}
+	if len(t.proxies) > 0 && t.trusted(remote) {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if !t.trusted(hop) || i == 0 {
+				return hop
+			}
+		}
+	}
+	if t.all {
+		first, _, _ := strings.Cut(forwarded[0], ",")
+		return strings.TrimSpace(first)
+	}
+	return remote
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestProxyTrustClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name      string
+		trust     proxyTrust
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{name: "direct", remote: "192.0.2.1:4711", want: "192.0.2.1"},
+		{name: "forwarded for an untrusted caller", remote: "192.0.2.1:4711", forwarded: []string{"203.0.113.7"}, want: "192.0.2.1"},
+		{name: "trust everyone", trust: proxyTrust{all: true}, remote: "192.0.2.1:4711", forwarded: []string{"203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "trusted proxy", trust: proxyTrust{proxies: proxies}, remote: "10.0.0.1:4711", forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "chain of trusted proxies", trust: proxyTrust{proxies: proxies}, remote: "10.0.0.1:4711", forwarded: []string{"203.0.113.7, 10.0.0.3", "10.0.0.2"}, want: "203.0.113.7"},
+		{name: "spoofed entry on the left", trust: proxyTrust{proxies: proxies}, remote: "10.0.0.1:4711", forwarded: []string{"198.51.100.9, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "only proxies", trust: proxyTrust{proxies: proxies}, remote: "10.0.0.1:4711", forwarded: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "untrusted remote", trust: proxyTrust{proxies: proxies}, remote: "192.0.2.1:4711", forwarded: []string{"203.0.113.7"}, want: "192.0.2.1"},
+		{name: "mapped IPv4 proxy", trust: proxyTrust{proxies: proxies}, remote: "[::ffff:10.0.0.1]:4711", forwarded: []string{"203.0.113.7"}, want: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pool-data", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := tt.trust.clientIP(r); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// querier runs queries on a pool or inside a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// database both runs statements and queries, on a pool or inside a transaction
+type database interface {
+	execer
+	querier
+}
+
+// queryFilter collects SQL WHERE conditions together with their positional arguments
+type queryFilter struct {
+	conditions []string
+	args       []any
+}
+
+// newQueryFilter returns a filter that leaves out readings flagged as suspect spikes
+func newQueryFilter() *queryFilter {
+	return &queryFilter{conditions: []string{"NOT suspect"}}
+}
+
+// add appends a condition; the condition must contain a single %d verb for the placeholder index
+func (f *queryFilter) add(condition string, arg any) {
+	f.args = append(f.args, arg)
+	f.conditions = append(f.conditions, fmt.Sprintf(condition, len(f.args)))
+}
+
+// bind appends an argument that is not part of a condition and returns its placeholder
+func (f *queryFilter) bind(arg any) string {
+	f.args = append(f.args, arg)
+	return fmt.Sprintf("$%d", len(f.args))
+}
+
+// where renders the collected conditions as a WHERE clause, or an empty string if there are none
+func (f *queryFilter) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// parseLocation reads the optional IANA 'tz' parameter, e.g. tz=Europe/Berlin;
+// nil means timestamps and buckets follow the database session time zone
+func parseLocation(r *http.Request) (*time.Location, error) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'tz' parameter: unknown time zone '%s'", name)
+	}
+	return loc, nil
+}
+
+// localTimestamp returns an SQL expression for the wall-clock time of the reading in loc
+func (f *queryFilter) localTimestamp(loc *time.Location) string {
+	if loc == nil {
+		return "timestamp"
+	}
+	return "(timestamp AT TIME ZONE " + f.bind(loc.String()) + "::text)"
+}
+
+// truncTimestamp returns an SQL expression truncating the timestamp to the given unit
+// on the calendar of loc, as an absolute point in time
+func (f *queryFilter) truncTimestamp(unit string, loc *time.Location) string {
+	if loc == nil {
+		return fmt.Sprintf("date_trunc('%s', timestamp)", unit)
+	}
+	tz := f.bind(loc.String()) + "::text"
+	return fmt.Sprintf("(date_trunc('%s', timestamp AT TIME ZONE %s) AT TIME ZONE %s)", unit, tz, tz)
+}
+
+// inLocation converts t to loc, leaving it untouched when loc is nil
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		return t
+	}
+	return t.In(loc)
+}
+
+// parseTimeParam parses an optional RFC3339 query parameter
+func parseTimeParam(r *http.Request, name string) (*time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid '%s' parameter: expected an RFC3339 timestamp", name)
+	}
+	return &t, nil
+}
+
+// parseTimeRange reads the optional 'from' (inclusive) and 'to' (exclusive) bounds; instead of 'from',
+// a client may pass a relative 'last' span such as 24h or 7d, counted back from 'to' or from now
+func parseTimeRange(r *http.Request) (from, to *time.Time, err error) {
+	from, err = parseTimeParam(r, "from")
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err = parseTimeParam(r, "to")
+	if err != nil {
+		return nil, nil, err
+	}
+	if last := r.URL.Query().Get("last"); last != "" {
+		if from != nil {
+			return nil, nil, fmt.Errorf("'from' and 'last' are mutually exclusive")
+		}
+		span, err := parseSpan(last)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid 'last' parameter: expected a duration such as 24h or 7d")
+		}
+		end := time.Now()
+		if to != nil {
+			end = *to
+		}
+		start := end.Add(-span)
+		from = &start
+	}
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, nil, fmt.Errorf("'from' must be before 'to'")
+	}
+	return from, to, nil
+}
+
+// parseRangeFilter builds a filter from the requested time range
+func parseRangeFilter(r *http.Request) (*queryFilter, error) {
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := newQueryFilter()
+	if r.URL.Query().Get("include_suspect") == "true" {
+		filter = &queryFilter{}
+	}
+	if from != nil {
+		filter.add("timestamp >= $%d", *from)
+	}
+	if to != nil {
+		filter.add("timestamp < $%d", *to)
+	}
+	return filter, nil
+}
+
+// addPercentageFilter applies the optional inclusive 'min_percentage' and 'max_percentage' thresholds
+func addPercentageFilter(r *http.Request, filter *queryFilter) error {
+	q := r.URL.Query()
+	bounds := make(map[string]int, 2)
+	for _, name := range []string{"min_percentage", "max_percentage"} {
+		if !q.Has(name) {
+			continue
+		}
+		n, err := strconv.Atoi(q.Get(name))
+		if err != nil || n < 0 || n > 100 {
+			return fmt.Errorf("invalid '%s' parameter: expected an integer between 0 and 100", name)
+		}
+		bounds[name] = n
+	}
+	lower, hasLower := bounds["min_percentage"]
+	upper, hasUpper := bounds["max_percentage"]
+	if hasLower && hasUpper && lower > upper {
+		return fmt.Errorf("'min_percentage' must not be greater than 'max_percentage'")
+	}
+	if hasLower {
+		filter.add("percentage >= $%d", lower)
+	}
+	if hasUpper {
+		filter.add("percentage <= $%d", upper)
+	}
+	return nil
+}
+
+const (
+	// defaultPageSize is used when a client does not ask for a specific page size
+	defaultPageSize = 1000
+	// maxPageSize bounds the number of rows a single request may return
+	maxPageSize = 10000
+)
+
+// pagination holds the limit/offset window requested by a client
+type pagination struct {
+	Limit  int
+	Offset int
+}
+
+// parseIntParam parses an optional non-negative integer query parameter, returning def if it is absent
+func parseIntParam(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid '%s' parameter: expected a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// parsePagination reads the 'limit' and 'offset' parameters
+func parsePagination(r *http.Request) (pagination, error) {
+	limit, err := parseIntParam(r, "limit", defaultPageSize)
+	if err != nil {
+		return pagination{}, err
+	}
+	if limit == 0 || limit > maxPageSize {
+		return pagination{}, fmt.Errorf("invalid 'limit' parameter: must be between 1 and %d", maxPageSize)
+	}
+	offset, err := parseIntParam(r, "offset", 0)
+	if err != nil {
+		return pagination{}, err
+	}
+	return pagination{Limit: limit, Offset: offset}, nil
+}
+
+// setPaginationHeaders exposes the total row count and next/prev links for the current page
+func setPaginationHeaders(w http.ResponseWriter, r *http.Request, page pagination, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Set("X-Limit", strconv.Itoa(page.Limit))
+	w.Header().Set("X-Offset", strconv.Itoa(page.Offset))
+
+	var links []string
+	if page.Offset+page.Limit < total {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, page.Offset+page.Limit)))
+	}
+	if page.Offset > 0 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(r, max(page.Offset-page.Limit, 0))))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// pageURL returns the request URL with its offset replaced
+func pageURL(r *http.Request, offset int) string {
+	u := *r.URL
+	q := u.Query()
+	q.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// parseDurationParam parses an optional Go-style duration parameter such as 5m or 1h
+func parseDurationParam(r *http.Request, name string) (time.Duration, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid '%s' parameter: expected a duration of at least 1s, such as 5m or 1h", name)
+	}
+	return d, nil
+}
+
+// pointsQuery describes the series of data points selected by a /pool-data request
+type pointsQuery struct {
+	filter *queryFilter
+	// resolution, when non-zero, averages the readings into buckets of this width
+	resolution time.Duration
+	// ascending returns the oldest points first; by default the newest points come first
+	ascending bool
+	// fields is the subset of columns returned to the client
+	fields fieldSet
+	// smooth, when non-zero, replaces each percentage with the average over this trailing window
+	smooth time.Duration
+	// loc is the time zone the timestamps are returned in, if any
+	loc *time.Location
+}
+
+// parsePointsQuery reads the filtering and downsampling parameters of a /pool-data request
+func parsePointsQuery(r *http.Request) (*pointsQuery, error) {
+	filter, err := parseRangeFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	// Thresholds apply to the raw readings, before any downsampling or smoothing
+	if err := addPercentageFilter(r, filter); err != nil {
+		return nil, err
+	}
+	resolution, err := parseDurationParam(r, "resolution")
+	if err != nil {
+		return nil, err
+	}
+	var ascending bool
+	switch r.URL.Query().Get("order") {
+	case "asc":
+		ascending = true
+	case "", "desc":
+	default:
+		return nil, fmt.Errorf("invalid 'order' parameter: expected asc or desc")
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		return nil, err
+	}
+	smooth, err := parseDurationParam(r, "smooth")
+	if err != nil {
+		return nil, err
+	}
+	loc, err := parseLocation(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pointsQuery{filter: filter, resolution: resolution, ascending: ascending, fields: fields, smooth: smooth, loc: loc}, nil
+}
+
+// orderBy returns the ORDER BY clause for the series; combined with a LIMIT it lets
+// Postgres walk the timestamp index from either end instead of sorting the whole table
+func (q *pointsQuery) orderBy() string {
+	if q.ascending {
+		return " ORDER BY timestamp, id"
+	}
+	return " ORDER BY timestamp DESC, id DESC"
+}
+
+// source returns a subquery yielding (id, timestamp, percentage) rows for the series;
+// downsampled buckets are labelled with their start time and the id of their first reading.
+// Smoothing is applied after downsampling and only sees readings inside the requested range.
+// It binds its arguments into the filter, so it must only be called once per query.
+func (q *pointsQuery) source() string {
+	series := "SELECT id, timestamp, percentage FROM pool_usage" + q.filter.where()
+	if q.resolution != 0 {
+		width := q.filter.bind(q.resolution.Seconds()) + "::float8"
+		series = fmt.Sprintf(`SELECT min(id) AS id, to_timestamp(floor(extract(epoch FROM timestamp) / %[1]s) * %[1]s) AS timestamp,
+			round(avg(percentage))::int AS percentage FROM pool_usage%[2]s GROUP BY 2`, width, q.filter.where())
+	}
+	if q.smooth != 0 {
+		window := q.filter.bind(q.smooth.Seconds())
+		series = fmt.Sprintf(`SELECT id, timestamp, round(avg(percentage) OVER (ORDER BY timestamp
+			RANGE BETWEEN make_interval(secs => %s::float8) PRECEDING AND CURRENT ROW))::int AS percentage
+			FROM (%s) AS series`, window, series)
+	}
+	return "(" + series + ") AS points"
+}
+
+// parseSpan parses a duration that may also use day (d) and week (w) units, such as 7d or 2w
+func parseSpan(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count <= 0 {
+				return 0, fmt.Errorf("invalid span '%s'", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid span '%s'", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRangeFilter(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query string
+		where string
+		args  []any
+		err   string
+	}{
+		{name: "no range", query: "", where: " WHERE NOT suspect"},
+		{name: "from", query: "from=2024-05-01T00:00:00Z", where: " WHERE NOT suspect AND timestamp >= $1", args: []any{from}},
+		{name: "to", query: "to=2024-05-02T00:00:00Z", where: " WHERE NOT suspect AND timestamp < $1", args: []any{to}},
+		{
+			name:  "from and to",
+			query: "from=2024-05-01T00:00:00Z&to=2024-05-02T00:00:00Z",
+			where: " WHERE NOT suspect AND timestamp >= $1 AND timestamp < $2",
+			args:  []any{from, to},
+		},
+		{name: "suspect readings included", query: "include_suspect=true&to=2024-05-02T00:00:00Z", where: " WHERE timestamp < $1", args: []any{to}},
+		{name: "invalid from", query: "from=yesterday", err: "invalid 'from' parameter: expected an RFC3339 timestamp"},
+		{name: "invalid to", query: "to=2024-05-02", err: "invalid 'to' parameter: expected an RFC3339 timestamp"},
+		{name: "empty range", query: "from=2024-05-01T00:00:00Z&to=2024-05-01T00:00:00Z", err: "'from' must be before 'to'"},
+		{name: "reversed range", query: "from=2024-05-02T00:00:00Z&to=2024-05-01T00:00:00Z", err: "'from' must be before 'to'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := parseRangeFilter(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := filter.where(); got != tt.where {
+				t.Errorf("got where %q, want %q", got, tt.where)
+			}
+			if !reflect.DeepEqual(filter.args, tt.args) {
+				t.Errorf("got args %v, want %v", filter.args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  pagination
+		err   string
+	}{
+		{name: "defaults", query: "", want: pagination{Limit: defaultPageSize}},
+		{name: "limit and offset", query: "limit=50&offset=100", want: pagination{Limit: 50, Offset: 100}},
+		{name: "largest page", query: "limit=10000", want: pagination{Limit: maxPageSize}},
+		{name: "zero limit", query: "limit=0", err: "invalid 'limit' parameter: must be between 1 and 10000"},
+		{name: "limit too large", query: "limit=10001", err: "invalid 'limit' parameter: must be between 1 and 10000"},
+		{name: "negative limit", query: "limit=-1", err: "invalid 'limit' parameter: expected a non-negative integer"},
+		{name: "negative offset", query: "offset=-5", err: "invalid 'offset' parameter: expected a non-negative integer"},
+		{name: "non-numeric offset", query: "offset=ten", err: "invalid 'offset' parameter: expected a non-negative integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePagination(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetPaginationHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		page  pagination
+		total int
+		link  string
+	}{
+		{name: "single page", query: "limit=10", page: pagination{Limit: 10}, total: 5, link: ""},
+		{name: "first page", query: "limit=10", page: pagination{Limit: 10}, total: 25, link: `</pool-data?limit=10&offset=10>; rel="next"`},
+		{
+			name:  "middle page",
+			query: "limit=10&offset=10",
+			page:  pagination{Limit: 10, Offset: 10},
+			total: 25,
+			link:  `</pool-data?limit=10&offset=20>; rel="next", </pool-data?limit=10&offset=0>; rel="prev"`,
+		},
+		{name: "last page", query: "limit=10&offset=20", page: pagination{Limit: 10, Offset: 20}, total: 25, link: `</pool-data?limit=10&offset=10>; rel="prev"`},
+		{name: "offset inside the first page", query: "limit=10&offset=4", page: pagination{Limit: 10, Offset: 4}, total: 10, link: `</pool-data?limit=10&offset=0>; rel="prev"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setPaginationHeaders(w, httptest.NewRequest("GET", "/pool-data?"+tt.query, nil), tt.page, tt.total)
+			if got := w.Header().Get("Link"); got != tt.link {
+				t.Errorf("got Link %q, want %q", got, tt.link)
+			}
+			if got, want := w.Header().Get("X-Total-Count"), strconv.Itoa(tt.total); got != want {
+				t.Errorf("got X-Total-Count %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseDurationParam(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  time.Duration
+		err   string
+	}{
+		{name: "absent", query: "", want: 0},
+		{name: "minutes", query: "resolution=5m", want: 5 * time.Minute},
+		{name: "compound", query: "resolution=1h30m", want: 90 * time.Minute},
+		{name: "one second", query: "resolution=1s", want: time.Second},
+		{name: "below a second", query: "resolution=500ms", err: "invalid 'resolution' parameter: expected a duration of at least 1s, such as 5m or 1h"},
+		{name: "negative", query: "resolution=-5m", err: "invalid 'resolution' parameter: expected a duration of at least 1s, such as 5m or 1h"},
+		{name: "no unit", query: "resolution=300", err: "invalid 'resolution' parameter: expected a duration of at least 1s, such as 5m or 1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDurationParam(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil), "resolution")
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointsQuerySource(t *testing.T) {
+	tests := []struct {
+		name       string
+		resolution time.Duration
+		smooth     time.Duration
+		contains   string
+		args       []any
+	}{
+		{name: "raw readings", contains: "(SELECT id, timestamp, percentage FROM pool_usage WHERE NOT suspect) AS points"},
+		{name: "downsampled", resolution: 5 * time.Minute, contains: "floor(extract(epoch FROM timestamp) / $1::float8) * $1::float8", args: []any{300.0}},
+		{name: "smoothed", smooth: time.Hour, contains: "RANGE BETWEEN make_interval(secs => $1::float8) PRECEDING AND CURRENT ROW", args: []any{3600.0}},
+		{
+			name:       "downsampled, then smoothed",
+			resolution: 5 * time.Minute,
+			smooth:     time.Hour,
+			contains:   "make_interval(secs => $2::float8) PRECEDING AND CURRENT ROW))::int AS percentage\n\t\t\tFROM (SELECT min(id) AS id",
+			args:       []any{300.0, 3600.0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &pointsQuery{filter: newQueryFilter(), resolution: tt.resolution, smooth: tt.smooth}
+			if got := q.source(); !strings.Contains(got, tt.contains) {
+				t.Errorf("got %q, want it to contain %q", got, tt.contains)
+			}
+			if !reflect.DeepEqual(q.filter.args, tt.args) {
+				t.Errorf("got args %v, want %v", q.filter.args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParsePointsQueryOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		orderBy string
+		err     string
+	}{
+		{name: "newest first by default", query: "", orderBy: " ORDER BY timestamp DESC, id DESC"},
+		{name: "descending", query: "order=desc", orderBy: " ORDER BY timestamp DESC, id DESC"},
+		{name: "ascending", query: "order=asc", orderBy: " ORDER BY timestamp, id"},
+		{name: "unknown", query: "order=random", err: "invalid 'order' parameter: expected asc or desc"},
+		{name: "upper case", query: "order=ASC", err: "invalid 'order' parameter: expected asc or desc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parsePointsQuery(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := q.orderBy(); got != tt.orderBy {
+				t.Errorf("got %q, want %q", got, tt.orderBy)
+			}
+		})
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		err   string
+	}{
+		{name: "session time zone", query: ""},
+		{name: "IANA name", query: "tz=Europe/Berlin", want: "Europe/Berlin"},
+		{name: "UTC", query: "tz=UTC", want: "UTC"},
+		{name: "unknown", query: "tz=Europe/Atlantis", err: "invalid 'tz' parameter: unknown time zone 'Europe/Atlantis'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := parseLocation(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.want == "" {
+				if loc != nil {
+					t.Errorf("got %v, want nil", loc)
+				}
+				return
+			}
+			if loc == nil || loc.String() != tt.want {
+				t.Errorf("got %v, want %s", loc, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncTimestamp(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		unit string
+		loc  *time.Location
+		want string
+		args []any
+	}{
+		{name: "session time zone", unit: "day", want: "date_trunc('day', timestamp)"},
+		{
+			name: "local calendar",
+			unit: "week",
+			loc:  berlin,
+			want: "(date_trunc('week', timestamp AT TIME ZONE $1::text) AT TIME ZONE $1::text)",
+			args: []any{"Europe/Berlin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := &queryFilter{}
+			if got := filter.truncTimestamp(tt.unit, tt.loc); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(filter.args, tt.args) {
+				t.Errorf("got args %v, want %v", filter.args, tt.args)
+			}
+		})
+	}
+}
+
+func TestAddPercentageFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		where string
+		args  []any
+		err   string
+	}{
+		{name: "no thresholds", query: "", where: " WHERE NOT suspect"},
+		{name: "minimum", query: "min_percentage=50", where: " WHERE NOT suspect AND percentage >= $1", args: []any{50}},
+		{name: "maximum", query: "max_percentage=20", where: " WHERE NOT suspect AND percentage <= $1", args: []any{20}},
+		{name: "both", query: "min_percentage=20&max_percentage=80", where: " WHERE NOT suspect AND percentage >= $1 AND percentage <= $2", args: []any{20, 80}},
+		{name: "equal", query: "min_percentage=40&max_percentage=40", where: " WHERE NOT suspect AND percentage >= $1 AND percentage <= $2", args: []any{40, 40}},
+		{name: "reversed", query: "min_percentage=80&max_percentage=20", err: "'min_percentage' must not be greater than 'max_percentage'"},
+		{name: "above 100", query: "max_percentage=101", err: "invalid 'max_percentage' parameter: expected an integer between 0 and 100"},
+		{name: "negative", query: "min_percentage=-1", err: "invalid 'min_percentage' parameter: expected an integer between 0 and 100"},
+		{name: "empty", query: "min_percentage=", err: "invalid 'min_percentage' parameter: expected an integer between 0 and 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := newQueryFilter()
+			err := addPercentageFilter(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil), filter)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := filter.where(); got != tt.where {
+				t.Errorf("got where %q, want %q", got, tt.where)
+			}
+			if !reflect.DeepEqual(filter.args, tt.args) {
+				t.Errorf("got args %v, want %v", filter.args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseSpan(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "24h", want: 24 * time.Hour},
+		{value: "90m", want: 90 * time.Minute},
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "0d", err: true},
+		{value: "-1d", err: true},
+		{value: "1.5d", err: true},
+		{value: "0s", err: true},
+		{value: "d", err: true},
+		{value: "week", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseSpan(tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeRangeLast(t *testing.T) {
+	to := time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		query string
+		from  time.Time
+		err   string
+	}{
+		{name: "counted back from to", query: "last=7d&to=2024-05-08T00:00:00Z", from: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "hours", query: "last=36h&to=2024-05-08T00:00:00Z", from: time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)},
+		{name: "with from", query: "last=7d&from=2024-05-01T00:00:00Z", err: "'from' and 'last' are mutually exclusive"},
+		{name: "invalid", query: "last=forever", err: "invalid 'last' parameter: expected a duration such as 24h or 7d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, got, err := parseTimeRange(httptest.NewRequest("GET", "/pool-data?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !from.Equal(tt.from) || !got.Equal(to) {
+				t.Errorf("got [%v, %v), want [%v, %v)", from, got, tt.from, to)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter limits requests per client IP and, once authenticated with an API key, per key as
+// well. Each client gets a token bucket that holds a minute's worth of requests and refills
+// continuously.
+type rateLimiter struct {
+	perIP   int
+	perKey  int
+	proxies proxyTrust
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+// maxRateBuckets bounds the clients tracked at once; beyond it, new clients share the
+// overflowClient bucket until idle ones are evicted, so the map cannot grow without limit
+const maxRateBuckets = 100000
+
+// overflowClient names the bucket shared by the clients beyond maxRateBuckets
+const overflowClient = "overflow"
+
+// rateBucket is the limiter of one client with the time it was last used
+type rateBucket struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// getRateLimiter reads RATE_LIMIT_IP and RATE_LIMIT_KEY, the requests per minute allowed per IP
+// (default 600) and per API key (default 6000); 0 disables a limit. It returns nil if both are off.
+func getRateLimiter() (*rateLimiter, error) {
+	perIP, err := getRateLimit("RATE_LIMIT_IP", 600)
+	if err != nil {
+		return nil, err
+	}
+	perKey, err := getRateLimit("RATE_LIMIT_KEY", 6000)
+	if err != nil {
+		return nil, err
+	}
+	if perIP == 0 && perKey == 0 {
+		return nil, nil
+	}
+	proxies, err := getProxyTrust()
+	if err != nil {
+		return nil, err
+	}
+	l := &rateLimiter{
+		perIP:   perIP,
+		perKey:  perKey,
+		proxies: proxies,
+		buckets: map[string]*rateBucket{},
+	}
+	go l.evictIdle()
+	return l, nil
+}
+
+// getRateLimit reads one requests-per-minute limit from the environment
+func getRateLimit(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected requests per minute", name, value)
+	}
+	return n, nil
+}
+
+// evictIdle drops buckets unused for a minute; by then they have refilled, so nothing is lost
+func (l *rateLimiter) evictIdle() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for client, b := range l.buckets {
+			if time.Since(b.seen) > time.Minute {
+				delete(l.buckets, client)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// bucket returns the bucket of client, creating it with a limit of perMinute
+func (l *rateLimiter) bucket(client string, perMinute int, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok && len(l.buckets) >= maxRateBuckets {
+		client = overflowClient
+		b, ok = l.buckets[client]
+	}
+	if !ok {
+		b = &rateBucket{limiter: rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)}
+		l.buckets[client] = b
+	}
+	b.seen = now
+	return b.limiter
+}
+
+// take counts a request against the bucket of client. If the bucket is empty, the request is not
+// counted and the delay until it would be admitted is returned.
+func (l *rateLimiter) take(client string, perMinute int, now time.Time) (*rate.Limiter, time.Duration) {
+	limiter := l.bucket(client, perMinute, now)
+	reservation := limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	return limiter, delay
+}
+
+// limit wraps next with the per-IP limit. It applies before authentication, so failed attempts
+// and made-up API keys count against the client's IP.
+func (l *rateLimiter) limit(next http.Handler) http.Handler {
+	return l.limitBy(func(r *http.Request) (string, int) {
+		return "ip:" + l.proxies.clientIP(r), l.perIP
+	}, next)
+}
+
+// limitKey wraps next with the per-key limit of requests authenticated with an API key. It
+// applies after authentication, so only keys that exist get a bucket.
+func (l *rateLimiter) limitKey(next http.Handler) http.Handler {
+	return l.limitBy(func(r *http.Request) (string, int) {
+		id, ok := apiKeyIDFrom(r.Context())
+		if !ok {
+			return "", 0
+		}
+		return "key:" + strconv.Itoa(id), l.perKey
+	}, next)
+}
+
+// limitBy wraps next so requests count against the bucket pick returns for them, with its limit;
+// a limit of 0 lets them through
+func (l *rateLimiter) limitBy(pick func(r *http.Request) (string, int), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, perMinute := pick(r)
+		if perMinute == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		limiter, delay := l.take(client, perMinute, now)
+		if delay > 0 {
+			setRateLimitHeaders(w, limiter, now)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		setRateLimitHeaders(w, limiter, now)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setRateLimitHeaders reports the bucket size, the requests left and the seconds until the bucket is full again
+func setRateLimitHeaders(w http.ResponseWriter, limiter *rate.Limiter, now time.Time) {
+	tokens := max(limiter.TokensAt(now), 0)
+	burst := limiter.Burst()
+	reset := (float64(burst) - tokens) / float64(limiter.Limit())
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(burst))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(tokens)))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset))))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetRateLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		err   string
+	}{
+		{value: "", want: 600},
+		{value: "0", want: 0},
+		{value: "120", want: 120},
+		{value: "-1", err: `invalid RATE_LIMIT_IP "-1": expected requests per minute`},
+		{value: "10/s", err: `invalid RATE_LIMIT_IP "10/s": expected requests per minute`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("RATE_LIMIT_IP", tt.value)
+			got, err := getRateLimit("RATE_LIMIT_IP", 600)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterLimit(t *testing.T) {
+	l := &rateLimiter{perIP: 2, perKey: 3, buckets: map[string]*rateBucket{}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(handler http.Handler, ip string, key int) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/pool-data", nil)
+		r.RemoteAddr = ip + ":40000"
+		if key > 0 {
+			r = r.WithContext(withAPIKeyID(r.Context(), key))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	tests := []struct {
+		name      string
+		handler   http.Handler
+		ip        string
+		key       int
+		status    int
+		remaining string
+	}{
+		{name: "first request", handler: l.limit(ok), ip: "192.0.2.1", status: http.StatusOK, remaining: "1"},
+		{name: "second request", handler: l.limit(ok), ip: "192.0.2.1", status: http.StatusOK, remaining: "0"},
+		{name: "over the limit", handler: l.limit(ok), ip: "192.0.2.1", status: http.StatusTooManyRequests, remaining: "0"},
+		{name: "other IP", handler: l.limit(ok), ip: "192.0.2.2", status: http.StatusOK, remaining: "1"},
+		{name: "without an API key", handler: l.limitKey(ok), ip: "192.0.2.1", status: http.StatusOK},
+		{name: "API key", handler: l.limitKey(ok), ip: "192.0.2.1", key: 7, status: http.StatusOK, remaining: "2"},
+		{name: "same API key", handler: l.limitKey(ok), ip: "192.0.2.2", key: 7, status: http.StatusOK, remaining: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(tt.handler, tt.ip, tt.key)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("X-RateLimit-Remaining"); got != tt.remaining {
+				t.Errorf("got X-RateLimit-Remaining %q, want %q", got, tt.remaining)
+			}
+			if tt.status == http.StatusTooManyRequests {
+				// Two requests per minute refill one every 30 seconds
+				if got := w.Header().Get("Retry-After"); got != "30" {
+					t.Errorf("got Retry-After %q, want 30", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterOverflow(t *testing.T) {
+	l := &rateLimiter{buckets: map[string]*rateBucket{}}
+	now := time.Now()
+	for i := range maxRateBuckets {
+		l.bucket("ip:"+strconv.Itoa(i), 60, now)
+	}
+	first := l.bucket("ip:192.0.2.1", 60, now)
+	if second := l.bucket("ip:192.0.2.2", 60, now); second != first {
+		t.Error("clients beyond the limit do not share a bucket")
+	}
+	if got := len(l.buckets); got != maxRateBuckets+1 {
+		t.Errorf("got %d buckets, want %d", got, maxRateBuckets+1)
+	}
+	if known := l.bucket("ip:5", 60, now); known == first {
+		t.Error("a tracked client was moved to the overflow bucket")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// defaultReplicaMaxLag is how far the replica may fall behind before reads go to the primary
+	defaultReplicaMaxLag = 10 * time.Second
+	// replicaCheckInterval is how often the replica's health and lag are checked
+	replicaCheckInterval = 5 * time.Second
+)
+
+// readPool routes read-only queries to the read replica of DATABASE_REPLICA_URL while it is up
+// and caught up, and to the primary otherwise. Responses read from the replica may lag the
+// primary by up to the maximum lag; writes and corrections always go to the primary.
+type readPool struct {
+	primary *pgxpool.Pool
+	replica *pgxpool.Pool
+	maxLag  time.Duration
+	healthy atomic.Bool
+}
+
+// getReadPool connects to DATABASE_REPLICA_URL, if set, reading the maximum lag from
+// REPLICA_MAX_LAG. Without a replica every read goes to the primary.
+func getReadPool(primary *pgxpool.Pool) (*readPool, error) {
+	maxLag := defaultReplicaMaxLag
+	if value := os.Getenv("REPLICA_MAX_LAG"); value != "" {
+		var err error
+		maxLag, err = time.ParseDuration(value)
+		if err != nil || maxLag <= 0 {
+			return nil, fmt.Errorf("invalid REPLICA_MAX_LAG %q: expected a positive duration", value)
+		}
+	}
+	replica, err := openDatabasePool("DATABASE_REPLICA_URL")
+	if err != nil {
+		return nil, err
+	}
+	return &readPool{primary: primary, replica: replica, maxLag: maxLag}, nil
+}
+
+// pool returns the pool to read from
+func (p *readPool) pool() *pgxpool.Pool {
+	if p.replica != nil && p.healthy.Load() {
+		return p.replica
+	}
+	return p.primary
+}
+
+// replicaLag reports how far the replica is behind the primary. A replica that has replayed
+// everything it received is not behind, however long ago the last transaction was, and a
+// server that is not in recovery is never behind.
+func replicaLag(ctx context.Context, replica *pgxpool.Pool) (time.Duration, error) {
+	var seconds float64
+	err := replica.QueryRow(ctx, `SELECT CASE
+		WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE coalesce(extract(epoch FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`).Scan(&seconds)
+	return time.Duration(seconds * float64(time.Second)), err
+}
+
+// check marks the replica healthy when it answers and is within the maximum lag, logging when
+// reads move between the replica and the primary
+func (p *readPool) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, replicaCheckInterval)
+	defer cancel()
+	lag, err := replicaLag(ctx, p.replica)
+	healthy := err == nil && lag <= p.maxLag
+	if healthy == p.healthy.Swap(healthy) {
+		return
+	}
+	switch {
+	case healthy:
+		slog.Info("Reading from the replica")
+	case err != nil:
+		slog.Warn("Reading from the primary, the replica is unavailable", "error", err)
+	default:
+		slog.Warn("Reading from the primary, the replica is behind", "lag", lag.Round(time.Second))
+	}
+}
+
+// watch checks the replica until ctx is done
+func (p *readPool) watch(ctx context.Context) {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		p.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
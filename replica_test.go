@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestGetReadPool(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "", want: defaultReplicaMaxLag},
+		{value: "30s", want: 30 * time.Second},
+		{value: "0s", err: `invalid REPLICA_MAX_LAG "0s": expected a positive duration`},
+		{value: "soon", err: `invalid REPLICA_MAX_LAG "soon": expected a positive duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			resetSecrets(t)
+			t.Setenv("REPLICA_MAX_LAG", tt.value)
+			t.Setenv("DATABASE_REPLICA_URL", "")
+			t.Setenv("DATABASE_REPLICA_URL_FILE", "")
+			primary := &pgxpool.Pool{}
+			got, err := getReadPool(primary)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.maxLag != tt.want {
+				t.Errorf("got maximum lag %v, want %v", got.maxLag, tt.want)
+			}
+			if got.pool() != primary {
+				t.Error("reads without a replica do not go to the primary")
+			}
+		})
+	}
+}
+
+func TestReadPoolPool(t *testing.T) {
+	primary, replica := &pgxpool.Pool{}, &pgxpool.Pool{}
+	tests := []struct {
+		name    string
+		replica *pgxpool.Pool
+		healthy bool
+		want    *pgxpool.Pool
+	}{
+		{name: "no replica", want: primary},
+		{name: "healthy replica", replica: replica, healthy: true, want: replica},
+		{name: "unhealthy replica", replica: replica, want: primary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &readPool{primary: primary, replica: tt.replica}
+			p.healthy.Store(tt.healthy)
+			if p.pool() != tt.want {
+				t.Errorf("read from the wrong pool")
+			}
+		})
+	}
+}
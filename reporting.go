@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// reportFlushTimeout bounds how long exiting waits for reports still being sent
+const reportFlushTimeout = 2 * time.Second
+
+// errorReporter sends errors to an error tracking service. Every record logged at the error
+// level is reported, with the attributes of the record and its request, so failures that only
+// reached the logs before get noticed.
+type errorReporter interface {
+	report(ctx context.Context, err error, attrs []slog.Attr)
+	flush()
+}
+
+// getErrorReporter returns the reporter for SENTRY_DSN, tagged with SENTRY_ENVIRONMENT and the
+// build, or nil when it is unset
+func getErrorReporter(build BuildInfo) (errorReporter, error) {
+	dsn, err := secret("SENTRY_DSN")
+	if err != nil || dsn == "" {
+		return nil, err
+	}
+	err = sentry.Init(sentry.ClientOptions{
+		Dsn:              dsn,
+		Environment:      os.Getenv("SENTRY_ENVIRONMENT"),
+		Release:          build.String(),
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize Sentry: %v", err)
+	}
+	return sentryReporter{}, nil
+}
+
+// sentryReporter reports to Sentry
+type sentryReporter struct{}
+
+// sentryTags are the attributes that become searchable Sentry tags; all attributes are sent as context
+var sentryTags = map[string]bool{"request_id": true, "trace_id": true, "method": true, "path": true}
+
+func (sentryReporter) report(ctx context.Context, err error, attrs []slog.Attr) {
+	hub := sentry.CurrentHub().Clone()
+	hub.WithScope(func(scope *sentry.Scope) {
+		fields := map[string]any{}
+		for _, attr := range attrs {
+			fields[attr.Key] = attr.Value.String()
+			if sentryTags[attr.Key] {
+				scope.SetTag(attr.Key, attr.Value.String())
+			}
+		}
+		scope.SetContext("log", fields)
+		hub.CaptureException(err)
+	})
+}
+
+func (sentryReporter) flush() {
+	sentry.Flush(reportFlushTimeout)
+}
+
+// reportRecord reports an error-level record as an error made of its message and "error" attribute
+func reportRecord(ctx context.Context, reporter errorReporter, record slog.Record) {
+	var cause error
+	var attrs []slog.Attr
+	record.Attrs(func(attr slog.Attr) bool {
+		if err, ok := attr.Value.Any().(error); ok && attr.Key == "error" {
+			cause = err
+		} else {
+			attrs = append(attrs, attr)
+		}
+		return true
+	})
+	err := errors.New(record.Message)
+	if cause != nil {
+		err = fmt.Errorf("%s: %w", record.Message, cause)
+	}
+	reporter.report(ctx, err, attrs)
+}
+
+// recoverPanics turns a panicking handler into a 500 response and logs the panic, which
+// reports it. Aborted responses, which panic with http.ErrAbortHandler on purpose, are left to
+// net/http.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			slog.ErrorContext(r.Context(), "Panic serving the request", "error", fmt.Errorf("%v", p), "stack", string(debug.Stack()))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
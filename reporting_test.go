@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingReporter keeps the errors it is sent
+type recordingReporter struct {
+	errors []string
+	attrs  [][]slog.Attr
+}
+
+func (r *recordingReporter) report(ctx context.Context, err error, attrs []slog.Attr) {
+	r.errors = append(r.errors, err.Error())
+	r.attrs = append(r.attrs, attrs)
+}
+
+func (r *recordingReporter) flush() {}
+
+// reportTo makes the default logger report to reporter for the rest of the test
+func reportTo(t *testing.T, reporter errorReporter) {
+	t.Helper()
+	previous := slog.Default()
+	slog.SetDefault(slog.New(contextHandler{slog.NewTextHandler(io.Discard, nil), reporter}))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+}
+
+func TestReportRecord(t *testing.T) {
+	tests := []struct {
+		name  string
+		log   func(ctx context.Context)
+		want  []string
+		attrs []string
+	}{
+		{name: "warning", log: func(ctx context.Context) { slog.WarnContext(ctx, "Slow query") }},
+		{name: "error", log: func(ctx context.Context) { slog.ErrorContext(ctx, "Error running query") }, want: []string{"Error running query"}, attrs: []string{"request_id"}},
+		{name: "error with cause", log: func(ctx context.Context) {
+			slog.ErrorContext(ctx, "Error running query", "error", errors.New("conn closed"), "rows", 3)
+		}, want: []string{"Error running query: conn closed"}, attrs: []string{"rows", "request_id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := &recordingReporter{}
+			reportTo(t, reporter)
+			tt.log(withLogAttrs(context.Background(), slog.String("request_id", "abc")))
+			if strings.Join(reporter.errors, "\n") != strings.Join(tt.want, "\n") {
+				t.Fatalf("got reports %q, want %q", reporter.errors, tt.want)
+			}
+			if len(tt.want) == 0 {
+				return
+			}
+			var keys []string
+			for _, attr := range reporter.attrs[0] {
+				keys = append(keys, attr.Key)
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.attrs, ",") {
+				t.Errorf("got attributes %v, want %v", keys, tt.attrs)
+			}
+		})
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		status  int
+		reports int
+		panics  bool
+	}{
+		{name: "no panic", status: http.StatusOK},
+		{name: "panic", value: "index out of range", status: http.StatusInternalServerError, reports: 1},
+		{name: "aborted response", value: http.ErrAbortHandler, panics: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reporter := &recordingReporter{}
+			reportTo(t, reporter)
+			defer func() {
+				if got := recover(); (got != nil) != tt.panics {
+					t.Errorf("got panic %v, want one %v", got, tt.panics)
+				}
+			}()
+			w := httptest.NewRecorder()
+			recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.value != nil {
+					panic(tt.value)
+				}
+			})).ServeHTTP(w, httptest.NewRequest("GET", "/pool-data", nil))
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+			if len(reporter.errors) != tt.reports {
+				t.Errorf("got reports %q, want %d", reporter.errors, tt.reports)
+			}
+		})
+	}
+}
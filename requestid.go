@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxRequestIDLength bounds the incoming X-Request-ID values that are kept
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the request's id
+type requestIDKey struct{}
+
+// requestIDFrom returns the id of the request ctx belongs to, or "" outside of a request
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts the ids of proxies and clients as long as they are short and made
+// of characters that are safe in headers, logs and SQL
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random id of 32 hex digits
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDs gives every request an id, taken from its X-Request-ID header when a proxy or the
+// client set one, and sends it back in X-Request-ID. The id is added to the request's log
+// records and trace, and to the text of error responses so it gets quoted in support tickets.
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.request_id", id))
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = withLogAttrs(ctx, slog.String("request_id", id))
+		next.ServeHTTP(&errorIDWriter{ResponseWriter: w, id: id}, r.WithContext(ctx))
+	})
+}
+
+// errorIDWriter appends the request id to plain text error responses such as those of http.Error
+type errorIDWriter struct {
+	http.ResponseWriter
+	id      string
+	wrote   bool
+	isError bool
+}
+
+func (w *errorIDWriter) WriteHeader(status int) {
+	if !w.wrote && status >= http.StatusOK {
+		w.wrote = true
+		w.isError = status >= http.StatusBadRequest && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") &&
+			w.Header().Get("Content-Encoding") == ""
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorIDWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	if !w.isError {
+		return w.ResponseWriter.Write(p)
+	}
+	// http.Error writes the message in one line, which is the only write of the response
+	w.isError = false
+	message := strings.TrimSuffix(string(p), "\n")
+	if _, err := w.ResponseWriter.Write([]byte(message + " (request id " + w.id + ")\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *errorIDWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection over to the WebSocket endpoint
+func (w *errorIDWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *errorIDWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// tagConnections sets the application_name of each connection of the pool to the id of the
+// request that acquires it, so pg_stat_activity and server logs with %a in log_line_prefix show
+// which request a query belongs to. Comments in the SQL would make every statement unique and
+// defeat the prepared statement cache; a round trip on every acquire is the price instead.
+func tagConnections(config *pgxpool.Config) {
+	var tags sync.Map
+	config.BeforeAcquire = func(ctx context.Context, conn *pgx.Conn) bool {
+		tag := "pool-api"
+		if id := requestIDFrom(ctx); id != "" {
+			tag += " " + id
+		}
+		if current, ok := tags.Load(conn); ok && current == tag {
+			return true
+		}
+		if _, err := conn.Exec(ctx, "SELECT set_config('application_name', $1, false)", tag); err != nil {
+			slog.WarnContext(ctx, "Error tagging a database connection", "error", err)
+			return ctx.Err() != nil
+		}
+		tags.Store(conn, tag)
+		return true
+	}
+	config.BeforeClose = func(conn *pgx.Conn) {
+		tags.Delete(conn)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{name: "uuid", id: "0b0c9a3e-5f2d-4c1e-9d6b-2a7f3e8c1d45", want: true},
+		{name: "proxy id", id: "req_01:abc.def", want: true},
+		{name: "empty", id: ""},
+		{name: "too long", id: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "longest", id: strings.Repeat("a", maxRequestIDLength), want: true},
+		{name: "quote", id: "abc'; DROP TABLE pool_usage"},
+		{name: "line break", id: "abc\ndef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validRequestID(tt.id); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		// keep tells whether the incoming id is used
+		keep    bool
+		handler http.HandlerFunc
+		body    string
+	}{
+		{name: "incoming id", incoming: "abc-123", keep: true, handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(requestIDFrom(r.Context())))
+		}, body: "abc-123"},
+		{name: "new id", incoming: "not valid!", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(requestIDFrom(r.Context())))
+		}, body: "{id}"},
+		{name: "plain text error", incoming: "abc-123", keep: true, handler: func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "invalid 'limit' parameter", http.StatusBadRequest)
+		}, body: "invalid 'limit' parameter (request id abc-123)\n"},
+		{name: "JSON error", incoming: "abc-123", keep: true, handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid"}`))
+		}, body: `{"error":"invalid"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/pool-data", nil)
+			r.Header.Set("X-Request-ID", tt.incoming)
+			w := httptest.NewRecorder()
+			requestIDs(tt.handler).ServeHTTP(w, r)
+			id := w.Header().Get("X-Request-ID")
+			if tt.keep && id != tt.incoming || !tt.keep && (!validRequestID(id) || id == tt.incoming) {
+				t.Errorf("got X-Request-ID %q for incoming %q", id, tt.incoming)
+			}
+			if got, want := w.Body.String(), strings.ReplaceAll(tt.body, "{id}", id); got != want {
+				t.Errorf("got body %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// defaultStartupTimeout is how long startup waits for the database when DB_STARTUP_TIMEOUT is
+	// unset, long enough for a Postgres container started alongside to accept connections
+	defaultStartupTimeout = time.Minute
+	// defaultConnectTimeout bounds dialing a connection unless connect_timeout is in the URL, so
+	// requests fail fast with 503 while the database is down instead of hanging
+	defaultConnectTimeout = 5 * time.Second
+	// initialRetryDelay and maxRetryDelay bound the exponential backoff between connection attempts
+	initialRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+)
+
+// getStartupTimeout reads DB_STARTUP_TIMEOUT, defaulting to defaultStartupTimeout; 0 fails on the
+// first connection error
+func getStartupTimeout() (time.Duration, error) {
+	value := os.Getenv("DB_STARTUP_TIMEOUT")
+	if value == "" {
+		return defaultStartupTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid DB_STARTUP_TIMEOUT %q: expected a non-negative duration", value)
+	}
+	return timeout, nil
+}
+
+// retryDelay is the backoff before the given retry, counting from 0: it doubles from
+// initialRetryDelay up to maxRetryDelay, randomized by up to half so instances restarted together
+// don't reconnect in lockstep
+func retryDelay(attempt int) time.Duration {
+	delay := maxRetryDelay
+	if attempt < 16 {
+		delay = min(initialRetryDelay<<attempt, maxRetryDelay)
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// waitForDatabase pings the database until it answers, retrying with backoff for up to timeout.
+// Once it is up, the pool replaces broken connections by itself on the next use, so outages
+// later on need no restart.
+func waitForDatabase(ctx context.Context, pool *pgxpool.Pool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 0; ; attempt++ {
+		err := pool.Ping(ctx)
+		if err == nil {
+			if attempt > 0 {
+				slog.Info("Connected to the database", "attempts", attempt+1)
+			}
+			return nil
+		}
+		delay := retryDelay(attempt)
+		if ctx.Err() != nil || time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("the database is unavailable: %v", err)
+		}
+		slog.Warn("Waiting for the database", "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isUnavailable reports whether err means the database could not be reached, rather than that
+// the query failed
+func isUnavailable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr) || pgconn.SafeToRetry(err)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// retryableError is an error pgx marks as safe to retry, as it does when a connection breaks before a query was sent
+type retryableError struct{}
+
+func (retryableError) Error() string     { return "conn closed" }
+func (retryableError) SafeToRetry() bool { return true }
+
+func TestGetStartupTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "", want: defaultStartupTimeout},
+		{value: "0", want: 0},
+		{value: "5m", want: 5 * time.Minute},
+		{value: "-1s", err: `invalid DB_STARTUP_TIMEOUT "-1s": expected a non-negative duration`},
+		{value: "60", err: `invalid DB_STARTUP_TIMEOUT "60": expected a non-negative duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("DB_STARTUP_TIMEOUT", tt.value)
+			got, err := getStartupTimeout()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: initialRetryDelay},
+		{attempt: 1, max: 2 * initialRetryDelay},
+		{attempt: 3, max: 8 * initialRetryDelay},
+		{attempt: 6, max: maxRetryDelay},
+		{attempt: 100, max: maxRetryDelay},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			for range 100 {
+				if got := retryDelay(tt.attempt); got < tt.max/2 || got >= tt.max {
+					t.Fatalf("got %v, want between %v and %v", got, tt.max/2, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "broken connection", err: fmt.Errorf("query: %w", retryableError{}), want: true},
+		{name: "query error", err: &pgconn.PgError{Code: "42P01"}},
+		{name: "other error", err: errors.New("scan failed")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUnavailable(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitForDatabase(t *testing.T) {
+	// Nothing listens on port 1, so every attempt fails at once
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost:1/pool?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pool.Close()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		timeout time.Duration
+	}{
+		{name: "no retries", ctx: context.Background()},
+		{name: "gives up before the timeout", ctx: context.Background(), timeout: initialRetryDelay},
+		{name: "canceled", ctx: canceled, timeout: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := waitForDatabase(tt.ctx, pool, tt.timeout)
+			if err == nil || !strings.HasPrefix(err.Error(), "the database is unavailable: ") {
+				t.Fatalf("got error %v, want the database to be unavailable", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// rollupHours is the view of the hourly rollup as (timestamp, readings, total, min, max)
+const rollupHours = "pool_usage_hours"
+
+// defaultRollupInterval is how often out-of-date hours are recomputed when ROLLUP_INTERVAL is unset
+const defaultRollupInterval = time.Minute
+
+// getRollupInterval reads ROLLUP_INTERVAL, defaulting to defaultRollupInterval
+func getRollupInterval() (time.Duration, error) {
+	value := os.Getenv("ROLLUP_INTERVAL")
+	if value == "" {
+		return defaultRollupInterval, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid ROLLUP_INTERVAL %q: expected a positive duration", value)
+	}
+	return interval, nil
+}
+
+// refreshRollups recomputes the hours marked out of date in one transaction. Readings stored
+// meanwhile mark their hour again once the transaction commits, so none are missed.
+func refreshRollups(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to start transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var hours []time.Time
+	err = tx.QueryRow(ctx, "WITH d AS (DELETE FROM pool_usage_rollup_dirty RETURNING hour) SELECT coalesce(array_agg(hour), '{}') FROM d").Scan(&hours)
+	if err != nil {
+		return 0, err
+	}
+	if len(hours) == 0 {
+		return 0, nil
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM pool_usage_hourly WHERE bucket = ANY($1)", hours); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO pool_usage_hourly (bucket, readings, total, min, max)
+		SELECT h.hour, count(*), sum(p.percentage), min(p.percentage), max(p.percentage)
+		FROM unnest($1::timestamptz[]) AS h(hour) JOIN pool_usage p ON p.timestamp >= h.hour AND p.timestamp < h.hour + interval '1 hour'
+		WHERE NOT p.suspect GROUP BY h.hour`, hours)
+	if err != nil {
+		return 0, err
+	}
+	return len(hours), tx.Commit(ctx)
+}
+
+// watchRollups refreshes the rollup every interval
+func watchRollups(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := refreshRollups(ctx, pool); err != nil {
+			slog.Error("Error refreshing rollups", "error", err)
+		} else if n > 0 {
+			slog.Debug("Refreshed the rollup", "hours", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rollupRange returns the bounds of a filter that can be answered from the hourly rollup: it
+// may only leave out suspect readings and restrict the time range to whole hours, and buckets
+// of the unit must be made of whole hours on the calendar of loc
+func (f *queryFilter) rollupRange(unit string, loc *time.Location) (from, to *time.Time, ok bool) {
+	if unit == "minute" || len(f.conditions) == 0 || f.conditions[0] != "NOT suspect" {
+		return nil, nil, false
+	}
+	for _, condition := range f.conditions[1:] {
+		var i int
+		bound := &from
+		if _, err := fmt.Sscanf(condition, "timestamp >= $%d", &i); err != nil {
+			bound = &to
+			if _, err := fmt.Sscanf(condition, "timestamp < $%d", &i); err != nil {
+				return nil, nil, false
+			}
+		}
+		t, isTime := f.args[i-1].(time.Time)
+		if !isTime || *bound != nil || !t.Equal(t.Truncate(time.Hour)) {
+			return nil, nil, false
+		}
+		*bound = &t
+	}
+	// Zones such as Asia/Kolkata are offset by half hours, so their days do not start on a whole hour
+	if loc != nil {
+		checked := []time.Time{time.Now()}
+		for _, bound := range []*time.Time{from, to} {
+			if bound != nil {
+				checked = append(checked, *bound)
+			}
+		}
+		for _, t := range checked {
+			if _, offset := t.In(loc).Zone(); offset%3600 != 0 {
+				return nil, nil, false
+			}
+		}
+	}
+	return from, to, true
+}
+
+// queryRollupBuckets aggregates the hourly rollup into buckets of the given unit, as queryBuckets
+// does with the raw readings, without percentiles
+func queryRollupBuckets(ctx context.Context, pool *pgxpool.Pool, unit string, from, to *time.Time, loc *time.Location) ([]Bucket, error) {
+	filter := &queryFilter{}
+	if from != nil {
+		filter.add("timestamp >= $%d", *from)
+	}
+	if to != nil {
+		filter.add("timestamp < $%d", *to)
+	}
+	query := fmt.Sprintf(`SELECT %s AS start, sum(total)::float8 / sum(readings)::float8, min(min), max(max), sum(readings)::int
+		FROM %s%s GROUP BY start ORDER BY start`, filter.truncTimestamp(unit, loc), rollupHours, filter.where())
+	rows, err := pool.Query(ctx, query, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []Bucket{}
+	for rows.Next() {
+		var b Bucket
+		if err := rows.Scan(&b.Start, &b.Avg, &b.Min, &b.Max, &b.Count); err != nil {
+			return nil, err
+		}
+		b.Start = inLocation(b.Start, loc)
+		b.End = bucketEnd(b.Start, unit)
+		buckets = append(buckets, b)
+		if err := checkRowLimit(ctx, len(buckets)); err != nil {
+			return nil, err
+		}
+	}
+	return buckets, rows.Err()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetRollupInterval(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "", want: defaultRollupInterval},
+		{value: "5m", want: 5 * time.Minute},
+		{value: "0s", err: `invalid ROLLUP_INTERVAL "0s": expected a positive duration`},
+		{value: "often", err: `invalid ROLLUP_INTERVAL "often": expected a positive duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("ROLLUP_INTERVAL", tt.value)
+			got, err := getRollupInterval()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollupRange(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	filter := func(conditions ...any) *queryFilter {
+		f := newQueryFilter()
+		for i := 0; i < len(conditions); i += 2 {
+			f.add(conditions[i].(string), conditions[i+1])
+		}
+		return f
+	}
+	tests := []struct {
+		name   string
+		filter *queryFilter
+		unit   string
+		loc    *time.Location
+		from   *time.Time
+		to     *time.Time
+		ok     bool
+	}{
+		{name: "everything", filter: filter(), unit: "day", ok: true},
+		{name: "whole hours", filter: filter("timestamp >= $%d", hour, "timestamp < $%d", hour.Add(24*time.Hour)), unit: "hour", from: &hour, to: timePtr(hour.Add(24 * time.Hour)), ok: true},
+		{name: "whole hours in Berlin", filter: filter("timestamp >= $%d", hour), unit: "day", loc: berlin, from: &hour, ok: true},
+		{name: "minutes", filter: filter(), unit: "minute"},
+		{name: "part of an hour", filter: filter("timestamp >= $%d", hour.Add(30*time.Minute)), unit: "hour"},
+		{name: "other condition", filter: filter("percentage >= $%d", 50), unit: "hour"},
+		{name: "two lower bounds", filter: filter("timestamp >= $%d", hour, "timestamp >= $%d", hour), unit: "hour"},
+		{name: "including suspect readings", filter: &queryFilter{}, unit: "hour"},
+		{name: "half-hour zone", filter: filter(), unit: "day", loc: kolkata},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, ok := tt.filter.rollupRange(tt.unit, tt.loc)
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+			if !equalTimes(from, tt.from) || !equalTimes(to, tt.to) {
+				t.Errorf("got range %v to %v, want %v to %v", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func equalTimes(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// apiRoute describes one HTTP endpoint; the same table drives the ServeMux and the OpenAPI document
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	Params  []apiParam
+	// Response is a value of the JSON response body type, used to derive its schema
+	Response any
+	// ContentTypes lists the media types the endpoint can produce; defaults to application/json
+	ContentTypes []string
+	// Scope names the bearer token or API key scope the endpoint requires; empty for public endpoints
+	Scope string
+	// OwnAuth marks endpoints that check their callers themselves and never require the read scope
+	OwnAuth bool
+	// Signed endpoints verify the HMAC signature of request bodies that carry one
+	Signed bool
+	// Cached endpoints have their responses kept in the response cache until they expire or a reading is stored,
+	// and concurrent identical requests share one run of the handler
+	Cached bool
+	// Conditional endpoints send ETag and Last-Modified validators and answer 304 when nothing changed
+	Conditional bool
+	// LongRunning endpoints stream or wait for longer than the query timeout and are exempt from it
+	LongRunning bool
+	// Group names the IP filter group of the endpoint; defaults to its scope, or read
+	Group   string
+	Handler http.Handler
+}
+
+// apiParam describes a query or path parameter
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Format      string
+	Description string
+	Enum        []string
+	Required    bool
+}
+
+func queryParam(name, typ, description string) apiParam {
+	return apiParam{Name: name, In: "query", Type: typ, Description: description}
+}
+
+var (
+	rangeParams = []apiParam{
+		{Name: "from", In: "query", Type: "string", Format: "date-time", Description: "Inclusive lower bound (RFC3339)"},
+		{Name: "to", In: "query", Type: "string", Format: "date-time", Description: "Exclusive upper bound (RFC3339)"},
+		queryParam("last", "string", "Relative range counted back from 'to' or now, e.g. 24h or 7d; excludes 'from'"),
+		queryParam("include_suspect", "boolean", "Also include readings flagged as implausible spikes"),
+	}
+	thresholdParams = []apiParam{
+		queryParam("min_percentage", "integer", "Only include readings at or above this percentage"),
+		queryParam("max_percentage", "integer", "Only include readings at or below this percentage"),
+	}
+	readingIDParam      = apiParam{Name: "id", In: "path", Type: "integer", Description: "Reading id", Required: true}
+	idempotencyKeyParam = apiParam{Name: "Idempotency-Key", In: "header", Type: "string",
+		Description: "Client-chosen key, scoped to the caller; retries with the same key and body replay the first response instead of writing again"}
+	tzParam      = queryParam("tz", "string", "IANA time zone for timestamps and calendar bucketing, e.g. Europe/Berlin")
+	bucketParams = []apiParam{
+		{Name: "bucket", In: "query", Type: "string", Description: "Bucket width (default hour)",
+			Enum: []string{"minute", "hour", "day", "week", "month"}},
+		{Name: "group_by", In: "query", Type: "string", Description: "Calendar alias for 'bucket'; weeks are ISO weeks",
+			Enum: []string{"minute", "hour", "day", "week", "month"}},
+	}
+	pointsParams = []apiParam{
+		queryParam("limit", "integer", "Page size (default 1000, at most 10000)"),
+		queryParam("offset", "integer", "Number of points to skip"),
+		queryParam("cursor", "string", "X-Next-Cursor of the previous page for keyset pagination; empty for the first page"),
+		{Name: "after_timestamp", In: "query", Type: "string", Format: "date-time", Description: "Return the points after this timestamp in the requested order"},
+		queryParam("after_id", "integer", "Id of the point at after_timestamp, to break ties"),
+		{Name: "order", In: "query", Type: "string", Description: "Sort order (default desc, newest first)", Enum: []string{"asc", "desc"}},
+		queryParam("fields", "string", "Comma-separated subset of id,timestamp,percentage"),
+		queryParam("resolution", "string", "Average the readings into buckets of this width, e.g. 5m or 1h"),
+		queryParam("smooth", "string", "Trailing moving-average window, e.g. 30m"),
+		{Name: "format", In: "query", Type: "string", Description: "Response format; overrides the Accept header",
+			Enum: append([]string{"json", "jsonapi"}, pointFormatNames()...)},
+	}
+)
+
+// pointFormatNames returns the names of the streaming formats in a stable order
+func pointFormatNames() []string {
+	return slices.Sorted(maps.Keys(pointFormats))
+}
+
+// params concatenates parameter groups
+func params(groups ...[]apiParam) []apiParam {
+	var all []apiParam
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	return all
+}
+
+// pointContentTypes lists the media types /pool-data can produce
+func pointContentTypes() []string {
+	types := []string{"application/json", jsonAPIContentType}
+	for _, name := range pointFormatNames() {
+		types = append(types, pointFormats[name].contentType)
+	}
+	return types
+}
+
+// apiRoutes returns every HTTP endpoint of the service; routes with a scope are wrapped so they
+// require that scope's token
+func apiRoutes(pool *pgxpool.Pool, reads *readPool, poolLocation *time.Location, store *readingStore, auth *authenticator, limits *rateLimiter, filters map[string]*ipFilter, cors *corsPolicy, queryTimeout time.Duration, caps resultCaps) []apiRoute {
+	routes := []apiRoute{
+		{
+			Method: "GET", Path: "/pool-data", Summary: "List readings, newest first, one page at a time",
+			Params:       params(rangeParams, thresholdParams, []apiParam{tzParam}, pointsParams),
+			Response:     []DataPoint{},
+			ContentTypes: pointContentTypes(),
+			Conditional:  true,
+			Cached:       true,
+			Handler:      getDataHandler(reads),
+		},
+		{
+			Method: "POST", Path: "/pool-data", Summary: "Store a reading; the timestamp defaults to now",
+			Params:   []apiParam{idempotencyKeyParam},
+			Response: DataPoint{},
+			Scope:    scopeIngest,
+			Signed:   true,
+			Handler:  idempotent(pool, getIngestHandler(store)),
+		},
+		{
+			Method: "POST", Path: "/pool-data/batch", Summary: "Store many readings at once from a JSON array or an NDJSON body, all or nothing",
+			Params:   []apiParam{idempotencyKeyParam},
+			Response: BatchResult{},
+			Scope:    scopeIngest,
+			Signed:   true,
+			Handler:  idempotent(pool, getBatchIngestHandler(store)),
+		},
+		{
+			Method: "POST", Path: "/import/csv", Summary: "Import readings from an uploaded CSV file, skipping timestamps that already exist",
+			Params: []apiParam{
+				queryParam("timestamp_column", "string", "Header of the timestamp column (default timestamp)"),
+				queryParam("percentage_column", "string", "Header of the percentage column (default percentage)"),
+				queryParam("time_format", "string", "rfc3339 (default), unix, or a Go layout such as 02.01.2006 15:04"),
+				queryParam("delimiter", "string", "Field separator (default ,); \\t for tabs"),
+				queryParam("tz", "string", "IANA time zone of timestamps without an offset (default POOL_TIMEZONE)"),
+			},
+			Response:    ImportResult{},
+			Scope:       scopeIngest,
+			LongRunning: true,
+			Handler:     getCSVImportHandler(store, poolLocation),
+		},
+		{
+			Method: "POST", Path: "/ingest/webhook/{name}", Summary: "Store a reading POSTed by a third-party system in its own JSON format",
+			Params: []apiParam{
+				{Name: "name", In: "path", Type: "string", Description: "Source name", Required: true},
+				queryParam("token", "string", "The source's token, for systems that cannot send an Authorization header"),
+			},
+			Response: DataPoint{},
+			Signed:   true,
+			OwnAuth:  true,
+			Group:    scopeIngest,
+			Handler:  getWebhookIngestHandler(store, poolLocation),
+		},
+		{
+			Method: "POST", Path: "/ingest/sources", Summary: "Register a webhook source with its mapping templates; returns its token once",
+			Response: WebhookSource{},
+			Scope:    scopeAdmin,
+			Handler:  getCreateWebhookSourceHandler(pool),
+		},
+		{
+			Method: "GET", Path: "/ingest/sources", Summary: "List webhook sources",
+			Response: []WebhookSource{},
+			Scope:    scopeAdmin,
+			Handler:  getListWebhookSourcesHandler(pool),
+		},
+		{
+			Method: "DELETE", Path: "/ingest/sources/{name}", Summary: "Remove a webhook source",
+			Params:  []apiParam{{Name: "name", In: "path", Type: "string", Description: "Source name", Required: true}},
+			Scope:   scopeAdmin,
+			Handler: getDeleteWebhookSourceHandler(pool),
+		},
+		{
+			Method: "POST", Path: "/ingest/signing-secrets", Summary: "Generate the secret a source signs its ingest requests with; returned once",
+			Response: SigningSecret{},
+			Scope:    scopeAdmin,
+			Handler:  getCreateSigningSecretHandler(pool),
+		},
+		{
+			Method: "DELETE", Path: "/ingest/signing-secrets/{source}", Summary: "Remove the signing secret of a source",
+			Params:  []apiParam{{Name: "source", In: "path", Type: "string", Description: "Source name", Required: true}},
+			Scope:   scopeAdmin,
+			Handler: getDeleteSigningSecretHandler(pool),
+		},
+		{
+			Method: "GET", Path: "/pool-data/latest", Summary: "The newest reading, or the newest N readings when 'count' is given",
+			Params:      []apiParam{queryParam("count", "integer", "Number of readings to return as an array"), tzParam},
+			Response:    DataPoint{},
+			Conditional: true,
+			Cached:      true,
+			Handler:     getLatestHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/pool-data/aggregate", Summary: "Per-bucket average, minimum, maximum and percentiles",
+			Params: params(rangeParams, thresholdParams, bucketParams, []apiParam{
+				queryParam("agg", "string", "Comma-separated percentiles, e.g. p50,p95"), tzParam,
+				{Name: "format", In: "query", Type: "string", Description: "Response format; overrides the Accept header", Enum: []string{"json", "xml"}},
+			}),
+			Response:     []Bucket{},
+			ContentTypes: []string{"application/json", "application/xml"},
+			Conditional:  true,
+			Cached:       true,
+			Handler:      getAggregateHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/pool-data/stats", Summary: "Summary statistics over a range",
+			Params:      params(rangeParams, thresholdParams),
+			Response:    Stats{},
+			Conditional: true,
+			Cached:      true,
+			Handler:     getStatsHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/pool-data/heatmap", Summary: "Average occupancy per weekday and hour of day",
+			Params:      params(rangeParams, []apiParam{tzParam}),
+			Response:    Heatmap{},
+			Conditional: true,
+			Handler:     getHeatmapHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/pool-data/compare", Summary: "Compare a range with an earlier one, by default the preceding period",
+			Params: params(rangeParams, bucketParams, []apiParam{
+				{Name: "compare_from", In: "query", Type: "string", Format: "date-time", Description: "Start of the previous range; excludes 'shift'"},
+				queryParam("shift", "string", "How far back the previous range lies, e.g. 7d"),
+				tzParam,
+			}),
+			Response: Comparison{},
+			Handler:  getCompareHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/pool-data/busy-times", Summary: "Busiest and quietest weekday/hour slots",
+			Params:   params(rangeParams, []apiParam{queryParam("n", "integer", "Number of slots per list (default 3)"), tzParam}),
+			Response: BusyTimes{},
+			Handler:  getBusyTimesHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/pool-data/gaps", Summary: "Windows in which readings are missing",
+			Params:   params(rangeParams, []apiParam{queryParam("interval", "string", "Expected sampling period (default 15m)"), tzParam}),
+			Response: []Gap{},
+			Handler:  getGapsHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/pool-data/wait", Summary: "Long-poll for readings newer than 'since', answering early when one arrives",
+			Params: []apiParam{
+				{Name: "since", In: "query", Type: "integer", Description: "Id of the newest reading already received", Required: true},
+				queryParam("timeout", "string", "How long to wait for a new reading, default 30s, at most 2m"),
+				tzParam,
+			},
+			Response:    []DataPoint{},
+			LongRunning: true,
+			Handler:     getWaitHandler(pool, store.readings),
+		},
+		{
+			Method: "GET", Path: "/pool-data/{date}", Summary: "All readings of one calendar day",
+			Params: []apiParam{
+				{Name: "date", In: "path", Type: "string", Format: "date", Description: "Day in YYYY-MM-DD form", Required: true},
+				tzParam,
+			},
+			Response: []DataPoint{},
+			Cached:   true,
+			Handler:  getDayHandler(reads, poolLocation),
+		},
+		{
+			Method: "PATCH", Path: "/pool-data/{id}", Summary: "Correct the timestamp, percentage or spike flag of a reading",
+			Params:   []apiParam{readingIDParam},
+			Response: DataPoint{},
+			Scope:    scopeAdmin,
+			Handler:  getPatchReadingHandler(pool, store.cache),
+		},
+		{
+			Method: "DELETE", Path: "/pool-data/{id}", Summary: "Remove an erroneous reading",
+			Params:  []apiParam{readingIDParam},
+			Scope:   scopeAdmin,
+			Handler: getDeleteReadingHandler(pool, store.cache),
+		},
+		{
+			Method: "GET", Path: "/ws", Summary: "WebSocket pushing a snapshot of the latest reading, then every new reading",
+			Response:    LiveMessage{},
+			LongRunning: true,
+			Handler:     getWebSocketHandler(pool, store.readings, cors),
+		},
+		{
+			Method: "GET", Path: "/events", Summary: "Server-sent events for new readings, resumable with Last-Event-ID",
+			Params: []apiParam{
+				{Name: "Last-Event-ID", In: "header", Type: "integer", Description: "Id of the last reading received; the missed readings are replayed"},
+				queryParam("last_event_id", "integer", "Alternative to the Last-Event-ID header"),
+			},
+			ContentTypes: []string{"text/event-stream"},
+			LongRunning:  true,
+			Handler:      getEventsHandler(pool, store.readings),
+		},
+		{
+			Method: "GET", Path: "/changes", Summary: "Inserts, updates and deletes after a sequence number, for incremental sync",
+			Params: []apiParam{
+				queryParam("since", "integer", "Sequence number of the last change already applied, 0 for the whole log"),
+				queryParam("limit", "integer", "Maximum number of changes, default 1000, at most 10000"),
+				tzParam,
+			},
+			Response: ChangeFeed{},
+			Handler:  getChangesHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/status", Summary: "Latest reading with its age and short-term trend",
+			Params:   []apiParam{tzParam},
+			Response: Status{},
+			Handler:  getStatusHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/export/parquet", Summary: "Export the readings in a range as a Parquet file",
+			Params:       rangeParams,
+			ContentTypes: []string{"application/vnd.apache.parquet"},
+			LongRunning:  true,
+			Handler:      getParquetExportHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/export/influx", Summary: "Export the readings in a range as InfluxDB line protocol",
+			Params: params(rangeParams, []apiParam{
+				{Name: "precision", In: "query", Type: "string", Description: "Timestamp precision (default ns)", Enum: []string{"ns", "us", "ms", "s"}},
+			}),
+			ContentTypes: []string{"text/plain"},
+			LongRunning:  true,
+			Handler:      getInfluxExportHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/export/arrow", Summary: "Export the readings in a range as an Arrow IPC stream",
+			Params:       rangeParams,
+			ContentTypes: []string{"application/vnd.apache.arrow.stream"},
+			LongRunning:  true,
+			Handler:      getArrowExportHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/export/xlsx", Summary: "Export raw readings or per-bucket aggregates as an Excel workbook",
+			Params: params(rangeParams, bucketParams, []apiParam{
+				{Name: "type", In: "query", Type: "string", Description: "Raw readings or aggregates (default raw)", Enum: []string{"raw", "aggregate"}},
+				tzParam,
+			}),
+			ContentTypes: []string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+			LongRunning:  true,
+			Handler:      getXLSXExportHandler(reads, poolLocation),
+		},
+		{
+			Method: "GET", Path: "/feed", Summary: "Atom feed with one entry per day summarizing its average and peak occupancy",
+			ContentTypes: []string{"application/atom+xml"},
+			Handler:      getFeedHandler(reads, poolLocation),
+		},
+		{
+			Method: "GET", Path: "/calendar/quiet-times.ics", Summary: "iCalendar feed of the predicted quietest hours of the coming week",
+			ContentTypes: []string{"text/calendar"},
+			Handler:      getQuietTimesCalendarHandler(reads, poolLocation),
+		},
+		{
+			Method: "GET", Path: "/ha/state", Summary: "Latest reading in the shape of a Home Assistant REST sensor",
+			Response: HAState{},
+			Handler:  getHAStateHandler(reads),
+		},
+		{
+			Method: "GET", Path: "/grafana/{$}", Summary: "Connection test for Grafana's JSON datasource",
+			ContentTypes: []string{"text/plain"},
+			Handler:      getGrafanaTestHandler(),
+		},
+		{
+			Method: "POST", Path: "/grafana/search", Summary: "Targets offered to Grafana's JSON datasource",
+			Response: []string{},
+			Handler:  getGrafanaSearchHandler(),
+		},
+		{
+			Method: "POST", Path: "/grafana/query", Summary: "Time series for Grafana's JSON datasource, bucketed to the panel interval",
+			Response: []GrafanaSeries{},
+			Handler:  getGrafanaQueryHandler(reads),
+		},
+		{
+			Method: "POST", Path: "/grafana/annotations", Summary: "Collection outages as Grafana annotations",
+			Response: []GrafanaAnnotation{},
+			Handler:  getGrafanaAnnotationsHandler(reads),
+		},
+		{
+			Method: "POST", Path: "/graphql", Summary: "GraphQL endpoint for data points, latest readings and aggregates",
+			Response: map[string]any{},
+			Handler:  getGraphQLHandler(reads),
+		},
+		{
+			Method: "POST", Path: "/webhooks", Summary: "Subscribe a URL to new readings or threshold crossings; returns the signing secret once",
+			Response: Webhook{},
+			Scope:    scopeAdmin,
+			Handler:  getCreateWebhookHandler(pool),
+		},
+		{
+			Method: "GET", Path: "/webhooks", Summary: "List webhook subscriptions",
+			Response: []Webhook{},
+			Scope:    scopeAdmin,
+			Handler:  getListWebhooksHandler(pool),
+		},
+		{
+			Method: "DELETE", Path: "/webhooks/{id}", Summary: "Remove a webhook subscription and its delivery log",
+			Params:  []apiParam{{Name: "id", In: "path", Type: "integer", Description: "Webhook id", Required: true}},
+			Scope:   scopeAdmin,
+			Handler: getDeleteWebhookHandler(pool),
+		},
+		{
+			Method: "GET", Path: "/webhooks/{id}/deliveries", Summary: "Most recent deliveries of a webhook with their status",
+			Params: []apiParam{
+				{Name: "id", In: "path", Type: "integer", Description: "Webhook id", Required: true},
+				queryParam("limit", "integer", "Number of deliveries (default 100)"),
+			},
+			Response: []WebhookDelivery{},
+			Scope:    scopeAdmin,
+			Handler:  getWebhookDeliveriesHandler(pool),
+		},
+		{
+			Method: "GET", Path: "/audit", Summary: "Changes made by authenticated callers, newest first, with before and after values",
+			Params: params(rangeParams[:2], []apiParam{
+				queryParam("limit", "integer", "Page size (default 1000, at most 10000)"),
+				queryParam("offset", "integer", "Number of entries to skip"),
+				queryParam("actor", "string", "Only changes by this caller, e.g. api-key:sensor-1"),
+				queryParam("action", "string", "Only this action, e.g. reading.update"),
+				queryParam("target", "string", "Only changes to this record, e.g. a reading id"),
+			}),
+			Response: []AuditEntry{},
+			Scope:    scopeAdmin,
+			Handler:  getAuditHandler(pool),
+		},
+		{
+			Method: "POST", Path: "/api-keys", Summary: "Issue an API key with scopes and an optional expiry; the key is returned once",
+			Response: APIKey{},
+			Scope:    scopeAdmin,
+			Handler:  getCreateAPIKeyHandler(pool),
+		},
+		{
+			Method: "GET", Path: "/api-keys", Summary: "List API keys, including revoked and expired ones",
+			Response: []APIKey{},
+			Scope:    scopeAdmin,
+			Handler:  getListAPIKeysHandler(pool),
+		},
+		{
+			Method: "DELETE", Path: "/api-keys/{id}", Summary: "Revoke an API key",
+			Params:  []apiParam{{Name: "id", In: "path", Type: "integer", Description: "API key id", Required: true}},
+			Scope:   scopeAdmin,
+			Handler: getRevokeAPIKeyHandler(pool),
+		},
+		{
+			Method: "POST", Path: "/api-keys/{id}/rotate", Summary: "Replace an API key with a new one, optionally keeping the old one valid for a grace period",
+			Params: []apiParam{
+				{Name: "id", In: "path", Type: "integer", Description: "API key id", Required: true},
+				queryParam("grace", "string", "How long the old key keeps working, e.g. 24h; revoked at once if omitted"),
+			},
+			Response: APIKey{},
+			Scope:    scopeAdmin,
+			Handler:  getRotateAPIKeyHandler(pool),
+		},
+		{
+			Method: "GET", Path: "/debug/dbpool", Summary: "Connection pool statistics of the primary and the read replica",
+			Response: []DBPoolStats{},
+			Scope:    scopeAdmin,
+			Handler:  getDBPoolHandler(reads),
+		},
+	}
+	if auth.oidc != nil {
+		routes = append(routes, auth.oidc.routes()...)
+	}
+	coalesce := &coalescer{}
+	for i, route := range routes {
+		if route.Scope == "" && auth.requireRead && !route.OwnAuth {
+			route.Scope = scopeRead
+			routes[i].Scope = scopeRead
+		}
+		if route.Cached {
+			routes[i].Handler = store.cache.cached(coalesce.coalesced(routes[i].Handler))
+		}
+		if route.Conditional {
+			routes[i].Handler = conditional(reads, routes[i].Handler)
+		}
+		if route.Signed {
+			routes[i].Handler = requireSignature(pool, routes[i].Handler)
+		}
+		if !route.LongRunning {
+			routes[i].Handler = caps.limit(routes[i].Handler)
+		}
+		if queryTimeout > 0 && !route.LongRunning {
+			routes[i].Handler = withTimeout(queryTimeout, routes[i].Handler)
+		}
+		if route.Scope != "" {
+			if limits != nil {
+				routes[i].Handler = limits.limitKey(routes[i].Handler)
+			}
+			routes[i].Handler = auth.require(route.Scope, routes[i].Handler)
+		}
+		// The IP limit applies before authentication so failed attempts count too
+		if limits != nil {
+			routes[i].Handler = limits.limit(routes[i].Handler)
+		}
+		// Refused networks are turned away before they use up any rate limit
+		if filter := filters[cmp.Or(route.Group, route.Scope, scopeRead)]; filter != nil {
+			routes[i].Handler = filter.filter(routes[i].Handler)
+		}
+	}
+	return routes
+}
+
+// registerRoutes adds the routes to mux under method-specific patterns, instrumented with metrics
+// and named in traces
+func registerRoutes(mux *http.ServeMux, routes []apiRoute, metrics *httpMetrics) {
+	for _, route := range routes {
+		mux.Handle(route.Method+" "+route.Path, traceRoute(route.Path, metrics.instrument(route.Path, route.Handler)))
+	}
+}
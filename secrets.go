@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// secretRefresh is how long a credential is used before it is read again, so rotated secrets are picked up
+const secretRefresh = time.Minute
+
+// cachedSecret is a credential with the time it was read
+type cachedSecret struct {
+	value  string
+	readAt time.Time
+}
+
+// secretCache holds the credentials read by secret
+var secretCache = struct {
+	sync.Mutex
+	values map[string]cachedSecret
+}{values: map[string]cachedSecret{}}
+
+// secretReads lets concurrent callers of secret share one read of a credential
+var secretReads singleflight.Group
+
+// secret returns the credential name, re-reading it at most every secretRefresh. In order of
+// precedence it comes from the file named by <name>_FILE, as mounted by Docker and Kubernetes
+// secrets; from the Vault KV secret at VAULT_SECRET_PATH, under the key name; or from the
+// environment variable itself. If a re-read fails, the previous value stays in use. The read
+// happens outside the lock, so a slow Vault only holds up the callers waiting for that credential.
+func secret(name string) (string, error) {
+	secretCache.Lock()
+	cached, ok := secretCache.values[name]
+	secretCache.Unlock()
+	if ok && time.Since(cached.readAt) < secretRefresh {
+		return cached.value, nil
+	}
+	value, err, _ := secretReads.Do(name, func() (any, error) {
+		return readSecret(name)
+	})
+	if err != nil {
+		if ok {
+			slog.Error("Error re-reading a secret, keeping the previous value", "name", name, "error", err)
+			return cached.value, nil
+		}
+		return "", err
+	}
+	secretCache.Lock()
+	secretCache.values[name] = cachedSecret{value: value.(string), readAt: time.Now()}
+	secretCache.Unlock()
+	return value.(string), nil
+}
+
+// readSecret reads the current value of a credential from its source
+func readSecret(name string) (string, error) {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read %s_FILE: %v", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if path := os.Getenv("VAULT_SECRET_PATH"); path != "" && name != "VAULT_TOKEN" {
+		values, err := vaultSecret(path)
+		if err != nil {
+			return "", err
+		}
+		if value, ok := values[name]; ok {
+			return value, nil
+		}
+	}
+	return os.Getenv(name), nil
+}
+
+// vaultClient bounds requests to Vault
+var vaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// cachedVaultSecret is the values of a Vault secret with the time they were fetched
+type cachedVaultSecret struct {
+	values map[string]string
+	readAt time.Time
+}
+
+// vaultCache holds the Vault secrets fetched by vaultSecret by path, so the credentials kept in
+// one secret cost a single request every secretRefresh
+var vaultCache = struct {
+	sync.Mutex
+	secrets map[string]cachedVaultSecret
+}{secrets: map[string]cachedVaultSecret{}}
+
+// vaultReads lets concurrent readers of a Vault secret share one request
+var vaultReads singleflight.Group
+
+// vaultSecret returns the values of the Vault secret at path, fetching it at most every secretRefresh
+func vaultSecret(path string) (map[string]string, error) {
+	vaultCache.Lock()
+	cached, ok := vaultCache.secrets[path]
+	vaultCache.Unlock()
+	if ok && time.Since(cached.readAt) < secretRefresh {
+		return cached.values, nil
+	}
+	values, err, _ := vaultReads.Do(path, func() (any, error) {
+		values, err := readVaultSecret(path)
+		if err != nil {
+			return nil, err
+		}
+		vaultCache.Lock()
+		vaultCache.secrets[path] = cachedVaultSecret{values: values, readAt: time.Now()}
+		vaultCache.Unlock()
+		return values, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values.(map[string]string), nil
+}
+
+// readVaultSecret fetches the KV secret at path, e.g. secret/data/pool-api for a KV version 2
+// engine mounted at secret/, from the Vault at VAULT_ADDR using VAULT_TOKEN
+func readVaultSecret(path string) (map[string]string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_SECRET_PATH requires VAULT_ADDR")
+	}
+	token, err := readSecret("VAULT_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach Vault: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault answered %s", resp.Status)
+	}
+
+	// KV version 2 nests the values in data.data, version 1 returns them in data
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid Vault response: %v", err)
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		data = nested
+	}
+	values := map[string]string{}
+	for key, value := range data {
+		if s, ok := value.(string); ok {
+			values[key] = s
+		}
+	}
+	return values, nil
+}
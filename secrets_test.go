@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clearVault := func() {
+		vaultCache.Lock()
+		vaultCache.secrets = map[string]cachedVaultSecret{}
+		vaultCache.Unlock()
+	}
+	clearVault()
+	t.Cleanup(clearVault)
+	missing := filepath.Join(t.TempDir(), "missing")
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/pool-api":
+			w.Write([]byte(`{"data": {"data": {"ADMIN_TOKEN": "from-kv2", "PORT": 8080}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/pool-api":
+			w.Write([]byte(`{"data": {"ADMIN_TOKEN": "from-kv1"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+
+	tests := []struct {
+		name     string
+		variable string
+		env      map[string]string
+		want     string
+		err      string
+	}{
+		{name: "environment", env: map[string]string{"ADMIN_TOKEN": "from-env"}, want: "from-env"},
+		{name: "file", env: map[string]string{"ADMIN_TOKEN": "from-env", "ADMIN_TOKEN_FILE": file}, want: "from-file"},
+		{name: "missing file", env: map[string]string{"ADMIN_TOKEN_FILE": missing}, err: "unable to read ADMIN_TOKEN_FILE: open " + missing + ": no such file or directory"},
+		{name: "KV version 2", env: map[string]string{"VAULT_ADDR": vault.URL, "VAULT_TOKEN": "root", "VAULT_SECRET_PATH": "secret/data/pool-api"}, want: "from-kv2"},
+		{name: "KV version 1", env: map[string]string{"VAULT_ADDR": vault.URL + "/", "VAULT_TOKEN": "root", "VAULT_SECRET_PATH": "/kv/pool-api"}, want: "from-kv1"},
+		{
+			name:     "not in Vault",
+			variable: "READ_TOKEN",
+			env:      map[string]string{"VAULT_ADDR": vault.URL, "VAULT_TOKEN": "root", "VAULT_SECRET_PATH": "secret/data/pool-api", "READ_TOKEN": "from-env"},
+			want:     "from-env",
+		},
+		{name: "Vault refuses", env: map[string]string{"VAULT_ADDR": vault.URL, "VAULT_TOKEN": "guess", "VAULT_SECRET_PATH": "secret/data/other"}, err: "Vault answered 403 Forbidden"},
+		{name: "no Vault address", env: map[string]string{"VAULT_SECRET_PATH": "secret/data/third"}, err: "VAULT_SECRET_PATH requires VAULT_ADDR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"ADMIN_TOKEN", "ADMIN_TOKEN_FILE", "READ_TOKEN", "VAULT_ADDR", "VAULT_TOKEN", "VAULT_TOKEN_FILE", "VAULT_SECRET_PATH"} {
+				t.Setenv(name, tt.env[name])
+			}
+			got, err := readSecret(cmp.Or(tt.variable, "ADMIN_TOKEN"))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretKeepsPreviousValue(t *testing.T) {
+	resetSecrets(t)
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("first"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ADMIN_TOKEN_FILE", file)
+	if got, err := secret("ADMIN_TOKEN"); err != nil || got != "first" {
+		t.Fatalf("got %q, %v, want first", got, err)
+	}
+
+	// Within secretRefresh the cached value is used without reading the file
+	if err := os.WriteFile(file, []byte("second"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := secret("ADMIN_TOKEN"); got != "first" {
+		t.Errorf("got %q before the refresh, want first", got)
+	}
+
+	backdate := func() {
+		secretCache.Lock()
+		cached := secretCache.values["ADMIN_TOKEN"]
+		cached.readAt = time.Now().Add(-2 * secretRefresh)
+		secretCache.values["ADMIN_TOKEN"] = cached
+		secretCache.Unlock()
+	}
+	backdate()
+	if got, _ := secret("ADMIN_TOKEN"); got != "second" {
+		t.Errorf("got %q after the refresh, want second", got)
+	}
+
+	backdate()
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := secret("ADMIN_TOKEN"); err != nil || got != "second" {
+		t.Errorf("got %q, %v after a failed read, want the previous value", got, err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultShutdownTimeout is how long in-flight requests may take to finish after SIGTERM when
+// SHUTDOWN_TIMEOUT is unset, within the 30 second grace period Kubernetes gives by default
+const defaultShutdownTimeout = 25 * time.Second
+
+// getShutdownTimeout reads SHUTDOWN_TIMEOUT, defaulting to defaultShutdownTimeout
+func getShutdownTimeout() (time.Duration, error) {
+	value := os.Getenv("SHUTDOWN_TIMEOUT")
+	if value == "" {
+		return defaultShutdownTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid SHUTDOWN_TIMEOUT %q: expected a positive duration", value)
+	}
+	return timeout, nil
+}
+
+// serveUntil runs server through listen until ctx is done, then stops accepting connections and
+// waits up to timeout for the requests in flight, closing whatever connections remain after
+// that. It returns the error of the listener, or nil once the server was shut down.
+func serveUntil(ctx context.Context, timeout time.Duration, server *http.Server, listen func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- listen()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	drain, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(drain); err != nil {
+		slog.Warn("Closing the connections of requests that did not finish in time", "addr", server.Addr, "error", err)
+		server.Close()
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetShutdownTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "", want: defaultShutdownTimeout},
+		{value: "10s", want: 10 * time.Second},
+		{value: "0s", err: `invalid SHUTDOWN_TIMEOUT "0s": expected a positive duration`},
+		{value: "25", err: `invalid SHUTDOWN_TIMEOUT "25": expected a positive duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("SHUTDOWN_TIMEOUT", tt.value)
+			got, err := getShutdownTimeout()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeUntil(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// work is how long the request in flight takes after the shutdown started
+		work time.Duration
+		// finished tells whether the client gets the whole response
+		finished bool
+	}{
+		{name: "drained", timeout: 5 * time.Second, work: 50 * time.Millisecond, finished: true},
+		{name: "cut off", timeout: 50 * time.Millisecond, work: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ctx, shutdown := context.WithCancel(context.Background())
+			defer shutdown()
+			server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				shutdown()
+				select {
+				case <-time.After(tt.work):
+				case <-r.Context().Done():
+				}
+				w.Write([]byte("ok"))
+			})}
+			served := make(chan error, 1)
+			go func() {
+				served <- serveUntil(ctx, tt.timeout, server, func() error { return server.Serve(listener) })
+			}()
+
+			resp, err := http.Get("http://" + listener.Addr().String())
+			if err == nil {
+				_, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if finished := err == nil; finished != tt.finished {
+				t.Errorf("got response finished %v (%v), want %v", finished, err, tt.finished)
+			}
+			if err := <-served; err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestServeUntilListenerError(t *testing.T) {
+	want := errors.New("address already in use")
+	err := serveUntil(context.Background(), time.Second, &http.Server{}, func() error { return want })
+	if err != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// signatureHeader carries "t=<unix>,v1=<hex>", the HMAC-SHA256 of "<t>.<body>" as for outgoing webhooks
+	signatureHeader = "X-Signature"
+	// signatureSourceHeader optionally names the source whose secret signed the request. The secret
+	// is picked by the caller, not by the header: webhook sources sign with the secret named after
+	// their path, other callers with the one bound to them, and a header naming another is refused.
+	signatureSourceHeader = "X-Signature-Source"
+	// signatureTolerance is how old a signature may be, bounding the window for replays
+	signatureTolerance = 5 * time.Minute
+)
+
+// SigningSecret is returned once when a source's signing secret is created
+type SigningSecret struct {
+	Source string `json:"source"`
+	// Actor binds the secret to the caller signing with it, e.g. api-key:sensor-1; secrets of
+	// webhook sources need none
+	Actor     *string   `json:"actor,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// verifySignature checks the signature header against body, reporting what is wrong with it. It
+// returns the time the signature was made.
+func verifySignature(secret, header string, body []byte, now time.Time) (time.Time, error) {
+	var timestamp string
+	for _, part := range strings.Split(header, ",") {
+		if value, ok := strings.CutPrefix(part, "t="); ok {
+			timestamp = value
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("the signature has no valid timestamp")
+	}
+	signedAt := time.Unix(unix, 0)
+	if now.Sub(signedAt).Abs() > signatureTolerance {
+		return time.Time{}, fmt.Errorf("the signature timestamp is more than %s off", signatureTolerance)
+	}
+	if subtle.ConstantTimeCompare([]byte(signWebhook(secret, signedAt, body)), []byte(header)) != 1 {
+		return time.Time{}, errors.New("the signature does not match")
+	}
+	return signedAt, nil
+}
+
+// signingSecret returns the source and secret a request must be signed with: for webhook sources
+// the one named after the path, otherwise the one bound to the authenticated caller
+func signingSecret(pool *pgxpool.Pool, r *http.Request) (string, string, error) {
+	var source, secret string
+	var err error
+	if name := r.PathValue("name"); name != "" {
+		err = pool.QueryRow(r.Context(), "SELECT source, secret FROM signing_secrets WHERE source = $1", name).Scan(&source, &secret)
+	} else {
+		err = pool.QueryRow(r.Context(), "SELECT source, secret FROM signing_secrets WHERE actor = $1", actorFrom(r.Context())).Scan(&source, &secret)
+	}
+	return source, secret, err
+}
+
+// requireSignature verifies the HMAC signature of request bodies before passing them on. Signed
+// requests with a bad or reused signature are always rejected; unsigned ones only with
+// INGEST_SIGNATURES=required.
+func requireSignature(pool *pgxpool.Pool, next http.Handler) http.Handler {
+	required := os.Getenv("INGEST_SIGNATURES") == "required"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(signatureHeader)
+		if header == "" {
+			if required {
+				http.Error(w, "The "+signatureHeader+" header is required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		source, secret, err := signingSecret(pool, r)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "Invalid signature: no signing secret belongs to this source or caller", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		if named := r.Header.Get(signatureSourceHeader); named != "" && named != source {
+			http.Error(w, "Invalid signature: the source '"+named+"' does not belong to this caller", http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		signedAt, err := verifySignature(secret, header, body, time.Now())
+		if err != nil {
+			http.Error(w, "Invalid signature: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// Each signature is accepted once; sweepUsedSignatures forgets them once they are too old
+		tag, err := pool.Exec(r.Context(), "INSERT INTO used_signatures (signature, signed_at) VALUES ($1, $2) ON CONFLICT DO NOTHING", header, signedAt)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Invalid signature: the signature was already used; sign every request anew", http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sweepUsedSignatures removes the used signatures every signatureTolerance that are too old to
+// pass the timestamp check, since they no longer need remembering
+func sweepUsedSignatures(ctx context.Context, db execer) {
+	ticker := time.NewTicker(signatureTolerance)
+	defer ticker.Stop()
+	for {
+		_, err := db.Exec(ctx, "DELETE FROM used_signatures WHERE signed_at < now() - make_interval(secs => $1)", signatureTolerance.Seconds())
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Error removing expired signatures", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// getCreateSigningSecretHandler handles POST /ingest/signing-secrets, generating the secret of a source
+func getCreateSigningSecretHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in SigningSecret
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&in); err != nil {
+			writeBodyError(w, decodeError(err))
+			return
+		}
+		var errs fieldErrors
+		if !webhookSourceName.MatchString(in.Source) {
+			errs.add("source", "must consist of lowercase letters, digits, '-' and '_'")
+		}
+		if in.Actor != nil && !strings.Contains(*in.Actor, ":") {
+			errs.add("actor", "must name a caller, e.g. api-key:sensor-1")
+		}
+		if in.Secret != "" {
+			errs.add("secret", "is generated by the server")
+		}
+		if err := errs.err(); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		secret, err := randomToken()
+		if err != nil {
+			http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error generating signing secret", "error", err)
+			return
+		}
+		in.Secret = secret
+		err = pool.QueryRow(r.Context(), "INSERT INTO signing_secrets (source, actor, secret) VALUES ($1, $2, $3) RETURNING created_at",
+			in.Source, in.Actor, in.Secret).Scan(&in.CreatedAt)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "signing_secrets_actor_key" {
+			http.Error(w, fmt.Sprintf("The caller '%s' already has a signing secret", *in.Actor), http.StatusConflict)
+			return
+		}
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			http.Error(w, fmt.Sprintf("The source '%s' already has a signing secret", in.Source), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to store the secret", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error storing signing secret", "error", err)
+			return
+		}
+		logAudit(r.Context(), pool, auditSigningSecretCreate, in.Source, nil, SigningSecret{Source: in.Source, Actor: in.Actor, CreatedAt: in.CreatedAt})
+		writeJSONStatus(w, http.StatusCreated, in)
+	}
+}
+
+// getDeleteSigningSecretHandler handles DELETE /ingest/signing-secrets/{source}
+func getDeleteSigningSecretHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag, err := pool.Exec(r.Context(), "DELETE FROM signing_secrets WHERE source = $1", r.PathValue("source"))
+		if err != nil {
+			http.Error(w, "Failed to delete the secret", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error deleting signing secret", "error", err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Signing secret not found", http.StatusNotFound)
+			return
+		}
+		logAudit(r.Context(), pool, auditSigningSecretDelete, r.PathValue("source"), nil, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
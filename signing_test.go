@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"percentage": 42}`)
+	tests := []struct {
+		name   string
+		header string
+		err    string
+	}{
+		{name: "valid", header: signWebhook("s3cret", now.Add(-time.Minute), body)},
+		{name: "clock ahead", header: signWebhook("s3cret", now.Add(time.Minute), body)},
+		{name: "missing timestamp", header: "v1=abcdef", err: "the signature has no valid timestamp"},
+		{name: "not a timestamp", header: "t=noon,v1=abcdef", err: "the signature has no valid timestamp"},
+		{name: "too old", header: signWebhook("s3cret", now.Add(-10*time.Minute), body), err: "the signature timestamp is more than 5m0s off"},
+		{name: "other secret", header: signWebhook("guess", now, body), err: "the signature does not match"},
+		{name: "other body", header: signWebhook("s3cret", now, []byte(`{"percentage": 99}`)), err: "the signature does not match"},
+		{name: "extra parts", header: signWebhook("s3cret", now, body) + ",v0=abcdef", err: "the signature does not match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifySignature("s3cret", tt.header, body, now)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRequireSignatureWithoutHeader(t *testing.T) {
+	tests := []struct {
+		signatures string
+		status     int
+	}{
+		{signatures: "", status: http.StatusOK},
+		{signatures: "required", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.signatures, func(t *testing.T) {
+			t.Setenv("INGEST_SIGNATURES", tt.signatures)
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			w := httptest.NewRecorder()
+			requireSignature(nil, next).ServeHTTP(w, httptest.NewRequest("POST", "/pool-data", strings.NewReader(`{"percentage": 42}`)))
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestCreateSigningSecretHandlerRejectsBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "invalid source",
+			body: `{"source": "Front Door"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"source","message":"must consist of lowercase letters, digits, '-' and '_'"}]}`,
+		},
+		{
+			name: "actor without kind",
+			body: `{"source": "gate", "actor": "sensor-1"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"actor","message":"must name a caller, e.g. api-key:sensor-1"}]}`,
+		},
+		{
+			name: "secret given",
+			body: `{"source": "gate", "secret": "hunter2"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"secret","message":"is generated by the server"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getCreateSigningSecretHandler(nil)(w, httptest.NewRequest("POST", "/ingest/signing-secrets", strings.NewReader(tt.body)))
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("got body %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSweepUsedSignatures(t *testing.T) {
+	// The sweep runs once right away and then ends with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db := &recordingExecer{}
+	sweepUsedSignatures(ctx, db)
+	if len(db.calls) != 1 || !slices.Equal(db.calls[0], []any{signatureTolerance.Seconds()}) {
+		t.Errorf("got calls %v, want one with the tolerance of %v", db.calls, signatureTolerance)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerSource("file", newFileSource)
+}
+
+// defaultInboxInterval is how often the drop directory is checked for new files
+const defaultInboxInterval = time.Minute
+
+// fileSource loads dump files dropped into INBOX_DIR, in any format the backfill understands, and
+// moves them to the processed or failed subdirectory afterwards. INBOX_INTERVAL sets how often the
+// directory is checked.
+type fileSource struct {
+	dir      string
+	interval time.Duration
+	mapping  csvMapping
+}
+
+// newFileSource configures the file source; it is not configured without INBOX_DIR
+func newFileSource() (Source, error) {
+	dir := os.Getenv("INBOX_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	interval := defaultInboxInterval
+	if value := os.Getenv("INBOX_INTERVAL"); value != "" {
+		var err error
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval < time.Second {
+			return nil, fmt.Errorf("invalid INBOX_INTERVAL %q: expected a duration of at least 1s", value)
+		}
+	}
+	loc, err := getPoolLocation()
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range []string{"processed", "failed"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &fileSource{
+		dir:      dir,
+		interval: interval,
+		mapping:  csvMapping{timestampColumn: "timestamp", percentageColumn: "percentage", timeFormat: "auto", loc: loc, delimiter: ','},
+	}, nil
+}
+
+func (s *fileSource) Describe() string {
+	return fmt.Sprintf("loading files dropped into %s every %s", s.dir, s.interval)
+}
+
+// Run checks the directory immediately and then on every interval until ctx is cancelled
+func (s *fileSource) Run(ctx context.Context, store *readingStore) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		if err := s.loadAll(ctx, store); err != nil {
+			slog.Error("Error reading inbox", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// loadAll loads every file currently in the directory, oldest name first
+func (s *fileSource) loadAll(ctx context.Context, store *readingStore) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		// Writers should create files under a dot name and rename them when complete
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || backfillFormat(entry.Name()) == "" {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		dest := "processed"
+		inserted, total, err := s.load(ctx, store, path)
+		if err != nil {
+			slog.Error("Error loading a file", "file", entry.Name(), "error", err)
+			dest = "failed"
+		} else {
+			slog.Info("Loaded a file", "file", entry.Name(), "readings", total, "inserted", inserted)
+		}
+		if err := os.Rename(path, filepath.Join(s.dir, dest, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load stores the readings of one file
+func (s *fileSource) load(ctx context.Context, store *readingStore, path string) (int64, int, error) {
+	readings, err := readBackfillFile(path, s.mapping)
+	if err != nil {
+		return 0, 0, err
+	}
+	rows := make([][]any, len(readings))
+	for i, in := range readings {
+		rows[i] = []any{in.Timestamp.UTC(), *in.Percentage}
+	}
+	inserted, err := store.storeAll(ctx, rows)
+	return inserted, len(rows), err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func init() {
+	registerSource("mqtt", newMQTTSource)
+}
+
+// mqttSource stores the readings a sensor publishes on MQTT_INGEST_TOPIC; it uses a connection of
+// its own, so it keeps working independently of the publisher
+type mqttSource struct {
+	cfg mqttConfig
+}
+
+// newMQTTSource configures the MQTT source; it is not configured without MQTT_BROKER and MQTT_INGEST_TOPIC
+func newMQTTSource() (Source, error) {
+	cfg, err := getMQTTConfig()
+	if err != nil || cfg.Broker == "" || cfg.IngestTopic == "" {
+		return nil, err
+	}
+	if mtlsRequired() {
+		return nil, fmt.Errorf("MQTT_INGEST_TOPIC cannot be used with MTLS_REQUIRED=true, which accepts readings over mTLS only")
+	}
+	cfg.ClientID += "-ingest"
+	return &mqttSource{cfg: cfg}, nil
+}
+
+func (s *mqttSource) Describe() string {
+	return fmt.Sprintf("subscribed to MQTT topic %s", s.cfg.IngestTopic)
+}
+
+// Run subscribes on every (re)connect and stays connected until ctx is cancelled
+func (s *mqttSource) Run(ctx context.Context, store *readingStore) error {
+	client, err := connectMQTT(s.cfg, func(client mqtt.Client) {
+		subscribeMQTTIngest(client, s.cfg.IngestTopic, ingestMQTT(store))
+	})
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	client.Disconnect(250)
+	return nil
+}
+
+// subscribeMQTTIngest subscribes to the ingest topic; it is meant to be called on every connect
+func subscribeMQTTIngest(client mqtt.Client, topic string, handler mqtt.MessageHandler) {
+	token := client.Subscribe(topic, 1, handler)
+	if !token.WaitTimeout(mqttTimeout) {
+		slog.Warn("Timed out subscribing to MQTT", "topic", topic)
+		return
+	}
+	if err := token.Error(); err != nil {
+		slog.Error("Error subscribing to MQTT", "topic", topic, "error", err)
+	}
+}
+
+// parseMQTTReading parses a sensor message: either a bare percentage such as "37" or "37.5",
+// or a JSON object in the shape accepted by POST /pool-data
+func parseMQTTReading(payload []byte, now time.Time) (ingestReading, error) {
+	var in ingestReading
+	if trimmed := bytes.TrimSpace(payload); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &in); err != nil {
+			return in, fmt.Errorf("invalid JSON payload: %v", err)
+		}
+	} else {
+		value, err := parseDecimal(string(trimmed))
+		if err != nil {
+			return in, err
+		}
+		percentage := int(math.Round(value))
+		in.Percentage = &percentage
+	}
+	return in, in.validate(now)
+}
+
+// ingestMQTT returns a message handler that stores every valid sensor message as a reading
+func ingestMQTT(store *readingStore) mqtt.MessageHandler {
+	return func(_ mqtt.Client, msg mqtt.Message) {
+		in, err := parseMQTTReading(msg.Payload(), time.Now())
+		if err != nil {
+			slog.Warn("Ignoring an MQTT message", "topic", msg.Topic(), "error", err)
+			return
+		}
+		_, err = store.store(context.Background(), *in.Timestamp, *in.Percentage)
+		if err != nil && !errors.Is(err, errDuplicateReading) {
+			slog.Error("Error inserting reading", "error", err)
+		}
+	}
+}
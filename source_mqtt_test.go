@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMQTTReading(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		payload    string
+		percentage int
+		timestamp  time.Time
+		err        string
+	}{
+		{name: "bare percentage", payload: "37", percentage: 37, timestamp: now},
+		{name: "decimal", payload: " 37.5\n", percentage: 38, timestamp: now},
+		{name: "decimal comma", payload: "12,4 %", percentage: 12, timestamp: now},
+		{name: "JSON", payload: `{"percentage": 42, "timestamp": "2024-05-01T11:55:00Z"}`, percentage: 42, timestamp: now.Add(-5 * time.Minute)},
+		{name: "JSON without timestamp", payload: `{"percentage": 42}`, percentage: 42, timestamp: now},
+		{name: "malformed JSON", payload: `{"percentage": }`, err: "invalid JSON payload: invalid character '}' looking for beginning of value"},
+		{name: "not a number", payload: "closed", err: "'closed' is not a number"},
+		{name: "out of range", payload: "180", err: "'percentage' must be between 0 and 100"},
+		{name: "JSON without percentage", payload: `{"timestamp": "2024-05-01T11:55:00Z"}`, err: "'percentage' is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := parseMQTTReading([]byte(tt.payload), now)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *in.Percentage != tt.percentage || !in.Timestamp.Equal(tt.timestamp) {
+				t.Errorf("got %d%% at %v, want %d%% at %v", *in.Percentage, in.Timestamp, tt.percentage, tt.timestamp)
+			}
+		})
+	}
+}
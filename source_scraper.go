@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultScrapePattern finds the first percentage on an HTML or text page
+const defaultScrapePattern = `(\d+(?:[.,]\d+)?)\s*%`
+
+// maxScrapeBytes bounds how much of the operator's response is read
+const maxScrapeBytes = 4 << 20
+
+// scraperConfig is read from SCRAPE_URL, SCRAPE_INTERVAL (default 15m), and either SCRAPE_JSON_PATH,
+// a dot-separated path to a number in a JSON response, or SCRAPE_PATTERN, a regular expression whose
+// first group captures the percentage; the scraper is disabled without a URL
+type scraperConfig struct {
+	URL      string
+	Interval time.Duration
+	JSONPath []string
+	Pattern  *regexp.Regexp
+}
+
+func getScraperConfig() (scraperConfig, error) {
+	cfg := scraperConfig{URL: os.Getenv("SCRAPE_URL"), Interval: defaultSamplingPeriod}
+	if value := os.Getenv("SCRAPE_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Second {
+			return cfg, fmt.Errorf("invalid SCRAPE_INTERVAL %q: expected a duration of at least 1s", value)
+		}
+		cfg.Interval = interval
+	}
+	if path := os.Getenv("SCRAPE_JSON_PATH"); path != "" {
+		cfg.JSONPath = strings.Split(path, ".")
+		return cfg, nil
+	}
+	pattern := os.Getenv("SCRAPE_PATTERN")
+	if pattern == "" {
+		pattern = defaultScrapePattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid SCRAPE_PATTERN: %v", err)
+	}
+	if re.NumSubexp() < 1 {
+		return cfg, fmt.Errorf("invalid SCRAPE_PATTERN: it needs a group capturing the percentage")
+	}
+	cfg.Pattern = re
+	return cfg, nil
+}
+
+// parseDecimal parses a number as written on web pages and in spreadsheets, e.g. "37", "37.5 %"
+// or "37,5" with a decimal comma as used on many European pages
+func parseDecimal(value string) (float64, error) {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	n, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a number", value)
+	}
+	return n, nil
+}
+
+func init() {
+	registerSource("scraper", newScraper)
+}
+
+// scraper periodically fetches the operator's occupancy page and stores the percentage it shows
+type scraper struct {
+	cfg    scraperConfig
+	client *http.Client
+}
+
+// newScraper configures the scraper source; it is not configured without SCRAPE_URL
+func newScraper() (Source, error) {
+	cfg, err := getScraperConfig()
+	if err != nil || cfg.URL == "" {
+		return nil, err
+	}
+	return &scraper{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+func (s *scraper) Describe() string {
+	return fmt.Sprintf("scraping %s every %s", s.cfg.URL, s.cfg.Interval)
+}
+
+// Run scrapes once immediately and then on every interval until ctx is cancelled
+func (s *scraper) Run(ctx context.Context, store *readingStore) error {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.scrape(ctx, store); err != nil {
+			slog.Error("Error scraping occupancy", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrape fetches, parses and stores a single reading
+func (s *scraper) scrape(ctx context.Context, store *readingStore) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "pool-api-scraper")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxScrapeBytes))
+	if err != nil {
+		return fmt.Errorf("unable to read response: %v", err)
+	}
+
+	value, err := s.parse(body)
+	if err != nil {
+		return err
+	}
+	percentage := int(math.Round(value))
+	if percentage < 0 || percentage > 100 {
+		return fmt.Errorf("scraped percentage %v is out of range", value)
+	}
+	// Retries after a timeout often return the reading that was already stored
+	_, err = store.store(ctx, time.Now(), percentage)
+	if errors.Is(err, errDuplicateReading) {
+		return nil
+	}
+	return err
+}
+
+// parse extracts the percentage from the response body
+func (s *scraper) parse(body []byte) (float64, error) {
+	if s.cfg.JSONPath != nil {
+		var doc any
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return 0, fmt.Errorf("unable to decode JSON response: %v", err)
+		}
+		for _, key := range s.cfg.JSONPath {
+			object, ok := doc.(map[string]any)
+			if !ok {
+				return 0, fmt.Errorf("SCRAPE_JSON_PATH does not match the response at %q", key)
+			}
+			doc = object[key]
+		}
+		switch v := doc.(type) {
+		case float64:
+			return v, nil
+		case string:
+			return parseDecimal(v)
+		}
+		return 0, fmt.Errorf("SCRAPE_JSON_PATH does not point to a number")
+	}
+
+	match := s.cfg.Pattern.FindSubmatch(body)
+	if match == nil {
+		return 0, fmt.Errorf("SCRAPE_PATTERN does not match the response")
+	}
+	return parseDecimal(string(match[1]))
+}
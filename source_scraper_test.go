@@ -0,0 +1,106 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+		err   bool
+	}{
+		{value: "37", want: 37},
+		{value: "37.5", want: 37.5},
+		{value: "37,5", want: 37.5},
+		{value: " 37.5 % ", want: 37.5},
+		{value: "100%", want: 100},
+		{value: "full", err: true},
+		{value: "1,000,5", err: true},
+		{value: "", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseDecimal(tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScraperParse(t *testing.T) {
+	defaultPattern := regexp.MustCompile(defaultScrapePattern)
+	tests := []struct {
+		name string
+		cfg  scraperConfig
+		body string
+		want float64
+		err  string
+	}{
+		{name: "page", cfg: scraperConfig{Pattern: defaultPattern}, body: `<p>Auslastung: <b>42,5 %</b></p>`, want: 42.5},
+		{name: "first percentage", cfg: scraperConfig{Pattern: defaultPattern}, body: `Pool 31% · Sauna 80%`, want: 31},
+		{name: "custom pattern", cfg: scraperConfig{Pattern: regexp.MustCompile(`occupancy="(\d+)"`)}, body: `<div occupancy="12">`, want: 12},
+		{name: "no match", cfg: scraperConfig{Pattern: defaultPattern}, body: `Closed today`, err: "SCRAPE_PATTERN does not match the response"},
+		{name: "JSON number", cfg: scraperConfig{JSONPath: []string{"pool", "occupancy"}}, body: `{"pool": {"occupancy": 64}}`, want: 64},
+		{name: "JSON string", cfg: scraperConfig{JSONPath: []string{"load"}}, body: `{"load": "64,2%"}`, want: 64.2},
+		{name: "JSON path missing", cfg: scraperConfig{JSONPath: []string{"pool", "occupancy"}}, body: `{"pool": 64}`, err: `SCRAPE_JSON_PATH does not match the response at "occupancy"`},
+		{name: "JSON not a number", cfg: scraperConfig{JSONPath: []string{"pool"}}, body: `{"pool": {"occupancy": 64}}`, err: "SCRAPE_JSON_PATH does not point to a number"},
+		{name: "not JSON", cfg: scraperConfig{JSONPath: []string{"pool"}}, body: `<html>`, err: "unable to decode JSON response: invalid character '<' looking for beginning of value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&scraper{cfg: tt.cfg}).parse([]byte(tt.body))
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetScraperConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  string
+	}{
+		{name: "defaults", env: map[string]string{"SCRAPE_URL": "https://example.com"}},
+		{name: "JSON path", env: map[string]string{"SCRAPE_JSON_PATH": "a.b"}},
+		{name: "short interval", env: map[string]string{"SCRAPE_INTERVAL": "100ms"}, err: `invalid SCRAPE_INTERVAL "100ms": expected a duration of at least 1s`},
+		{name: "invalid pattern", env: map[string]string{"SCRAPE_PATTERN": "(\\d+"}, err: "invalid SCRAPE_PATTERN: error parsing regexp: missing closing ): `(\\d+`"},
+		{name: "pattern without a group", env: map[string]string{"SCRAPE_PATTERN": "\\d+%"}, err: "invalid SCRAPE_PATTERN: it needs a group capturing the percentage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"SCRAPE_URL", "SCRAPE_INTERVAL", "SCRAPE_JSON_PATH", "SCRAPE_PATTERN"} {
+				t.Setenv(name, tt.env[name])
+			}
+			_, err := getScraperConfig()
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxWebhookPayloadBytes bounds the size of a payload POSTed by a third-party system
+const maxWebhookPayloadBytes = 1 << 20
+
+var webhookSourceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// WebhookSource is a third-party system that POSTs its own JSON to /ingest/webhook/{name}. The
+// templates are Go text/templates evaluated against the decoded payload, e.g. {{.data.occupancy}}
+// or {{percent .visitors 350}}; the timestamp template may be empty to use the time of arrival.
+type WebhookSource struct {
+	Name               string    `json:"name"`
+	PercentageTemplate string    `json:"percentage_template"`
+	TimestampTemplate  string    `json:"timestamp_template"`
+	CreatedAt          time.Time `json:"created_at"`
+	// Token is only returned when the source is created
+	Token string `json:"token,omitempty"`
+}
+
+// templateFuncs are available in mapping templates for payloads that report counts instead of percentages
+var templateFuncs = template.FuncMap{
+	"add": func(a, b any) (float64, error) {
+		return applyNumbers(a, b, func(x, y float64) float64 { return x + y })
+	},
+	"sub": func(a, b any) (float64, error) {
+		return applyNumbers(a, b, func(x, y float64) float64 { return x - y })
+	},
+	"mul": func(a, b any) (float64, error) {
+		return applyNumbers(a, b, func(x, y float64) float64 { return x * y })
+	},
+	"div": func(a, b any) (float64, error) {
+		return applyNumbers(a, b, func(x, y float64) float64 { return x / y })
+	},
+	// percent converts a count and a capacity into a percentage
+	"percent": func(count, capacity any) (float64, error) {
+		return applyNumbers(count, capacity, func(x, y float64) float64 { return x / y * 100 })
+	},
+}
+
+// applyNumbers converts template values, which may be JSON numbers or numeric strings, and applies op
+func applyNumbers(a, b any, op func(x, y float64) float64) (float64, error) {
+	x, err := templateNumber(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := templateNumber(b)
+	if err != nil {
+		return 0, err
+	}
+	result := op(x, y)
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return 0, fmt.Errorf("the result is not a number")
+	}
+	return result, nil
+}
+
+func templateNumber(v any) (float64, error) {
+	switch n := v.(type) {
+	case json.Number:
+		return n.Float64()
+	case float64:
+		return n, nil
+	case int:
+		return float64(n), nil
+	case string:
+		return parseDecimal(n)
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// parseMappingTemplate compiles a mapping template; missing payload keys are errors rather than "<no value>"
+func parseMappingTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// hashToken returns the hex SHA-256 of a source token; only the hash is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// mapWebhookPayload evaluates the source's templates against the payload
+func mapWebhookPayload(source WebhookSource, payload any, loc *time.Location, now time.Time) (ingestReading, error) {
+	var in ingestReading
+	render := func(name, text string) (string, error) {
+		tmpl, err := parseMappingTemplate(name, text)
+		if err != nil {
+			return "", err
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, payload); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(out.String()), nil
+	}
+
+	value, err := render("percentage", source.PercentageTemplate)
+	if err != nil {
+		return in, fmt.Errorf("unable to map the percentage: %v", err)
+	}
+	n, err := parseDecimal(value)
+	if err != nil {
+		return in, fmt.Errorf("unable to map the percentage: %v", err)
+	}
+	percentage := int(math.Round(n))
+	in.Percentage = &percentage
+
+	if source.TimestampTemplate != "" {
+		value, err := render("timestamp", source.TimestampTemplate)
+		if err != nil {
+			return in, fmt.Errorf("unable to map the timestamp: %v", err)
+		}
+		t, err := csvMapping{timeFormat: "auto", loc: loc}.parseTimestamp(value)
+		if err != nil {
+			return in, fmt.Errorf("unable to map the timestamp: %v", err)
+		}
+		in.Timestamp = &t
+	}
+	return in, in.validate(now)
+}
+
+// getWebhookIngestHandler handles POST /ingest/webhook/{name}. Systems that cannot set an
+// Authorization header may pass their token as the 'token' query parameter instead.
+func getWebhookIngestHandler(store *readingStore, poolLocation *time.Location) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var source WebhookSource
+		var tokenHash string
+		err := store.pool.QueryRow(r.Context(),
+			"SELECT name, token_hash, percentage_template, timestamp_template FROM webhook_sources WHERE name = $1", r.PathValue("name")).
+			Scan(&source.Name, &tokenHash, &source.PercentageTemplate, &source.TimestampTemplate)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			writeQueryError(w, r, err)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		// Unknown sources and wrong tokens look the same to the caller
+		if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(tokenHash)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayloadBytes))
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		var payload any
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&payload); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		in, err := mapWebhookPayload(source, payload, poolLocation, time.Now())
+		if err != nil {
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				err = &ValidationError{Message: err.Error(), Errors: []FieldError{}}
+			}
+			writeBodyError(w, err)
+			return
+		}
+
+		dp, err := store.store(withActorContext(r.Context(), "webhook-source:"+source.Name), *in.Timestamp, *in.Percentage)
+		switch {
+		case errors.Is(err, errDuplicateReading):
+			writeJSON(w, dp)
+		case errors.Is(err, errConflictingReading):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, errSpikeReading):
+			writeBodyError(w, store.spikes.rejection())
+		case err != nil:
+			http.Error(w, "Failed to store the reading", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error inserting reading", "error", err)
+		default:
+			writeJSONStatus(w, http.StatusCreated, dp)
+		}
+	}
+}
+
+// getCreateWebhookSourceHandler handles POST /ingest/sources; the response contains the source's
+// token, which is not shown again
+func getCreateWebhookSourceHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var source WebhookSource
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&source); err != nil {
+			writeBodyError(w, decodeError(err))
+			return
+		}
+		var errs fieldErrors
+		if !webhookSourceName.MatchString(source.Name) {
+			errs.add("name", "must consist of lowercase letters, digits, '-' and '_'")
+		}
+		if source.PercentageTemplate == "" {
+			errs.add("percentage_template", "is required")
+		} else if _, err := parseMappingTemplate("percentage", source.PercentageTemplate); err != nil {
+			errs.add("percentage_template", err.Error())
+		}
+		if _, err := parseMappingTemplate("timestamp", source.TimestampTemplate); err != nil {
+			errs.add("timestamp_template", err.Error())
+		}
+		if err := errs.err(); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		token := make([]byte, 32)
+		if _, err := rand.Read(token); err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error generating source token", "error", err)
+			return
+		}
+		source.Token = hex.EncodeToString(token)
+		err := pool.QueryRow(r.Context(), `INSERT INTO webhook_sources (name, token_hash, percentage_template, timestamp_template)
+			VALUES ($1, $2, $3, $4) RETURNING created_at`,
+			source.Name, hashToken(source.Token), source.PercentageTemplate, source.TimestampTemplate).Scan(&source.CreatedAt)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			http.Error(w, fmt.Sprintf("A source named '%s' already exists", source.Name), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to store the source", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error inserting webhook source", "error", err)
+			return
+		}
+
+		logged := source
+		logged.Token = ""
+		logAudit(r.Context(), pool, auditSourceCreate, source.Name, nil, logged)
+		writeJSONStatus(w, http.StatusCreated, source)
+	}
+}
+
+// getListWebhookSourcesHandler handles GET /ingest/sources
+func getListWebhookSourcesHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := pool.Query(r.Context(),
+			"SELECT name, percentage_template, timestamp_template, created_at FROM webhook_sources ORDER BY name")
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		sources := []WebhookSource{}
+		for rows.Next() {
+			var source WebhookSource
+			if err := rows.Scan(&source.Name, &source.PercentageTemplate, &source.TimestampTemplate, &source.CreatedAt); err != nil {
+				http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+				return
+			}
+			sources = append(sources, source)
+		}
+		writeJSON(w, sources)
+	}
+}
+
+// getDeleteWebhookSourceHandler handles DELETE /ingest/sources/{name}; readings it stored are kept
+func getDeleteWebhookSourceHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tag, err := pool.Exec(r.Context(), "DELETE FROM webhook_sources WHERE name = $1", r.PathValue("name"))
+		if err != nil {
+			http.Error(w, "Failed to delete the source", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error deleting webhook source", "error", err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Source not found", http.StatusNotFound)
+			return
+		}
+		logAudit(r.Context(), pool, auditSourceDelete, r.PathValue("name"), nil, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMapWebhookPayload(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		percentage string
+		timestamp  string
+		payload    string
+		want       int
+		wantTime   time.Time
+		err        string
+	}{
+		{name: "field", percentage: "{{.data.occupancy}}", payload: `{"data": {"occupancy": 42}}`, want: 42, wantTime: now},
+		{name: "rounded", percentage: "{{.occupancy}}", payload: `{"occupancy": 41.5}`, want: 42, wantTime: now},
+		{name: "numeric string", percentage: "{{.occupancy}}", payload: `{"occupancy": "37,5"}`, want: 38, wantTime: now},
+		{name: "percent", percentage: "{{percent .visitors 350}}", payload: `{"visitors": 70}`, want: 20, wantTime: now},
+		{name: "arithmetic", percentage: "{{mul (div .in .capacity) 100}}", payload: `{"in": "30", "capacity": 120}`, want: 25, wantTime: now},
+		{
+			name:       "timestamp",
+			percentage: "{{.occupancy}}",
+			timestamp:  "{{.time}}",
+			payload:    `{"occupancy": 10, "time": "2024-05-01T11:30:00Z"}`,
+			want:       10,
+			wantTime:   time.Date(2024, 5, 1, 11, 30, 0, 0, time.UTC),
+		},
+		{
+			name:       "unix timestamp",
+			percentage: "{{.occupancy}}",
+			timestamp:  "{{.ts}}",
+			payload:    `{"occupancy": 10, "ts": 1714563000}`,
+			want:       10,
+			wantTime:   time.Unix(1714563000, 0),
+		},
+		{name: "missing key", percentage: "{{.occupancy}}", payload: `{"visitors": 3}`, err: `unable to map the percentage: template: percentage:1:2: executing "percentage" at <.occupancy>: map has no entry for key "occupancy"`},
+		{name: "not a number", percentage: "{{.status}}", payload: `{"status": "open"}`, err: "unable to map the percentage: 'open' is not a number"},
+		{name: "division by zero", percentage: "{{percent .visitors .capacity}}", payload: `{"visitors": 3, "capacity": 0}`, err: `unable to map the percentage: template: percentage:1:2: executing "percentage" at <percent .visitors .capacity>: error calling percent: the result is not a number`},
+		{name: "bad timestamp", percentage: "{{.occupancy}}", timestamp: "{{.time}}", payload: `{"occupancy": 10, "time": "noon"}`, err: "unable to map the timestamp: 'noon' is not in a known timestamp format"},
+		{name: "out of range", percentage: "{{.occupancy}}", payload: `{"occupancy": 180}`, err: "'percentage' must be between 0 and 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload any
+			decoder := json.NewDecoder(strings.NewReader(tt.payload))
+			decoder.UseNumber()
+			if err := decoder.Decode(&payload); err != nil {
+				t.Fatal(err)
+			}
+			source := WebhookSource{Name: "counter", PercentageTemplate: tt.percentage, TimestampTemplate: tt.timestamp}
+			got, err := mapWebhookPayload(source, payload, time.UTC, now)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got.Percentage != tt.want {
+				t.Errorf("got percentage %d, want %d", *got.Percentage, tt.want)
+			}
+			if !got.Timestamp.Equal(tt.wantTime) {
+				t.Errorf("got timestamp %v, want %v", *got.Timestamp, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestCreateWebhookSourceHandlerRejectsBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "unknown field",
+			body: `{"name": "counter", "percentage_template": "{{.occupancy}}", "token": "secret", "capacity": 350}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"capacity","message":"is not a known field"}]}`,
+		},
+		{
+			name: "invalid name",
+			body: `{"name": "Front Door", "percentage_template": "{{.occupancy}}"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"name","message":"must consist of lowercase letters, digits, '-' and '_'"}]}`,
+		},
+		{
+			name: "template missing",
+			body: `{"name": "counter"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"percentage_template","message":"is required"}]}`,
+		},
+		{
+			name: "invalid templates",
+			body: `{"name": "counter", "percentage_template": "{{.occupancy", "timestamp_template": "{{ago .time}}"}`,
+			want: `{"message":"The request body is invalid","errors":[{"field":"percentage_template","message":"template: percentage:1: unclosed action"},{"field":"timestamp_template","message":"template: timestamp:1: function \"ago\" not defined"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getCreateWebhookSourceHandler(nil)(w, httptest.NewRequest("POST", "/ingest/sources", strings.NewReader(tt.body)))
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("got body %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
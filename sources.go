@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// Source is an ingestion adapter that collects readings from one data feed, such as an
+// operator's web page, an MQTT topic or a drop directory, and writes them through the store.
+// Adapters register a factory from an init function in their own source_*.go file, so a new
+// feed does not require changes to the server itself.
+type Source interface {
+	// Describe returns a short description of what the source is collecting from, for the log
+	Describe() string
+	// Run collects readings until ctx is cancelled
+	Run(ctx context.Context, store *readingStore) error
+}
+
+// sourceFactory builds a source from its configuration; it returns nil if the source is not configured
+type sourceFactory func() (Source, error)
+
+// sourceFactories holds every registered adapter by name
+var sourceFactories = map[string]sourceFactory{}
+
+// registerSource makes an adapter available; it panics on duplicate names
+func registerSource(name string, factory sourceFactory) {
+	if _, ok := sourceFactories[name]; ok {
+		panic("source " + name + " registered twice")
+	}
+	sourceFactories[name] = factory
+}
+
+// configuredSources builds every registered source that is configured, keyed by name
+func configuredSources() (map[string]Source, error) {
+	sources := map[string]Source{}
+	for _, name := range slices.Sorted(maps.Keys(sourceFactories)) {
+		source, err := sourceFactories[name]()
+		if err != nil {
+			return nil, fmt.Errorf("unable to configure source %s: %v", name, err)
+		}
+		if source != nil {
+			sources[name] = source
+		}
+	}
+	return sources, nil
+}
+
+// runSources starts every source in its own goroutine; a source that fails is logged and not restarted
+func runSources(ctx context.Context, sources map[string]Source, store *readingStore) {
+	for _, name := range slices.Sorted(maps.Keys(sources)) {
+		source := sources[name]
+		slog.Info("Starting source", "source", name, "description", source.Describe())
+		go func() {
+			if err := source.Run(ctx, store); err != nil && ctx.Err() == nil {
+				slog.Error("Source stopped", "source", name, "error", err)
+			}
+		}()
+	}
+}
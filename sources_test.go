@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegisterSourceTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a source name twice did not panic")
+		}
+	}()
+	registerSource("file", newFileSource)
+}
+
+func TestNewFileSource(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		dir      string
+		interval string
+		want     time.Duration
+		err      string
+	}{
+		{name: "not configured"},
+		{name: "default interval", dir: dir, want: defaultInboxInterval},
+		{name: "interval", dir: dir, interval: "10s", want: 10 * time.Second},
+		{name: "short interval", dir: dir, interval: "10ms", err: `invalid INBOX_INTERVAL "10ms": expected a duration of at least 1s`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("INBOX_DIR", tt.dir)
+			t.Setenv("INBOX_INTERVAL", tt.interval)
+			source, err := newFileSource()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.dir == "" {
+				if source != nil {
+					t.Fatalf("got %v, want no source", source)
+				}
+				return
+			}
+			if got := source.(*fileSource).interval; got != tt.want {
+				t.Errorf("got interval %v, want %v", got, tt.want)
+			}
+			for _, sub := range []string{"processed", "failed"} {
+				if info, err := os.Stat(filepath.Join(tt.dir, sub)); err != nil || !info.IsDir() {
+					t.Errorf("%s directory not created: %v", sub, err)
+				}
+			}
+		})
+	}
+}
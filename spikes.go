@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Spike handling modes, selected with SPIKE_MODE
+const (
+	spikeOff = "off"
+	// spikeFlag stores implausible jumps marked as suspect; queries leave suspect readings out, and
+	// a suspect reading is cleared again once the next reading confirms the new level
+	spikeFlag = "flag"
+	// spikeReject refuses implausible jumps; only suitable for pools without genuine sudden changes,
+	// since a real jump is only accepted once SPIKE_WINDOW has passed without a plausible reading
+	spikeReject = "reject"
+)
+
+// errSpikeReading means the reading jumps implausibly far from the previous one
+var errSpikeReading = errors.New("the reading changes implausibly fast")
+
+// spikeConfig is read from SPIKE_MODE (default off), SPIKE_MAX_DELTA, the largest plausible change
+// in percentage points (default 40), and SPIKE_WINDOW, how far back the previous reading may lie to
+// be compared with (default 30m)
+type spikeConfig struct {
+	mode     string
+	maxDelta int
+	window   time.Duration
+}
+
+func getSpikeConfig() (spikeConfig, error) {
+	cfg := spikeConfig{mode: os.Getenv("SPIKE_MODE"), maxDelta: 40, window: 30 * time.Minute}
+	switch cfg.mode {
+	case "":
+		cfg.mode = spikeOff
+	case spikeOff, spikeFlag, spikeReject:
+	default:
+		return cfg, fmt.Errorf("invalid SPIKE_MODE %q: expected off, flag or reject", cfg.mode)
+	}
+	if value := os.Getenv("SPIKE_MAX_DELTA"); value != "" {
+		delta, err := strconv.Atoi(value)
+		if err != nil || delta < 1 || delta > 100 {
+			return cfg, fmt.Errorf("invalid SPIKE_MAX_DELTA %q: expected an integer between 1 and 100", value)
+		}
+		cfg.maxDelta = delta
+	}
+	if value := os.Getenv("SPIKE_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return cfg, fmt.Errorf("invalid SPIKE_WINDOW %q: expected a positive duration", value)
+		}
+		cfg.window = window
+	}
+	return cfg, nil
+}
+
+// checkSpike compares a new reading with the previous plausible one within the window. It returns
+// whether the reading is suspect, or errSpikeReading in reject mode. A suspect previous reading that
+// the new one agrees with is cleared first, since the level really changed, and is published as
+// it had been held back from the hub.
+func (s *readingStore) checkSpike(ctx context.Context, timestamp time.Time, percentage int) (bool, error) {
+	if s.spikes.mode == "" || s.spikes.mode == spikeOff {
+		return false, nil
+	}
+
+	const previousQuery = `SELECT id, timestamp, percentage, suspect FROM pool_usage
+		WHERE timestamp < $1 AND timestamp >= $1 - make_interval(secs => $2)%s
+		ORDER BY timestamp DESC, id DESC LIMIT 1`
+	var previous DataPoint
+	var suspect bool
+	err := s.pool.QueryRow(ctx, fmt.Sprintf(previousQuery, ""), timestamp, s.spikes.window.Seconds()).
+		Scan(&previous.ID, &previous.Timestamp, &previous.Percentage, &suspect)
+	if err == nil && suspect {
+		if abs(percentage-previous.Percentage) <= s.spikes.maxDelta {
+			if _, err := s.pool.Exec(ctx, "UPDATE pool_usage SET suspect = false WHERE id = $1", previous.ID); err != nil {
+				return false, err
+			}
+			s.cache.invalidate()
+			s.readings.publish(previous)
+			return false, nil
+		}
+		err = s.pool.QueryRow(ctx, fmt.Sprintf(previousQuery, " AND NOT suspect"), timestamp, s.spikes.window.Seconds()).
+			Scan(&previous.ID, &previous.Timestamp, &previous.Percentage, &suspect)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if abs(percentage-previous.Percentage) <= s.spikes.maxDelta {
+		return false, nil
+	}
+	if s.spikes.mode == spikeReject {
+		return false, errSpikeReading
+	}
+	return true, nil
+}
+
+// rejection is the field error a reading rejected as a spike is answered with, as a body error
+func (c spikeConfig) rejection() error {
+	var errs fieldErrors
+	errs.add("percentage", fmt.Sprintf("must not differ by more than %d points from the previous reading", c.maxDelta))
+	return errs.err()
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
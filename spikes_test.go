@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetSpikeConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		maxDelta string
+		window   string
+		want     spikeConfig
+		err      string
+	}{
+		{name: "defaults", want: spikeConfig{mode: spikeOff, maxDelta: 40, window: 30 * time.Minute}},
+		{name: "flag", mode: "flag", want: spikeConfig{mode: spikeFlag, maxDelta: 40, window: 30 * time.Minute}},
+		{name: "reject", mode: "reject", maxDelta: "25", window: "1h", want: spikeConfig{mode: spikeReject, maxDelta: 25, window: time.Hour}},
+		{name: "unknown mode", mode: "drop", err: `invalid SPIKE_MODE "drop": expected off, flag or reject`},
+		{name: "delta of 0", mode: "flag", maxDelta: "0", err: `invalid SPIKE_MAX_DELTA "0": expected an integer between 1 and 100`},
+		{name: "delta above 100", mode: "flag", maxDelta: "101", err: `invalid SPIKE_MAX_DELTA "101": expected an integer between 1 and 100`},
+		{name: "window of 0", mode: "flag", window: "0s", err: `invalid SPIKE_WINDOW "0s": expected a positive duration`},
+		{name: "window without unit", mode: "flag", window: "30", err: `invalid SPIKE_WINDOW "30": expected a positive duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SPIKE_MODE", tt.mode)
+			t.Setenv("SPIKE_MAX_DELTA", tt.maxDelta)
+			t.Setenv("SPIKE_WINDOW", tt.window)
+			got, err := getSpikeConfig()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpikeRejection(t *testing.T) {
+	err := spikeConfig{mode: spikeReject, maxDelta: 25}.rejection()
+	want := "'percentage' must not differ by more than 25 points from the previous reading"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+}
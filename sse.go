@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// sseHeartbeat is how often a comment line is sent so proxies do not close idle streams
+	sseHeartbeat = 30 * time.Second
+	// maxSSECatchUp bounds how many missed readings are replayed after a reconnect
+	maxSSECatchUp = 1000
+)
+
+// writeSSE writes one reading as a server-sent event whose id is the reading id
+func writeSSE(w http.ResponseWriter, dp DataPoint) error {
+	data, err := json.Marshal(dp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: reading\nid: %d\ndata: %s\n\n", dp.ID, data)
+	return err
+}
+
+// getEventsHandler handles /events, a text/event-stream of new readings. A new client first
+// receives the latest reading; a reconnecting EventSource sends Last-Event-ID and receives the
+// readings it missed instead. Clients that cannot set headers may pass last_event_id.
+func getEventsHandler(pool *pgxpool.Pool, readings *hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = r.URL.Query().Get("last_event_id")
+		}
+		lastID := -1
+		if lastEventID != "" {
+			id, err := strconv.Atoi(lastEventID)
+			if err != nil || id < 0 {
+				http.Error(w, "invalid Last-Event-ID: expected a reading id", http.StatusBadRequest)
+				return
+			}
+			lastID = id
+		}
+
+		// Subscribe before catching up so no reading falls in between
+		points, unsubscribe := readings.subscribe()
+		defer unsubscribe()
+		var backlog []DataPoint
+		if lastID >= 0 {
+			rows, err := pool.Query(r.Context(),
+				"SELECT id, timestamp, percentage FROM pool_usage WHERE id > $1 AND NOT suspect ORDER BY id LIMIT $2", lastID, maxSSECatchUp)
+			if err == nil {
+				backlog, err = scanDataPoints(rows)
+				rows.Close()
+			}
+			if err != nil {
+				writeQueryError(w, r, err)
+				return
+			}
+		} else {
+			latest, err := latestReading(r.Context(), pool)
+			if err != nil {
+				writeQueryError(w, r, err)
+				return
+			}
+			if latest != nil {
+				backlog = []DataPoint{*latest}
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Ask nginx not to buffer the stream
+		w.Header().Set("X-Accel-Buffering", "no")
+		rc := http.NewResponseController(w)
+		fmt.Fprintf(w, "retry: %d\n\n", 5000)
+		for _, dp := range backlog {
+			if err := writeSSE(w, dp); err != nil {
+				return
+			}
+			lastID = dp.ID
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case dp, ok := <-points:
+				if !ok {
+					return
+				}
+				if dp.ID <= lastID {
+					continue
+				}
+				if err := writeSSE(w, dp); err != nil {
+					return
+				}
+				lastID = dp.ID
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSSE(t *testing.T) {
+	w := httptest.NewRecorder()
+	dp := DataPoint{ID: 42, Timestamp: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), Percentage: 37}
+	if err := writeSSE(w, dp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "event: reading\nid: 42\ndata: {\"id\":42,\"timestamp\":\"2024-05-01T10:00:00Z\",\"percentage\":37}\n\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEventsHandlerRejectsLastEventID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		query  string
+	}{
+		{name: "header", header: "latest"},
+		{name: "negative header", header: "-1"},
+		{name: "query parameter", query: "last_event_id=abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/events?"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("Last-Event-ID", tt.header)
+			}
+			w := httptest.NewRecorder()
+			getEventsHandler(nil, newHub())(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got, want := strings.TrimSpace(w.Body.String()), "invalid Last-Event-ID: expected a reading id"; got != want {
+				t.Errorf("got body %q, want %q", got, want)
+			}
+		})
+	}
+}
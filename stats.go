@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Stats summarizes the percentage readings over a time range; the values are null when there are no readings
+type Stats struct {
+	Count  int      `json:"count"`
+	Min    *int     `json:"min"`
+	Max    *int     `json:"max"`
+	Mean   *float64 `json:"mean"`
+	Median *float64 `json:"median"`
+	StdDev *float64 `json:"stddev"`
+}
+
+// getStatsHandler handles the /pool-data/stats endpoint and returns summary statistics for the requested range
+func getStatsHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		filter, err := parseRangeFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := addPercentageFilter(r, filter); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s, err := queryStats(r.Context(), pool, filter)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		writeJSON(w, s)
+	}
+}
+
+// queryStats computes summary statistics over the readings matching filter
+func queryStats(ctx context.Context, db querier, filter *queryFilter) (Stats, error) {
+	query := `SELECT count(*), min(percentage), max(percentage), avg(percentage)::float8,
+		percentile_cont(0.5) WITHIN GROUP (ORDER BY percentage), stddev_pop(percentage)::float8
+		FROM pool_usage` + filter.where()
+	var s Stats
+	err := db.QueryRow(ctx, query, filter.args...).Scan(&s.Count, &s.Min, &s.Max, &s.Mean, &s.Median, &s.StdDev)
+	return s, err
+}
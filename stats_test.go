@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeQuerier answers every query with the same rows and records the last statement
+type fakeQuerier struct {
+	rows [][]any
+	err  error
+	sql  string
+	args []any
+}
+
+func (q *fakeQuerier) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	q.sql, q.args = sql, args
+	if q.err != nil {
+		return nil, q.err
+	}
+	return &fakeRows{rows: q.rows}, nil
+}
+
+func (q *fakeQuerier) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	q.sql, q.args = sql, args
+	return pgconn.CommandTag{}, q.err
+}
+
+func (q *fakeQuerier) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	rows, err := q.Query(ctx, sql, args...)
+	return fakeRow{rows: rows, err: err}
+}
+
+// fakeRows yields fixed rows, scanning each value into the destination of its column
+type fakeRows struct {
+	rows    [][]any
+	current int
+}
+
+func (r *fakeRows) Next() bool {
+	r.current++
+	return r.current <= len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	for i, d := range dest {
+		target := reflect.ValueOf(d).Elem()
+		value := r.rows[r.current-1][i]
+		switch {
+		case value == nil:
+			target.SetZero()
+		case target.Kind() == reflect.Pointer:
+			// A nullable column
+			p := reflect.New(target.Type().Elem())
+			p.Elem().Set(reflect.ValueOf(value).Convert(target.Type().Elem()))
+			target.Set(p)
+		default:
+			target.Set(reflect.ValueOf(value).Convert(target.Type()))
+		}
+	}
+	return nil
+}
+
+func (r *fakeRows) Values() ([]any, error)                       { return r.rows[r.current-1], nil }
+func (r *fakeRows) Close()                                       {}
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+// fakeRow is the first of the rows of a query, as QueryRow returns it
+type fakeRow struct {
+	rows pgx.Rows
+	err  error
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	if !r.rows.Next() {
+		return pgx.ErrNoRows
+	}
+	return r.rows.Scan(dest...)
+}
+
+func TestStatsHandlerRejectsParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{name: "from after to", query: "from=2024-05-02T00:00:00Z&to=2024-05-01T00:00:00Z", body: "'from' must be before 'to'"},
+		{name: "from and last", query: "from=2024-05-01T00:00:00Z&last=24h", body: "'from' and 'last' are mutually exclusive"},
+		{name: "invalid from", query: "from=yesterday", body: "invalid 'from' parameter: expected an RFC3339 timestamp"},
+		{name: "percentage out of range", query: "min_percentage=101", body: "invalid 'min_percentage' parameter: expected an integer between 0 and 100"},
+		{name: "inverted percentages", query: "min_percentage=60&max_percentage=40", body: "'min_percentage' must not be greater than 'max_percentage'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getStatsHandler(&readPool{})(w, httptest.NewRequest("GET", "/pool-data/stats?"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	from, to := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		row  []any
+		want string
+	}{
+		{name: "readings", row: []any{4, 10, 80, 45.0, 42.5, 25.5}, want: `{"count":4,"min":10,"max":80,"mean":45,"median":42.5,"stddev":25.5}`},
+		// Aggregates over no rows are NULL, except for the count
+		{name: "empty range", row: []any{0, nil, nil, nil, nil, nil}, want: `{"count":0,"min":null,"max":null,"mean":null,"median":null,"stddev":null}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeQuerier{rows: [][]any{tt.row}}
+			s, err := queryStats(context.Background(), db, rangeFilter(from, to))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := json.Marshal(s)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			for _, part := range []string{"percentile_cont(0.5) WITHIN GROUP (ORDER BY percentage)", "stddev_pop(percentage)", " WHERE NOT suspect AND timestamp >= $1 AND timestamp < $2"} {
+				if !strings.Contains(db.sql, part) {
+					t.Errorf("got query %q, want it to contain %q", db.sql, part)
+				}
+			}
+			if !slices.Equal(db.args, []any{from, to}) {
+				t.Errorf("got arguments %v, want %v", db.args, []any{from, to})
+			}
+		})
+	}
+}
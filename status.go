@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Status describes the current occupancy and its short-term trend
+type Status struct {
+	Percentage int       `json:"percentage"`
+	Timestamp  time.Time `json:"timestamp"`
+	AgeSeconds int       `json:"age_seconds"`
+	// Trend is "rising", "falling" or "steady"
+	Trend string `json:"trend"`
+	// ChangePerHour is the fitted slope of the readings in the trend window, in percentage points per hour
+	ChangePerHour float64 `json:"change_per_hour"`
+}
+
+const (
+	// trendWindow is how far back the readings used for the trend reach
+	trendWindow = time.Hour
+	// steadyThreshold is the slope in percentage points per hour below which the trend counts as steady
+	steadyThreshold = 5.0
+)
+
+// getStatusHandler handles the /status endpoint and returns the latest reading, its age and its trend
+func getStatusHandler(reads *readPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pool := reads.pool()
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s, err := queryStatus(r.Context(), pool)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "No data available", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		s.Timestamp = inLocation(s.Timestamp, loc)
+
+		writeJSON(w, s)
+	}
+}
+
+// queryStatus reads the latest reading and derives its age and trend; it returns pgx.ErrNoRows
+// when there are no readings yet
+func queryStatus(ctx context.Context, pool *pgxpool.Pool) (Status, error) {
+	// Fit a least-squares line through the readings of the hour leading up to the latest one
+	var s Status
+	var slope *float64
+	err := pool.QueryRow(ctx, `WITH latest AS (
+			SELECT timestamp, percentage FROM pool_usage WHERE NOT suspect ORDER BY timestamp DESC, id DESC LIMIT 1
+		)
+		SELECT latest.timestamp, latest.percentage,
+			(SELECT regr_slope(percentage, extract(epoch FROM timestamp)) FROM pool_usage
+				WHERE NOT suspect AND timestamp > latest.timestamp - make_interval(secs => $1::float8) AND timestamp <= latest.timestamp)
+		FROM latest`, trendWindow.Seconds()).Scan(&s.Timestamp, &s.Percentage, &slope)
+	if err != nil {
+		return s, err
+	}
+
+	s.AgeSeconds = int(time.Since(s.Timestamp).Seconds())
+	s.Trend, s.ChangePerHour = trendOf(slope)
+	return s, nil
+}
+
+// trendOf classifies a fitted slope in percentage points per second and returns it per hour,
+// rounded to a tenth; without a slope, as with a single reading in the window, it is steady
+func trendOf(slope *float64) (trend string, changePerHour float64) {
+	if slope == nil {
+		return "steady", 0
+	}
+	changePerHour = math.Round(*slope*3600*10) / 10
+	switch {
+	case changePerHour >= steadyThreshold:
+		return "rising", changePerHour
+	case changePerHour <= -steadyThreshold:
+		return "falling", changePerHour
+	}
+	return "steady", changePerHour
+}
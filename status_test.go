@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestTrendOf(t *testing.T) {
+	perHour := func(points float64) *float64 {
+		slope := points / 3600
+		return &slope
+	}
+	tests := []struct {
+		name          string
+		slope         *float64
+		trend         string
+		changePerHour float64
+	}{
+		{name: "no slope", slope: nil, trend: "steady", changePerHour: 0},
+		{name: "flat", slope: perHour(0), trend: "steady", changePerHour: 0},
+		{name: "slowly rising", slope: perHour(4.9), trend: "steady", changePerHour: 4.9},
+		{name: "rising", slope: perHour(5), trend: "rising", changePerHour: 5},
+		{name: "rising fast", slope: perHour(23.46), trend: "rising", changePerHour: 23.5},
+		{name: "slowly falling", slope: perHour(-4.9), trend: "steady", changePerHour: -4.9},
+		{name: "falling", slope: perHour(-12), trend: "falling", changePerHour: -12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trend, changePerHour := trendOf(tt.slope)
+			if trend != tt.trend || changePerHour != tt.changePerHour {
+				t.Errorf("got %s at %v per hour, want %s at %v", trend, changePerHour, tt.trend, tt.changePerHour)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultDedupWindow is how long an unchanged percentage is suppressed as a repeat of the previous reading
+const defaultDedupWindow = 2 * time.Minute
+
+var (
+	// errDuplicateReading means the reading repeats one that is already stored
+	errDuplicateReading = errors.New("the reading is already stored")
+	// errConflictingReading means another percentage is already stored for the same timestamp
+	errConflictingReading = errors.New("a different reading is already stored for this timestamp")
+)
+
+// readingStore writes readings to pool_usage, dropping duplicates, and publishes the new ones to the hub
+type readingStore struct {
+	pool     *pgxpool.Pool
+	readings *hub
+	// dedupWindow suppresses a reading when the previous one within the window has the same
+	// percentage, e.g. when the scraper retries; zero only rejects identical timestamps
+	dedupWindow time.Duration
+	spikes      spikeConfig
+	// cache holds read responses that every insert makes stale; nil when caching is off
+	cache *responseCache
+}
+
+// getDedupWindow reads DEDUP_WINDOW, defaulting to defaultDedupWindow; 0 disables same-value suppression
+func getDedupWindow() (time.Duration, error) {
+	value := os.Getenv("DEDUP_WINDOW")
+	if value == "" {
+		return defaultDedupWindow, nil
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		return 0, fmt.Errorf("invalid DEDUP_WINDOW %q: expected a non-negative duration", value)
+	}
+	return window, nil
+}
+
+// newImportStore returns a store for the import commands, with the dedup window and spike
+// checks the server applies
+func newImportStore(pool *pgxpool.Pool) (*readingStore, error) {
+	dedupWindow, err := getDedupWindow()
+	if err != nil {
+		return nil, err
+	}
+	spikes, err := getSpikeConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &readingStore{pool: pool, dedupWindow: dedupWindow, spikes: spikes}, nil
+}
+
+// previous returns the newest stored reading at or up to dedupWindow before timestamp
+func (s *readingStore) previous(ctx context.Context, timestamp time.Time) (DataPoint, error) {
+	var dp DataPoint
+	err := s.pool.QueryRow(ctx, `SELECT id, timestamp, percentage FROM pool_usage
+		WHERE timestamp <= $1 AND timestamp >= $1 - make_interval(secs => $2)
+		ORDER BY timestamp DESC, id DESC LIMIT 1`, timestamp, s.dedupWindow.Seconds()).
+		Scan(&dp.ID, &dp.Timestamp, &dp.Percentage)
+	return dp, err
+}
+
+// store inserts one reading and publishes it to the hub. A repeat of the previous reading returns
+// errDuplicateReading together with the stored reading; a different percentage at an already stored
+// timestamp returns errConflictingReading. Implausible jumps are rejected with errSpikeReading or
+// stored as suspect without being published, depending on the spike mode.
+func (s *readingStore) store(ctx context.Context, timestamp time.Time, percentage int) (DataPoint, error) {
+	// A concurrent insert of the same timestamp makes the INSERT a no-op; the second pass then finds it
+	for range 2 {
+		prev, err := s.previous(ctx, timestamp)
+		switch {
+		case err == nil && prev.Timestamp.Equal(timestamp) && prev.Percentage != percentage:
+			return prev, errConflictingReading
+		case err == nil && prev.Percentage == percentage:
+			return prev, errDuplicateReading
+		case err != nil && !errors.Is(err, pgx.ErrNoRows):
+			return DataPoint{}, err
+		}
+
+		suspect, err := s.checkSpike(ctx, timestamp, percentage)
+		if err != nil {
+			return DataPoint{}, err
+		}
+
+		var dp DataPoint
+		err = s.pool.QueryRow(ctx,
+			"INSERT INTO pool_usage (timestamp, percentage, suspect) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING RETURNING id, timestamp, percentage",
+			timestamp, percentage, suspect).Scan(&dp.ID, &dp.Timestamp, &dp.Percentage)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return DataPoint{}, err
+		}
+		logAudit(ctx, s.pool, auditReadingCreate, strconv.Itoa(dp.ID), nil, dp)
+		s.cache.invalidate()
+		if !suspect {
+			s.readings.publish(dp)
+		}
+		return dp, nil
+	}
+	return DataPoint{}, errDuplicateReading
+}
+
+// bulkInsert copies the staged readings that are not stored yet into pool_usage. Each is judged
+// by its neighbours among the stored readings and the batch, since the whole batch is known: one
+// that repeats the reading before it within the dedup window is skipped, and one that jumps more
+// than the spike delta from the reading before it is a spike unless the reading after it agrees,
+// in which case the level really changed. Spikes are flagged or left out by the spike mode.
+const bulkInsert = `WITH fresh AS (
+		SELECT DISTINCT ON (timestamp) timestamp, percentage FROM staged_readings s
+		WHERE NOT EXISTS (SELECT 1 FROM pool_usage p WHERE p.timestamp = s.timestamp)
+		ORDER BY timestamp
+	), neighbours AS (
+		SELECT timestamp, percentage, fresh,
+			lag(timestamp) OVER w AS prev_timestamp, lag(percentage) OVER w AS prev_percentage,
+			lead(timestamp) OVER w AS next_timestamp, lead(percentage) OVER w AS next_percentage
+		FROM (
+			SELECT timestamp, percentage, true AS fresh FROM fresh
+			UNION ALL
+			SELECT timestamp, percentage, false FROM pool_usage
+			WHERE NOT suspect
+			AND timestamp >= (SELECT min(timestamp) FROM fresh) - make_interval(secs => greatest($1, $2))
+			AND timestamp <= (SELECT max(timestamp) FROM fresh) + make_interval(secs => $2)
+		) r
+		WINDOW w AS (ORDER BY timestamp)
+	), judged AS (
+		SELECT timestamp, percentage,
+			coalesce(prev_timestamp >= timestamp - make_interval(secs => $1) AND prev_percentage = percentage, false) AS repeated,
+			$4 AND coalesce(prev_timestamp >= timestamp - make_interval(secs => $2) AND abs(percentage - prev_percentage) > $3, false)
+				AND NOT coalesce(next_timestamp <= timestamp + make_interval(secs => $2) AND abs(percentage - next_percentage) <= $3, false) AS spike
+		FROM neighbours WHERE fresh
+	)
+	INSERT INTO pool_usage (timestamp, percentage, suspect)
+	SELECT timestamp, percentage, spike FROM judged
+	WHERE NOT repeated AND NOT (spike AND $5)
+	ORDER BY timestamp
+	ON CONFLICT DO NOTHING`
+
+// clearAgreedSpikes clears the spike flag of stored readings that the next plausible reading
+// within the window agrees with, as store does when a reading confirms the suspect one before it
+const clearAgreedSpikes = `UPDATE pool_usage p SET suspect = false
+	WHERE p.suspect
+	AND p.timestamp >= (SELECT min(timestamp) FROM staged_readings) - make_interval(secs => $1)
+	AND p.timestamp <= (SELECT max(timestamp) FROM staged_readings)
+	AND abs(p.percentage - (SELECT n.percentage FROM pool_usage n
+		WHERE n.timestamp > p.timestamp AND n.timestamp <= p.timestamp + make_interval(secs => $1) AND NOT n.suspect
+		ORDER BY n.timestamp LIMIT 1)) <= $2`
+
+// storeAll inserts many readings in one transaction, skipping timestamps that are already stored
+// or repeated within rows ({timestamp, percentage} pairs), and applies the dedup window and spike
+// checks as bulkInsert describes; it returns the number inserted. The new readings are not
+// published one by one; the poller picks up those newer than the latest reading.
+func (s *readingStore) storeAll(ctx context.Context, rows [][]any) (int64, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to start transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Stage the rows in a temporary table so they are judged and filtered in a single statement
+	_, err = tx.Exec(ctx, "CREATE TEMPORARY TABLE staged_readings (timestamp timestamptz, percentage int) ON COMMIT DROP")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"staged_readings"}, []string{"timestamp", "percentage"}, pgx.CopyFromRows(rows)); err != nil {
+		return 0, err
+	}
+	checkSpikes := s.spikes.mode == spikeFlag || s.spikes.mode == spikeReject
+	tag, err := tx.Exec(ctx, bulkInsert, s.dedupWindow.Seconds(), s.spikes.window.Seconds(), s.spikes.maxDelta,
+		checkSpikes, s.spikes.mode == spikeReject)
+	if err != nil {
+		return 0, err
+	}
+	if s.spikes.mode == spikeFlag {
+		if _, err := tx.Exec(ctx, clearAgreedSpikes, s.spikes.window.Seconds(), s.spikes.maxDelta); err != nil {
+			return 0, err
+		}
+	}
+	err = recordAudit(ctx, tx, auditReadingImport, "", nil, BatchResult{Inserted: tag.RowsAffected(), Duplicates: int64(len(rows)) - tag.RowsAffected()})
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	if tag.RowsAffected() > 0 {
+		s.cache.invalidate()
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDedupWindow(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "", want: defaultDedupWindow},
+		{value: "0", want: 0},
+		{value: "30s", want: 30 * time.Second},
+		{value: "1h", want: time.Hour},
+		{value: "-1m", err: `invalid DEDUP_WINDOW "-1m": expected a non-negative duration`},
+		{value: "5", err: `invalid DEDUP_WINDOW "5": expected a non-negative duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("DEDUP_WINDOW", tt.value)
+			got, err := getDedupWindow()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// defaultQueryTimeout bounds a request's database work when QUERY_TIMEOUT is unset
+	defaultQueryTimeout = 30 * time.Second
+	// statusClientClosedRequest is the status nginx logs for requests the client gave up on
+	statusClientClosedRequest = 499
+)
+
+// getQueryTimeout reads QUERY_TIMEOUT, defaulting to defaultQueryTimeout; 0 disables the timeout
+func getQueryTimeout() (time.Duration, error) {
+	value := os.Getenv("QUERY_TIMEOUT")
+	if value == "" {
+		return defaultQueryTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid QUERY_TIMEOUT %q: expected a non-negative duration", value)
+	}
+	return timeout, nil
+}
+
+// withTimeout gives the request's context a deadline, so queries still running after timeout
+// are cancelled just like those of clients that disconnect
+func withTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// writeQueryError answers a failed query with 499 when the client has gone away, 504 when the
+// query ran out of time, either by the request's deadline or by the server's statement_timeout,
+// 413 when the result exceeds the row cap, 503 when the database cannot be reached, and 500 otherwise
+func writeQueryError(w http.ResponseWriter, r *http.Request, err error) {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, errTooManyRows):
+		writeTooLarge(w, err.Error())
+	case errors.Is(r.Context().Err(), context.Canceled):
+		// Nobody reads the response, but the status shows up in access logs and metrics
+		w.WriteHeader(statusClientClosedRequest)
+	case errors.Is(r.Context().Err(), context.DeadlineExceeded) || errors.As(err, &pgErr) && pgErr.Code == "57014":
+		http.Error(w, "The query took too long", http.StatusGatewayTimeout)
+		slog.WarnContext(r.Context(), "Query timed out", "error", err)
+	case isUnavailable(err):
+		// The pool reconnects on its own once the database is back
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "The database is unavailable", http.StatusServiceUnavailable)
+		slog.WarnContext(r.Context(), "Database unavailable", "error", err)
+	default:
+		http.Error(w, "Failed to query the database", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error querying database", "error", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestGetQueryTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "", want: defaultQueryTimeout},
+		{value: "0", want: 0},
+		{value: "5s", want: 5 * time.Second},
+		{value: "-5s", err: `invalid QUERY_TIMEOUT "-5s": expected a non-negative duration`},
+		{value: "5", err: `invalid QUERY_TIMEOUT "5": expected a non-negative duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("QUERY_TIMEOUT", tt.value)
+			got, err := getQueryTimeout()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	withTimeout(time.Minute, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/pool-data", nil))
+	if !ok || time.Until(deadline) > time.Minute || time.Until(deadline) < 50*time.Second {
+		t.Errorf("got deadline %v (set %v), want one minute from now", deadline, ok)
+	}
+}
+
+func TestWriteQueryError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		err    error
+		status int
+		body   string
+		// retryAfter is the expected Retry-After header
+		retryAfter string
+	}{
+		{name: "client gone", ctx: canceled, err: context.Canceled, status: statusClientClosedRequest},
+		{name: "deadline", ctx: expired, err: context.DeadlineExceeded, status: http.StatusGatewayTimeout, body: "The query took too long"},
+		{name: "statement timeout", ctx: context.Background(), err: &pgconn.PgError{Code: "57014"}, status: http.StatusGatewayTimeout, body: "The query took too long"},
+		{name: "row cap", ctx: context.Background(), err: checkRowLimit(context.WithValue(context.Background(), rowLimitKey{}, 10), 11), status: http.StatusRequestEntityTooLarge,
+			body: "the result has too many rows (more than 10); narrow the time range, use a coarser bucket or fetch the data in pages with 'limit' and 'cursor'"},
+		{name: "database down", ctx: context.Background(), err: retryableError{}, status: http.StatusServiceUnavailable, body: "The database is unavailable", retryAfter: "5"},
+		{name: "other database error", ctx: context.Background(), err: &pgconn.PgError{Code: "42P01"}, status: http.StatusInternalServerError, body: "Failed to query the database"},
+		{name: "other error", ctx: context.Background(), err: errors.New("scan failed"), status: http.StatusInternalServerError, body: "Failed to query the database"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeQueryError(w, httptest.NewRequest("GET", "/pool-data", nil).WithContext(tt.ctx), tt.err)
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("got Retry-After %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
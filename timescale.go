@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultCompressAfter is the age at which chunks are compressed when TIMESCALEDB_COMPRESS_AFTER
+// is unset
+const defaultCompressAfter = 30 * 24 * time.Hour
+
+// getTimescaleMode reads TIMESCALEDB for the migration converting pool_usage: "auto" (the
+// default) uses TimescaleDB when the extension is installed in the database, "on" installs it
+// and "off" never uses it. It only matters when that migration runs.
+func getTimescaleMode() (string, error) {
+	mode := os.Getenv("TIMESCALEDB")
+	switch mode {
+	case "":
+		return "auto", nil
+	case "auto", "on", "off":
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid TIMESCALEDB %q: expected auto, on or off", mode)
+}
+
+// getCompressAfter reads TIMESCALEDB_COMPRESS_AFTER, defaulting to defaultCompressAfter; 0
+// disables compression
+func getCompressAfter() (time.Duration, error) {
+	value := os.Getenv("TIMESCALEDB_COMPRESS_AFTER")
+	if value == "" {
+		return defaultCompressAfter, nil
+	}
+	after, err := time.ParseDuration(value)
+	if err != nil || after < 0 {
+		return 0, fmt.Errorf("invalid TIMESCALEDB_COMPRESS_AFTER %q: expected a duration", value)
+	}
+	return after, nil
+}
+
+// useTimescale reports whether migration 0013 converted pool_usage for TimescaleDB, in which
+// case the continuous aggregate serves pool_usage_hours and the rollup worker is not needed,
+// and sets the compression policy. The policy is a setting rather than schema, so it follows
+// TIMESCALEDB_COMPRESS_AFTER on every start.
+func useTimescale(pool *pgxpool.Pool, compressAfter time.Duration) (bool, error) {
+	ctx := context.Background()
+	var converted bool
+	var version string
+	err := pool.QueryRow(ctx, `SELECT to_regclass('pool_usage_hourly_cagg') IS NOT NULL,
+		coalesce((SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'), '')`).Scan(&converted, &version)
+	if err != nil {
+		return false, fmt.Errorf("unable to detect timescaledb: %v", err)
+	}
+	if !converted || version == "" {
+		return false, nil
+	}
+	if err := setCompressionPolicy(ctx, pool, compressAfter); err != nil {
+		return false, err
+	}
+	slog.Info("Using TimescaleDB", "version", version)
+	return true, nil
+}
+
+// setCompressionPolicy compresses chunks older than after, or removes the policy when after is 0
+func setCompressionPolicy(ctx context.Context, pool *pgxpool.Pool, after time.Duration) error {
+	// Replace the policy so a changed TIMESCALEDB_COMPRESS_AFTER takes effect
+	_, err := pool.Exec(ctx, "SELECT remove_compression_policy('pool_usage', if_exists => true)")
+	if err == nil && after > 0 {
+		_, err = pool.Exec(ctx, "SELECT add_compression_policy('pool_usage', compress_after => $1::interval)", after)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to set compression policy: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetTimescaleMode(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   string
+	}{
+		{value: "", want: "auto"},
+		{value: "auto", want: "auto"},
+		{value: "on", want: "on"},
+		{value: "off", want: "off"},
+		{value: "yes", err: `invalid TIMESCALEDB "yes": expected auto, on or off`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("TIMESCALEDB", tt.value)
+			got, err := getTimescaleMode()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCompressAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "", want: defaultCompressAfter},
+		{value: "0", want: 0},
+		{value: "168h", want: 7 * 24 * time.Hour},
+		{value: "-1h", err: `invalid TIMESCALEDB_COMPRESS_AFTER "-1h": expected a duration`},
+		{value: "7d", err: `invalid TIMESCALEDB_COMPRESS_AFTER "7d": expected a duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("TIMESCALEDB_COMPRESS_AFTER", tt.value)
+			got, err := getCompressAfter()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serveConfig describes the listeners of the HTTP API
+type serveConfig struct {
+	// addr is the plain HTTP listener; with TLS it only redirects to HTTPS
+	addr string
+	// tlsAddr is the HTTPS listener, empty without TLS
+	tlsAddr  string
+	certFile string
+	keyFile  string
+	// manager obtains certificates from Let's Encrypt in autocert mode
+	manager *autocert.Manager
+}
+
+// getServeConfig reads the listeners from the environment. TLS_CERT_FILE and TLS_KEY_FILE serve
+// HTTPS with a certificate on disk; AUTOCERT_DOMAINS instead obtains certificates for the listed
+// domains from Let's Encrypt, caching them in AUTOCERT_CACHE_DIR. HTTP_ADDR and TLS_ADDR override
+// the default ports, :8080 and :8443, or :80 and :443 in autocert mode where Let's Encrypt needs them.
+func getServeConfig() (serveConfig, error) {
+	cfg := serveConfig{
+		addr:     os.Getenv("HTTP_ADDR"),
+		tlsAddr:  os.Getenv("TLS_ADDR"),
+		certFile: os.Getenv("TLS_CERT_FILE"),
+		keyFile:  os.Getenv("TLS_KEY_FILE"),
+	}
+	domains := splitList(os.Getenv("AUTOCERT_DOMAINS"))
+	switch {
+	case len(domains) > 0:
+		if cfg.certFile != "" || cfg.keyFile != "" {
+			return cfg, fmt.Errorf("AUTOCERT_DOMAINS cannot be combined with TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		cacheDir := os.Getenv("AUTOCERT_CACHE_DIR")
+		if cacheDir == "" {
+			cacheDir = "autocert-cache"
+		}
+		cfg.manager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      os.Getenv("AUTOCERT_EMAIL"),
+		}
+		cfg.addr = cmp.Or(cfg.addr, ":80")
+		cfg.tlsAddr = cmp.Or(cfg.tlsAddr, ":443")
+	case cfg.certFile != "" || cfg.keyFile != "":
+		if cfg.certFile == "" || cfg.keyFile == "" {
+			return cfg, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		cfg.addr = cmp.Or(cfg.addr, ":8080")
+		cfg.tlsAddr = cmp.Or(cfg.tlsAddr, ":8443")
+	default:
+		cfg.addr = cmp.Or(cfg.addr, ":8080")
+		cfg.tlsAddr = ""
+	}
+	return cfg, nil
+}
+
+// serve runs the API until a listener fails or ctx is done, then drains the requests in flight
+// for up to timeout
+func (cfg serveConfig) serve(ctx context.Context, timeout time.Duration, handler http.Handler) error {
+	if cfg.tlsAddr == "" {
+		server := &http.Server{Addr: cfg.addr, Handler: handler}
+		slog.Info("Starting server", "addr", cfg.addr)
+		return serveUntil(ctx, timeout, server, server.ListenAndServe)
+	}
+
+	// The plain listener answers ACME challenges in autocert mode and redirects everything else
+	var redirect http.Handler = http.HandlerFunc(redirectToHTTPS(cfg.tlsAddr))
+	server := &http.Server{Addr: cfg.tlsAddr, Handler: handler}
+	if cfg.manager != nil {
+		redirect = cfg.manager.HTTPHandler(redirect)
+		server.TLSConfig = cfg.manager.TLSConfig()
+	} else {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	redirectServer := &http.Server{Addr: cfg.addr, Handler: redirect}
+	errs := make(chan error, 2)
+	go func() {
+		slog.Info("Redirecting HTTP to HTTPS", "addr", cfg.addr)
+		errs <- serveUntil(ctx, timeout, redirectServer, redirectServer.ListenAndServe)
+	}()
+	go func() {
+		slog.Info("Starting server with TLS", "addr", cfg.tlsAddr)
+		errs <- serveUntil(ctx, timeout, server, func() error {
+			return server.ListenAndServeTLS(cfg.certFile, cfg.keyFile)
+		})
+	}()
+	// Both listeners run until ctx is done unless one of them fails first
+	if err := <-errs; err != nil {
+		return err
+	}
+	return <-errs
+}
+
+// redirectToHTTPS sends clients to the same URL on the HTTPS listener
+func redirectToHTTPS(tlsAddr string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}
+}
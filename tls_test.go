@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetServeConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		addr     string
+		tlsAddr  string
+		autocert bool
+		err      string
+	}{
+		{name: "plain HTTP", addr: ":8080"},
+		{name: "HTTP_ADDR", env: map[string]string{"HTTP_ADDR": ":9000", "TLS_ADDR": ":9443"}, addr: ":9000"},
+		{name: "certificate files", env: map[string]string{"TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem"}, addr: ":8080", tlsAddr: ":8443"},
+		{name: "autocert", env: map[string]string{"AUTOCERT_DOMAINS": "pool.example, www.pool.example"}, addr: ":80", tlsAddr: ":443", autocert: true},
+		{name: "autocert with TLS_ADDR", env: map[string]string{"AUTOCERT_DOMAINS": "pool.example", "TLS_ADDR": ":8443"}, addr: ":80", tlsAddr: ":8443", autocert: true},
+		{name: "certificate without key", env: map[string]string{"TLS_CERT_FILE": "cert.pem"}, err: "TLS_CERT_FILE and TLS_KEY_FILE must be set together"},
+		{
+			name: "autocert and certificate files",
+			env:  map[string]string{"AUTOCERT_DOMAINS": "pool.example", "TLS_CERT_FILE": "cert.pem", "TLS_KEY_FILE": "key.pem"},
+			err:  "AUTOCERT_DOMAINS cannot be combined with TLS_CERT_FILE and TLS_KEY_FILE",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"HTTP_ADDR", "TLS_ADDR", "TLS_CERT_FILE", "TLS_KEY_FILE", "AUTOCERT_DOMAINS", "AUTOCERT_CACHE_DIR", "AUTOCERT_EMAIL"} {
+				t.Setenv(name, tt.env[name])
+			}
+			cfg, err := getServeConfig()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.addr != tt.addr || cfg.tlsAddr != tt.tlsAddr || (cfg.manager != nil) != tt.autocert {
+				t.Errorf("got addr %q, TLS addr %q, autocert %v, want %q, %q, %v", cfg.addr, cfg.tlsAddr, cfg.manager != nil, tt.addr, tt.tlsAddr, tt.autocert)
+			}
+		})
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name    string
+		tlsAddr string
+		host    string
+		target  string
+		want    string
+	}{
+		{name: "default port", tlsAddr: ":443", host: "pool.example", target: "/pool-data?last=1d", want: "https://pool.example/pool-data?last=1d"},
+		{name: "port of the request", tlsAddr: ":443", host: "pool.example:80", target: "/", want: "https://pool.example/"},
+		{name: "other port", tlsAddr: ":8443", host: "pool.example:8080", target: "/pool-data/latest", want: "https://pool.example:8443/pool-data/latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			redirectToHTTPS(tt.tlsAddr)(w, r)
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusMovedPermanently)
+			}
+			if got := w.Header().Get("Location"); got != tt.want {
+				t.Errorf("got Location %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the database queries
+var tracer = otel.Tracer("igor.am/pool-api")
+
+// startTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard OTEL_* variables, and
+// returns a function that flushes the remaining spans. Without an endpoint spans are not recorded.
+func startTracing(ctx context.Context, build BuildInfo) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP exporter: %v", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "pool-api"), attribute.String("service.version", build.Version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe the service: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// traceRequests starts a span for every request, continuing the trace of the caller
+func traceRequests(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "HTTP", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method
+	}))
+}
+
+// traceRoute names the request's span after the route registered under the path pattern, which
+// is only known once the request has been routed
+func traceRoute(path string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + path)
+		span.SetAttributes(attribute.String("http.route", path))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// queryTracer records a span for every query and batch sent through a connection pool, and
+// logs those that take longer than slow, unless it is 0
+type queryTracer struct {
+	slow time.Duration
+}
+
+// defaultSlowQueryThreshold is the duration above which queries are logged when
+// SLOW_QUERY_THRESHOLD is unset
+const defaultSlowQueryThreshold = time.Second
+
+// getSlowQueryThreshold reads SLOW_QUERY_THRESHOLD, defaulting to defaultSlowQueryThreshold; 0
+// disables the slow query log
+func getSlowQueryThreshold() (time.Duration, error) {
+	value := os.Getenv("SLOW_QUERY_THRESHOLD")
+	if value == "" {
+		return defaultSlowQueryThreshold, nil
+	}
+	threshold, err := time.ParseDuration(value)
+	if err != nil || threshold < 0 {
+		return 0, fmt.Errorf("invalid SLOW_QUERY_THRESHOLD %q: expected a duration", value)
+	}
+	return threshold, nil
+}
+
+// queryStartKey is the context key of the query or batch being traced
+type queryStartKey struct{}
+
+// queryStart is what the end of a trace needs to know about its query or batch
+type queryStart struct {
+	started time.Time
+	sql     string
+	args    []any
+	// queries counts the queries of a batch
+	queries int
+}
+
+// querySpanName names a query's span after its first keyword, e.g. "SELECT", since the full
+// statement is too long and varied to group spans by
+func querySpanName(sql string) string {
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		return "db " + strings.ToUpper(fields[0])
+	}
+	return "db"
+}
+
+func (t queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, querySpanName(data.SQL), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", data.SQL),
+	))
+	return context.WithValue(ctx, queryStartKey{}, &queryStart{started: time.Now(), sql: data.SQL, args: data.Args})
+}
+
+func (t queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	endQuerySpan(trace.SpanFromContext(ctx), data.CommandTag.RowsAffected(), data.Err)
+	if start, ok := ctx.Value(queryStartKey{}).(*queryStart); ok {
+		t.logSlow(ctx, start, "Slow query")
+	}
+}
+
+func (t queryTracer) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "db batch", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.Int("db.batch.size", data.Batch.Len()),
+	))
+	return context.WithValue(ctx, queryStartKey{}, &queryStart{started: time.Now()})
+}
+
+// TraceBatchQuery records each query of a batch as a child span, as they share one round trip
+func (t queryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	_, span := tracer.Start(ctx, querySpanName(data.SQL), trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", data.SQL),
+	))
+	endQuerySpan(span, data.CommandTag.RowsAffected(), data.Err)
+	// A slow batch is logged with the statements of its queries, without their parameters
+	if start, ok := ctx.Value(queryStartKey{}).(*queryStart); ok {
+		start.queries++
+		start.sql = strings.TrimPrefix(start.sql+"; "+normalizeSQL(data.SQL), "; ")
+	}
+}
+
+func (t queryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	endQuerySpan(trace.SpanFromContext(ctx), -1, data.Err)
+	if start, ok := ctx.Value(queryStartKey{}).(*queryStart); ok {
+		t.logSlow(ctx, start, "Slow batch")
+	}
+}
+
+// logSlow logs the query or batch if it took longer than the threshold
+func (t queryTracer) logSlow(ctx context.Context, start *queryStart, msg string) {
+	duration := time.Since(start.started)
+	if t.slow == 0 || duration < t.slow {
+		return
+	}
+	attrs := []any{"duration", duration, "sql", normalizeSQL(start.sql)}
+	if len(start.args) > 0 {
+		attrs = append(attrs, "args", summarizeArgs(start.args))
+	}
+	if start.queries > 0 {
+		attrs = append(attrs, "queries", start.queries)
+	}
+	slog.WarnContext(ctx, msg, attrs...)
+}
+
+// normalizeSQL collapses the indentation and line breaks of a statement into single spaces
+func normalizeSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+// summarizeArgs describes query parameters without giving away their contents: numbers, times
+// and booleans are shown, strings and byte slices only by their length, since they might be
+// secrets, and lists by their type and length
+func summarizeArgs(args []any) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		var value string
+		switch v := arg.(type) {
+		case nil:
+			value = "NULL"
+		case int, int32, int64, float64, bool:
+			value = fmt.Sprint(v)
+		case time.Time:
+			value = v.Format(time.RFC3339Nano)
+		case *time.Time:
+			value = "NULL"
+			if v != nil {
+				value = v.Format(time.RFC3339Nano)
+			}
+		case time.Duration:
+			value = v.String()
+		case string:
+			value = fmt.Sprintf("string(%d)", len(v))
+		case []byte:
+			value = fmt.Sprintf("bytes(%d)", len(v))
+		default:
+			if rv := reflect.ValueOf(arg); rv.Kind() == reflect.Slice {
+				value = fmt.Sprintf("%T(%d)", arg, rv.Len())
+			} else {
+				value = fmt.Sprintf("%T", arg)
+			}
+		}
+		parts[i] = fmt.Sprintf("$%d=%s", i+1, value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// endQuerySpan records the outcome of a query on its span, leaving out the row count when it is negative
+func endQuerySpan(span trace.Span, rows int64, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if rows >= 0 {
+		span.SetAttributes(attribute.Int64("db.rows_affected", rows))
+	}
+	span.End()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestQuerySpanName(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{sql: "SELECT count FROM pool_usage", want: "db SELECT"},
+		{sql: "\n\t\tinsert INTO pool_usage VALUES ($1, $2)", want: "db INSERT"},
+		{sql: "", want: "db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := querySpanName(tt.sql); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartTracingWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	shutdown, err := startTracing(context.Background(), BuildInfo{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTraceRoute(t *testing.T) {
+	tests := []struct {
+		method string
+		route  string
+		want   string
+	}{
+		{method: "GET", route: "/pool-data/latest", want: "GET /pool-data/latest"},
+		{method: "DELETE", route: "/pool-data/{id}", want: "DELETE /pool-data/{id}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			ctx, span := provider.Tracer("test").Start(context.Background(), "HTTP")
+			traceRoute(tt.route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+				ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, "/", nil).WithContext(ctx))
+			span.End()
+			ended := recorder.Ended()
+			if len(ended) != 1 {
+				t.Fatalf("got %d spans, want 1", len(ended))
+			}
+			if got := ended[0].Name(); got != tt.want {
+				t.Errorf("got span %q, want %q", got, tt.want)
+			}
+			if got := ended[0].Attributes(); len(got) != 1 || got[0] != attribute.String("http.route", tt.route) {
+				t.Errorf("got attributes %v, want http.route %q", got, tt.route)
+			}
+		})
+	}
+}
+
+func TestGetSlowQueryThreshold(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   string
+	}{
+		{value: "", want: defaultSlowQueryThreshold},
+		{value: "0", want: 0},
+		{value: "250ms", want: 250 * time.Millisecond},
+		{value: "-1s", err: `invalid SLOW_QUERY_THRESHOLD "-1s": expected a duration`},
+		{value: "1", err: `invalid SLOW_QUERY_THRESHOLD "1": expected a duration`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("SLOW_QUERY_THRESHOLD", tt.value)
+			got, err := getSlowQueryThreshold()
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeArgs(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var noTime *time.Time
+	tests := []struct {
+		name string
+		args []any
+		want string
+	}{
+		{name: "none", want: ""},
+		{name: "numbers", args: []any{42, int64(7), 0.5, true}, want: "$1=42 $2=7 $3=0.5 $4=true"},
+		{name: "times", args: []any{at, &at, noTime, time.Hour}, want: "$1=2024-05-01T12:00:00Z $2=2024-05-01T12:00:00Z $3=NULL $4=1h0m0s"},
+		{name: "hidden contents", args: []any{"s3cr3t", []byte("key"), nil}, want: "$1=string(6) $2=bytes(3) $3=NULL"},
+		{name: "lists", args: []any{[]int{1, 2, 3}, []string{"a"}}, want: "$1=[]int(3) $2=[]string(1)"},
+		{name: "other", args: []any{struct{}{}}, want: "$1=struct {}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeArgs(tt.args); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTracerLogSlow(t *testing.T) {
+	tests := []struct {
+		name  string
+		slow  time.Duration
+		msg   string
+		start queryStart
+		want  string
+	}{
+		{name: "disabled", start: queryStart{started: time.Now().Add(-time.Hour), sql: "SELECT 1"}},
+		{name: "fast", slow: time.Hour, start: queryStart{started: time.Now(), sql: "SELECT 1"}},
+		{name: "slow query", slow: time.Millisecond, start: queryStart{started: time.Now().Add(-time.Second), sql: "SELECT count\n\t\tFROM pool_usage WHERE id = $1", args: []any{7}},
+			want: `msg="Slow query" sql="SELECT count FROM pool_usage WHERE id = $1" args="$1=7"`},
+		{name: "slow batch", slow: time.Millisecond, msg: "Slow batch", start: queryStart{started: time.Now().Add(-time.Second), sql: "SELECT 1; SELECT 2", queries: 2},
+			want: `msg="Slow batch" sql="SELECT 1; SELECT 2" queries=2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			previous := slog.Default()
+			slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == "duration" {
+					return slog.Attr{}
+				}
+				return a
+			}})))
+			defer slog.SetDefault(previous)
+			queryTracer{slow: tt.slow}.logSlow(context.Background(), &tt.start, cmp.Or(tt.msg, "Slow query"))
+			if got := strings.TrimSpace(buf.String()); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
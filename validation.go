@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FieldError describes what is wrong with a single field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned for request bodies that are well-formed JSON but do not describe
+// valid input; it is answered with 422 and lists every offending field
+type ValidationError struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		messages[i] = fmt.Sprintf("'%s' %s", fe.Field, fe.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// fieldErrors accumulates FieldErrors while a value is checked
+type fieldErrors []FieldError
+
+func (errs *fieldErrors) add(field, message string) {
+	*errs = append(*errs, FieldError{Field: field, Message: message})
+}
+
+// err returns the collected errors as a *ValidationError, or nil if there are none
+func (errs fieldErrors) err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Message: "The request body is invalid", Errors: errs}
+}
+
+// prefixFieldErrors qualifies the fields of a validation error, e.g. with the index of a batch item
+func prefixFieldErrors(err error, prefix string) error {
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		return err
+	}
+	prefixed := make(fieldErrors, len(verr.Errors))
+	for i, fe := range verr.Errors {
+		prefixed[i] = FieldError{Field: prefix + "." + fe.Field, Message: fe.Message}
+	}
+	return prefixed.err()
+}
+
+// decodeError turns a JSON decoding error into a ValidationError naming the field where
+// possible; syntax errors are returned as they are, since the body is not JSON at all, and so
+// are bodies over the size limit
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+	var tooLarge *http.MaxBytesError
+	var errs fieldErrors
+	switch {
+	case errors.As(err, &tooLarge):
+		return err
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		errs.add(typeErr.Field, "must be of type "+jsonTypeName(typeErr.Type.Kind().String()))
+	case errors.As(err, &timeErr):
+		errs.add("timestamp", "must be an RFC3339 timestamp")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		errs.add(strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`), "is not a known field")
+	default:
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return errs.err()
+}
+
+// jsonTypeName maps Go kinds to the JSON type names API clients know
+func jsonTypeName(kind string) string {
+	switch kind {
+	case "int", "int64":
+		return "integer"
+	case "float64":
+		return "number"
+	case "slice":
+		return "array"
+	case "struct", "map":
+		return "object"
+	}
+	return kind
+}
+
+// writeBodyError answers a failed decode or validation with 422 and the field errors, with
+// 413 for bodies over the size limit, or with 400 for bodies that cannot be parsed at all
+func writeBodyError(w http.ResponseWriter, err error) {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		writeJSONStatus(w, http.StatusUnprocessableEntity, verr)
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("The request body is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
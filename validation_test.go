@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeError(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		field string
+		want  string
+	}{
+		{name: "wrong type", body: `{"percentage": "high"}`, field: "percentage", want: "'percentage' must be of type integer"},
+		{name: "bad timestamp", body: `{"timestamp": "tomorrow"}`, field: "timestamp", want: "'timestamp' must be an RFC3339 timestamp"},
+		{name: "unknown field", body: `{"percent": 5}`, field: "percent", want: "'percent' is not a known field"},
+		{name: "syntax error", body: `{"percentage": 5,}`, want: "invalid JSON: invalid character '}' looking for beginning of object key string"},
+		{name: "truncated", body: `{"percentage"`, want: "invalid JSON: unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := json.NewDecoder(strings.NewReader(tt.body))
+			decoder.DisallowUnknownFields()
+			var in ingestReading
+			err := decodeError(decoder.Decode(&in))
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("got %v, want %q", err, tt.want)
+			}
+			var verr *ValidationError
+			if isField := errors.As(err, &verr); isField != (tt.field != "") {
+				t.Fatalf("got a ValidationError %v, want %v", isField, tt.field != "")
+			}
+			if tt.field != "" && verr.Errors[0].Field != tt.field {
+				t.Errorf("got field %q, want %q", verr.Errors[0].Field, tt.field)
+			}
+		})
+	}
+}
+
+func TestPrefixFieldErrors(t *testing.T) {
+	var errs fieldErrors
+	errs.add("percentage", "is required")
+	errs.add("timestamp", "must be an RFC3339 timestamp")
+	other := errors.New("invalid JSON: unexpected EOF")
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "field errors", err: errs.err(), want: "'[3].percentage' is required; '[3].timestamp' must be an RFC3339 timestamp"},
+		{name: "other errors", err: other, want: "invalid JSON: unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prefixFieldErrors(tt.err, "[3]"); got.Error() != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteBodyError(t *testing.T) {
+	var errs fieldErrors
+	errs.add("percentage", "must be between 0 and 100")
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{name: "field errors", err: errs.err(), status: http.StatusUnprocessableEntity, body: `{"message":"The request body is invalid","errors":[{"field":"percentage","message":"must be between 0 and 100"}]}`},
+		{name: "too large", err: &http.MaxBytesError{Limit: 1024}, status: http.StatusRequestEntityTooLarge, body: "The request body is larger than 1024 bytes"},
+		{name: "not JSON", err: errors.New("invalid JSON: unexpected EOF"), status: http.StatusBadRequest, body: "invalid JSON: unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeBodyError(w, tt.err)
+			if w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %s, want %s", got, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// The build is described by linker flags, e.g.
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them the commit and time come from the VCS stamp go build adds to the binary.
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// BuildInfo identifies the running build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Modified is set when the build had uncommitted changes, as far as the VCS stamp tells
+	Modified bool `json:"modified,omitempty"`
+}
+
+// getBuildInfo combines the linker flags with the build information embedded by go build
+func getBuildInfo() BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, BuildTime: buildTime}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = build.GoVersion
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				if t, err := time.Parse(time.RFC3339, setting.Value); err == nil {
+					info.BuildTime = t.UTC().Format(time.RFC3339)
+				}
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// String is the version with the abbreviated commit, e.g. "v1.4.0 (3f2a9c1d)"
+func (b BuildInfo) String() string {
+	if len(b.Commit) > 8 {
+		return b.Version + " (" + b.Commit[:8] + ")"
+	}
+	if b.Commit != "" {
+		return b.Version + " (" + b.Commit + ")"
+	}
+	return b.Version
+}
+
+// getVersionHandler reports the running build
+func getVersionHandler(info BuildInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, info)
+	}
+}
+
+// withVersion sends the running build in the X-Version header of every response
+func withVersion(info BuildInfo, next http.Handler) http.Handler {
+	value := info.String()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Version", value)
+		next.ServeHTTP(w, r)
+	})
+}
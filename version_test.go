@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildInfoString(t *testing.T) {
+	tests := []struct {
+		name string
+		info BuildInfo
+		want string
+	}{
+		{name: "version only", info: BuildInfo{Version: "dev"}, want: "dev"},
+		{name: "short commit", info: BuildInfo{Version: "v1.4.0", Commit: "3f2a9c1"}, want: "v1.4.0 (3f2a9c1)"},
+		{name: "full commit", info: BuildInfo{Version: "v1.4.0", Commit: "3f2a9c1d5e7b9a0c2d4f6e8a1b3c5d7e9f0a2b4c"}, want: "v1.4.0 (3f2a9c1d)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionHandlers(t *testing.T) {
+	info := BuildInfo{Version: "v1.4.0", Commit: "3f2a9c1d5e7b", GoVersion: "go1.23.0"}
+	tests := []struct {
+		name    string
+		handler http.Handler
+		body    string
+		header  string
+	}{
+		{name: "version", handler: getVersionHandler(info), body: `{"version":"v1.4.0","commit":"3f2a9c1d5e7b","go_version":"go1.23.0"}`},
+		{name: "header", handler: withVersion(info, getLivenessHandler()), body: "ok", header: "v1.4.0 (3f2a9c1d)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, httptest.NewRequest("GET", "/version", nil))
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+			if got := w.Header().Get("X-Version"); got != tt.header {
+				t.Errorf("got X-Version %q, want %q", got, tt.header)
+			}
+		})
+	}
+}
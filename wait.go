@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// defaultWaitTimeout is how long /pool-data/wait holds a request without a 'timeout' parameter
+	defaultWaitTimeout = 30 * time.Second
+	// maxWaitTimeout caps the 'timeout' parameter well below common proxy idle limits
+	maxWaitTimeout = 2 * time.Minute
+	// maxWaitResults bounds the delta returned to a client that fell far behind
+	maxWaitResults = 1000
+)
+
+// readingsSince returns the readings stored after the given id, oldest first
+func readingsSince(ctx context.Context, pool *pgxpool.Pool, since int) ([]DataPoint, error) {
+	rows, err := pool.Query(ctx,
+		"SELECT id, timestamp, percentage FROM pool_usage WHERE id > $1 AND NOT suspect ORDER BY id LIMIT $2", since, maxWaitResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDataPoints(rows)
+}
+
+// getWaitHandler handles /pool-data/wait, a long-polling fallback for clients that cannot use
+// /ws or /events. It answers as soon as readings newer than 'since' exist, or with an empty
+// array once the timeout elapses; either way the client polls again with the highest id it has.
+func getWaitHandler(pool *pgxpool.Pool, readings *hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("since") == "" {
+			http.Error(w, "missing 'since' parameter: expected the id of the newest reading already received", http.StatusBadRequest)
+			return
+		}
+		since, err := parseIntParam(r, "since", 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		timeout, err := parseDurationParam(r, "timeout")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if timeout == 0 {
+			timeout = defaultWaitTimeout
+		}
+		if timeout > maxWaitTimeout {
+			http.Error(w, fmt.Sprintf("invalid 'timeout' parameter: must be at most %s", maxWaitTimeout), http.StatusBadRequest)
+			return
+		}
+		loc, err := parseLocation(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Subscribe before the first query so no reading falls in between
+		points, unsubscribe := readings.subscribe()
+		defer unsubscribe()
+		dataPoints, err := readingsSince(r.Context(), pool, since)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		if len(dataPoints) == 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+		wait:
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-timer.C:
+					break wait
+				case dp, ok := <-points:
+					// A closed channel means the server is shutting down
+					if !ok || dp.ID > since {
+						break wait
+					}
+				}
+			}
+			// Load the whole delta rather than just the pushed point, in case several arrived
+			dataPoints, err = readingsSince(r.Context(), pool, since)
+			if err != nil {
+				writeQueryError(w, r, err)
+				return
+			}
+		}
+
+		for i := range dataPoints {
+			dataPoints[i].Timestamp = inLocation(dataPoints[i].Timestamp, loc)
+		}
+		if dataPoints == nil {
+			dataPoints = []DataPoint{}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, dataPoints)
+	}
+}
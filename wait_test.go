@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWaitHandlerRejectsParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+	}{
+		{name: "since missing", query: "", body: "missing 'since' parameter: expected the id of the newest reading already received"},
+		{name: "since not a number", query: "since=latest", body: "invalid 'since' parameter: expected a non-negative integer"},
+		{name: "negative since", query: "since=-1", body: "invalid 'since' parameter: expected a non-negative integer"},
+		{name: "invalid timeout", query: "since=5&timeout=soon", body: "invalid 'timeout' parameter: expected a duration of at least 1s, such as 5m or 1h"},
+		{name: "timeout too long", query: "since=5&timeout=5m", body: "invalid 'timeout' parameter: must be at most 2m0s"},
+		{name: "unknown time zone", query: "since=5&tz=Mars/Olympus", body: "invalid 'tz' parameter: unknown time zone 'Mars/Olympus'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getWaitHandler(nil, newHub())(w, httptest.NewRequest("GET", "/pool-data/wait?"+tt.query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.body {
+				t.Errorf("got body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// eventReadingCreated is sent for every new data point
+	eventReadingCreated = "reading.created"
+	// eventThresholdCrossed is sent when a new data point crosses the webhook's threshold in either direction
+	eventThresholdCrossed = "threshold.crossed"
+
+	// maxWebhookAttempts is the number of times in a row a delivery is tried before it is marked as failed
+	maxWebhookAttempts = 5
+	// webhookTimeout bounds a single delivery attempt
+	webhookTimeout = 10 * time.Second
+	// webhookSweepAge is how long an undelivered event must have gone without an attempt before
+	// the sweep retries it, well beyond the pause between two attempts; the sweep runs as often
+	webhookSweepAge = 5 * time.Minute
+	// webhookSweepWindow bounds how far back the sweep retries undelivered events
+	webhookSweepWindow = 24 * time.Hour
+)
+
+var webhookEvents = []string{eventReadingCreated, eventThresholdCrossed}
+
+// Webhook is a subscription to data point events
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Threshold *int      `json:"threshold"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	// Secret is only returned when the webhook is created
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookDelivery records one event sent (or being sent) to a webhook
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// WebhookEvent is the JSON body POSTed to subscribers
+type WebhookEvent struct {
+	Event     string    `json:"event"`
+	DataPoint DataPoint `json:"data_point"`
+	// Threshold and Direction ("above" or "below") are only set for threshold.crossed events
+	Threshold *int   `json:"threshold,omitempty"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// signWebhook computes the X-Webhook-Signature header value; receivers recompute the HMAC-SHA256
+// of "<t>.<body>" with their secret and should reject stale timestamps to prevent replays
+func signWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp.Unix())
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+// webhookDispatcher turns new data points into webhook deliveries
+type webhookDispatcher struct {
+	pool   *pgxpool.Pool
+	client *http.Client
+}
+
+func newWebhookDispatcher(pool *pgxpool.Pool) *webhookDispatcher {
+	return &webhookDispatcher{pool: pool, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// run dispatches every data point received from points until the channel is closed, sweeping
+// up undelivered events meanwhile
+func (d *webhookDispatcher) run(ctx context.Context, points <-chan DataPoint) {
+	go d.sweep(ctx)
+	var previous *DataPoint
+	for dp := range points {
+		if previous == nil {
+			previous = d.previousReading(ctx, dp)
+		}
+		d.dispatch(ctx, dp, previous)
+		previous = &dp
+	}
+}
+
+// sweep retries undelivered events every webhookSweepAge: those left pending by an instance that
+// stopped, and at startup also those that ran out of attempts, so none are lost while the server
+// or a subscriber was down
+func (d *webhookDispatcher) sweep(ctx context.Context) {
+	d.redeliver(ctx, true)
+	ticker := time.NewTicker(webhookSweepAge)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.redeliver(ctx, false)
+		}
+	}
+}
+
+// redeliver claims the undelivered events of active webhooks within the sweep window that have
+// gone without an attempt for webhookSweepAge, including failed ones if failed is set, and
+// delivers them again. Claiming sets attempted_at, so instances sweeping together share them out.
+func (d *webhookDispatcher) redeliver(ctx context.Context, failed bool) {
+	rows, err := d.pool.Query(ctx, `WITH claimed AS (
+			UPDATE webhook_deliveries SET attempted_at = now() WHERE id IN (
+				SELECT d.id FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+				WHERE w.active AND d.created_at >= now() - make_interval(secs => $2)
+				AND coalesce(d.attempted_at, d.created_at) < now() - make_interval(secs => $1)
+				AND (d.status = 'pending' OR $3 AND d.status = 'failed')
+				FOR UPDATE OF d SKIP LOCKED)
+			RETURNING id, webhook_id, event, payload, attempts)
+		SELECT c.id, c.event, c.payload, c.attempts, w.id, w.url, w.secret
+		FROM claimed c JOIN webhooks w ON w.id = c.webhook_id ORDER BY c.id`,
+		webhookSweepAge.Seconds(), webhookSweepWindow.Seconds(), failed)
+	if err != nil {
+		slog.Error("Error claiming undelivered webhook events", "error", err)
+		return
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var hook Webhook
+		var deliveryID int64
+		var event string
+		var payload []byte
+		var attempts int
+		if err := rows.Scan(&deliveryID, &event, &payload, &attempts, &hook.ID, &hook.URL, &hook.Secret); err != nil {
+			slog.Error("Error scanning webhook delivery", "error", err)
+			return
+		}
+		go d.deliver(ctx, hook, deliveryID, event, payload, attempts)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Error claiming undelivered webhook events", "error", err)
+	}
+	if n > 0 {
+		slog.Info("Retrying undelivered webhook events", "deliveries", n)
+	}
+}
+
+// previousReading loads the reading stored before dp, so threshold crossings can be detected after a restart
+func (d *webhookDispatcher) previousReading(ctx context.Context, dp DataPoint) *DataPoint {
+	var prev DataPoint
+	err := d.pool.QueryRow(ctx, "SELECT id, timestamp, percentage FROM pool_usage WHERE NOT suspect AND id < $1 ORDER BY id DESC LIMIT 1", dp.ID).
+		Scan(&prev.ID, &prev.Timestamp, &prev.Percentage)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			slog.Error("Error loading previous reading", "error", err)
+		}
+		return nil
+	}
+	return &prev
+}
+
+// dispatch records a delivery for every active webhook interested in dp and sends them in the background
+func (d *webhookDispatcher) dispatch(ctx context.Context, dp DataPoint, previous *DataPoint) {
+	rows, err := d.pool.Query(ctx, "SELECT id, url, secret, events, threshold FROM webhooks WHERE active")
+	if err != nil {
+		slog.Error("Error loading webhooks", "error", err)
+		return
+	}
+	var hooks []Webhook
+	for rows.Next() {
+		var hook Webhook
+		if err := rows.Scan(&hook.ID, &hook.URL, &hook.Secret, &hook.Events, &hook.Threshold); err != nil {
+			slog.Error("Error scanning webhook", "error", err)
+			rows.Close()
+			return
+		}
+		hooks = append(hooks, hook)
+	}
+	rows.Close()
+
+	for _, hook := range hooks {
+		var events []WebhookEvent
+		if slices.Contains(hook.Events, eventReadingCreated) {
+			events = append(events, WebhookEvent{Event: eventReadingCreated, DataPoint: dp})
+		}
+		if slices.Contains(hook.Events, eventThresholdCrossed) && hook.Threshold != nil && previous != nil {
+			threshold := *hook.Threshold
+			switch {
+			case previous.Percentage < threshold && dp.Percentage >= threshold:
+				events = append(events, WebhookEvent{Event: eventThresholdCrossed, DataPoint: dp, Threshold: &threshold, Direction: "above"})
+			case previous.Percentage >= threshold && dp.Percentage < threshold:
+				events = append(events, WebhookEvent{Event: eventThresholdCrossed, DataPoint: dp, Threshold: &threshold, Direction: "below"})
+			}
+		}
+		for _, event := range events {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("Error encoding webhook payload", "error", err)
+				continue
+			}
+			var deliveryID int64
+			err = d.pool.QueryRow(ctx, "INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES ($1, $2, $3) RETURNING id",
+				hook.ID, event.Event, payload).Scan(&deliveryID)
+			if err != nil {
+				slog.Error("Error recording webhook delivery", "error", err)
+				continue
+			}
+			go d.deliver(ctx, hook, deliveryID, event.Event, payload, 0)
+		}
+	}
+}
+
+// deliver POSTs the payload to the webhook, retrying with exponential backoff, and records every
+// attempt; tried is the number of attempts an earlier run already made
+func (d *webhookDispatcher) deliver(ctx context.Context, hook Webhook, deliveryID int64, event string, payload []byte, tried int) {
+	backoff := time.Second
+	for n := 1; n <= maxWebhookAttempts; n++ {
+		attempt := tried + n
+		statusCode, err := d.send(ctx, hook, deliveryID, event, payload)
+		if err == nil {
+			_, err = d.pool.Exec(ctx, `UPDATE webhook_deliveries SET status = 'delivered', attempts = $2, response_status = $3,
+				last_error = NULL, attempted_at = now(), delivered_at = now() WHERE id = $1`, deliveryID, attempt, statusCode)
+			if err != nil {
+				slog.Error("Error updating webhook delivery", "error", err)
+			}
+			return
+		}
+
+		status := "pending"
+		if n == maxWebhookAttempts {
+			status = "failed"
+		}
+		var responseStatus *int
+		if statusCode != 0 {
+			responseStatus = &statusCode
+		}
+		_, dbErr := d.pool.Exec(ctx, `UPDATE webhook_deliveries SET status = $2, attempts = $3, response_status = $4, last_error = $5,
+			attempted_at = now() WHERE id = $1`,
+			deliveryID, status, attempt, responseStatus, err.Error())
+		if dbErr != nil {
+			slog.Error("Error updating webhook delivery", "error", dbErr)
+		}
+		if n == maxWebhookAttempts {
+			slog.Warn("Giving up on webhook delivery", "delivery", deliveryID, "url", hook.URL, "error", err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// send makes a single delivery attempt; any non-2xx response counts as a failure
+func (d *webhookDispatcher) send(ctx context.Context, hook Webhook, deliveryID int64, event string, payload []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pool-api-webhooks")
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(deliveryID, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(hook.Secret, time.Now(), payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// getCreateWebhookHandler handles POST /webhooks; the response contains the signing secret, which is not shown again
+func getCreateWebhookHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var hook Webhook
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&hook); err != nil {
+			writeBodyError(w, decodeError(err))
+			return
+		}
+		target, err := url.Parse(hook.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			http.Error(w, "'url' must be an absolute http or https URL", http.StatusBadRequest)
+			return
+		}
+		if len(hook.Events) == 0 {
+			hook.Events = []string{eventReadingCreated}
+		}
+		for _, event := range hook.Events {
+			if !slices.Contains(webhookEvents, event) {
+				http.Error(w, fmt.Sprintf("Unknown event '%s'", event), http.StatusBadRequest)
+				return
+			}
+		}
+		if slices.Contains(hook.Events, eventThresholdCrossed) && (hook.Threshold == nil || *hook.Threshold < 0 || *hook.Threshold > 100) {
+			http.Error(w, "'threshold' between 0 and 100 is required for threshold.crossed events", http.StatusBadRequest)
+			return
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error generating webhook secret", "error", err)
+			return
+		}
+		hook.Secret = hex.EncodeToString(secret)
+		err = pool.QueryRow(r.Context(),
+			"INSERT INTO webhooks (url, secret, events, threshold) VALUES ($1, $2, $3, $4) RETURNING id, active, created_at",
+			hook.URL, hook.Secret, hook.Events, hook.Threshold).Scan(&hook.ID, &hook.Active, &hook.CreatedAt)
+		if err != nil {
+			http.Error(w, "Failed to store webhook", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error inserting webhook", "error", err)
+			return
+		}
+
+		logged := hook
+		logged.Secret = ""
+		logAudit(r.Context(), pool, auditWebhookCreate, strconv.FormatInt(hook.ID, 10), nil, logged)
+		writeJSONStatus(w, http.StatusCreated, hook)
+	}
+}
+
+// getListWebhooksHandler handles GET /webhooks
+func getListWebhooksHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := pool.Query(r.Context(), "SELECT id, url, events, threshold, active, created_at FROM webhooks ORDER BY id")
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		hooks := []Webhook{}
+		for rows.Next() {
+			var hook Webhook
+			if err := rows.Scan(&hook.ID, &hook.URL, &hook.Events, &hook.Threshold, &hook.Active, &hook.CreatedAt); err != nil {
+				http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+				return
+			}
+			hooks = append(hooks, hook)
+		}
+		writeJSON(w, hooks)
+	}
+}
+
+// getDeleteWebhookHandler handles DELETE /webhooks/{id}; the delivery log of the webhook is removed with it
+func getDeleteWebhookHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+			return
+		}
+		tag, err := pool.Exec(r.Context(), "DELETE FROM webhooks WHERE id = $1", id)
+		if err != nil {
+			http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error deleting webhook", "error", err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		logAudit(r.Context(), pool, auditWebhookDelete, strconv.FormatInt(id, 10), nil, nil)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getWebhookDeliveriesHandler handles GET /webhooks/{id}/deliveries and returns the most recent deliveries
+func getWebhookDeliveriesHandler(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid webhook id", http.StatusBadRequest)
+			return
+		}
+		limit, err := parseIntParam(r, "limit", 100)
+		if err != nil || limit == 0 || limit > maxPageSize {
+			http.Error(w, fmt.Sprintf("invalid 'limit' parameter: must be between 1 and %d", maxPageSize), http.StatusBadRequest)
+			return
+		}
+		rows, err := pool.Query(r.Context(), `SELECT id, event, payload, status, attempts, response_status, last_error, created_at, delivered_at
+			FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`, id, limit)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+		defer rows.Close()
+
+		deliveries := []WebhookDelivery{}
+		for rows.Next() {
+			var d WebhookDelivery
+			err := rows.Scan(&d.ID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+			if err != nil {
+				http.Error(w, "Failed to scan row", http.StatusInternalServerError)
+				slog.ErrorContext(r.Context(), "Error scanning row", "error", err)
+				return
+			}
+			deliveries = append(deliveries, d)
+		}
+		writeJSON(w, deliveries)
+	}
+}
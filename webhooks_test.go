@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignWebhook(t *testing.T) {
+	at := time.Unix(1714564800, 0)
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp time.Time
+		body      string
+		want      string
+	}{
+		{name: "event", secret: "whsec", timestamp: at, body: `{"event":"reading.created"}`, want: "t=1714564800,v1=24971c5e3bc705f81567af6708479c9f7af8a20fc8feacaab0fb90dc817ccefb"},
+		{name: "other secret", secret: "other", timestamp: at, body: `{"event":"reading.created"}`, want: "t=1714564800,v1=4b62c217bbd6fe07ae95ed38af943ea652c18414c07e27310fb1019164f500bb"},
+		{name: "empty body", secret: "whsec", timestamp: at.Add(time.Second), body: "", want: "t=1714564801,v1=e292cbd00050fc4960ddcfb265b12cad8d78c4c04bc27e9155ca8dfdea01c1db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signWebhook(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateWebhookHandlerRejectsBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "not JSON", body: "url=https://example.com", status: http.StatusBadRequest, want: "invalid JSON: invalid character 'u' looking for beginning of value"},
+		{name: "unknown field", body: `{"url": "https://example.com/hook", "filter": "weekdays"}`, status: http.StatusUnprocessableEntity, want: `{"message":"The request body is invalid","errors":[{"field":"filter","message":"is not a known field"}]}`},
+		{name: "relative URL", body: `{"url": "/hook"}`, status: http.StatusBadRequest, want: "'url' must be an absolute http or https URL"},
+		{name: "other scheme", body: `{"url": "ftp://example.com/hook"}`, status: http.StatusBadRequest, want: "'url' must be an absolute http or https URL"},
+		{name: "unknown event", body: `{"url": "https://example.com/hook", "events": ["reading.deleted"]}`, status: http.StatusBadRequest, want: "Unknown event 'reading.deleted'"},
+		{
+			name:   "threshold missing",
+			body:   `{"url": "https://example.com/hook", "events": ["threshold.crossed"]}`,
+			status: http.StatusBadRequest,
+			want:   "'threshold' between 0 and 100 is required for threshold.crossed events",
+		},
+		{
+			name:   "threshold out of range",
+			body:   `{"url": "https://example.com/hook", "events": ["threshold.crossed"], "threshold": 120}`,
+			status: http.StatusBadRequest,
+			want:   "'threshold' between 0 and 100 is required for threshold.crossed events",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			getCreateWebhookHandler(nil)(w, httptest.NewRequest("POST", "/webhooks", strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("got body %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
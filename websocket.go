@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// wsPingInterval is how often idle connections are pinged so proxies keep them open
+	wsPingInterval = 30 * time.Second
+	// wsWriteTimeout bounds a single write to a client
+	wsWriteTimeout = 10 * time.Second
+)
+
+// LiveMessage is sent to live update clients: first a "snapshot" with the latest reading
+// (null while the table is empty), then a "reading" for every new data point
+type LiveMessage struct {
+	Type string     `json:"type"`
+	Data *DataPoint `json:"data"`
+}
+
+// latestReading returns the newest reading, or nil while there is none
+func latestReading(ctx context.Context, db querier) (*DataPoint, error) {
+	var dp DataPoint
+	err := db.QueryRow(ctx, "SELECT id, timestamp, percentage FROM pool_usage WHERE NOT suspect ORDER BY timestamp DESC, id DESC LIMIT 1").
+		Scan(&dp.ID, &dp.Timestamp, &dp.Percentage)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dp, nil
+}
+
+// getWebSocketHandler handles /ws; it pushes new readings to the client as they are stored.
+// Handshakes from origins the CORS policy does not allow are rejected with 403.
+func getWebSocketHandler(db querier, readings *hub, cors *corsPolicy) http.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: cors.allowWebSocket}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Upgrade would reject the origin too, but only after the snapshot has been queried
+		if !cors.allowWebSocket(r) {
+			http.Error(w, "The origin is not allowed to connect", http.StatusForbidden)
+			return
+		}
+		// Subscribe before loading the snapshot so no reading falls in between
+		points, unsubscribe := readings.subscribe()
+		defer unsubscribe()
+		snapshot, err := latestReading(r.Context(), db)
+		if err != nil {
+			writeQueryError(w, r, err)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already answered the request
+			return
+		}
+		defer conn.Close()
+
+		// The client sends nothing but control frames; reading is needed to notice it going away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		send := func(msg LiveMessage) error {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			return conn.WriteJSON(msg)
+		}
+		if err := send(LiveMessage{Type: "snapshot", Data: snapshot}); err != nil {
+			return
+		}
+		ping := time.NewTicker(wsPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case dp, ok := <-points:
+				if !ok {
+					return
+				}
+				// Skip readings that the snapshot already contains
+				if snapshot != nil && dp.ID <= snapshot.ID {
+					continue
+				}
+				if err := send(LiveMessage{Type: "reading", Data: &dp}); err != nil {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
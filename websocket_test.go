@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWebSocket connects to the /ws handler of server from origin
+func dialWebSocket(t *testing.T, server *httptest.Server, origin string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	return websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+}
+
+// readMessage returns the next message of conn
+func readMessage(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return strings.TrimSpace(string(msg))
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		rows     [][]any
+		origin   string
+		snapshot string
+		// published are broadcast after the snapshot; the client receives reading
+		published []DataPoint
+		reading   string
+	}{
+		{
+			name: "latest reading", rows: [][]any{{7, at, 40}}, origin: "https://dashboard.example",
+			snapshot:  `{"type":"snapshot","data":{"id":7,"timestamp":"2024-06-01T12:00:00Z","percentage":40}}`,
+			published: []DataPoint{{ID: 7, Timestamp: at, Percentage: 40}, {ID: 8, Timestamp: at.Add(15 * time.Minute), Percentage: 45}},
+			reading:   `{"type":"reading","data":{"id":8,"timestamp":"2024-06-01T12:15:00Z","percentage":45}}`,
+		},
+		{
+			name: "no readings yet", snapshot: `{"type":"snapshot","data":null}`,
+			published: []DataPoint{{ID: 1, Timestamp: at, Percentage: 5}},
+			reading:   `{"type":"reading","data":{"id":1,"timestamp":"2024-06-01T12:00:00Z","percentage":5}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readings := newHub()
+			defer readings.close()
+			policy := &corsPolicy{origins: []string{"https://dashboard.example"}}
+			mux := http.NewServeMux()
+			mux.Handle("/ws", getWebSocketHandler(&fakeQuerier{rows: tt.rows}, readings, policy))
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			conn, _, err := dialWebSocket(t, server, tt.origin)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Closing the connection ends the handler, which the server waits for on Close
+			defer conn.Close()
+			if got := readMessage(t, conn); got != tt.snapshot {
+				t.Errorf("got snapshot %s, want %s", got, tt.snapshot)
+			}
+			// The handler subscribed before it sent the snapshot, so nothing published now is missed;
+			// the reading the snapshot already holds is not sent again
+			for _, dp := range tt.published {
+				readings.publish(dp)
+			}
+			if got := readMessage(t, conn); got != tt.reading {
+				t.Errorf("got %s, want %s", got, tt.reading)
+			}
+		})
+	}
+}
+
+func TestWebSocketHandlerRejectsOrigin(t *testing.T) {
+	readings := newHub()
+	defer readings.close()
+	db := &fakeQuerier{}
+	server := httptest.NewServer(getWebSocketHandler(db, readings, &corsPolicy{origins: []string{"https://dashboard.example"}}))
+	defer server.Close()
+
+	_, resp, err := dialWebSocket(t, server, "https://evil.example")
+	if err != websocket.ErrBadHandshake {
+		t.Fatalf("got error %v, want %v", err, websocket.ErrBadHandshake)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if got := strings.TrimSpace(string(body)); got != "The origin is not allowed to connect" {
+		t.Errorf("got body %q", got)
+	}
+	if db.sql != "" {
+		t.Errorf("got query %q, want the origin rejected before the snapshot", db.sql)
+	}
+}